@@ -0,0 +1,374 @@
+package mps3
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ArchiveLimits are the limits of the content of the uploaded ZIP, tar and gzip files, including
+// the archives nested in them. Zero values mean no limit.
+//
+// ZIP files are inspected from their local headers while they're uploaded, so entries that can't
+// be skipped without decompressing them (stored entries without their size, or encrypted ones
+// compressed with an unknown size) are rejected.
+type ArchiveLimits struct {
+	// MaxEntries is the maximum number of files and directories
+	MaxEntries int
+
+	// MaxDepth is the maximum nesting of archives inside archives, e.g. 1 allows ZIP files that
+	// contain ZIP files that don't contain other archives. Archives more than 10 levels deep are
+	// always rejected.
+	MaxDepth int
+
+	// MaxSize is the maximum uncompressed size in bytes
+	MaxSize int64
+
+	// MaxRatio is the maximum ratio between the uncompressed size and the size of the file, it's
+	// checked once the content is larger than 1MB.
+	MaxRatio float64
+}
+
+const (
+	// maxArchiveDepth is the maximum nesting of archives that are inspected.
+	maxArchiveDepth = 10
+	// minRatioSize is the uncompressed size from which ArchiveLimits.MaxRatio is checked.
+	minRatioSize = 1 << 20
+)
+
+// archiveInspector inspects an archive while it's uploaded, see Config.ArchiveLimits.
+type archiveInspector struct {
+	lim     *ArchiveLimits
+	file    *countReader
+	entries int
+	size    int64
+}
+
+// countReader counts the bytes read.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// inspectArchive checks the content of the file against Config.ArchiveLimits if it's an archive,
+// it fails with ErrSuspiciousArchive as soon as a limit is exceeded.
+func (wr Wrapper) inspectArchive(r io.Reader) error {
+	a := &archiveInspector{lim: wr.archLimits, file: &countReader{r: r}}
+	return a.inspect(a.file, 0)
+}
+
+// inspect inspects the content of a file at the depth of nesting, consuming it if it's an archive.
+func (a *archiveInspector) inspect(r io.Reader, depth int) error {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return invalidArchive(err)
+	}
+	kind := archiveKind(head)
+	if kind == "" {
+		return nil
+	}
+	if depth > maxArchiveDepth || (a.lim.MaxDepth > 0 && depth > a.lim.MaxDepth) {
+		return fmt.Errorf("%w: archives nested more than %d levels deep", ErrSuspiciousArchive, depth-1)
+	}
+
+	entry := func(e archiveEntry) error {
+		return a.entry(e, depth)
+	}
+	switch kind {
+	case "gzip":
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return invalidArchive(err)
+		}
+		content := bufio.NewReader(&expandReader{r: gz, a: a})
+		// the compressed file is nested in the gzip file, unless it's a tar file
+		head, err := content.Peek(512)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return invalidArchive(err)
+		}
+		if archiveKind(head) != "tar" {
+			depth++
+		}
+		return a.contents(content, depth)
+	case "tar":
+		return walkTar(br, entry)
+	default:
+		return walkZip(br, entry)
+	}
+}
+
+// contents inspects the content of an entry, which may be an archive, and consumes it.
+func (a *archiveInspector) contents(r io.Reader, depth int) error {
+	if err := a.inspect(r, depth); err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return invalidArchive(err)
+	}
+	return nil
+}
+
+// entry counts an entry of an archive and inspects its content.
+func (a *archiveInspector) entry(e archiveEntry, depth int) error {
+	a.entries++
+	if a.lim.MaxEntries > 0 && a.entries > a.lim.MaxEntries {
+		return fmt.Errorf("%w: more than %d entries", ErrSuspiciousArchive, a.lim.MaxEntries)
+	}
+	if e.size > 0 && a.lim.MaxSize > 0 && a.size+e.size > a.lim.MaxSize {
+		return fmt.Errorf("%w: larger than %d bytes uncompressed", ErrSuspiciousArchive, a.lim.MaxSize)
+	}
+	if e.content == nil {
+		return nil
+	}
+	return a.contents(&expandReader{r: e.content, a: a}, depth+1)
+}
+
+// archiveEntry is a file or directory of an archive.
+type archiveEntry struct {
+	name string
+	// size is the uncompressed size declared in the archive, -1 if it's unknown
+	size      int64
+	dir       bool
+	encrypted bool
+	// content is nil for entries that can't be read, e.g. directories, links and encrypted files
+	content io.Reader
+}
+
+// walkTar calls fn with the entries of a tar file, the content of each entry is skipped after fn
+// returns.
+func walkTar(r io.Reader, fn func(archiveEntry) error) error {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return invalidArchive(err)
+		}
+		e := archiveEntry{name: h.Name, size: h.Size, dir: h.Typeflag == tar.TypeDir}
+		if h.FileInfo().Mode().IsRegular() {
+			e.content = tr
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+}
+
+// ZIP signatures and flags.
+const (
+	zipLocalHeader      = 0x04034b50
+	zipCentralDirectory = 0x02014b50
+	zipEndOfDirectory   = 0x06054b50
+	zip64EndOfDirectory = 0x06064b50
+	zipDataDescriptor   = 0x08074b50
+	zipEncrypted        = 0x1
+	zipHasDescriptor    = 0x8
+)
+
+// walkZip calls fn with the entries of a ZIP file from their local headers, the central directory
+// at the end of the file is ignored. The content of each entry is skipped after fn returns.
+func walkZip(r *bufio.Reader, fn func(archiveEntry) error) error {
+	for {
+		var sig [4]byte
+		if _, err := io.ReadFull(r, sig[:]); err != nil {
+			return invalidArchive(err)
+		}
+		switch binary.LittleEndian.Uint32(sig[:]) {
+		case zipLocalHeader:
+		case zipCentralDirectory, zipEndOfDirectory, zip64EndOfDirectory:
+			return nil
+		default:
+			return invalidArchive(errors.New("invalid ZIP header"))
+		}
+		if err := zipEntry(r, fn); err != nil {
+			return err
+		}
+	}
+}
+
+// zipEntry reads an entry of a ZIP file, after the signature of its local header.
+func zipEntry(r *bufio.Reader, fn func(archiveEntry) error) error {
+	var h [26]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return invalidArchive(err)
+	}
+	flags := binary.LittleEndian.Uint16(h[2:])
+	method := binary.LittleEndian.Uint16(h[4:])
+	csize := int64(binary.LittleEndian.Uint32(h[14:]))
+	usize := int64(binary.LittleEndian.Uint32(h[18:]))
+	name := make([]byte, binary.LittleEndian.Uint16(h[22:]))
+	extra := make([]byte, binary.LittleEndian.Uint16(h[24:]))
+	if _, err := io.ReadFull(r, name); err != nil {
+		return invalidArchive(err)
+	}
+	if _, err := io.ReadFull(r, extra); err != nil {
+		return invalidArchive(err)
+	}
+	zip64 := false
+	if csize == 0xffffffff || usize == 0xffffffff {
+		zip64 = true
+		usize, csize = zip64Sizes(extra, usize, csize)
+	}
+	descriptor := flags&zipHasDescriptor != 0
+	e := archiveEntry{name: string(name), size: usize, encrypted: flags&zipEncrypted != 0}
+	e.dir = bytes.HasSuffix(name, []byte("/"))
+	if descriptor {
+		e.size = -1
+	}
+
+	compressed := io.LimitReader(r, csize)
+	var content io.Reader
+	switch {
+	case e.encrypted || (method != zip.Store && method != zip.Deflate):
+		// the content can't be read, only skipped if its size is known
+		if err := fn(e); err != nil {
+			return err
+		}
+		if descriptor {
+			return invalidArchive(fmt.Errorf("can't read entry %q", name))
+		}
+		if _, err := io.CopyN(io.Discard, r, csize); err != nil {
+			return invalidArchive(err)
+		}
+		return nil
+	case method == zip.Store:
+		if descriptor {
+			return invalidArchive(fmt.Errorf("stored entry %q without size", name))
+		}
+		content = compressed
+	case descriptor:
+		// the compressed data ends where the deflate stream ends
+		content = flate.NewReader(r)
+	default:
+		content = flate.NewReader(compressed)
+	}
+
+	counter := &countReader{r: content}
+	if !e.dir {
+		e.content = counter
+	}
+	if err := fn(e); err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, counter); err != nil {
+		return invalidArchive(err)
+	}
+	if !descriptor {
+		if counter.n != usize {
+			return fmt.Errorf("%w: entry %q has %d bytes, not the %d it declares", ErrSuspiciousArchive, name, counter.n, usize)
+		}
+		// the compressed data declared after the end of the deflate stream
+		if _, err := io.Copy(io.Discard, compressed); err != nil {
+			return invalidArchive(err)
+		}
+		return nil
+	}
+
+	// the data descriptor has an optional signature, the CRC and the sizes
+	if sig, err := r.Peek(4); err == nil && binary.LittleEndian.Uint32(sig) == zipDataDescriptor {
+		_, _ = r.Discard(4)
+	}
+	size := 12
+	if zip64 {
+		size = 20
+	}
+	d := make([]byte, size)
+	if _, err := io.ReadFull(r, d); err != nil {
+		return invalidArchive(err)
+	}
+	if zip64 {
+		usize = int64(binary.LittleEndian.Uint64(d[12:]))
+	} else {
+		usize = int64(binary.LittleEndian.Uint32(d[8:]))
+	}
+	if counter.n != usize {
+		return fmt.Errorf("%w: entry %q has %d bytes, not the %d it declares", ErrSuspiciousArchive, name, counter.n, usize)
+	}
+	return nil
+}
+
+// zip64Sizes returns the sizes of the ZIP64 extra field, which has the sizes that don't fit the
+// local header.
+func zip64Sizes(extra []byte, usize, csize int64) (int64, int64) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		}
+		if id == 0x0001 {
+			field := extra[:size]
+			if usize == 0xffffffff && len(field) >= 8 {
+				usize = int64(binary.LittleEndian.Uint64(field))
+				field = field[8:]
+			}
+			if csize == 0xffffffff && len(field) >= 8 {
+				csize = int64(binary.LittleEndian.Uint64(field))
+			}
+			break
+		}
+		extra = extra[size:]
+	}
+	return usize, csize
+}
+
+// expandReader counts the uncompressed content of an archive, failing as soon as it exceeds the
+// limits.
+type expandReader struct {
+	r io.Reader
+	a *archiveInspector
+	n int64
+}
+
+func (e *expandReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	e.n += int64(n)
+	a := e.a
+	a.size += int64(n)
+	if a.lim.MaxSize > 0 && a.size > a.lim.MaxSize {
+		return n, fmt.Errorf("%w: larger than %d bytes uncompressed", ErrSuspiciousArchive, a.lim.MaxSize)
+	}
+	if a.lim.MaxRatio > 0 && a.size > minRatioSize && float64(a.size) > a.lim.MaxRatio*float64(a.file.n) {
+		return n, fmt.Errorf("%w: compression ratio larger than %g", ErrSuspiciousArchive, a.lim.MaxRatio)
+	}
+	return n, err
+}
+
+// archiveKind returns the kind of archive of the file from its first bytes, "zip", "tar" or
+// "gzip", or an empty string if it isn't an archive.
+func archiveKind(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return "zip"
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return "gzip"
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		return "tar"
+	}
+	return ""
+}
+
+// invalidArchive returns the error of an archive that can't be read.
+func invalidArchive(err error) error {
+	if errors.Is(err, ErrSuspiciousArchive) {
+		return err
+	}
+	return fmt.Errorf("%w: invalid archive: %w", ErrSuspiciousArchive, err)
+}
@@ -0,0 +1,88 @@
+package mps3
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiveLimits(t *testing.T) {
+	assert := assert.New(t)
+	newZip := func(files map[string][]byte) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for name, content := range files {
+			w, err := zw.Create(name)
+			assert.NoError(err)
+			_, _ = w.Write(content)
+		}
+		assert.NoError(zw.Close())
+		return buf.Bytes()
+	}
+	inspect := func(lim ArchiveLimits, content []byte) error {
+		return Wrapper{archLimits: &lim}.inspectArchive(bytes.NewReader(content))
+	}
+
+	small := newZip(map[string][]byte{"a.txt": []byte("hello"), "b.txt": []byte("world")})
+	assert.NoError(inspect(ArchiveLimits{MaxEntries: 2, MaxDepth: 1, MaxSize: 10, MaxRatio: 2}, small))
+	assert.ErrorIs(inspect(ArchiveLimits{MaxEntries: 1}, small), ErrSuspiciousArchive)
+	assert.ErrorIs(inspect(ArchiveLimits{MaxSize: 9}, small), ErrSuspiciousArchive)
+	// files that aren't archives aren't inspected
+	assert.NoError(inspect(ArchiveLimits{MaxEntries: 1}, []byte("hello")))
+
+	bomb := newZip(map[string][]byte{"zeros": make([]byte, 10<<20)})
+	assert.NoError(inspect(ArchiveLimits{}, bomb))
+	assert.ErrorIs(inspect(ArchiveLimits{MaxRatio: 100}, bomb), ErrSuspiciousArchive)
+
+	nested := newZip(map[string][]byte{"inner.zip": newZip(map[string][]byte{"inner2.zip": small})})
+	assert.NoError(inspect(ArchiveLimits{MaxDepth: 2}, nested))
+	assert.ErrorIs(inspect(ArchiveLimits{MaxDepth: 1}, nested), ErrSuspiciousArchive)
+
+	// an entry larger than the size in its local header
+	var stored bytes.Buffer
+	zw := zip.NewWriter(&stored)
+	w, err := zw.CreateRaw(&zip.FileHeader{Name: "a.txt", Method: zip.Store, CRC32: crc32.ChecksumIEEE([]byte("hello")),
+		CompressedSize64: 5, UncompressedSize64: 5})
+	assert.NoError(err)
+	_, _ = w.Write([]byte("hello"))
+	assert.NoError(zw.Close())
+	assert.NoError(inspect(ArchiveLimits{}, stored.Bytes()))
+	lie := bytes.Clone(stored.Bytes())
+	binary.LittleEndian.PutUint32(lie[22:], 2)
+	assert.ErrorIs(inspect(ArchiveLimits{}, lie), ErrSuspiciousArchive)
+
+	// a tar.gz file isn't nested in the gzip file
+	var tgz bytes.Buffer
+	gz := gzip.NewWriter(&tgz)
+	tw := tar.NewWriter(gz)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		assert.NoError(tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: 5}))
+		_, _ = tw.Write([]byte("hello"))
+	}
+	assert.NoError(tw.Close())
+	assert.NoError(gz.Close())
+	assert.NoError(inspect(ArchiveLimits{MaxEntries: 3, MaxDepth: 0}, tgz.Bytes()))
+	assert.ErrorIs(inspect(ArchiveLimits{MaxEntries: 2}, tgz.Bytes()), ErrSuspiciousArchive)
+	assert.ErrorIs(inspect(ArchiveLimits{MaxSize: 14}, tgz.Bytes()), ErrSuspiciousArchive)
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, ArchiveLimits: &ArchiveLimits{MaxRatio: 100}})
+	assert.NoError(err)
+	handler := wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, newFileRequest(t, "small.zip", string(small)))
+	assert.Equal(http.StatusOK, res.Code)
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, newFileRequest(t, "bomb.zip", string(bomb)))
+	assert.Equal(http.StatusUnprocessableEntity, res.Code)
+	assert.Len(backend.Objects(), 1)
+}
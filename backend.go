@@ -0,0 +1,251 @@
+package mps3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Backend stores the uploaded files. The default backend streams the files to S3,
+// other implementations are free to ignore the S3 specific fields of the inputs.
+type Backend interface {
+	// Upload reads in.Body until EOF and stores it under in.Bucket and in.Key.
+	Upload(ctx context.Context, in *s3.PutObjectInput) (*manager.UploadOutput, error)
+
+	// Copy copies the object in.CopySource (the URL encoded `<bucket>/<key>`, see CopySource)
+	// to in.Bucket and in.Key.
+	Copy(ctx context.Context, in *s3.CopyObjectInput) (*s3.CopyObjectOutput, error)
+
+	// Delete removes the object stored under in.Bucket and in.Key, it's not an error if
+	// the object doesn't exist.
+	Delete(ctx context.Context, in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error)
+
+	// Head returns the object stored under in.Bucket and in.Key without its content, if the
+	// object doesn't exist the error is a *types.NotFound.
+	Head(ctx context.Context, in *s3.HeadObjectInput) (*s3.HeadObjectOutput, error)
+}
+
+// isNotFound returns true if the error means the object doesn't exist.
+func isNotFound(err error) bool {
+	var nf *types.NotFound
+	var nsk *types.NoSuchKey
+	return errors.As(err, &nf) || errors.As(err, &nsk)
+}
+
+// CopySource returns the value of s3.CopyObjectInput.CopySource for the object.
+func CopySource(bucket, key string) string {
+	return url.PathEscape(bucket + "/" + key)
+}
+
+// ParseCopySource returns the bucket and key of a s3.CopyObjectInput.CopySource value.
+func ParseCopySource(src string) (bucket, key string, err error) {
+	src, err = url.PathUnescape(src)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid copy source: %w", err)
+	}
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(src, "/"), "/")
+	if !ok || bucket == "" || key == "" {
+		return "", "", fmt.Errorf("invalid copy source %q", src)
+	}
+	return bucket, key, nil
+}
+
+type s3Backend struct {
+	client     *s3.Client
+	uploader   *manager.Uploader
+	leaveParts bool
+}
+
+// NewS3Backend creates the default S3 backend, only the S3Config, Bucket, Buckets, BucketACL, CreateBucket,
+// AbortIncompleteUploadsAfterDays, PartSize, Concurrency, BufferProvider, LeavePartsOnError and
+// UseAccelerateEndpoint options are used. It's useful to replicate files to a bucket in another region,
+// see ReplicaConfig.
+func NewS3Backend(cfg Config) (Backend, error) {
+	if cfg.S3Config == nil {
+		s3cfg, err := config.LoadDefaultConfig(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create S3 configuration: %w", err)
+		}
+		cfg.S3Config = &s3cfg
+	}
+
+	cli := s3.NewFromConfig(*cfg.S3Config, func(o *s3.Options) {
+		o.UseAccelerate = cfg.UseAccelerateEndpoint
+		o.TracerProvider = smithyTracerProvider{tracerProvider(cfg)}
+	})
+
+	if cfg.CreateBucket || cfg.CheckBucket {
+		if cfg.BucketACL == "" {
+			cfg.BucketACL = "private"
+		}
+		region, err := checkBuckets(cli, cfg)
+		if err != nil {
+			return nil, err
+		}
+		if region != "" && region != cli.Options().Region {
+			cli = s3.NewFromConfig(*cfg.S3Config, func(o *s3.Options) {
+				o.UseAccelerate = cfg.UseAccelerateEndpoint
+				o.TracerProvider = smithyTracerProvider{tracerProvider(cfg)}
+				o.Region = region
+			})
+		}
+	}
+
+	if cfg.AbortIncompleteUploadsAfterDays > 0 {
+		for _, name := range bucketNames(cfg) {
+			if err := ensureAbortRule(cli, name, cfg.AbortIncompleteUploadsAfterDays); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if cfg.PartSize < manager.MinUploadPartSize {
+		cfg.PartSize = manager.MinUploadPartSize
+	}
+
+	return &s3Backend{
+		client:     cli,
+		leaveParts: cfg.LeavePartsOnError,
+		uploader: manager.NewUploader(cli, func(u *manager.Uploader) {
+			u.PartSize = cfg.PartSize
+			// failed uploads are aborted by abortUpload, which also works when the request was canceled
+			u.LeavePartsOnError = true
+			if cfg.Concurrency > 0 {
+				u.Concurrency = cfg.Concurrency
+			}
+			if cfg.BufferProvider != nil {
+				u.BufferProvider = cfg.BufferProvider
+			}
+		}),
+	}, nil
+}
+
+func (b *s3Backend) Upload(ctx context.Context, in *s3.PutObjectInput) (*manager.UploadOutput, error) {
+	out, err := b.uploader.Upload(ctx, in)
+	if err != nil {
+		return nil, b.abortUpload(ctx, in, err)
+	}
+	return out, nil
+}
+
+func (b *s3Backend) Copy(ctx context.Context, in *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	return b.client.CopyObject(ctx, in)
+}
+
+func (b *s3Backend) Delete(ctx context.Context, in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	return b.client.DeleteObject(ctx, in)
+}
+
+func (b *s3Backend) Head(ctx context.Context, in *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	return b.client.HeadObject(ctx, in)
+}
+
+// checkBuckets makes sure the buckets exist, creating them if CreateBucket is set, and returns their region
+// if it's known.
+func checkBuckets(cli *s3.Client, cfg Config) (string, error) {
+	var region string
+	for _, name := range bucketNames(cfg) {
+		r, err := checkBucket(cli, name, cfg.BucketACL, cfg.CreateBucket)
+		if err != nil {
+			return "", err
+		}
+		if r != "" && region != "" && r != region {
+			return "", fmt.Errorf("buckets must be in the same region, %q is in %q instead of %q", name, r, region)
+		}
+		if r != "" {
+			region = r
+		}
+	}
+	return region, nil
+}
+
+// checkBucket probes the bucket with HeadBucket and returns its region, if the bucket doesn't exist
+// it's created when create is true.
+func checkBucket(cli *s3.Client, name, acl string, create bool) (string, error) {
+	out, err := cli.HeadBucket(context.Background(), &s3.HeadBucketInput{Bucket: aws.String(name)})
+	if err == nil {
+		return aws.ToString(out.BucketRegion), nil
+	}
+
+	var re *awshttp.ResponseError
+	if !errors.As(err, &re) {
+		return "", fmt.Errorf("failed to check bucket %q: %w", name, err)
+	}
+	switch re.HTTPStatusCode() {
+	case http.StatusMovedPermanently:
+		// the bucket is in another region, S3 tells which one in the response
+		if region := re.Response.Header.Get("X-Amz-Bucket-Region"); region != "" {
+			return region, nil
+		}
+		return "", fmt.Errorf("bucket %q is in a different region than the configured %q", name, cli.Options().Region)
+	case http.StatusForbidden:
+		return "", nil
+	case http.StatusNotFound:
+		if !create {
+			return "", fmt.Errorf("bucket %q doesn't exist", name)
+		}
+		return "", createBucket(cli, name, acl)
+	}
+	return "", fmt.Errorf("failed to check bucket %q: %w", name, err)
+}
+
+func createBucket(cli *s3.Client, name, acl string) error {
+	_, err := cli.CreateBucket(context.Background(), createBucketInput(name, acl))
+	if err != nil {
+		var aerr *types.BucketAlreadyOwnedByYou
+		if errors.As(err, &aerr) {
+			return nil
+		}
+		return fmt.Errorf("failed to create bucket %q: %w", name, err)
+	}
+	return nil
+}
+
+func createBucketInput(name, acl string) *s3.CreateBucketInput {
+	if !isDirectoryBucket(name) {
+		return &s3.CreateBucketInput{
+			Bucket: aws.String(name),
+			ACL:    types.BucketCannedACL(acl),
+		}
+	}
+
+	// directory buckets don't support ACLs and are created in the zone that is part of the name
+	return &s3.CreateBucketInput{
+		Bucket: aws.String(name),
+		CreateBucketConfiguration: &types.CreateBucketConfiguration{
+			Location: &types.LocationInfo{
+				Type: types.LocationTypeAvailabilityZone,
+				Name: aws.String(directoryBucketZone(name)),
+			},
+			Bucket: &types.BucketInfo{
+				Type:           types.BucketTypeDirectory,
+				DataRedundancy: types.DataRedundancySingleAvailabilityZone,
+			},
+		},
+	}
+}
+
+// isDirectoryBucket returns true for S3 Express One Zone directory buckets, which are named
+// `<name>--<zone-id>--x-s3`.
+func isDirectoryBucket(name string) bool {
+	return strings.HasSuffix(name, "--x-s3")
+}
+
+// directoryBucketZone returns the availability zone ID of a directory bucket.
+func directoryBucketZone(name string) string {
+	name = strings.TrimSuffix(name, "--x-s3")
+	if i := strings.LastIndex(name, "--"); i >= 0 {
+		return name[i+2:]
+	}
+	return ""
+}
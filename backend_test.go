@@ -0,0 +1,176 @@
+package mps3
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirectoryBuckets(t *testing.T) {
+	assert := assert.New(t)
+
+	in := createBucketInput("uploads--usw2-az1--x-s3", "private")
+	assert.Empty(in.ACL)
+	assert.Equal("usw2-az1", *in.CreateBucketConfiguration.Location.Name)
+	assert.Equal(types.BucketTypeDirectory, in.CreateBucketConfiguration.Bucket.Type)
+
+	in = createBucketInput("uploads", "private")
+	assert.Equal(types.BucketCannedACLPrivate, in.ACL)
+	assert.Nil(in.CreateBucketConfiguration)
+
+	// ACLs, tags and Object Lock aren't sent to directory buckets
+	backend, form, res := uploadToMemory(t, Config{
+		Bucket:     "uploads--usw2-az1--x-s3",
+		TagFunc:    func(*http.Request, string) map[string]string { return map[string]string{"a": "b"} },
+		ObjectLock: ObjectLock{Mode: "GOVERNANCE", RetainFor: time.Hour, LegalHold: true},
+	}, nil, "test_file2.txt")
+	assert.Equal(200, res.Code)
+	obj, ok := backend.Object("uploads--usw2-az1--x-s3", form.Get("file"))
+	assert.True(ok)
+	assert.Empty(obj.Input.ACL)
+	assert.Nil(obj.Input.Tagging)
+	assert.Empty(obj.Input.ObjectLockMode)
+	assert.Nil(obj.Input.ObjectLockRetainUntilDate)
+	assert.Empty(obj.Input.ObjectLockLegalHoldStatus)
+}
+
+func TestUploaderOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	s3cfg, err := NewR2Config("abc123", "key", "secret")
+	assert.NoError(err)
+	pool := manager.NewBufferedReadSeekerWriteToPool(1024)
+	b, err := NewS3Backend(Config{
+		S3Config:          s3cfg,
+		Bucket:            bucket,
+		Concurrency:       2,
+		BufferProvider:    pool,
+		LeavePartsOnError: true,
+	})
+	assert.NoError(err)
+
+	uploader := b.(*s3Backend).uploader
+	assert.Equal(2, uploader.Concurrency)
+	assert.Equal(pool, uploader.BufferProvider)
+	assert.True(b.(*s3Backend).leaveParts)
+	assert.Equal(manager.MinUploadPartSize, uploader.PartSize)
+}
+
+func TestCheckBucket(t *testing.T) {
+	assert := assert.New(t)
+
+	var created []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name := strings.Trim(req.URL.Path, "/")
+		switch {
+		case req.Method == http.MethodPut:
+			created = append(created, name)
+		case name == "moved":
+			w.Header().Set("X-Amz-Bucket-Region", "eu-west-1")
+			w.WriteHeader(http.StatusMovedPermanently)
+		case name == "private":
+			w.WriteHeader(http.StatusForbidden)
+		case name == "missing":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Header().Set("X-Amz-Bucket-Region", "us-east-1")
+		}
+	}))
+	defer server.Close()
+
+	s3cfg, err := newEndpointConfig(server.URL, "us-east-1", "key", "secret")
+	assert.NoError(err)
+	s3cfg.RetryMaxAttempts = 1
+	backend := func(create bool, buckets ...string) (*s3Backend, error) {
+		b, err := NewS3Backend(Config{S3Config: s3cfg, Buckets: buckets, CheckBucket: true, CreateBucket: create})
+		if err != nil {
+			return nil, err
+		}
+		return b.(*s3Backend), nil
+	}
+
+	b, err := backend(false, "existing", "private")
+	assert.NoError(err)
+	assert.Equal("us-east-1", b.client.Options().Region)
+
+	b, err = backend(false, "moved")
+	assert.NoError(err)
+	assert.Equal("eu-west-1", b.client.Options().Region)
+
+	_, err = backend(false, "existing", "moved")
+	assert.ErrorContains(err, "same region")
+
+	_, err = backend(false, "missing")
+	assert.ErrorContains(err, `bucket "missing" doesn't exist`)
+	assert.Empty(created)
+
+	_, err = backend(true, "missing")
+	assert.NoError(err)
+	assert.Equal([]string{"missing"}, created)
+}
+
+func TestSinglePartUpload(t *testing.T) {
+	assert := assert.New(t)
+
+	var mu sync.Mutex
+	var requests []string
+	var content []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		body, _ := io.ReadAll(req.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case req.Method == http.MethodPost && q.Has("uploads"):
+			requests = append(requests, "create")
+			w.Write([]byte(`<InitiateMultipartUploadResult><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>`))
+		case req.Method == http.MethodPost:
+			requests = append(requests, "complete")
+			w.Write([]byte(`<CompleteMultipartUploadResult><ETag>"etag"</ETag></CompleteMultipartUploadResult>`))
+		case q.Has("uploadId"):
+			requests = append(requests, "part")
+			w.Header().Set("ETag", `"part"`)
+		default:
+			requests = append(requests, "put")
+			content = body
+			w.Header().Set("ETag", `"etag"`)
+		}
+	}))
+	defer server.Close()
+
+	s3cfg, err := newEndpointConfig(server.URL, "us-east-1", "key", "secret")
+	assert.NoError(err)
+	b, err := NewS3Backend(Config{S3Config: s3cfg, Bucket: bucket})
+	assert.NoError(err)
+	upload := func(size int64) *manager.UploadOutput {
+		requests = nil
+		body := bytes.NewReader(bytes.Repeat([]byte("a"), int(size)))
+		out, err := b.Upload(context.Background(), &s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String("key"), Body: struct{ *bytes.Reader }{body}})
+		assert.NoError(err)
+		return out
+	}
+
+	// the uploader stores files that fit in a part with a single request
+	out := upload(10)
+	assert.Equal([]string{"put"}, requests)
+	assert.Equal("aaaaaaaaaa", string(content))
+	assert.Equal(`"etag"`, aws.ToString(out.ETag))
+	assert.Equal("key", aws.ToString(out.Key))
+	assert.True(strings.HasSuffix(out.Location, "/key"), out.Location)
+
+	out = upload(manager.MinUploadPartSize + 1)
+	assert.Equal([]string{"create", "part", "part", "complete"}, requests)
+	assert.Equal(`"etag"`, aws.ToString(out.ETag))
+}
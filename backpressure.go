@@ -0,0 +1,54 @@
+package mps3
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// gate limits the upload requests handled at the same time by the middleware, see
+// Config.MaxConcurrentUploads.
+type gate struct {
+	slots chan struct{}
+	wait  time.Duration
+}
+
+func newGate(size int, wait time.Duration) *gate {
+	return &gate{slots: make(chan struct{}, size), wait: wait}
+}
+
+// acquire waits for a slot for the request, up to the wait of the gate. It fails with
+// ErrOverloaded if none was freed, otherwise release must be called once the files of the request
+// were uploaded, calling it more than once is fine.
+func (g *gate) acquire(req *http.Request) (release func(), err error) {
+	release = sync.OnceFunc(func() { <-g.slots })
+	select {
+	case g.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if g.wait <= 0 {
+		return nil, fmt.Errorf("%w: more than %d uploads in progress", ErrOverloaded, cap(g.slots))
+	}
+
+	t := time.NewTimer(g.wait)
+	defer t.Stop()
+	select {
+	case g.slots <- struct{}{}:
+		return release, nil
+	case <-t.C:
+		return nil, fmt.Errorf("%w: no upload finished in %s", ErrOverloaded, g.wait)
+	case <-req.Context().Done():
+		if err := req.Context().Err(); isTimeout(err) {
+			return nil, timeoutError(err)
+		}
+		return nil, fmt.Errorf("%w: %w", ErrClientDisconnected, req.Context().Err())
+	}
+}
+
+// retryAfter is the Retry-After header of the requests that fail with ErrOverloaded, in seconds.
+func (g *gate) retryAfter() string {
+	return strconv.Itoa(max(1, int(g.wait.Round(time.Second).Seconds())))
+}
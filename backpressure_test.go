@@ -0,0 +1,85 @@
+package mps3
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingBackend waits for unblock before uploading.
+type blockingBackend struct {
+	*mps3test.Backend
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (b blockingBackend) Upload(ctx context.Context, in *s3.PutObjectInput) (*manager.UploadOutput, error) {
+	b.started <- struct{}{}
+	<-b.unblock
+	return b.Backend.Upload(ctx, in)
+}
+
+func TestMaxConcurrentUploads(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := blockingBackend{mps3test.NewBackend(), make(chan struct{}, 3), make(chan struct{})}
+	var handled error
+	upload := func(wrapper *Wrapper) *httptest.ResponseRecorder {
+		req, err := newRequest(nil, "test_file2.txt")
+		assert.NoError(err)
+		res := httptest.NewRecorder()
+		wrapper.Wrap(http.NotFoundHandler()).ServeHTTP(res, req)
+		return res
+	}
+	newWrapper := func(wait time.Duration) *Wrapper {
+		wrapper, err := New(Config{
+			Bucket:               bucket,
+			Backend:              backend,
+			MaxConcurrentUploads: 1,
+			UploadQueueTimeout:   wait,
+			ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+				handled = err
+				w.WriteHeader(StatusCode(err))
+			},
+		})
+		assert.NoError(err)
+		return wrapper
+	}
+
+	// without a queue timeout requests fail right away
+	wrapper := newWrapper(0)
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- upload(wrapper) }()
+	<-backend.started
+	res := upload(wrapper)
+	assert.Equal(http.StatusServiceUnavailable, res.Code)
+	assert.Equal("1", res.Header().Get("Retry-After"))
+	assert.ErrorIs(handled, ErrOverloaded)
+	backend.unblock <- struct{}{}
+	assert.Equal(http.StatusNotFound, (<-first).Code)
+
+	// the slot was released
+	go func() { backend.unblock <- struct{}{} }()
+	assert.Equal(http.StatusNotFound, upload(wrapper).Code)
+	<-backend.started
+
+	// with a queue timeout requests wait for a slot
+	wrapper = newWrapper(time.Second)
+	go func() { first <- upload(wrapper) }()
+	<-backend.started
+	second := make(chan *httptest.ResponseRecorder)
+	go func() { second <- upload(wrapper) }()
+	time.Sleep(20 * time.Millisecond)
+	backend.unblock <- struct{}{}
+	assert.Equal(http.StatusNotFound, (<-first).Code)
+	<-backend.started
+	backend.unblock <- struct{}{}
+	assert.Equal(http.StatusNotFound, (<-second).Code)
+}
@@ -0,0 +1,71 @@
+package mps3
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// DangerousExtensions are extensions of files that can be executed by operating systems, browsers
+// or web servers, to be used with Config.BlockedExtensions.
+var DangerousExtensions = []string{
+	".exe", ".dll", ".com", ".scr", ".pif", ".msi", ".msp", ".cpl", ".sys", ".bat", ".cmd",
+	".ps1", ".psm1", ".vbs", ".vbe", ".js", ".jse", ".wsf", ".wsh", ".hta", ".lnk", ".reg",
+	".jar", ".app", ".dmg", ".pkg", ".sh", ".bash", ".zsh", ".command", ".elf", ".so",
+	".dylib", ".php", ".phtml", ".php5", ".phar", ".asp", ".aspx", ".jsp", ".cgi", ".pl", ".py",
+	".html", ".htm", ".xhtml", ".svg", ".shtml", ".htaccess",
+}
+
+// executableSignatures are the magic bytes of executable files and scripts.
+var executableSignatures = [][]byte{
+	[]byte("MZ"),               // Windows PE
+	[]byte("\x7fELF"),          // ELF
+	[]byte("\xfe\xed\xfa\xce"), // Mach-O 32 bit
+	[]byte("\xfe\xed\xfa\xcf"), // Mach-O 64 bit
+	[]byte("\xce\xfa\xed\xfe"), // Mach-O 32 bit, little endian
+	[]byte("\xcf\xfa\xed\xfe"), // Mach-O 64 bit, little endian
+	[]byte("\xca\xfe\xba\xbe"), // Mach-O universal binary and Java class
+	[]byte("#!"),               // scripts
+}
+
+// checkBlocked fails with ErrUnsupportedType if the file has a blocked extension or, with
+// BlockExecutables, its content is an executable.
+func (wr Wrapper) checkBlocked(name string, head []byte) error {
+	if len(wr.blockExts) > 0 {
+		for _, ext := range extensions(name) {
+			if slices.Contains(wr.blockExts, ext) {
+				return fmt.Errorf("%w: extension %q is blocked", ErrUnsupportedType, ext)
+			}
+		}
+	}
+	if wr.blockExec && isExecutable(head) {
+		return fmt.Errorf("%w: executable content", ErrUnsupportedType)
+	}
+	return nil
+}
+
+// extensions returns all the lowercase extensions of the file name, since some web servers use
+// all of them (e.g. "file.php.jpg"). Trailing dots and spaces are ignored like Windows does.
+func extensions(name string) []string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.ToLower(strings.TrimRight(name, ". "))
+	parts := strings.Split(name, ".")
+	exts := make([]string, 0, len(parts)-1)
+	for _, p := range parts[1:] {
+		exts = append(exts, "."+strings.TrimSpace(p))
+	}
+	return exts
+}
+
+// isExecutable returns true if the content starts with the signature of an executable.
+func isExecutable(head []byte) bool {
+	for _, sig := range executableSignatures {
+		if bytes.HasPrefix(head, sig) {
+			return true
+		}
+	}
+	return false
+}
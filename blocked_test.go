@@ -0,0 +1,93 @@
+package mps3
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockedFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		cfg     Config
+		name    string
+		content string
+		code    int
+	}{
+		{Config{BlockedExtensions: DangerousExtensions}, "setup.EXE", "hello", http.StatusUnsupportedMediaType},
+		{Config{BlockedExtensions: DangerousExtensions}, "shell.php.jpg", "hello", http.StatusUnsupportedMediaType},
+		{Config{BlockedExtensions: DangerousExtensions}, "shell.php. ", "hello", http.StatusUnsupportedMediaType},
+		{Config{BlockedExtensions: []string{"EXE"}}, "setup.exe", "hello", http.StatusUnsupportedMediaType},
+		{Config{BlockedExtensions: DangerousExtensions}, "notes.txt", "hello", http.StatusOK},
+		{Config{BlockExecutables: true}, "notes.txt", "#!/bin/sh\nrm -rf /", http.StatusUnsupportedMediaType},
+		{Config{BlockExecutables: true}, "photo.jpg", "\x7fELF\x02\x01\x01", http.StatusUnsupportedMediaType},
+		{Config{BlockExecutables: true}, "photo.jpg", "MZ\x90\x00", http.StatusUnsupportedMediaType},
+		{Config{BlockExecutables: true}, "notes.txt", "hello", http.StatusOK},
+		{Config{}, "setup.exe", "MZ\x90\x00", http.StatusOK},
+	}
+	for _, tt := range tests {
+		backend := mps3test.NewBackend()
+		tt.cfg.Bucket = bucket
+		tt.cfg.Backend = backend
+		tt.cfg.Logger = log.New(io.Discard, "", 0)
+		wrapper, err := New(tt.cfg)
+		assert.NoError(err)
+
+		res := httptest.NewRecorder()
+		wrapper.Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).
+			ServeHTTP(res, newFileRequest(t, tt.name, tt.content))
+		assert.Equal(tt.code, res.Code, tt.name)
+		if tt.code != http.StatusOK {
+			assert.Empty(backend.Objects())
+		}
+	}
+}
+
+func TestExtensions(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal([]string{".php", ".jpg"}, extensions("shell.PHP.jpg"))
+	assert.Equal([]string{".htaccess"}, extensions(".htaccess"))
+	assert.Equal([]string{".exe"}, extensions(`C:\Users\me\setup.exe . `))
+	assert.Empty(extensions("README"))
+}
+
+// newFileRequest returns a request sending a file with the content in the "file" field.
+func newFileRequest(t *testing.T, name, content string) *http.Request {
+	t.Helper()
+	return newTypedFileRequest(t, name, "application/octet-stream", content)
+}
+
+// newTypedFileRequest is like newFileRequest, declaring the content type of the file.
+func newTypedFileRequest(t *testing.T, name, ftype, content string) *http.Request {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	writer := multipart.NewWriter(buf)
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": "file", "filename": name}))
+	h.Set("Content-Type", ftype)
+	part, err := writer.CreatePart(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := part.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", buf)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
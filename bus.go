@@ -0,0 +1,77 @@
+package mps3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// KafkaMessage is a message of NewKafkaNotifier.
+type KafkaMessage struct {
+	Topic string
+	// Key is the key of the file, so the events of a file are in the same partition
+	Key []byte
+	// Value is the event as JSON
+	Value []byte
+	// Headers has the "content-type" ("application/json") and "event" ("mps3.upload") headers
+	Headers map[string]string
+}
+
+// KafkaProducer sends the messages of NewKafkaNotifier to Kafka, usually adapting a client with
+// KafkaProducerFunc, e.g. a Writer of github.com/segmentio/kafka-go:
+//
+//	mps3.KafkaProducerFunc(func(ctx context.Context, m mps3.KafkaMessage) error {
+//		return writer.WriteMessages(ctx, kafka.Message{Topic: m.Topic, Key: m.Key, Value: m.Value})
+//	})
+type KafkaProducer interface {
+	Produce(ctx context.Context, m KafkaMessage) error
+}
+
+// KafkaProducerFunc is a function that implements KafkaProducer.
+type KafkaProducerFunc func(ctx context.Context, m KafkaMessage) error
+
+func (f KafkaProducerFunc) Produce(ctx context.Context, m KafkaMessage) error {
+	return f(ctx, m)
+}
+
+// NewKafkaNotifier returns a Notifier that sends the events as JSON messages to the Kafka topic,
+// keyed by the key of the file.
+func NewKafkaNotifier(producer KafkaProducer, topic string) Notifier {
+	return NotifierFunc(func(ctx context.Context, e UploadEvent) error {
+		body, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		m := KafkaMessage{
+			Topic:   topic,
+			Key:     []byte(e.Key),
+			Value:   body,
+			Headers: map[string]string{"content-type": "application/json", "event": e.Event},
+		}
+		if err := producer.Produce(ctx, m); err != nil {
+			return fmt.Errorf("failed to produce to Kafka: %w", err)
+		}
+		return nil
+	})
+}
+
+// NATSPublisher is the method of *nats.Conn (github.com/nats-io/nats.go) used by
+// NewNATSNotifier.
+type NATSPublisher interface {
+	Publish(subject string, data []byte) error
+}
+
+// NewNATSNotifier returns a Notifier that publishes the events as JSON messages to the NATS
+// subject. Streams of JetStream that capture the subject persist the events.
+func NewNATSNotifier(conn NATSPublisher, subject string) Notifier {
+	return NotifierFunc(func(ctx context.Context, e UploadEvent) error {
+		body, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := conn.Publish(subject, body); err != nil {
+			return fmt.Errorf("failed to publish to NATS: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,50 @@
+package mps3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeNATS struct {
+	subject string
+	data    []byte
+}
+
+func (f *fakeNATS) Publish(subject string, data []byte) error {
+	f.subject, f.data = subject, data
+	return nil
+}
+
+func TestBusNotifiers(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	event := UploadEvent{Event: "mps3.upload", Bucket: bucket, Key: "a.txt", Size: 5}
+	decode := func(b []byte) UploadEvent {
+		var e UploadEvent
+		assert.NoError(json.Unmarshal(b, &e))
+		return e
+	}
+
+	var sent KafkaMessage
+	producer := KafkaProducerFunc(func(ctx context.Context, m KafkaMessage) error {
+		sent = m
+		return nil
+	})
+	assert.NoError(NewKafkaNotifier(producer, "uploads").Notify(ctx, event))
+	assert.Equal("uploads", sent.Topic)
+	assert.Equal([]byte("a.txt"), sent.Key)
+	assert.Equal(map[string]string{"content-type": "application/json", "event": "mps3.upload"}, sent.Headers)
+	assert.Equal(event, decode(sent.Value))
+
+	failing := KafkaProducerFunc(func(ctx context.Context, m KafkaMessage) error { return errors.New("no brokers") })
+	assert.ErrorContains(NewKafkaNotifier(failing, "uploads").Notify(ctx, event), "no brokers")
+
+	conn := &fakeNATS{}
+	assert.NoError(NewNATSNotifier(conn, "uploads.created").Notify(ctx, event))
+	assert.Equal("uploads.created", conn.subject)
+	assert.Equal(event, decode(conn.data))
+}
@@ -0,0 +1,152 @@
+package mps3
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// contentKey returns the content addressable key of the hex encoded SHA-256 digest.
+func contentKey(digest string) string {
+	return "/sha256/" + digest[:2] + "/" + digest[2:4] + "/" + digest
+}
+
+// moveToContentKey copies the uploaded file to its content addressable key and removes the
+// uploaded one, the file is updated with its new location. If it fails the uploaded file is
+// removed too.
+func (wr Wrapper) moveToContentKey(req *http.Request, in *s3.PutObjectInput, f *file) (err error) {
+	ctx := req.Context()
+	defer func() {
+		if err == nil {
+			return
+		}
+		// it usually fails because the request was canceled
+		din := &s3.DeleteObjectInput{Bucket: in.Bucket, Key: in.Key, RequestPayer: in.RequestPayer}
+		if _, derr := wr.backend.Delete(context.WithoutCancel(ctx), din); derr != nil {
+			wr.log(req).ErrorContext(ctx, "failed to delete file", "key", aws.ToString(in.Key), "error", derr)
+			return
+		}
+		f.stored = false
+	}()
+
+	key := contentKey(f.sha256)
+	bucket, err := wr.bucketFor(req, key)
+	if err != nil {
+		return err
+	}
+
+	var existing *s3.HeadObjectOutput
+	if wr.dedup {
+		if existing, err = wr.head(ctx, in, bucket, key); err != nil {
+			wr.log(req).ErrorContext(ctx, "failed to check if file exists", "key", key, "error", err)
+		}
+	}
+	if existing != nil {
+		f.duplicate = true
+		f.version = aws.ToString(existing.VersionId)
+		f.etag = aws.ToString(existing.ETag)
+	} else {
+		out, err := wr.backend.Copy(ctx, copyInput(in, bucket, key))
+		if err != nil {
+			return fmt.Errorf("%w: failed to copy file to %q: %w", ErrS3Upload, key, err)
+		}
+		f.version = aws.ToString(out.VersionId)
+		if out.CopyObjectResult != nil {
+			f.etag = aws.ToString(out.CopyObjectResult.ETag)
+		}
+	}
+
+	if _, err := wr.backend.Delete(ctx, &s3.DeleteObjectInput{Bucket: in.Bucket, Key: in.Key, RequestPayer: in.RequestPayer}); err != nil {
+		wr.log(req).ErrorContext(ctx, "failed to delete file", "key", aws.ToString(in.Key), "error", err)
+	}
+	f.bucket = bucket
+	f.key = key
+	return nil
+}
+
+// discardDuplicate checks if a file with the declared digest is already stored, in which case
+// the file is read to verify the digest instead of being uploaded.
+func (wr Wrapper) discardDuplicate(req *http.Request, in *s3.PutObjectInput, counter *bytesCounter, declared string, f *file) (bool, error) {
+	declared = strings.ToLower(declared)
+	if b, err := hex.DecodeString(declared); err != nil || len(b) != sha256.Size {
+		return false, nil
+	}
+
+	key := contentKey(declared)
+	bucket, err := wr.bucketFor(req, key)
+	if err != nil {
+		return false, err
+	}
+	existing, err := wr.head(req.Context(), in, bucket, key)
+	if err != nil {
+		wr.log(req).ErrorContext(req.Context(), "failed to check if file exists", "key", key, "error", err)
+	}
+	if existing == nil {
+		return false, nil
+	}
+
+	if _, err := io.Copy(io.Discard, counter); err != nil {
+		if counter.invalid != nil {
+			return false, counter.invalid
+		}
+		return false, fmt.Errorf("%w: failed to read file part: %w", ErrMalformedMultipart, err)
+	}
+	if digest := hex.EncodeToString(counter.sha256.Sum(nil)); digest != declared {
+		return false, fmt.Errorf("file digest %q doesn't match the declared digest %q", digest, declared)
+	}
+	f.version = aws.ToString(existing.VersionId)
+	f.etag = aws.ToString(existing.ETag)
+	return true, nil
+}
+
+// head returns the object stored under the key or nil if it doesn't exist, in is the input
+// used to upload the file.
+func (wr Wrapper) head(ctx context.Context, in *s3.PutObjectInput, bucket, key string) (*s3.HeadObjectOutput, error) {
+	out, err := wr.backend.Head(ctx, &s3.HeadObjectInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key),
+		RequestPayer:         in.RequestPayer,
+		SSECustomerAlgorithm: in.SSECustomerAlgorithm,
+		SSECustomerKey:       in.SSECustomerKey,
+		SSECustomerKeyMD5:    in.SSECustomerKeyMD5,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+// copyInput returns the input to copy an uploaded file to another key keeping its settings,
+// content type, metadata and tags are copied by S3.
+func copyInput(in *s3.PutObjectInput, bucket, key string) *s3.CopyObjectInput {
+	return &s3.CopyObjectInput{
+		Bucket:                         aws.String(bucket),
+		Key:                            aws.String(key),
+		CopySource:                     aws.String(CopySource(aws.ToString(in.Bucket), aws.ToString(in.Key))),
+		ACL:                            in.ACL,
+		StorageClass:                   in.StorageClass,
+		ChecksumAlgorithm:              in.ChecksumAlgorithm,
+		ServerSideEncryption:           in.ServerSideEncryption,
+		SSEKMSKeyId:                    in.SSEKMSKeyId,
+		SSECustomerAlgorithm:           in.SSECustomerAlgorithm,
+		SSECustomerKey:                 in.SSECustomerKey,
+		SSECustomerKeyMD5:              in.SSECustomerKeyMD5,
+		CopySourceSSECustomerAlgorithm: in.SSECustomerAlgorithm,
+		CopySourceSSECustomerKey:       in.SSECustomerKey,
+		CopySourceSSECustomerKeyMD5:    in.SSECustomerKeyMD5,
+		ObjectLockMode:                 in.ObjectLockMode,
+		ObjectLockRetainUntilDate:      in.ObjectLockRetainUntilDate,
+		ObjectLockLegalHoldStatus:      in.ObjectLockLegalHoldStatus,
+		RequestPayer:                   in.RequestPayer,
+	}
+}
@@ -0,0 +1,99 @@
+package mps3
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentAddressableKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	backend, form, res := uploadToMemory(t, Config{ContentAddressable: true}, nil, "test_file2.txt", "test_file2.txt")
+	assert.Equal(200, res.Code)
+
+	content, _ := os.ReadFile("test_file2.txt")
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+	key := "/sha256/" + digest[:2] + "/" + digest[2:4] + "/" + digest
+
+	assert.Equal([]string{key, key}, form["file"])
+	assert.Len(backend.Objects(), 1)
+	obj, ok := backend.Object(bucket, key)
+	assert.True(ok)
+	assert.Equal(content, obj.Body)
+	assert.Equal("text/plain; charset=utf-8", *obj.Input.ContentType)
+}
+
+func TestDeduplicate(t *testing.T) {
+	assert := assert.New(t)
+
+	content, _ := os.ReadFile("test_file2.txt")
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+
+	backend, form, res := uploadToMemory(t, Config{ContentAddressable: true, Deduplicate: true}, nil, "test_file2.txt", "test_file2.txt")
+	assert.Equal(200, res.Code)
+	assert.Equal([]string{"false", "true"}, form["file_duplicate"])
+	assert.Len(backend.Objects(), 1)
+
+	// the digest is declared before the file so it's not uploaded
+	_, form, res = uploadToMemory(t, Config{
+		ContentAddressable: true,
+		Deduplicate:        true,
+		Backend:            backend,
+	}, map[string]string{"file_sha256": digest}, "test_file2.txt")
+	assert.Equal(200, res.Code)
+	assert.Equal([]string{"true"}, form["file_duplicate"])
+	assert.Equal("12", form.Get("file_size"))
+	assert.Len(backend.Objects(), 1)
+
+	// declared digest doesn't match the content
+	_, _, res = uploadToMemory(t, Config{
+		ContentAddressable: true,
+		Deduplicate:        true,
+		Backend:            backend,
+	}, map[string]string{"file_sha256": digest}, "test_file1.png")
+	assert.Equal(500, res.Code)
+}
+
+// copyFailingBackend fails to copy files.
+type copyFailingBackend struct{ *mps3test.Backend }
+
+func (copyFailingBackend) Copy(context.Context, *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	return nil, errors.New("copy failed")
+}
+
+// presignFailingBackend fails to presign URLs.
+type presignFailingBackend struct{ *mps3test.Backend }
+
+func (presignFailingBackend) PresignGet(context.Context, *s3.GetObjectInput, time.Duration) (string, error) {
+	return "", errors.New("presign failed")
+}
+
+func TestRollbackAfterUpload(t *testing.T) {
+	assert := assert.New(t)
+
+	// the uploaded file is removed when it can't be moved to its content addressable key
+	backend := mps3test.NewBackend()
+	cfg := Config{ContentAddressable: true, Backend: copyFailingBackend{backend}}
+	_, _, res := uploadToMemory(t, cfg, nil, "test_file2.txt")
+	assert.Equal(http.StatusInternalServerError, res.Code)
+	assert.Empty(backend.Objects())
+
+	// or when a later step fails, like presigning its URL
+	backend = mps3test.NewBackend()
+	cfg = Config{PresignExpiry: time.Minute, Backend: presignFailingBackend{backend}}
+	_, _, res = uploadToMemory(t, cfg, nil, "test_file2.txt")
+	assert.Equal(http.StatusInternalServerError, res.Code)
+	assert.Empty(backend.Objects())
+}
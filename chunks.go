@@ -0,0 +1,389 @@
+package mps3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// chunkStatePrefix is where the state of chunked uploads is stored.
+const chunkStatePrefix = ".chunks/"
+
+// maxChunkSize is the maximum size of a chunk, chunks are kept in memory while they're uploaded.
+const maxChunkSize = 100 << 20
+
+// maxChunks is the maximum number of parts of a S3 multipart upload.
+const maxChunks = 10000
+
+// chunkConvention are the form fields of a chunking library, sent before the file.
+type chunkConvention struct {
+	id, index, total, size, name string
+	// first is the index of the first chunk
+	first int
+}
+
+var chunkConventions = []chunkConvention{
+	{id: "dzuuid", index: "dzchunkindex", total: "dztotalchunkcount", size: "dztotalfilesize"},
+	{id: "resumableIdentifier", index: "resumableChunkNumber", total: "resumableTotalChunks", size: "resumableTotalSize", name: "resumableFilename", first: 1},
+	{id: "flowIdentifier", index: "flowChunkNumber", total: "flowTotalChunks", size: "flowTotalSize", name: "flowFilename", first: 1},
+	{id: "uploadId", index: "chunkIndex", total: "totalChunks"},
+}
+
+// chunk is a chunk of a file, index is zero based.
+type chunk struct {
+	id    string
+	index int
+	total int
+	size  int64
+	name  string
+}
+
+// chunkOf returns the chunk described by the form values sent before the file, if any.
+func chunkOf(form map[string][]string) (chunk, bool, error) {
+	get := func(name string) string {
+		if v := form[name]; len(v) > 0 {
+			return v[len(v)-1]
+		}
+		return ""
+	}
+	for _, cc := range chunkConventions {
+		id := get(cc.id)
+		if id == "" || get(cc.index) == "" {
+			continue
+		}
+		c := chunk{id: id}
+		var err error
+		if c.index, err = strconv.Atoi(get(cc.index)); err != nil {
+			return c, false, fmt.Errorf("%w: invalid %s", ErrMalformedMultipart, cc.index)
+		}
+		c.index -= cc.first
+		if c.total, err = strconv.Atoi(get(cc.total)); err != nil {
+			return c, false, fmt.Errorf("%w: invalid %s", ErrMalformedMultipart, cc.total)
+		}
+		if c.total < 1 || c.total > maxChunks || c.index < 0 || c.index >= c.total {
+			return c, false, fmt.Errorf("%w: invalid chunk %d of %d", ErrMalformedMultipart, c.index, c.total)
+		}
+		if cc.size != "" && get(cc.size) != "" {
+			if c.size, err = strconv.ParseInt(get(cc.size), 10, 64); err != nil {
+				return c, false, fmt.Errorf("%w: invalid %s", ErrMalformedMultipart, cc.size)
+			}
+		}
+		if cc.name != "" {
+			c.name = get(cc.name)
+		}
+		return c, true, nil
+	}
+	return chunk{}, false, nil
+}
+
+// chunkSession is the state of a chunked upload, stored as JSON in the bucket.
+type chunkSession struct {
+	UploadID string    `json:"upload_id"`
+	Bucket   string    `json:"bucket"`
+	Key      string    `json:"key"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Total    int       `json:"total"`
+	Created  time.Time `json:"created"`
+}
+
+// storeChunk uploads the chunk as a part of the multipart upload of its file, which is completed
+// and added to the result like other files when all chunks were uploaded.
+func (wr Wrapper) storeChunk(req *http.Request, part *multipart.Part, body io.Reader, res *result, c chunk) error {
+	field := part.FormName()
+	name := wr.filename(part)
+	if c.name != "" {
+		name = wr.filename(filePart(field, c.name, ""))
+	}
+	err := wr.checkToken(req, field)
+	if err == nil {
+		err = wr.uploadChunk(req, part, body, res, c, name)
+	}
+	if err != nil {
+		return &FileError{Field: field, Name: name, Err: err}
+	}
+	return nil
+}
+
+func (wr Wrapper) uploadChunk(req *http.Request, part *multipart.Part, body io.Reader, res *result, c chunk, name string) error {
+	ctx := req.Context()
+	field := part.FormName()
+	limit := tokenSizeLimit(req, wr.sizeLimit(field))
+	if limit > 0 && c.size > limit {
+		return fmt.Errorf("%w: larger than %d bytes", ErrTooLarge, limit)
+	}
+
+	// chunks are kept in memory, S3 requires the size of the parts
+	content, err := io.ReadAll(io.LimitReader(body, maxChunkSize+1))
+	if err != nil {
+		return fmt.Errorf("%w: failed to read file part: %w", ErrMalformedMultipart, err)
+	}
+	if len(content) > maxChunkSize {
+		return fmt.Errorf("%w: chunk larger than %d bytes", ErrTooLarge, maxChunkSize)
+	}
+	if limit > 0 && int64(len(content)) > limit {
+		return fmt.Errorf("%w: larger than %d bytes", ErrTooLarge, limit)
+	}
+	if q := quotaOf(req); q != nil {
+		if err := q.add(len(content), true); err != nil {
+			return err
+		}
+	}
+
+	stateBucket, stateKey, err := wr.chunkState(req, c.id)
+	if err != nil {
+		return err
+	}
+	// only the first chunk has the content the type is detected from
+	var ftype string
+	if c.index == 0 {
+		if ftype, err = wr.checkChunk(req, part, name, content[:min(len(content), wr.sniffSize)]); err != nil {
+			// the chunks sent before it may have started the upload
+			var s chunkSession
+			if wr.loadState(ctx, stateBucket, stateKey, &s) == nil {
+				wr.abortChunks(ctx, s, stateBucket, stateKey)
+			}
+			return err
+		}
+	} else if err := wr.checkBlocked(name, nil); err != nil {
+		return err
+	}
+	s, err := wr.chunkSession(req, part, c, name, ftype, stateBucket, stateKey)
+	if err != nil {
+		return err
+	}
+	// the upload may have been started by a request with another token
+	if err := checkTokenType(req, s.Type); err != nil {
+		return err
+	}
+
+	mu := wr.backend.(MultipartUploader)
+	_, err = mu.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:            aws.String(s.Bucket),
+		Key:               aws.String(s.Key),
+		UploadId:          aws.String(s.UploadID),
+		PartNumber:        aws.Int32(int32(c.index + 1)),
+		Body:              bytes.NewReader(content),
+		ContentLength:     aws.Int64(int64(len(content))),
+		ChecksumAlgorithm: types.ChecksumAlgorithm(wr.checksumAlgo),
+		RequestPayer:      wr.payer(),
+	})
+	if err != nil {
+		return fmt.Errorf("%w: failed to upload chunk %d: %w", ErrS3Upload, c.index, err)
+	}
+
+	// chunks may be sent in parallel, the upload is completed by the request that sees all of them
+	parts, err := listParts(ctx, mu, s.Bucket, s.Key, s.UploadID, wr.payer())
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrS3Upload, err)
+	}
+	if len(parts) < s.Total {
+		return nil
+	}
+	return wr.completeChunks(req, field, s, parts, stateBucket, stateKey, res)
+}
+
+// completeChunks stores the file made of the chunks and adds it to the result.
+func (wr Wrapper) completeChunks(req *http.Request, field string, s chunkSession, parts []types.Part, stateBucket, stateKey string, res *result) error {
+	ctx := req.Context()
+	mu := wr.backend.(MultipartUploader)
+	f := file{name: s.Name, ftype: s.Type, key: s.Key, bucket: s.Bucket}
+	completed := make([]types.CompletedPart, 0, len(parts))
+	for _, p := range parts {
+		f.size += aws.ToInt64(p.Size)
+		completed = append(completed, completedPart(p))
+	}
+
+	var invalid error
+	if limit := tokenSizeLimit(req, wr.sizeLimit(field)); limit > 0 && f.size > limit {
+		invalid = fmt.Errorf("%w: larger than %d bytes", ErrTooLarge, limit)
+	} else if f.size < wr.minSize {
+		invalid = fmt.Errorf("%w: smaller than %d bytes", ErrTooSmall, wr.minSize)
+	}
+	var out *s3.CompleteMultipartUploadOutput
+	if invalid == nil {
+		in := &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(s.Bucket),
+			Key:             aws.String(s.Key),
+			UploadId:        aws.String(s.UploadID),
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+			RequestPayer:    wr.payer(),
+		}
+		if wr.exclusive {
+			in.IfNoneMatch = aws.String("*")
+		}
+		var err error
+		out, err = mu.CompleteMultipartUpload(ctx, in)
+		var nsu *types.NoSuchUpload
+		var aerr smithy.APIError
+		switch {
+		case errors.As(err, &nsu):
+			// completed by the request of another chunk
+			return nil
+		case isPreconditionFailed(err):
+			invalid = fmt.Errorf("%w: %q", ErrKeyExists, s.Key)
+		case errors.As(err, &aerr) && aerr.ErrorCode() == "EntityTooSmall":
+			invalid = fmt.Errorf("%w: chunks other than the last must have at least 5MB", ErrMalformedMultipart)
+		case err != nil:
+			return fmt.Errorf("%w: failed to complete upload: %w", ErrS3Upload, err)
+		}
+	}
+	if invalid != nil {
+		wr.abortChunks(ctx, s, stateBucket, stateKey)
+		return invalid
+	}
+	if err := wr.deleteState(ctx, stateBucket, stateKey); err != nil {
+		wr.log(req).ErrorContext(ctx, "failed to delete chunked upload state", "key", stateKey, "error", err)
+	}
+
+	f.etag = aws.ToString(out.ETag)
+	f.version = aws.ToString(out.VersionId)
+	if wr.presignTTL > 0 {
+		var err error
+		if f.url, err = wr.presign(ctx, f, wr.presignTTL); err != nil {
+			return err
+		}
+	}
+	uf, err := wr.scanStored(req, wr.uploadedFile(field, f))
+	if err != nil {
+		return err
+	}
+	if wr.uploadMS {
+		uf.UploadMS = time.Since(s.Created).Milliseconds()
+	}
+	if wr.onComplete != nil {
+		herr := wr.onComplete(req, uf, time.Since(s.Created), nil)
+		if wr.quarantined(herr) {
+			if uf, err = wr.quarantineFile(req, uf, herr); err != nil {
+				return err
+			}
+		} else if herr != nil {
+			res.uploaded = append(res.uploaded, uf)
+			return fmt.Errorf("file rejected by OnUploadComplete: %w", herr)
+		}
+	}
+	res.uploaded = append(res.uploaded, uf)
+	res.stubs[field] = append(res.stubs[field], fileHeader(uf, nil))
+	return wr.appendFile(res.form, uf)
+}
+
+// checkChunk validates the first chunk of the file like the middleware does with the files, it
+// returns the type detected from head.
+func (wr Wrapper) checkChunk(req *http.Request, part *multipart.Part, name string, head []byte) (string, error) {
+	if err := wr.checkBlocked(name, head); err != nil {
+		return "", err
+	}
+	if wr.mismatch {
+		if err := checkMismatch(name, part.Header.Get("Content-Type"), head); err != nil {
+			return "", err
+		}
+	}
+	ftype := wr.detectType(head, name)
+	if err := wr.checkType(part.FormName(), ftype); err != nil {
+		return "", err
+	}
+	return ftype, checkTokenType(req, ftype)
+}
+
+// chunkSession returns the upload of the chunks of the file, the first chunk to arrive starts it.
+// The type of the file is ftype if it's the first chunk, otherwise the declared one.
+func (wr Wrapper) chunkSession(req *http.Request, part *multipart.Part, c chunk, name, ftype, stateBucket, stateKey string) (chunkSession, error) {
+	ctx := req.Context()
+	var s chunkSession
+	err := wr.loadState(ctx, stateBucket, stateKey, &s)
+	if !errors.Is(err, errNoState) {
+		if err == nil && s.Total != c.total {
+			err = fmt.Errorf("%w: chunk of an upload of %d chunks sent as %d chunks", ErrMalformedMultipart, s.Total, c.total)
+		}
+		return s, err
+	}
+
+	field := part.FormName()
+	f := file{name: name, ftype: ftype}
+	if f.ftype == "" {
+		f.ftype = mediaType(part.Header.Get("Content-Type"))
+	}
+	if f.ftype == "" || f.ftype == "application/octet-stream" {
+		f.ftype = wr.detectType(nil, name)
+	}
+	if err := wr.checkType(field, f.ftype); err != nil {
+		return s, err
+	}
+	if err := checkTokenType(req, f.ftype); err != nil {
+		return s, err
+	}
+	if err := wr.locate(req, field, &f); err != nil {
+		return s, err
+	}
+	in := wr.objectInput(req, f, wr.fieldCfgs[field])
+	out, err := wr.backend.(MultipartUploader).CreateMultipartUpload(ctx, createMultipartInput(in))
+	if err != nil {
+		return s, fmt.Errorf("%w: failed to create multipart upload: %w", ErrS3Upload, err)
+	}
+	s = chunkSession{
+		UploadID: aws.ToString(out.UploadId),
+		Bucket:   f.bucket,
+		Key:      f.key,
+		Name:     f.name,
+		Type:     f.ftype,
+		Total:    c.total,
+		Created:  time.Now().UTC(),
+	}
+
+	// the state is only written if it doesn't exist, if another chunk started the upload first
+	// this one is discarded
+	err = wr.saveState(ctx, stateBucket, stateKey, s, true)
+	if errors.Is(err, ErrKeyExists) {
+		wr.abortChunks(ctx, s, "", "")
+		s = chunkSession{}
+		err = wr.loadState(ctx, stateBucket, stateKey, &s)
+	}
+	return s, err
+}
+
+// chunkState returns where the state of the chunked upload is stored, the ID is hashed since it's
+// chosen by the client.
+func (wr Wrapper) chunkState(req *http.Request, id string) (string, string, error) {
+	sum := sha256.Sum256([]byte(id))
+	key := chunkStatePrefix + hex.EncodeToString(sum[:]) + ".json"
+	bucket := wr.bucket
+	if bucket == "" {
+		var err error
+		if bucket, err = wr.bucketFor(req, key); err != nil {
+			return "", "", err
+		}
+	}
+	return bucket, key, nil
+}
+
+// abortChunks removes the multipart upload and its state, if stateKey is set.
+func (wr Wrapper) abortChunks(ctx context.Context, s chunkSession, stateBucket, stateKey string) {
+	ctx = context.WithoutCancel(ctx)
+	_, err := wr.backend.(MultipartUploader).AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:       aws.String(s.Bucket),
+		Key:          aws.String(s.Key),
+		UploadId:     aws.String(s.UploadID),
+		RequestPayer: wr.payer(),
+	})
+	if err != nil {
+		wr.logger.ErrorContext(ctx, "failed to abort multipart upload", "upload_id", s.UploadID, "key", s.Key, "error", err)
+	}
+	if stateKey != "" {
+		if err := wr.deleteState(ctx, stateBucket, stateKey); err != nil {
+			wr.logger.ErrorContext(ctx, "failed to delete chunked upload state", "key", stateKey, "error", err)
+		}
+	}
+}
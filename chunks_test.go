@@ -0,0 +1,158 @@
+package mps3
+
+import (
+	"bytes"
+	"crypto/rand"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+// newChunkRequest returns a request with the fields followed by the chunk.
+func newChunkRequest(t *testing.T, content []byte, fields ...string) *http.Request {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for i := 0; i < len(fields); i += 2 {
+		assert.NoError(t, mw.WriteField(fields[i], fields[i+1]))
+	}
+	fw, err := mw.CreateFormFile("file", "video.mp4")
+	assert.NoError(t, err)
+	_, _ = fw.Write(content)
+	assert.NoError(t, mw.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestChunkedUploads(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, ChunkedUploads: true})
+	assert.NoError(err)
+
+	content := make([]byte, 11<<20)
+	_, _ = rand.Read(content)
+	chunks := [][]byte{content[:5<<20], content[5<<20 : 10<<20], content[10<<20:]}
+
+	// chunks may arrive in any order, the request that completes the file gets its form values
+	var key string
+	for i, index := range []string{"2", "0", "1"} {
+		req := newChunkRequest(t, chunks[[]int{2, 0, 1}[i]], "dzuuid", "6f1c7a56", "dzchunkindex", index, "dztotalchunkcount", "3")
+		res := httptest.NewRecorder()
+		wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			key = req.FormValue("file")
+			if i < 2 {
+				assert.Empty(key)
+				assert.Empty(FilesFromContext(req.Context()))
+				return
+			}
+			assert.Equal("video.mp4", req.FormValue("file_name"))
+			assert.Equal("11534336", req.FormValue("file_size"))
+		})).ServeHTTP(res, req)
+		assert.Equal(http.StatusOK, res.Code)
+	}
+
+	obj, ok := backend.Object(bucket, key)
+	assert.True(ok)
+	assert.Equal(content, obj.Body)
+	assert.Equal(0, backend.Uploads())
+	assert.Len(backend.Objects(), 1)
+
+	// a single Resumable.js chunk, numbered from 1
+	req := newChunkRequest(t, []byte("hello"), "resumableIdentifier", "5-hellotxt", "resumableChunkNumber", "1",
+		"resumableTotalChunks", "1", "resumableFilename", "hello.txt")
+	res := httptest.NewRecorder()
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal("hello.txt", req.FormValue("file_name"))
+		obj, ok := backend.Object(bucket, req.FormValue("file"))
+		assert.True(ok)
+		assert.Equal("hello", string(obj.Body))
+	})).ServeHTTP(res, req)
+	assert.Equal(http.StatusOK, res.Code)
+}
+
+func TestChunkedUploadErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, ChunkedUploads: true})
+	assert.NoError(err)
+	handler := wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	for _, fields := range [][]string{
+		{"uploadId", "a", "chunkIndex", "2", "totalChunks", "2"},
+		{"uploadId", "a", "chunkIndex", "x", "totalChunks", "2"},
+		{"uploadId", "a", "chunkIndex", "0", "totalChunks", "0"},
+	} {
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, newChunkRequest(t, []byte("hello"), fields...))
+		assert.Equal(http.StatusBadRequest, res.Code, fields)
+	}
+
+	// chunks other than the last are too small, the upload is removed
+	for _, index := range []string{"0", "1"} {
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, newChunkRequest(t, []byte("hello"), "uploadId", "b", "chunkIndex", index, "totalChunks", "2"))
+		if index == "0" {
+			assert.Equal(http.StatusOK, res.Code)
+		} else {
+			assert.Equal(http.StatusBadRequest, res.Code)
+		}
+	}
+	assert.Equal(0, backend.Uploads())
+	assert.Empty(backend.Objects())
+
+	// options that need the whole file can't be used
+	for _, cfg := range []Config{
+		{SanitizeSVG: true},
+		{StripMetadata: true},
+		{ImageLimits: &ImageLimits{MaxWidth: 100}},
+		{ArchiveLimits: &ArchiveLimits{MaxEntries: 10}},
+	} {
+		cfg.Bucket, cfg.Backend, cfg.ChunkedUploads = bucket, backend, true
+		_, err := New(cfg)
+		assert.ErrorContains(err, "ChunkedUploads can't be used")
+	}
+}
+
+func TestChunkedUploadContent(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, ChunkedUploads: true, AllowedTypes: []string{"image/*"}, RejectTypeMismatch: true})
+	assert.NoError(err)
+	// the chunks of x.png, declared as a PNG image
+	upload := func(content []byte, index string) int {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		for _, kv := range [][2]string{{"uploadId", "a"}, {"chunkIndex", index}, {"totalChunks", "2"}} {
+			assert.NoError(mw.WriteField(kv[0], kv[1]))
+		}
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", `form-data; name="file"; filename="x.png"`)
+		h.Set("Content-Type", "image/png")
+		fw, err := mw.CreatePart(h)
+		assert.NoError(err)
+		_, _ = fw.Write(content)
+		assert.NoError(mw.Close())
+		req := httptest.NewRequest(http.MethodPost, "/", body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		res := httptest.NewRecorder()
+		wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})).ServeHTTP(res, req)
+		return res.Code
+	}
+
+	// the first chunk is checked, the upload started by the other chunks is removed
+	assert.Equal(http.StatusOK, upload([]byte("world"), "1"))
+	assert.Equal(1, backend.Uploads())
+	assert.Equal(http.StatusUnsupportedMediaType, upload([]byte("<html><body>hello</body></html>"), "0"))
+	assert.Equal(0, backend.Uploads())
+	assert.Empty(backend.Objects())
+}
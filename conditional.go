@@ -0,0 +1,29 @@
+package mps3
+
+import (
+	"errors"
+	"net/http"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+)
+
+// errPreconditionFailed is the error of S3 when a conditional write fails, returned by the other
+// backends too.
+func errPreconditionFailed(key string) error {
+	return &smithy.GenericAPIError{Code: "PreconditionFailed", Message: "object " + key + " already exists"}
+}
+
+// isPreconditionFailed returns true if the error means a conditional write failed because the
+// object already exists, or another conditional write of the same key is in progress.
+func isPreconditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			return true
+		}
+	}
+	var re *awshttp.ResponseError
+	return errors.As(err, &re) && re.HTTPStatusCode() == http.StatusPreconditionFailed
+}
@@ -0,0 +1,56 @@
+package mps3
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreventOverwrite(t *testing.T) {
+	assert := assert.New(t)
+
+	backends := map[string]Backend{
+		"memory": mps3test.NewBackend(),
+		"local":  NewLocalBackend(t.TempDir()),
+	}
+	for name, backend := range backends {
+		for _, prevent := range []bool{false, true} {
+			wrapper, err := New(Config{
+				Bucket:           bucket,
+				Backend:          backend,
+				KeyTemplate:      "/{{.Filename}}",
+				PreventOverwrite: prevent,
+				Logger:           log.New(io.Discard, "", 0),
+			})
+			assert.NoError(err)
+			handler := wrapper.Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+			// the first upload only succeeds before the file exists
+			res := httptest.NewRecorder()
+			handler.ServeHTTP(res, newFileRequest(t, name+".txt", "first"))
+			if !prevent {
+				assert.Equal(http.StatusOK, res.Code, name)
+			}
+
+			res = httptest.NewRecorder()
+			handler.ServeHTTP(res, newFileRequest(t, name+".txt", "second"))
+			if prevent {
+				assert.Equal(http.StatusConflict, res.Code, name)
+			} else {
+				assert.Equal(http.StatusOK, res.Code, name)
+			}
+
+			uf := UploadedFile{Key: "/" + name + ".txt", Bucket: bucket, wr: wrapper}
+			r, err := uf.Open(t.Context())
+			assert.NoError(err)
+			content, _ := io.ReadAll(r)
+			r.Close()
+			assert.Equal("second", string(content), name)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package mps3
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// maxDataURIHeader is how many bytes are read looking for the `;base64,` of a data URI.
+const maxDataURIHeader = 512
+
+// readDataURI checks if the value read from r is a base64 data URI. If it is, it returns a part
+// of the field describing the file and a reader of its decoded content, otherwise a nil part. The
+// returned reader must be used instead of r in both cases.
+func readDataURI(field string, r io.Reader) (*multipart.Part, io.Reader) {
+	br := bufio.NewReaderSize(r, maxDataURIHeader)
+	head, _ := br.Peek(maxDataURIHeader)
+	if !bytes.HasPrefix(head, []byte("data:")) {
+		return nil, br
+	}
+	end := bytes.Index(head, []byte(";base64,"))
+	if end < 0 {
+		return nil, br
+	}
+
+	// the media type may have a name parameter, e.g. data:image/png;name=photo.png;base64,...
+	ftype, params, err := mime.ParseMediaType(string(head[len("data:"):end]))
+	if err != nil {
+		ftype, params = "", nil
+	}
+	name := params["name"]
+	if name == "" {
+		name = params["filename"]
+	}
+	if name == "" {
+		name = "file"
+	}
+	_, _ = br.Discard(end + len(";base64,"))
+	return filePart(field, name, ftype), newBase64Reader(br)
+}
+
+// base64Reader decodes base64 content, failing with ErrMalformedMultipart if it's invalid.
+type base64Reader struct {
+	r io.Reader
+}
+
+func newBase64Reader(r io.Reader) io.Reader {
+	return base64Reader{r: base64.NewDecoder(base64.StdEncoding, r)}
+}
+
+func (br base64Reader) Read(b []byte) (int, error) {
+	n, err := br.r.Read(b)
+	if err != nil && err != io.EOF {
+		// the error isn't wrapped, truncated content would be taken for the end of the file
+		err = fmt.Errorf("%w: invalid base64 content: %v", ErrMalformedMultipart, err)
+	}
+	return n, err
+}
+
+// filePart returns a part of the field describing a file that wasn't sent as a multipart file,
+// the part has no content.
+func filePart(field, name, ftype string) *multipart.Part {
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": field, "filename": name}))
+	if ftype != "" && !strings.HasPrefix(ftype, "application/octet-stream") {
+		h.Set("Content-Type", ftype)
+	}
+	return &multipart.Part{Header: h}
+}
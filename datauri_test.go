@@ -0,0 +1,61 @@
+package mps3
+
+import (
+	"bytes"
+	"encoding/base64"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataURIFields(t *testing.T) {
+	assert := assert.New(t)
+
+	png, err := os.ReadFile("test_file1.png")
+	assert.NoError(err)
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, DataURIFields: true})
+	assert.NoError(err)
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	assert.NoError(mw.WriteField("photo", "data:image/png;name=photo.png;base64,"+base64.StdEncoding.EncodeToString(png)))
+	assert.NoError(mw.WriteField("snapshot", "data:image/png;base64,"+base64.StdEncoding.EncodeToString(png)))
+	assert.NoError(mw.WriteField("title", "data: not a file"))
+	assert.NoError(mw.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	res := httptest.NewRecorder()
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal("data: not a file", req.FormValue("title"))
+		assert.Equal("photo.png", req.FormValue("photo_name"))
+		assert.Equal("file", req.FormValue("snapshot_name"))
+		for _, field := range []string{"photo", "snapshot"} {
+			assert.Equal("image/png", req.FormValue(field+"_type"))
+			obj, ok := backend.Object(bucket, req.FormValue(field))
+			assert.True(ok)
+			assert.Equal(png, obj.Body)
+		}
+	})).ServeHTTP(res, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	// invalid base64 fails the request
+	body.Reset()
+	mw = multipart.NewWriter(body)
+	assert.NoError(mw.WriteField("photo", "data:image/png;base64,!!!!"))
+	assert.NoError(mw.Close())
+	req = httptest.NewRequest(http.MethodPost, "/", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	res = httptest.NewRecorder()
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t.Error("handler should not be called")
+	})).ServeHTTP(res, req)
+	assert.Equal(http.StatusBadRequest, res.Code)
+}
@@ -0,0 +1,80 @@
+package mps3
+
+import (
+	"mime"
+	"net/http"
+	"path/filepath"
+
+	"github.com/h2non/filetype"
+)
+
+// Detector detects the content type of the uploaded files, see Config.Detector.
+type Detector interface {
+	// Detect returns the content type of the file from its first bytes (Config.SniffSize, or less
+	// for smaller files) and its name, or an empty string if it doesn't recognize it. The first
+	// bytes are empty when only the name is known, e.g. when a chunked upload starts.
+	Detect(head []byte, filename string) string
+}
+
+// DetectorFunc is a function that implements Detector.
+type DetectorFunc func(head []byte, filename string) string
+
+func (f DetectorFunc) Detect(head []byte, filename string) string {
+	return f(head, filename)
+}
+
+// Detectors tries each detector in order, returning the first content type detected.
+type Detectors []Detector
+
+func (d Detectors) Detect(head []byte, filename string) string {
+	for _, detector := range d {
+		if t := detector.Detect(head, filename); t != "" {
+			return t
+		}
+	}
+	return ""
+}
+
+var (
+	// SignatureDetector detects the content type from the signature of the file (its "magic
+	// numbers") with github.com/h2non/filetype.
+	SignatureDetector Detector = DetectorFunc(func(head []byte, _ string) string {
+		if t, err := filetype.Match(head); err == nil {
+			return t.MIME.Value
+		}
+		return ""
+	})
+
+	// SniffDetector detects the content type with http.DetectContentType, which also recognizes
+	// text formats like HTML and XML. The generic "text/plain" and "application/octet-stream"
+	// types aren't returned, so the next detector can be more specific.
+	SniffDetector Detector = DetectorFunc(func(head []byte, _ string) string {
+		if len(head) == 0 {
+			return ""
+		}
+		switch t := http.DetectContentType(head); mediaType(t) {
+		case "text/plain", "application/octet-stream":
+			return ""
+		default:
+			return t
+		}
+	})
+
+	// ExtensionDetector detects the content type from the extension of the file name.
+	ExtensionDetector Detector = DetectorFunc(func(_ []byte, filename string) string {
+		return mime.TypeByExtension(filepath.Ext(filename))
+	})
+
+	// DefaultDetector is used when Config.Detector isn't set, it tries the signature of the file
+	// and then its extension.
+	DefaultDetector = Detectors{SignatureDetector, ExtensionDetector}
+)
+
+// detectType returns the content type of the file detected by Config.Detector, or
+// "application/octet-stream" if it's unknown.
+func (wr Wrapper) detectType(head []byte, name string) string {
+	if t := wr.detector.Detect(head, name); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
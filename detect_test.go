@@ -0,0 +1,53 @@
+package mps3
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetector(t *testing.T) {
+	assert := assert.New(t)
+	png := "\x89PNG\r\n\x1a\n"
+	html := "<!DOCTYPE html><html></html>"
+
+	assert.Equal("image/png", DefaultDetector.Detect([]byte(png), "image.txt"))
+	assert.Equal("text/plain; charset=utf-8", DefaultDetector.Detect([]byte(html), "page.txt"))
+	assert.Equal("", DefaultDetector.Detect([]byte("hello"), "file"))
+
+	chain := Detectors{SignatureDetector, SniffDetector, ExtensionDetector}
+	assert.Equal("text/html; charset=utf-8", chain.Detect([]byte(html), "page.txt"))
+	assert.Equal("text/csv; charset=utf-8", chain.Detect([]byte("a,b"), "data.csv"))
+
+	// a custom format and the bytes read to detect it
+	var sniffed int
+	parquet := DetectorFunc(func(head []byte, _ string) string {
+		sniffed = len(head)
+		if bytes.HasPrefix(head, []byte("PAR1")) {
+			return "application/vnd.apache.parquet"
+		}
+		return ""
+	})
+	content := "PAR1" + string(bytes.Repeat([]byte{0}, 1000))
+	upload := func(cfg Config) string {
+		cfg.Bucket, cfg.Backend = bucket, mps3test.NewBackend()
+		wrapper, err := New(cfg)
+		assert.NoError(err)
+		var ftype string
+		res := httptest.NewRecorder()
+		wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ftype = req.FormValue("file_type")
+		})).ServeHTTP(res, newFileRequest(t, "data", content))
+		assert.Equal(http.StatusOK, res.Code)
+		return ftype
+	}
+	assert.Equal("application/vnd.apache.parquet", upload(Config{Detector: Detectors{parquet, DefaultDetector}}))
+	assert.Equal(261, sniffed)
+	upload(Config{Detector: parquet, SniffSize: 512})
+	assert.Equal(512, sniffed)
+	assert.Equal("application/octet-stream", upload(Config{}))
+}
@@ -0,0 +1,70 @@
+package mps3
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// DownloadPolicy configures the handler returned by DownloadHandler.
+type DownloadPolicy struct {
+	// Expires is how long the presigned URL is valid (default: 1 minute)
+	Expires time.Duration
+
+	// Authorize is called before redirecting to the file, if it returns false the request fails
+	// with 403 Forbidden. It's required, use a function that always returns true for public files.
+	Authorize func(req *http.Request, key string) bool
+}
+
+// DownloadHandler returns a handler that redirects (302 Found) to a short lived presigned URL of
+// the file whose key is the request URL path, use http.StripPrefix to remove the route prefix:
+//
+//	server.Handle("/files/", http.StripPrefix("/files", wrapper.DownloadHandler(policy)))
+//
+// The backend must implement Presigner. Files that don't exist fail with 404 Not Found.
+func (wr Wrapper) DownloadHandler(p DownloadPolicy) http.Handler {
+	if p.Expires <= 0 {
+		p.Expires = time.Minute
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key := req.URL.Path
+		if key == "" || key == "/" {
+			http.NotFound(w, req)
+			return
+		}
+		if p.Authorize == nil || !p.Authorize(req, key) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		bucket, err := wr.bucketFor(req, key)
+		if err != nil {
+			wr.handleError(w, req, err)
+			return
+		}
+		in := &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+		if wr.requestPayer != "" {
+			in.RequestPayer = types.RequestPayer(wr.requestPayer)
+		}
+		if _, err := wr.backend.Head(req.Context(), in); err != nil {
+			if isNotFound(err) {
+				http.NotFound(w, req)
+				return
+			}
+			wr.handleError(w, req, fmt.Errorf("failed to check %q: %w", key, err))
+			return
+		}
+
+		url, err := wr.presign(req.Context(), file{bucket: bucket, key: key}, p.Expires)
+		if err != nil {
+			wr.handleError(w, req, err)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, req, url, http.StatusFound)
+	})
+}
@@ -0,0 +1,42 @@
+package mps3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	backend, form, res := uploadToMemory(t, Config{}, nil, "test_file2.txt")
+	assert.Equal(200, res.Code)
+	key := form.Get("file")
+
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend})
+	assert.NoError(err)
+	handler := http.StripPrefix("/files", wrapper.DownloadHandler(DownloadPolicy{
+		Authorize: func(req *http.Request, key string) bool {
+			return req.Header.Get("Authorization") == "secret"
+		},
+	}))
+
+	get := func(path, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", auth)
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res
+	}
+
+	res = get("/files"+key, "secret")
+	assert.Equal(http.StatusFound, res.Code)
+	assert.Equal("mem://"+bucket+"/"+key+"?X-Amz-Expires=60", res.Header().Get("Location"))
+	assert.Equal("no-store", res.Header().Get("Cache-Control"))
+
+	assert.Equal(http.StatusForbidden, get("/files"+key, "wrong").Code)
+	assert.Equal(http.StatusNotFound, get("/files/missing", "secret").Code)
+	assert.Equal(http.StatusNotFound, get("/files/", "secret").Code)
+}
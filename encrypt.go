@@ -0,0 +1,256 @@
+package mps3
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Metadata of the encrypted files, see EncryptionConfig.
+const (
+	// MetaEncryption is the encryption scheme, "AES-256-GCM-STREAM"
+	MetaEncryption = "mps3-encryption"
+	// MetaKeyID is the ID of the key that encrypts the data key, returned by EncryptionConfig.KeyFunc
+	MetaKeyID = "mps3-key-id"
+	// MetaDataKey is the base64 encoded data key of the file, encrypted with the key
+	MetaDataKey = "mps3-data-key"
+	// MetaNonce is the base64 encoded prefix of the nonces of the segments of the file
+	MetaNonce = "mps3-nonce"
+)
+
+const (
+	encryptionScheme = "AES-256-GCM-STREAM"
+	// segmentSize is the size of the segments of plaintext encrypted separately
+	segmentSize = 64 << 10
+	// noncePrefixSize is the random part of the nonces, followed by the segment number (4 bytes)
+	// and a byte that is 1 for the last segment, so truncated files can't be decrypted.
+	noncePrefixSize = 7
+)
+
+// EncryptionConfig encrypts the files in the middleware before they're sent to S3, so the storage
+// never has their content. Each file is encrypted with its own random data key with AES-256-GCM,
+// in segments of 64KB so it can be streamed. The data key is encrypted with the key returned by
+// KeyFunc and stored with the key ID in the metadata of the object (see MetaKeyID), use Decrypt to
+// read the files.
+//
+// The size and digests reported for the files are of their plaintext, the checksums calculated by
+// S3, presigned URLs and UploadedFile.Open are of the encrypted content.
+type EncryptionConfig struct {
+	// KeyFunc returns the key that encrypts the data keys of the files of the request (32 bytes)
+	// and its ID, e.g. a key of the tenant or a version of a key
+	KeyFunc func(req *http.Request) (keyID string, key []byte, err error)
+}
+
+// encrypt sets the body of the input to the encryption of body and adds the data key to its
+// metadata.
+func (wr Wrapper) encrypt(req *http.Request, in *s3.PutObjectInput, body io.Reader) error {
+	keyID, key, err := wr.encryption.KeyFunc(req)
+	if err != nil {
+		return fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	dataKey := make([]byte, 32)
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return fmt.Errorf("failed to generate data key: %w", err)
+	}
+	if _, err := rand.Read(prefix); err != nil {
+		return fmt.Errorf("failed to generate data key: %w", err)
+	}
+	wrapped, err := wrapKey(key, keyID, dataKey)
+	if err != nil {
+		return err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+
+	meta := make(map[string]string, len(in.Metadata)+4)
+	for k, v := range in.Metadata {
+		meta[k] = v
+	}
+	meta[MetaEncryption] = encryptionScheme
+	meta[MetaKeyID] = keyID
+	meta[MetaDataKey] = base64.StdEncoding.EncodeToString(wrapped)
+	meta[MetaNonce] = base64.StdEncoding.EncodeToString(prefix)
+	in.Metadata = meta
+	in.Body = &encryptReader{segments: segments{r: bufio.NewReader(body), aead: aead, prefix: prefix}, buf: make([]byte, segmentSize)}
+	return nil
+}
+
+// Decrypt returns the content of a file encrypted by the middleware, from the content and the
+// metadata of its object (e.g. s3.GetObjectOutput.Body and Metadata). keys returns the key of the
+// ID stored in the metadata. The content is authenticated while it's read, the reader fails if it
+// was modified or truncated.
+func Decrypt(r io.Reader, metadata map[string]string, keys func(keyID string) ([]byte, error)) (io.Reader, error) {
+	if metadata[MetaEncryption] != encryptionScheme {
+		return nil, fmt.Errorf("unsupported encryption %q", metadata[MetaEncryption])
+	}
+	keyID := metadata[MetaKeyID]
+	key, err := keys(keyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(metadata[MetaDataKey])
+	if err != nil {
+		return nil, fmt.Errorf("invalid data key: %w", err)
+	}
+	prefix, err := base64.StdEncoding.DecodeString(metadata[MetaNonce])
+	if err != nil || len(prefix) != noncePrefixSize {
+		return nil, fmt.Errorf("invalid nonce")
+	}
+	dataKey, err := unwrapKey(key, keyID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{segments: segments{r: bufio.NewReader(r), aead: aead, prefix: prefix}, buf: make([]byte, segmentSize+aead.Overhead())}, nil
+}
+
+// wrapKey encrypts the data key with the key, authenticating its ID.
+func wrapKey(key []byte, keyID string, dataKey []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to encrypt data key: %w", err)
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(keyID)), nil
+}
+
+// unwrapKey decrypts a data key encrypted with wrapKey.
+func unwrapKey(key []byte, keyID string, wrapped []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("invalid data key")
+	}
+	dataKey, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes long, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// segments reads the segments of a file, encrypted or not.
+type segments struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	n      uint32
+	// out is the last segment processed, returned before the next one
+	out  []byte
+	last bool
+	err  error
+}
+
+// next reads the next segment in buf, it's the last one if nothing follows it.
+func (s *segments) next(buf []byte) ([]byte, bool, error) {
+	n, err := io.ReadFull(s.r, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, false, err
+	}
+	if n < len(buf) {
+		return buf[:n], true, nil
+	}
+	if _, err := s.r.Peek(1); errors.Is(err, io.EOF) {
+		return buf, true, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return buf, false, nil
+}
+
+// nonce returns the nonce of the current segment.
+func (s *segments) nonce(last bool) []byte {
+	nonce := make([]byte, noncePrefixSize+5)
+	copy(nonce, s.prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], s.n)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// read returns the processed segments, processing the next one with process when they're consumed.
+func (s *segments) read(p []byte, process func() error) (int, error) {
+	for len(s.out) == 0 {
+		switch {
+		case s.err != nil:
+			return 0, s.err
+		case s.last:
+			return 0, io.EOF
+		}
+		if s.err = process(); s.err == nil {
+			s.n++
+		}
+	}
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+// encryptReader encrypts a file while it's read.
+type encryptReader struct {
+	segments
+	buf []byte
+}
+
+func (e *encryptReader) Read(p []byte) (int, error) {
+	return e.read(p, func() error {
+		plain, last, err := e.next(e.buf)
+		if err != nil {
+			return err
+		}
+		e.last = last
+		e.out = e.aead.Seal(e.out[:0], e.nonce(last), plain, nil)
+		return nil
+	})
+}
+
+// decryptReader decrypts a file encrypted by encryptReader while it's read.
+type decryptReader struct {
+	segments
+	buf []byte
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	return d.read(p, func() error {
+		sealed, last, err := d.next(d.buf)
+		if err != nil {
+			return err
+		}
+		if d.out, err = d.aead.Open(d.out[:0], d.nonce(last), sealed, nil); err != nil {
+			return fmt.Errorf("failed to decrypt file: %w", err)
+		}
+		d.last = last
+		return nil
+	})
+}
@@ -0,0 +1,101 @@
+package mps3
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryption(t *testing.T) {
+	assert := assert.New(t)
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	keys := func(keyID string) ([]byte, error) {
+		if keyID != "tenant-1" {
+			return nil, errors.New("unknown key")
+		}
+		return key, nil
+	}
+	cfg := Config{Encryption: &EncryptionConfig{KeyFunc: func(*http.Request) (string, []byte, error) {
+		return "tenant-1", key, nil
+	}}}
+
+	backend, form, res := uploadToMemory(t, cfg, nil, "test_file1.png")
+	assert.Equal(200, res.Code)
+	content, _ := os.ReadFile("test_file1.png")
+	assert.Equal("image/png", form.Get("file_type"))
+	// the size is of the plaintext
+	assert.Equal(strconv.Itoa(len(content)), form.Get("file_size"))
+
+	obj, _ := backend.Object(bucket, form.Get("file"))
+	assert.NotContains(string(obj.Body), string(content[:16]))
+	assert.Equal("tenant-1", obj.Metadata[MetaKeyID])
+	r, err := Decrypt(bytes.NewReader(obj.Body), obj.Metadata, keys)
+	assert.NoError(err)
+	plain, err := io.ReadAll(r)
+	assert.NoError(err)
+	assert.Equal(content, plain)
+
+	// files of several segments, and of exactly one
+	cfg.Backend = mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: cfg.Backend, Encryption: cfg.Encryption})
+	assert.NoError(err)
+	for _, size := range []int{0, segmentSize, 2*segmentSize + 10} {
+		content := strings.Repeat("a", size)
+		res := httptest.NewRecorder()
+		var key string
+		wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			key = req.FormValue("file")
+		})).ServeHTTP(res, newFileRequest(t, "file.txt", content))
+		assert.Equal(200, res.Code)
+
+		obj, _ := cfg.Backend.(*mps3test.Backend).Object(bucket, key)
+		r, err := Decrypt(bytes.NewReader(obj.Body), obj.Metadata, keys)
+		assert.NoError(err)
+		plain, err := io.ReadAll(r)
+		assert.NoError(err)
+		assert.Equal(content, string(plain), "size %d", size)
+
+		if size > segmentSize {
+			// modified content
+			modified := bytes.Clone(obj.Body)
+			modified[10] ^= 1
+			r, _ := Decrypt(bytes.NewReader(modified), obj.Metadata, keys)
+			_, err = io.ReadAll(r)
+			assert.Error(err)
+
+			// truncated after the first segment
+			r, _ = Decrypt(bytes.NewReader(obj.Body[:segmentSize+16]), obj.Metadata, keys)
+			_, err = io.ReadAll(r)
+			assert.Error(err)
+		}
+	}
+
+	// unknown key and wrong key
+	_, err = Decrypt(bytes.NewReader(obj.Body), map[string]string{MetaEncryption: encryptionScheme, MetaKeyID: "tenant-2"}, keys)
+	assert.Error(err)
+	other := make([]byte, 32)
+	_, err = Decrypt(bytes.NewReader(obj.Body), obj.Metadata, func(string) ([]byte, error) { return other, nil })
+	assert.Error(err)
+
+	// the key of the request is required
+	_, _, res = uploadToMemory(t, Config{Encryption: &EncryptionConfig{KeyFunc: func(*http.Request) (string, []byte, error) {
+		return "", nil, errors.New("no key")
+	}}}, nil, "test_file1.png")
+	assert.Equal(500, res.Code)
+
+	_, err = New(Config{Bucket: bucket, Backend: mps3test.NewBackend(), Encryption: cfg.Encryption, ChunkedUploads: true})
+	assert.Error(err)
+	_, err = New(Config{Bucket: bucket, Backend: mps3test.NewBackend(), Encryption: &EncryptionConfig{}})
+	assert.Error(err)
+}
@@ -0,0 +1,199 @@
+package mps3
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Errors the middleware fails requests with, use errors.Is to check them in Config.ErrorHandler.
+var (
+	// ErrTooLarge means a file or the request is larger than allowed.
+	ErrTooLarge = errors.New("file is too large")
+
+	// ErrTooSmall means a file is smaller than allowed, see Config.MinFileSize.
+	ErrTooSmall = errors.New("file is too small")
+
+	// ErrUnsupportedType means the content type of a file is not allowed.
+	ErrUnsupportedType = errors.New("unsupported file type")
+
+	// ErrKeyExists means a file wasn't uploaded because its key already exists, see
+	// Config.PreventOverwrite.
+	ErrKeyExists = errors.New("key already exists")
+
+	// ErrS3Upload means a file couldn't be stored.
+	ErrS3Upload = errors.New("failed to upload file")
+
+	// ErrMalformedMultipart means the request body couldn't be read as a multipart form.
+	ErrMalformedMultipart = errors.New("malformed multipart request")
+
+	// ErrUnexpectedField means a file was sent in a form field that isn't uploaded, see
+	// Config.RejectIgnoredFields.
+	ErrUnexpectedField = errors.New("unexpected file field")
+
+	// ErrClientDisconnected means the request body couldn't be read completely, usually because
+	// the client went away in the middle of the request.
+	ErrClientDisconnected = errors.New("client disconnected")
+
+	// ErrTimeout means the request took longer than Config.RequestTimeout or a file longer than
+	// Config.UploadTimeout.
+	ErrTimeout = errors.New("upload timed out")
+
+	// ErrInfected means a file contains malware, see Config.Scan.
+	ErrInfected = errors.New("infected file")
+
+	// ErrSuspiciousArchive means an archive exceeds Config.ArchiveLimits or can't be inspected.
+	ErrSuspiciousArchive = errors.New("suspicious archive")
+
+	// ErrEncryptedArchive means a file is a password-protected archive, see Config.EncryptedArchives.
+	ErrEncryptedArchive = errors.New("password-protected archive")
+
+	// ErrPII means a text field or file contains personal information, see Config.PII.
+	ErrPII = errors.New("personal information found")
+
+	// ErrQuarantine can be wrapped by the errors of Config.OnUploadStart and
+	// Config.OnUploadComplete to quarantine the file instead of failing the request, see
+	// Config.Quarantine.
+	ErrQuarantine = errors.New("quarantined")
+
+	// ErrQuotaExceeded can be wrapped by the errors of Config.QuotaFunc, to fail the request with
+	// 402 Payment Required.
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+	// ErrRateLimited means a client has too many uploads in progress, see Config.RateLimit.
+	ErrRateLimited = errors.New("too many uploads")
+
+	// ErrOverloaded means the server has too many uploads in progress, see
+	// Config.MaxConcurrentUploads.
+	ErrOverloaded = errors.New("too many uploads in progress")
+
+	// ErrUnauthorized means a request was refused by Config.Authorize.
+	ErrUnauthorized = errors.New("unauthorized")
+
+	// ErrInvalidToken means the upload token of a request is missing, invalid or expired, see
+	// Config.RequireUploadToken.
+	ErrInvalidToken = errors.New("invalid upload token")
+
+	// ErrSpam means a request was rejected as spam, see Config.HoneypotFields and Config.SpamCheck.
+	ErrSpam = errors.New("spam submission")
+
+	// ErrSessionNotFound means an upload session doesn't exist, see Wrapper.Session.
+	ErrSessionNotFound = errors.New("upload session not found")
+
+	// ErrInvalidSignature means the signature of a webhook request is missing or invalid, see
+	// VerifyWebhook.
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// errEmptyFile means an empty file was skipped, see Config.SkipEmptyFiles.
+var errEmptyFile = errors.New("empty file")
+
+// StatusCode returns the HTTP status code of the error the request failed with: 400 Bad Request
+// for malformed or incomplete requests, unexpected and too small files and spam, 401 Unauthorized
+// for unauthorized requests and invalid upload tokens, 402 Payment Required for exceeded quotas,
+// 404 Not Found for unknown upload sessions, 408 Request Timeout, 409 Conflict for existing keys,
+// 413 Content Too Large, 415 Unsupported Media Type, 422 Unprocessable Content for infected files,
+// suspicious and password-protected archives and personal information, 429 Too Many Requests for
+// rate limited clients, 503 Service Unavailable for overloaded servers and 500 Internal Server
+// Error for everything else.
+func StatusCode(err error) int {
+	switch {
+	case errors.Is(err, ErrTimeout):
+		return http.StatusRequestTimeout
+	case errors.Is(err, ErrMalformedMultipart), errors.Is(err, ErrClientDisconnected),
+		errors.Is(err, ErrUnexpectedField), errors.Is(err, ErrTooSmall), errors.Is(err, ErrSpam):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrKeyExists):
+		return http.StatusConflict
+	case errors.Is(err, ErrSessionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrUnauthorized), errors.Is(err, ErrInvalidToken):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrQuotaExceeded):
+		return http.StatusPaymentRequired
+	case errors.Is(err, ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrOverloaded):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrUnsupportedType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrInfected), errors.Is(err, ErrSuspiciousArchive),
+		errors.Is(err, ErrEncryptedArchive), errors.Is(err, ErrPII):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// FileError is the error of a request that failed while processing one of its files, use errors.As
+// to get the file that failed in Config.ErrorHandler.
+type FileError struct {
+	// Field is the form field and Name the original name of the file
+	Field string
+	Name  string
+	Err   error
+}
+
+func (e *FileError) Error() string {
+	return "file " + e.Name + " (" + e.Field + "): " + e.Err.Error()
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// handleError fails the request, with Config.ErrorHandler if set.
+func (wr Wrapper) handleError(w http.ResponseWriter, req *http.Request, err error) {
+	failSpan(trace.SpanFromContext(req.Context()), err)
+	progressOf(req).finish(req, err)
+	wr.requestDone(req, err)
+	wr.reportError(req, err, ErrorInfo{})
+	if wr.errHandler != nil {
+		wr.errHandler(w, req, err)
+		return
+	}
+	code := StatusCode(err)
+	wr.log(req).ErrorContext(req.Context(), "failed to handle request", "status", code, "error", err)
+	http.Error(w, http.StatusText(code), code)
+}
+
+// clientBody remembers the error reading the request body, so failures caused by the client can
+// be told apart from the ones of the storage, which also reads the body while uploading files.
+type clientBody struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *clientBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// classify replaces the error with the one of the client if reading the body failed.
+func (b *clientBody) classify(err error) error {
+	if b.err == nil {
+		return err
+	}
+
+	cause := ErrClientDisconnected
+	var mbe *http.MaxBytesError
+	if errors.As(b.err, &mbe) {
+		cause = ErrTooLarge
+	} else if isTimeout(b.err) {
+		cause = ErrTimeout
+	}
+	cerr := fmt.Errorf("%w: failed to read request body: %w", cause, b.err)
+
+	var ferr *FileError
+	if errors.As(err, &ferr) {
+		return &FileError{Field: ferr.Field, Name: ferr.Name, Err: cerr}
+	}
+	return cerr
+}
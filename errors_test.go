@@ -0,0 +1,86 @@
+package mps3
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	var handled error
+	cfg := Config{
+		Bucket:  bucket,
+		Backend: failingBackend{},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			handled = err
+			http.Error(w, `{"error": "upload failed"}`, http.StatusBadGateway)
+		},
+	}
+	_, _, res := uploadToMemory(t, cfg, nil, "test_file2.txt")
+	assert.Equal(http.StatusBadGateway, res.Code)
+	assert.ErrorIs(handled, ErrS3Upload)
+	var ferr *FileError
+	assert.True(errors.As(handled, &ferr))
+	assert.Equal("file", ferr.Field)
+	assert.Equal("test_file2.txt", ferr.Name)
+
+	wrapper, err := New(cfg)
+	assert.NoError(err)
+	req := httptest.NewRequest("POST", "/", strings.NewReader("--boundary\r\nbroken"))
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=boundary")
+	res = httptest.NewRecorder()
+	wrapper.Wrap(http.NotFoundHandler()).ServeHTTP(res, req)
+	assert.Equal(http.StatusBadGateway, res.Code)
+	assert.ErrorIs(handled, ErrMalformedMultipart)
+}
+
+func TestClientErrorStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	var handled error
+	wrapper, err := New(Config{Bucket: bucket, Backend: mps3test.NewBackend(), Logger: log.New(io.Discard, "", 0)})
+	assert.NoError(err)
+	handler := wrapper.Wrap(http.NotFoundHandler())
+	serve := func(req *http.Request) int {
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader("--boundary\r\nbroken"))
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=boundary")
+	assert.Equal(http.StatusBadRequest, serve(req))
+
+	req, err = newRequest(nil, "test_file1.png")
+	assert.NoError(err)
+	req.Body = io.NopCloser(io.MultiReader(io.LimitReader(req.Body, 1000), iotest.ErrReader(errors.New("connection reset"))))
+	assert.Equal(http.StatusBadRequest, serve(req))
+
+	req, err = newRequest(nil, "test_file1.png")
+	assert.NoError(err)
+	req.Body = http.MaxBytesReader(httptest.NewRecorder(), req.Body, 1000)
+	assert.Equal(http.StatusRequestEntityTooLarge, serve(req))
+
+	wrapper.errHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		handled = err
+	}
+	req, err = newRequest(nil, "test_file1.png")
+	assert.NoError(err)
+	req.Body = io.NopCloser(io.MultiReader(io.LimitReader(req.Body, 1000), iotest.ErrReader(errors.New("connection reset"))))
+	wrapper.Wrap(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), req)
+	assert.ErrorIs(handled, ErrClientDisconnected)
+	assert.NotErrorIs(handled, ErrS3Upload)
+	var ferr *FileError
+	assert.True(errors.As(handled, &ferr))
+	assert.Equal("test_file1.png", ferr.Name)
+}
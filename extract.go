@@ -0,0 +1,157 @@
+package mps3
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// extractArchive stores the files of an uploaded ZIP or tar file (optionally compressed with
+// gzip) as objects under the key of the archive, see Config.ExtractArchives. Other files are
+// returned as is. If it fails the file has the entries stored until then, so they can be removed.
+func (wr Wrapper) extractArchive(req *http.Request, uf UploadedFile) (UploadedFile, error) {
+	switch {
+	case uf.Duplicate || uf.Threat != "":
+		return uf, nil
+	case uf.ContentType != "application/zip" && uf.ContentType != "application/x-tar" && uf.ContentType != "application/gzip":
+		return uf, nil
+	}
+	backend, bucket, _, err := uf.location()
+	if err != nil {
+		return uf, err
+	}
+	rc, err := uf.Open(req.Context())
+	if err != nil {
+		return uf, fmt.Errorf("failed to extract archive: %w", err)
+	}
+	defer rc.Close()
+
+	r := bufio.NewReader(rc)
+	head, err := r.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return uf, fmt.Errorf("failed to extract archive: %w", err)
+	}
+	kind := archiveKind(head)
+	if kind == "gzip" {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return uf, fmt.Errorf("failed to extract archive: %w", invalidArchive(err))
+		}
+		// only compressed tar files are extracted
+		r = bufio.NewReader(gz)
+		if head, err = r.Peek(512); err != nil && !errors.Is(err, io.EOF) {
+			return uf, fmt.Errorf("failed to extract archive: %w", invalidArchive(err))
+		}
+		if kind = archiveKind(head); kind != "tar" {
+			return uf, nil
+		}
+	}
+
+	extract := func(e archiveEntry) error {
+		key, err := wr.extractEntry(req, backend, bucket, uf, e)
+		if key != "" {
+			uf.Entries = append(uf.Entries, key)
+		}
+		return err
+	}
+	uf.Entries = []string{}
+	switch kind {
+	case "tar":
+		err = walkTar(r, extract)
+	case "zip":
+		err = walkZip(r, extract)
+	default:
+		err = invalidArchive(errors.New("unknown format"))
+	}
+	if err != nil {
+		return uf, fmt.Errorf("failed to extract archive: %w", err)
+	}
+	return uf, nil
+}
+
+// extractEntry stores an entry of the archive, returning its key. Directories, links and
+// encrypted files aren't stored. The entries are checked and transformed like the files of the
+// field of the archive, an entry that can't be uploaded fails the whole archive.
+func (wr Wrapper) extractEntry(req *http.Request, backend Backend, bucket string, uf UploadedFile, e archiveEntry) (string, error) {
+	key := entryKey(uf.Key, e.name)
+	if key == "" || e.dir || e.content == nil {
+		return "", nil
+	}
+	content := &entryReader{r: e.content}
+	head, err := readHead(content, wr.sniffSize)
+	if err != nil {
+		return "", invalidArchive(err)
+	}
+	f := file{name: path.Base(key), ftype: wr.detectType(head, key), key: key, bucket: bucket}
+	err = wr.checkBlocked(f.name, head)
+	if err == nil {
+		err = wr.checkType(uf.Field, f.ftype)
+	}
+	if err == nil {
+		err = checkTokenType(req, f.ftype)
+	}
+	if err != nil {
+		return "", fmt.Errorf("entry %q: %w", e.name, err)
+	}
+
+	body := io.MultiReader(bytes.NewReader(head), content)
+	if wr.stripMeta {
+		body = stripMetadata(f.ftype, body)
+	}
+	if wr.cleanSVG && f.ftype == "image/svg+xml" {
+		body = sanitizeSVG(body)
+	}
+	in := wr.objectInput(req, f, wr.fieldCfgs[uf.Field])
+	in.Body = body
+	if wr.sseKeyFunc != nil {
+		if err := wr.setCustomerKey(req, in, &f); err != nil {
+			return "", err
+		}
+	}
+	if _, err := backend.Upload(req.Context(), in); err != nil {
+		if content.err != nil {
+			return "", invalidArchive(content.err)
+		}
+		return "", fmt.Errorf("%w: %w", ErrS3Upload, err)
+	}
+	return key, nil
+}
+
+// entryKey returns the key of an entry of an archive under the key of the archive, entries can't
+// be stored outside of it with absolute paths or "..". It's empty if the entry has no name.
+func entryKey(archiveKey, name string) string {
+	name = path.Clean("/" + strings.ReplaceAll(name, `\`, "/"))
+	if name == "/" {
+		return ""
+	}
+	return archiveKey + name
+}
+
+// entryReader keeps the error reading an entry, to tell it from the errors of the upload.
+type entryReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *entryReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		e.err = err
+	}
+	return n, err
+}
+
+// entryFiles returns the files extracted from the archive, in the same location.
+func (uf UploadedFile) entryFiles() []UploadedFile {
+	files := make([]UploadedFile, len(uf.Entries))
+	for i, key := range uf.Entries {
+		files[i] = UploadedFile{Field: uf.Field, Key: key, Bucket: uf.Bucket, Fallback: uf.Fallback, wr: uf.wr}
+	}
+	return files
+}
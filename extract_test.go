@@ -0,0 +1,134 @@
+package mps3
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractArchives(t *testing.T) {
+	assert := assert.New(t)
+
+	var zipFile bytes.Buffer
+	zw := zip.NewWriter(&zipFile)
+	for name, content := range map[string]string{"docs/": "", "docs/a.txt": "hello", "../../b.txt": "world"} {
+		w, err := zw.Create(name)
+		assert.NoError(err)
+		_, _ = w.Write([]byte(content))
+	}
+	assert.NoError(zw.Close())
+
+	var tgz bytes.Buffer
+	gz := gzip.NewWriter(&tgz)
+	tw := tar.NewWriter(gz)
+	assert.NoError(tw.WriteHeader(&tar.Header{Name: "c.txt", Mode: 0o600, Size: 5}))
+	_, _ = tw.Write([]byte("hello"))
+	assert.NoError(tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "c.txt"}))
+	assert.NoError(tw.Close())
+	assert.NoError(gz.Close())
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, ExtractArchives: true})
+	assert.NoError(err)
+	handler := func(check func(req *http.Request)) http.Handler {
+		return wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { check(req) }))
+	}
+
+	res := httptest.NewRecorder()
+	handler(func(req *http.Request) {
+		key := req.FormValue("file")
+		var entries []string
+		assert.NoError(json.Unmarshal([]byte(req.FormValue("file_entries")), &entries))
+		assert.ElementsMatch([]string{key + "/docs/a.txt", key + "/b.txt"}, entries)
+		assert.Equal(entries, FilesFromContext(req.Context())[0].Entries)
+		obj, ok := backend.Object(bucket, key+"/docs/a.txt")
+		assert.True(ok)
+		assert.Equal("hello", string(obj.Body))
+		_, ok = backend.Object(bucket, key)
+		assert.True(ok)
+	}).ServeHTTP(res, newFileRequest(t, "files.zip", zipFile.String()))
+	assert.Equal(http.StatusOK, res.Code)
+
+	res = httptest.NewRecorder()
+	handler(func(req *http.Request) {
+		key := req.FormValue("file")
+		assert.Equal(`["`+key+`/c.txt"]`, req.FormValue("file_entries"))
+	}).ServeHTTP(res, newFileRequest(t, "files.tar.gz", tgz.String()))
+	assert.Equal(http.StatusOK, res.Code)
+
+	// other files aren't extracted
+	res = httptest.NewRecorder()
+	handler(func(req *http.Request) {
+		assert.Equal("", req.FormValue("file_entries"))
+	}).ServeHTTP(res, newFileRequest(t, "file.txt", "hello"))
+	assert.Equal(http.StatusOK, res.Code)
+
+	// the archive and the files extracted until then are removed if it's invalid
+	backend.Reset()
+	// truncated in the content of the last file
+	invalid := zipFile.Bytes()[:bytes.Index(zipFile.Bytes(), []byte("../../b.txt"))+13]
+	res = httptest.NewRecorder()
+	handler(func(req *http.Request) { t.Error("handler called") }).ServeHTTP(res, newFileRequest(t, "files.zip", string(invalid)))
+	assert.Equal(http.StatusUnprocessableEntity, res.Code)
+	assert.Empty(backend.Objects())
+
+	_, err = New(Config{Bucket: bucket, Backend: backend, ExtractArchives: true, ContentAddressable: true})
+	assert.Error(err)
+}
+
+func TestExtractArchivesChecks(t *testing.T) {
+	assert := assert.New(t)
+
+	archive := func(files map[string]string) string {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for name, content := range files {
+			w, err := zw.Create(name)
+			assert.NoError(err)
+			_, _ = w.Write([]byte(content))
+		}
+		assert.NoError(zw.Close())
+		return buf.String()
+	}
+	backend := mps3test.NewBackend()
+	upload := func(cfg Config, content string) (int, string) {
+		cfg.Bucket, cfg.Backend, cfg.ExtractArchives = bucket, backend, true
+		wrapper, err := New(cfg)
+		assert.NoError(err)
+		var key string
+		res := httptest.NewRecorder()
+		wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			key = req.FormValue("file")
+		})).ServeHTTP(res, newFileRequest(t, "files.zip", content))
+		return res.Code, key
+	}
+
+	// blocked entries fail the archive, which is removed
+	exe := "MZ" + strings.Repeat("\x00", 100)
+	code, _ := upload(Config{BlockedExtensions: []string{".exe"}}, archive(map[string]string{"a.txt": "hello", "evil.exe": "hello"}))
+	assert.Equal(http.StatusUnsupportedMediaType, code)
+	code, _ = upload(Config{BlockExecutables: true}, archive(map[string]string{"a.txt": "hello", "evil.bin": exe}))
+	assert.Equal(http.StatusUnsupportedMediaType, code)
+	code, _ = upload(Config{AllowedTypes: []string{"application/zip"}}, archive(map[string]string{"a.txt": "hello"}))
+	assert.Equal(http.StatusUnsupportedMediaType, code)
+	assert.Empty(backend.Objects())
+
+	// and they're transformed like the other files
+	svg := `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script><rect/></svg>`
+	code, key := upload(Config{SanitizeSVG: true}, archive(map[string]string{"x.svg": svg}))
+	assert.Equal(http.StatusOK, code)
+	obj, ok := backend.Object(bucket, key+"/x.svg")
+	assert.True(ok)
+	assert.Equal("image/svg+xml", aws.ToString(obj.Input.ContentType))
+	assert.NotContains(string(obj.Body), "script")
+}
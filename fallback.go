@@ -0,0 +1,79 @@
+package mps3
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// FallbackConfig configures where files are stored when the main backend fails.
+//
+// Since files are streamed, the bytes already sent to the main backend are kept in memory
+// (at most PartSize bytes per file) so they can be sent again to the fallback. If the main
+// backend fails after reading more than that the upload can't be retried and the request fails.
+type FallbackConfig struct {
+	// Backend where files are stored when the main backend fails, if not specified the main
+	// backend is used, in which case Bucket is required. Use NewLocalBackend to fallback to disk.
+	Backend Backend
+
+	// Bucket name of the bucket used as fallback (default: Config.Bucket)
+	Bucket string
+}
+
+// uploadFallback retries a failed upload in the fallback backend, it also returns the location of the file.
+func (wr Wrapper) uploadFallback(ctx context.Context, in *s3.PutObjectInput, replay *replayReader, cause error) (*manager.UploadOutput, string, error) {
+	body, ok := replay.rewind()
+	if !ok {
+		return nil, "", cause
+	}
+	wr.logger.WarnContext(ctx, "failed to upload file, using fallback", "key", aws.ToString(in.Key), "error", cause)
+
+	fin := *in
+	fin.Body = body
+	if wr.fallback.Bucket != "" {
+		fin.Bucket = aws.String(wr.fallback.Bucket)
+	}
+	out, err := wr.fallback.Backend.Upload(ctx, &fin)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to upload to fallback: %w (original error: %v)", err, cause)
+	}
+	if out.Location != "" {
+		return out, out.Location, nil
+	}
+	return out, aws.ToString(fin.Bucket) + "/" + aws.ToString(fin.Key), nil
+}
+
+// replayReader remembers the first bytes read, up to the size of its buffer, so they can be read
+// again.
+type replayReader struct {
+	r        io.Reader
+	buf      []byte
+	n        int
+	overflow bool
+}
+
+func (rr *replayReader) Read(b []byte) (int, error) {
+	n, err := rr.r.Read(b)
+	if !rr.overflow {
+		if rr.n+n > len(rr.buf) {
+			rr.overflow = true
+		} else {
+			rr.n += copy(rr.buf[rr.n:], b[:n])
+		}
+	}
+	return n, err
+}
+
+// rewind returns a reader with all the bytes read so far followed by the remaining data,
+// it's not possible if more bytes than the size of the buffer were read.
+func (rr *replayReader) rewind() (io.Reader, bool) {
+	if rr.overflow {
+		return nil, false
+	}
+	return io.MultiReader(bytes.NewReader(rr.buf[:rr.n]), rr.r), true
+}
@@ -0,0 +1,55 @@
+package mps3
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFallbackOnUploadError(t *testing.T) {
+	assert := assert.New(t)
+
+	fallback := mps3test.NewBackend()
+	wrapper, err := New(Config{
+		Bucket:   bucket,
+		Backend:  failingBackend{},
+		Logger:   log.New(io.Discard, "", 0),
+		Fallback: &FallbackConfig{Backend: fallback, Bucket: "fallback"},
+	})
+	assert.NoError(err)
+
+	req, err := newRequest(nil, "test_file1.png")
+	assert.NoError(err)
+	res := httptest.NewRecorder()
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		expected, _ := os.ReadFile("test_file1.png")
+		obj, ok := fallback.Object("fallback", req.Form.Get("file"))
+		assert.True(ok)
+		assert.Equal(expected, obj.Body)
+		assert.Equal("15716", req.Form.Get("file_size"))
+		assert.Equal("mem://fallback/"+req.Form.Get("file"), req.Form.Get("file_fallback"))
+	})).ServeHTTP(res, req)
+
+	assert.Equal(200, res.Result().StatusCode)
+}
+
+func TestReplayReaderLimit(t *testing.T) {
+	rr := &replayReader{r: strings.NewReader("0123456789"), buf: make([]byte, 4)}
+	_, _ = rr.Read(make([]byte, 3))
+	body, ok := rr.rewind()
+	assert.True(t, ok)
+	b, _ := io.ReadAll(body)
+	assert.Equal(t, "0123456789", string(b))
+
+	rr = &replayReader{r: strings.NewReader("0123456789"), buf: make([]byte, 4)}
+	_, _ = rr.Read(make([]byte, 5))
+	_, ok = rr.rewind()
+	assert.False(t, ok)
+}
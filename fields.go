@@ -0,0 +1,50 @@
+package mps3
+
+import (
+	"slices"
+	"strings"
+)
+
+// FieldConfig overrides the settings of the files sent in a form field, see Config.FieldConfigs.
+type FieldConfig struct {
+	// Bucket where the files are stored, instead of the one chosen by Bucket, Buckets or BucketFunc.
+	// It's reported in the `<field>_bucket` form value.
+	Bucket string
+
+	// ACL of the files, instead of FileACL
+	ACL string
+
+	// Prefix is prepended to the keys of the files
+	Prefix string
+
+	// MaxSize is the maximum size of each file in bytes, instead of MaxFileSize
+	MaxSize int64
+
+	// AllowedTypes are the content types the files can have, instead of AllowedTypes
+	AllowedTypes []string
+}
+
+// uploads returns true if the files sent in the form field are uploaded.
+func (wr Wrapper) uploads(field string) bool {
+	if len(wr.fields) > 0 && !slices.Contains(wr.fields, field) {
+		return false
+	}
+	return !slices.Contains(wr.ignored, field)
+}
+
+// typeAllowed returns true if the content type matches one of the allowed ones or there are none,
+// parameters like the charset are ignored.
+func typeAllowed(allowed []string, ftype string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	ftype, _, _ = strings.Cut(ftype, ";")
+	ftype = strings.ToLower(strings.TrimSpace(ftype))
+	for _, t := range allowed {
+		t = strings.ToLower(t)
+		if t == ftype || (strings.HasSuffix(t, "/*") && strings.HasPrefix(ftype, strings.TrimSuffix(t, "*"))) {
+			return true
+		}
+	}
+	return false
+}
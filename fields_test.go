@@ -0,0 +1,121 @@
+package mps3
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFields(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		cfg      Config
+		code     int
+		uploaded []string
+	}{
+		{Config{}, http.StatusOK, []string{"avatar", "attachment"}},
+		{Config{Fields: []string{"avatar"}}, http.StatusOK, []string{"avatar"}},
+		{Config{IgnoreFields: []string{"avatar"}}, http.StatusOK, []string{"attachment"}},
+		{Config{Fields: []string{"avatar"}, RejectIgnoredFields: true}, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		backend := mps3test.NewBackend()
+		tt.cfg.Bucket = bucket
+		tt.cfg.Backend = backend
+		tt.cfg.Logger = log.New(io.Discard, "", 0)
+		wrapper, err := New(tt.cfg)
+		assert.NoError(err)
+
+		req := newFieldsRequest(t, "avatar", "attachment")
+		res := httptest.NewRecorder()
+		var form url.Values
+		wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			form = req.Form
+		})).ServeHTTP(res, req)
+
+		assert.Equal(tt.code, res.Code)
+		assert.Len(backend.Objects(), len(tt.uploaded))
+		for _, field := range tt.uploaded {
+			_, ok := backend.Object(bucket, form.Get(field))
+			assert.True(ok, field)
+		}
+	}
+}
+
+func TestFieldConfigs(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{
+		Bucket:  bucket,
+		Backend: backend,
+		Logger:  log.New(io.Discard, "", 0),
+		FieldConfigs: map[string]FieldConfig{
+			"avatar": {Bucket: "public", ACL: "public-read", Prefix: "/avatars", AllowedTypes: []string{"text/*"}},
+			"image":  {AllowedTypes: []string{"image/*"}},
+			"small":  {MaxSize: 5},
+		},
+	})
+	assert.NoError(err)
+
+	serve := func(fields ...string) (url.Values, int) {
+		res := httptest.NewRecorder()
+		var form url.Values
+		wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			form = req.Form
+		})).ServeHTTP(res, newFieldsRequest(t, fields...))
+		return form, res.Code
+	}
+
+	form, code := serve("avatar", "attachment")
+	assert.Equal(http.StatusOK, code)
+	assert.True(strings.HasPrefix(form.Get("avatar"), "/avatars/"))
+	assert.Equal("public", form.Get("avatar_bucket"))
+	obj, ok := backend.Object("public", form.Get("avatar"))
+	assert.True(ok)
+	assert.Equal("public-read", string(obj.Input.ACL))
+	obj, ok = backend.Object(bucket, form.Get("attachment"))
+	assert.True(ok)
+	assert.Equal("private", string(obj.Input.ACL))
+
+	backend.Reset()
+	_, code = serve("image")
+	assert.Equal(http.StatusUnsupportedMediaType, code)
+	_, code = serve("small")
+	assert.Equal(http.StatusRequestEntityTooLarge, code)
+	assert.Empty(backend.Objects())
+}
+
+// newFieldsRequest returns a request sending a text file in each of the form fields.
+func newFieldsRequest(t *testing.T, fields ...string) *http.Request {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	writer := multipart.NewWriter(buf)
+	for _, field := range fields {
+		part, err := writer.CreateFormFile(field, field+".txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := part.Write([]byte("content of " + field)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", buf)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
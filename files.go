@@ -0,0 +1,204 @@
+package mps3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Getter is implemented by backends that can read the stored files.
+type Getter interface {
+	// Get returns the object stored under in.Bucket and in.Key, the caller must close the body. If
+	// the object doesn't exist the error is a *types.NoSuchKey.
+	Get(ctx context.Context, in *s3.GetObjectInput) (*s3.GetObjectOutput, error)
+}
+
+func (b *s3Backend) Get(ctx context.Context, in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	return b.client.GetObject(ctx, in)
+}
+
+func (b *replicatedBackend) Get(ctx context.Context, in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	g, ok := b.primary.(Getter)
+	if !ok {
+		return nil, fmt.Errorf("backend doesn't support reading files")
+	}
+	return g.Get(ctx, in)
+}
+
+// UploadedFile is a file uploaded by the middleware, the same information is available in the
+// form values of the request.
+type UploadedFile struct {
+	// Field is the name of the form field of the file
+	Field string `json:"field"`
+	// Key and Bucket where the file is stored
+	Key    string `json:"key"`
+	Bucket string `json:"bucket"`
+	// Name is the original file name
+	Name        string `json:"name"`
+	ContentType string `json:"type"`
+	Size        int64  `json:"size"`
+	ETag        string `json:"etag"`
+	// VersionID is the version of the object in versioned buckets
+	VersionID string `json:"version,omitempty"`
+
+	// URL is the presigned URL of the file, if Config.PresignExpiry is set
+	URL string `json:"url,omitempty"`
+	// PublicURL is the public URL of the file, if Config.PublicURL is set
+	PublicURL string `json:"public_url,omitempty"`
+	// Checksum is the checksum of Config.ChecksumAlgorithm calculated by S3
+	Checksum string `json:"checksum,omitempty"`
+	// SHA256 and MD5 are the hex encoded digests, if Config.ComputeSHA256 or Config.ComputeMD5 are set
+	SHA256 string `json:"sha256,omitempty"`
+	MD5    string `json:"md5,omitempty"`
+	// Fallback is the location of the file if it was stored in the fallback backend
+	Fallback string `json:"fallback,omitempty"`
+	// Duplicate is true if the file was already stored, see Config.Deduplicate
+	Duplicate bool `json:"duplicate,omitempty"`
+	// Width and Height are the dimensions of images, if Config.ImageLimits is set
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// Threat is the malware found in the file by ScanConfig.Scanner, if it was quarantined or tagged
+	Threat string `json:"threat,omitempty"`
+	// Encrypted is true if the file is a password-protected archive that was tagged, see
+	// Config.EncryptedArchives
+	Encrypted bool `json:"encrypted,omitempty"`
+	// PII are the kinds of personal information found in the file, if it was flagged, see Config.PII
+	PII []string `json:"pii,omitempty"`
+	// Quarantine is the reason the file was quarantined by a hook, see Config.Quarantine
+	Quarantine string `json:"quarantine,omitempty"`
+	// Entries are the keys of the files extracted from archives, see Config.ExtractArchives
+	Entries []string `json:"entries,omitempty"`
+	// UploadMS is the number of milliseconds spent receiving and storing the file, since its first
+	// chunk for chunked uploads, if Config.FormUploadTime is set
+	UploadMS int64 `json:"upload_ms,omitempty"`
+
+	wr     *Wrapper
+	sse    customerKey
+	staged *staged
+}
+
+// PresignedURL returns a URL to download the file that is valid for the given duration, the
+// backend must implement Presigner. Files encrypted with Config.CustomerKeyFunc can only be
+// downloaded sending the key headers.
+func (uf UploadedFile) PresignedURL(ttl time.Duration) (string, error) {
+	backend, bucket, _, err := uf.location()
+	if err != nil {
+		return "", err
+	}
+	p, ok := backend.(Presigner)
+	if !ok {
+		return "", fmt.Errorf("backend doesn't support presigned URLs")
+	}
+	in := &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(uf.Key), RequestPayer: uf.wr.payer()}
+	u, err := p.PresignGet(context.Background(), in, ttl)
+	if err != nil {
+		return "", fmt.Errorf("failed to presign %q: %w", uf.Key, err)
+	}
+	return u, nil
+}
+
+// Open returns the content of the file, the backend must implement Getter. The reader must be closed.
+func (uf UploadedFile) Open(ctx context.Context) (io.ReadCloser, error) {
+	backend, bucket, key, err := uf.location()
+	if err != nil {
+		return nil, err
+	}
+	g, ok := backend.(Getter)
+	if !ok {
+		return nil, fmt.Errorf("backend doesn't support reading files")
+	}
+	in := &s3.GetObjectInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key),
+		RequestPayer:         uf.wr.payer(),
+		SSECustomerAlgorithm: uf.sse.algorithm,
+		SSECustomerKey:       uf.sse.key,
+		SSECustomerKeyMD5:    uf.sse.md5,
+	}
+	out, err := g.Get(ctx, in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %q: %w", key, err)
+	}
+	return out.Body, nil
+}
+
+// Delete removes the file, it's not an error if the file doesn't exist.
+func (uf UploadedFile) Delete(ctx context.Context) error {
+	backend, bucket, key, err := uf.location()
+	if err != nil {
+		return err
+	}
+	in := &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key), RequestPayer: uf.wr.payer()}
+	if _, err := backend.Delete(ctx, in); err != nil {
+		return fmt.Errorf("failed to delete %q: %w", key, err)
+	}
+	return nil
+}
+
+// location returns the backend, bucket and key where the file is stored, which is the staging
+// key until the file is committed.
+func (uf UploadedFile) location() (Backend, string, string, error) {
+	if uf.wr == nil {
+		return nil, "", "", errors.New("file wasn't uploaded by the middleware")
+	}
+	key := uf.Key
+	if uf.staged != nil {
+		key = uf.staged.key
+	}
+	if uf.Fallback != "" {
+		bucket := uf.Bucket
+		if uf.wr.fallback.Bucket != "" {
+			bucket = uf.wr.fallback.Bucket
+		}
+		return uf.wr.fallback.Backend, bucket, key, nil
+	}
+	return uf.wr.backend, uf.Bucket, key, nil
+}
+
+type filesKey struct{}
+
+// FilesFromContext returns the files uploaded by the middleware in the order they were sent,
+// use it with the request context:
+//
+//	files := mps3.FilesFromContext(req.Context())
+//
+// Small files kept in memory (see Config.InlineFileSize) are not included.
+func FilesFromContext(ctx context.Context) []UploadedFile {
+	files, _ := ctx.Value(filesKey{}).([]UploadedFile)
+	return files
+}
+
+// uploadedFile returns the public description of the file.
+func (wr Wrapper) uploadedFile(field string, f file) UploadedFile {
+	uf := UploadedFile{
+		Field:       field,
+		Key:         f.key,
+		Bucket:      f.bucket,
+		Name:        f.name,
+		ContentType: f.ftype,
+		Size:        f.size,
+		ETag:        f.etag,
+		VersionID:   f.version,
+		URL:         f.url,
+		Checksum:    f.checksum,
+		SHA256:      f.sha256,
+		MD5:         f.md5,
+		Fallback:    f.fallback,
+		Duplicate:   f.duplicate,
+		Width:       f.width,
+		Height:      f.height,
+		Quarantine:  f.quarantine,
+		wr:          &wr,
+		sse:         f.sse,
+		staged:      f.staged,
+	}
+	if wr.publicURL != "" {
+		uf.PublicURL = joinURL(wr.publicURL, f.key)
+	}
+	return uf
+}
@@ -0,0 +1,107 @@
+package mps3
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilesFromContext(t *testing.T) {
+	assert := assert.New(t)
+
+	wrapper, err := New(Config{Bucket: bucket, Backend: mps3test.NewBackend(), PublicURL: "https://cdn.example.com"})
+	assert.NoError(err)
+
+	req, err := newRequest(map[string]string{"name": "test"}, "test_file1.png", "test_file2.txt")
+	assert.NoError(err)
+	res := httptest.NewRecorder()
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		files := FilesFromContext(req.Context())
+		assert.Len(files, 2)
+		for i, f := range files {
+			assert.Equal("file", f.Field)
+			assert.Equal(req.Form["file"][i], f.Key)
+			assert.Equal(req.Form["file_name"][i], f.Name)
+			assert.Equal(req.Form["file_type"][i], f.ContentType)
+			assert.Equal(req.Form["file_etag"][i], f.ETag)
+			assert.Equal(bucket, f.Bucket)
+			assert.Equal("https://cdn.example.com"+f.Key, f.PublicURL)
+		}
+		assert.Equal("test_file1.png", files[0].Name)
+		assert.Equal(int64(12), files[1].Size)
+	})).ServeHTTP(res, req)
+	assert.Equal(200, res.Code)
+
+	assert.Nil(FilesFromContext(req.Context()))
+}
+
+func TestUploadedFileMethods(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend})
+	assert.NoError(err)
+
+	var files []UploadedFile
+	req, err := newRequest(nil, "test_file2.txt")
+	assert.NoError(err)
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		files = FilesFromContext(req.Context())
+	})).ServeHTTP(httptest.NewRecorder(), req)
+	assert.Len(files, 1)
+	f := files[0]
+
+	r, err := f.Open(ctx)
+	assert.NoError(err)
+	content, _ := io.ReadAll(r)
+	assert.NoError(r.Close())
+	expected, _ := os.ReadFile("test_file2.txt")
+	assert.Equal(expected, content)
+
+	url, err := f.PresignedURL(time.Minute)
+	assert.NoError(err)
+	assert.Equal("mem://"+bucket+"/"+f.Key+"?X-Amz-Expires=60", url)
+
+	assert.NoError(f.Delete(ctx))
+	assert.Empty(backend.Objects())
+	_, err = f.Open(ctx)
+	assert.Error(err)
+
+	_, err = UploadedFile{Key: "key"}.Open(ctx)
+	assert.Error(err)
+}
+
+func TestUploadedFileInFallback(t *testing.T) {
+	assert := assert.New(t)
+
+	fallback := mps3test.NewBackend()
+	wrapper, err := New(Config{
+		Bucket:   bucket,
+		Backend:  failingBackend{},
+		Logger:   log.New(io.Discard, "", 0),
+		Fallback: &FallbackConfig{Backend: fallback, Bucket: "fallback"},
+	})
+	assert.NoError(err)
+
+	req, err := newRequest(nil, "test_file2.txt")
+	assert.NoError(err)
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		f := FilesFromContext(req.Context())[0]
+		r, err := f.Open(req.Context())
+		assert.NoError(err)
+		content, _ := io.ReadAll(r)
+		assert.Equal("hello world\n", string(content))
+
+		assert.NoError(f.Delete(req.Context()))
+		assert.Empty(fallback.Objects())
+	})).ServeHTTP(httptest.NewRecorder(), req)
+}
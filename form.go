@@ -0,0 +1,186 @@
+package mps3
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Headers of the multipart.FileHeader of uploaded files, see fileHeader.
+const (
+	HeaderKey    = "X-Mps3-Key"
+	HeaderBucket = "X-Mps3-Bucket"
+)
+
+// fileHeader returns the multipart.FileHeader of an uploaded file in req.MultipartForm, so code
+// using req.FormFile keeps working. The key and bucket are in the HeaderKey and HeaderBucket
+// headers. Unless the content was copied (tee, with Config.TeeFiles) the file can't be opened.
+func fileHeader(uf UploadedFile, tee *multipart.FileHeader) *multipart.FileHeader {
+	if tee != nil {
+		tee.Header.Set(HeaderKey, uf.Key)
+		tee.Header.Set(HeaderBucket, uf.Bucket)
+		return tee
+	}
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": uf.Field, "filename": uf.Name}))
+	h.Set("Content-Type", uf.ContentType)
+	h.Set(HeaderKey, uf.Key)
+	h.Set(HeaderBucket, uf.Bucket)
+	return &multipart.FileHeader{Filename: uf.Name, Header: h, Size: uf.Size}
+}
+
+// FormSuffixes are appended to the field name of a file to name the form values with information
+// about the file, e.g. "<field>_name". Empty suffixes keep their default value.
+type FormSuffixes struct {
+	Name       string // default: "_name"
+	Type       string // default: "_type"
+	Size       string // default: "_size"
+	Version    string // default: "_version"
+	ETag       string // default: "_etag"
+	URL        string // default: "_url"
+	PublicURL  string // default: "_public_url"
+	Bucket     string // default: "_bucket"
+	Fallback   string // default: "_fallback"
+	Checksum   string // default: "_" + the lower case checksum algorithm, e.g. "_crc32"
+	SHA256     string // default: "_sha256", also used for the digest declared by the client
+	MD5        string // default: "_md5"
+	Duplicate  string // default: "_duplicate"
+	Meta       string // default: "_meta", see Config.FormMeta
+	Error      string // default: "_error", see Config.ContinueOnError
+	Threat     string // default: "_threat", see ScanConfig
+	Width      string // default: "_width", see Config.ImageLimits
+	Height     string // default: "_height", see Config.ImageLimits
+	Entries    string // default: "_entries", see Config.ExtractArchives
+	Encrypted  string // default: "_encrypted", see Config.EncryptedArchives
+	PII        string // default: "_pii", see Config.PII
+	Quarantine string // default: "_quarantine", see Config.Quarantine
+	UploadMS   string // default: "_upload_ms", see Config.FormUploadTime
+}
+
+// withDefaults returns the suffixes with the default value of the empty ones.
+func (s FormSuffixes) withDefaults(checksumAlgo string) FormSuffixes {
+	def := func(v *string, d string) {
+		if *v == "" {
+			*v = d
+		}
+	}
+	def(&s.Name, "_name")
+	def(&s.Type, "_type")
+	def(&s.Size, "_size")
+	def(&s.Version, "_version")
+	def(&s.ETag, "_etag")
+	def(&s.URL, "_url")
+	def(&s.PublicURL, "_public_url")
+	def(&s.Bucket, "_bucket")
+	def(&s.Fallback, "_fallback")
+	def(&s.Checksum, "_"+strings.ToLower(checksumAlgo))
+	def(&s.SHA256, "_sha256")
+	def(&s.MD5, "_md5")
+	def(&s.Duplicate, "_duplicate")
+	def(&s.Meta, "_meta")
+	def(&s.Error, "_error")
+	def(&s.Threat, "_threat")
+	def(&s.Width, "_width")
+	def(&s.Height, "_height")
+	def(&s.Entries, "_entries")
+	def(&s.Encrypted, "_encrypted")
+	def(&s.PII, "_pii")
+	def(&s.Quarantine, "_quarantine")
+	def(&s.UploadMS, "_upload_ms")
+	return s
+}
+
+// appendFile adds the form values of the uploaded file, values are appended so the ones of files
+// sent in the same field are aligned by index.
+func (wr Wrapper) appendFile(frm url.Values, uf UploadedFile) error {
+	name, sfx := uf.Field, wr.suffixes
+	frm[name] = append(frm[name], uf.Key)
+
+	if wr.formMeta {
+		meta, err := json.Marshal(uf)
+		if err != nil {
+			return fmt.Errorf("failed to encode file information: %w", err)
+		}
+		frm[name+sfx.Meta] = append(frm[name+sfx.Meta], string(meta))
+		return nil
+	}
+
+	frm[name+sfx.Name] = append(frm[name+sfx.Name], uf.Name)
+	frm[name+sfx.Type] = append(frm[name+sfx.Type], uf.ContentType)
+	frm[name+sfx.Size] = append(frm[name+sfx.Size], strconv.FormatInt(uf.Size, 10))
+	frm[name+sfx.Version] = append(frm[name+sfx.Version], uf.VersionID)
+	frm[name+sfx.ETag] = append(frm[name+sfx.ETag], uf.ETag)
+	if wr.presignTTL > 0 {
+		frm[name+sfx.URL] = append(frm[name+sfx.URL], uf.URL)
+	}
+	if wr.publicURL != "" {
+		frm[name+sfx.PublicURL] = append(frm[name+sfx.PublicURL], uf.PublicURL)
+	}
+	if len(wr.buckets) > 0 || wr.bucketFunc != nil || wr.fieldCfgs[name].Bucket != "" {
+		frm[name+sfx.Bucket] = append(frm[name+sfx.Bucket], uf.Bucket)
+	}
+	if wr.fallback != nil {
+		frm[name+sfx.Fallback] = append(frm[name+sfx.Fallback], uf.Fallback)
+	}
+	if wr.checksumAlgo != "" && !(wr.computeSHA256 && wr.checksumAlgo == string(types.ChecksumAlgorithmSha256)) {
+		frm[name+sfx.Checksum] = append(frm[name+sfx.Checksum], uf.Checksum)
+	}
+	if wr.computeSHA256 {
+		frm[name+sfx.SHA256] = append(frm[name+sfx.SHA256], uf.SHA256)
+	}
+	if wr.computeMD5 {
+		frm[name+sfx.MD5] = append(frm[name+sfx.MD5], uf.MD5)
+	}
+	if wr.dedup {
+		frm[name+sfx.Duplicate] = append(frm[name+sfx.Duplicate], strconv.FormatBool(uf.Duplicate))
+	}
+	if wr.imgLimits != nil {
+		frm[name+sfx.Width] = append(frm[name+sfx.Width], dimension(uf.Width))
+		frm[name+sfx.Height] = append(frm[name+sfx.Height], dimension(uf.Height))
+	}
+	if wr.extract {
+		frm[name+sfx.Entries] = append(frm[name+sfx.Entries], entryList(uf.Entries))
+	}
+	if wr.encArchive == EncryptedTag {
+		frm[name+sfx.Encrypted] = append(frm[name+sfx.Encrypted], strconv.FormatBool(uf.Encrypted))
+	}
+	if wr.pii != nil && wr.pii.Action == PIIFlag && len(wr.pii.FileTypes) > 0 {
+		frm[name+sfx.PII] = append(frm[name+sfx.PII], strings.Join(uf.PII, ","))
+	}
+	if wr.quarantine != nil {
+		frm[name+sfx.Quarantine] = append(frm[name+sfx.Quarantine], uf.Quarantine)
+	}
+	if wr.scan != nil && wr.scan.Action != ScanReject {
+		frm[name+sfx.Threat] = append(frm[name+sfx.Threat], uf.Threat)
+	}
+	if wr.uploadMS {
+		frm[name+sfx.UploadMS] = append(frm[name+sfx.UploadMS], strconv.FormatInt(uf.UploadMS, 10))
+	}
+	return nil
+}
+
+// dimension formats the width or height of an image, empty for other files.
+func dimension(v int) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.Itoa(v)
+}
+
+// entryList formats the keys of the files extracted from an archive as a JSON array, empty for
+// other files.
+func entryList(keys []string) string {
+	if keys == nil {
+		return ""
+	}
+	// a list of strings can always be encoded
+	b, _ := json.Marshal(keys)
+	return string(b)
+}
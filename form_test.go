@@ -0,0 +1,88 @@
+package mps3
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormSuffixes(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := Config{FormSuffixes: FormSuffixes{Name: "_filename", Type: "_mime"}}
+	_, form, res := uploadToMemory(t, cfg, nil, "test_file2.txt")
+	assert.Equal(200, res.Code)
+	assert.Equal("test_file2.txt", form.Get("file_filename"))
+	assert.Equal("text/plain; charset=utf-8", form.Get("file_mime"))
+	assert.Equal("12", form.Get("file_size"))
+	assert.NotContains(form, "file_name")
+	assert.NotContains(form, "file_type")
+}
+
+func TestFormMeta(t *testing.T) {
+	assert := assert.New(t)
+
+	_, form, res := uploadToMemory(t, Config{FormMeta: true, ComputeMD5: true}, nil, "test_file1.png", "test_file2.txt")
+	assert.Equal(200, res.Code)
+	assert.Len(form["file"], 2)
+	assert.Len(form["file_meta"], 2)
+	assert.NotContains(form, "file_name")
+	assert.NotContains(form, "file_size")
+
+	var meta map[string]any
+	assert.NoError(json.Unmarshal([]byte(form["file_meta"][1]), &meta))
+	assert.Equal("file", meta["field"])
+	assert.Equal(form["file"][1], meta["key"])
+	assert.Equal("test_file2.txt", meta["name"])
+	assert.Equal(float64(12), meta["size"])
+	assert.Equal("6f5902ac237024bdd0c176cb93063dc4", meta["md5"])
+	assert.NotContains(meta, "url")
+}
+
+func TestFormUploadTime(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := Config{Backend: sleepyBackend{mps3test.NewBackend(), 30 * time.Millisecond}, FormUploadTime: true}
+	_, form, res := uploadToMemory(t, cfg, nil, "test_file1.png", "test_file2.txt")
+	assert.Equal(200, res.Code)
+	assert.Len(form["file_upload_ms"], 2)
+	for _, v := range form["file_upload_ms"] {
+		ms, err := strconv.Atoi(v)
+		assert.NoError(err)
+		assert.GreaterOrEqual(ms, 30)
+	}
+
+	_, form, _ = uploadToMemory(t, Config{}, nil, "test_file2.txt")
+	assert.NotContains(form, "file_upload_ms")
+}
+
+func TestMultipartForm(t *testing.T) {
+	assert := assert.New(t)
+
+	wrapper, err := New(Config{Bucket: bucket, Backend: mps3test.NewBackend()})
+	assert.NoError(err)
+
+	req, err := newRequest(map[string]string{"name": "test"}, "test_file1.png")
+	assert.NoError(err)
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.NoError(req.ParseMultipartForm(1 << 20))
+		assert.Equal("test", req.FormValue("name"))
+		assert.Equal("test", req.MultipartForm.Value["name"][0])
+
+		_, _, err := req.FormFile("file")
+		assert.Error(err) // the content is in the bucket
+
+		fh := req.MultipartForm.File["file"][0]
+		assert.Equal("test_file1.png", fh.Filename)
+		assert.Equal(int64(15716), fh.Size)
+		assert.Equal("image/png", fh.Header.Get("Content-Type"))
+		assert.Equal(req.FormValue("file"), fh.Header.Get(HeaderKey))
+		assert.Equal(bucket, fh.Header.Get(HeaderBucket))
+	})).ServeHTTP(httptest.NewRecorder(), req)
+}
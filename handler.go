@@ -0,0 +1,70 @@
+package mps3
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// UploadResponse is the JSON response of Handler, unless Config.ResponseFunc is set.
+type UploadResponse struct {
+	Files []UploadedFile `json:"files"`
+	// Errors are the files that failed to upload, with Config.ContinueOnError
+	Errors []UploadError `json:"errors,omitempty"`
+}
+
+// UploadError is a file that failed to upload.
+type UploadError struct {
+	Field string `json:"field"`
+	Name  string `json:"name"`
+	Error string `json:"error"`
+	// Status is the StatusCode of the error
+	Status int `json:"status"`
+}
+
+// Handler returns a handler that uploads the files of the request and responds with 201 Created
+// and a JSON document describing them, an UploadResponse or the value returned by
+// Config.ResponseFunc. Requests that fail are handled like the ones of Wrap, requests that are not
+// uploads fail with 405 Method Not Allowed or 415 Unsupported Media Type.
+//
+//	mux.Handle("POST /upload", wrapper.Handler())
+func (wr Wrapper) Handler() http.Handler {
+	return wr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// the middleware calls the handler without uploading anything if it's not an upload
+		if req.Context().Value(filesKey{}) == nil {
+			switch req.Method {
+			case http.MethodPost, http.MethodPut, http.MethodPatch:
+				http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+			default:
+				w.Header().Set("Allow", "POST, PUT, PATCH")
+				http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			}
+			return
+		}
+
+		files := FilesFromContext(req.Context())
+		var doc any
+		if wr.respond != nil {
+			doc = wr.respond(req, files)
+		} else {
+			res := UploadResponse{Files: files}
+			if res.Files == nil {
+				res.Files = []UploadedFile{}
+			}
+			for _, ferr := range FileErrorsFromContext(req.Context()) {
+				res.Errors = append(res.Errors, UploadError{
+					Field:  ferr.Field,
+					Name:   ferr.Name,
+					Error:  ferr.Err.Error(),
+					Status: StatusCode(ferr),
+				})
+			}
+			doc = res
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(doc); err != nil {
+			wr.log(req).ErrorContext(req.Context(), "failed to write upload response", "error", err)
+		}
+	}))
+}
@@ -0,0 +1,71 @@
+package mps3
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, MaxFileSize: 1000, ContinueOnError: true})
+	assert.NoError(err)
+	handler := wrapper.Handler()
+
+	req, err := newRequest(nil, "test_file1.png", "test_file2.txt")
+	assert.NoError(err)
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	assert.Equal(http.StatusCreated, res.Code)
+	assert.Equal("application/json", res.Header().Get("Content-Type"))
+
+	var doc UploadResponse
+	assert.NoError(json.NewDecoder(res.Body).Decode(&doc))
+	assert.Len(doc.Files, 1)
+	assert.Equal("test_file2.txt", doc.Files[0].Name)
+	_, ok := backend.Object(bucket, doc.Files[0].Key)
+	assert.True(ok)
+	assert.Equal([]UploadError{{Field: "file", Name: "test_file1.png", Error: "file is too large: larger than 1000 bytes", Status: http.StatusRequestEntityTooLarge}}, doc.Errors)
+
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(http.StatusMethodNotAllowed, res.Code)
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}")))
+	assert.Equal(http.StatusUnsupportedMediaType, res.Code)
+}
+
+func TestHandlerResponseFunc(t *testing.T) {
+	assert := assert.New(t)
+
+	wrapper, err := New(Config{Bucket: bucket, Backend: mps3test.NewBackend(),
+		ResponseFunc: func(req *http.Request, files []UploadedFile) any {
+			keys := make([]string, len(files))
+			for i, f := range files {
+				keys[i] = f.Key
+			}
+			return map[string]any{"keys": keys, "title": req.FormValue("title")}
+		}})
+	assert.NoError(err)
+
+	req, err := newRequest(map[string]string{"title": "hello"}, "test_file2.txt")
+	assert.NoError(err)
+	res := httptest.NewRecorder()
+	wrapper.Handler().ServeHTTP(res, req)
+	assert.Equal(http.StatusCreated, res.Code)
+
+	var doc struct {
+		Keys  []string `json:"keys"`
+		Title string   `json:"title"`
+	}
+	assert.NoError(json.NewDecoder(res.Body).Decode(&doc))
+	assert.Len(doc.Keys, 1)
+	assert.Equal("hello", doc.Title)
+}
@@ -0,0 +1,94 @@
+package mps3
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// healthProbeKey is the key HealthCheck looks for in the buckets of backends that don't implement
+// BucketChecker, it doesn't need to exist.
+const healthProbeKey = ".mps3-health"
+
+// healthTimeout is the timeout of the checks of HealthHandler.
+const healthTimeout = 5 * time.Second
+
+// BucketChecker is implemented by backends that can check if a bucket exists and is accessible.
+type BucketChecker interface {
+	// HeadBucket fails if the bucket in.Bucket doesn't exist or can't be accessed.
+	HeadBucket(ctx context.Context, in *s3.HeadBucketInput) (*s3.HeadBucketOutput, error)
+}
+
+func (b *s3Backend) HeadBucket(ctx context.Context, in *s3.HeadBucketInput) (*s3.HeadBucketOutput, error) {
+	return b.client.HeadBucket(ctx, in)
+}
+
+func (b *replicatedBackend) HeadBucket(ctx context.Context, in *s3.HeadBucketInput) (*s3.HeadBucketOutput, error) {
+	if bc, ok := b.primary.(BucketChecker); ok {
+		return bc.HeadBucket(ctx, in)
+	}
+	return &s3.HeadBucketOutput{}, probeBucket(ctx, b.primary, aws.ToString(in.Bucket))
+}
+
+// probeBucket checks that the backend is reachable by looking for a file that doesn't need to
+// exist in the bucket.
+func probeBucket(ctx context.Context, backend Backend, bucket string) error {
+	_, err := backend.Head(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(healthProbeKey)})
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// HealthCheck verifies that the buckets where files are stored (except the ones of BucketFunc)
+// are reachable with the credentials, with HeadBucket if the backend implements BucketChecker.
+// If Config.HealthCheckKey is set, it also uploads and deletes a small file under that key in the
+// buckets, verifying that files can be written.
+func (wr Wrapper) HealthCheck(ctx context.Context) error {
+	for _, bucket := range wr.allBuckets {
+		var err error
+		if bc, ok := wr.backend.(BucketChecker); ok {
+			_, err = bc.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+		} else {
+			err = probeBucket(ctx, wr.backend, bucket)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to check bucket %q: %w", bucket, err)
+		}
+		if wr.healthKey == "" {
+			continue
+		}
+		in := &s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String(wr.healthKey), Body: strings.NewReader("ok")}
+		if _, err := wr.backend.Upload(ctx, in); err != nil {
+			return fmt.Errorf("failed to write to bucket %q: %w", bucket, err)
+		}
+		din := &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(wr.healthKey)}
+		if _, err := wr.backend.Delete(ctx, din); err != nil {
+			return fmt.Errorf("failed to delete from bucket %q: %w", bucket, err)
+		}
+	}
+	return nil
+}
+
+// HealthHandler responds to health checks, e.g. the readiness probes of orchestrators, with 200 if
+// HealthCheck succeeds within 5 seconds, otherwise it logs the error and responds with 503.
+//
+//	mux.Handle("/healthz", wrapper.HealthHandler())
+func (wr Wrapper) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), healthTimeout)
+		defer cancel()
+		if err := wr.HealthCheck(ctx); err != nil {
+			wr.log(req).ErrorContext(ctx, "health check failed", "error", err)
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte("ok\n"))
+	})
+}
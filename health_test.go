@@ -0,0 +1,76 @@
+package mps3
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+// bucketCheckerBackend only has the buckets in exists.
+type bucketCheckerBackend struct {
+	*mps3test.Backend
+	exists  []string
+	checked []string
+}
+
+func (b *bucketCheckerBackend) HeadBucket(_ context.Context, in *s3.HeadBucketInput) (*s3.HeadBucketOutput, error) {
+	b.checked = append(b.checked, aws.ToString(in.Bucket))
+	for _, name := range b.exists {
+		if name == aws.ToString(in.Bucket) {
+			return &s3.HeadBucketOutput{}, nil
+		}
+	}
+	return nil, &types.NotFound{}
+}
+
+func TestHealthCheck(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, HealthCheckKey: ".healthz"})
+	assert.NoError(err)
+	assert.NoError(wrapper.HealthCheck(ctx))
+	assert.Empty(backend.Objects())
+
+	res := httptest.NewRecorder()
+	wrapper.HealthHandler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal("ok\n", res.Body.String())
+
+	wrapper, err = New(Config{Bucket: bucket, Backend: failingBackend{}})
+	assert.NoError(err)
+	assert.ErrorContains(wrapper.HealthCheck(ctx), "head failed")
+	res = httptest.NewRecorder()
+	wrapper.HealthHandler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(http.StatusServiceUnavailable, res.Code)
+
+	// the buckets of the fields are checked too, with HeadBucket
+	checker := &bucketCheckerBackend{Backend: mps3test.NewBackend(), exists: []string{bucket}}
+	wrapper, err = New(Config{
+		Buckets:      []string{bucket},
+		Backend:      checker,
+		FieldConfigs: map[string]FieldConfig{"avatar": {Bucket: "avatars"}},
+	})
+	assert.NoError(err)
+	assert.ErrorContains(wrapper.HealthCheck(ctx), `bucket "avatars"`)
+	assert.Equal([]string{bucket, "avatars"}, checker.checked)
+
+	// replicated backends check the primary
+	checker.checked = nil
+	wrapper, err = New(Config{
+		Bucket:  bucket,
+		Backend: checker,
+		Replica: &ReplicaConfig{Backend: failingBackend{}, Bucket: "replica"},
+	})
+	assert.NoError(err)
+	assert.NoError(wrapper.HealthCheck(ctx))
+	assert.Equal([]string{bucket}, checker.checked)
+}
@@ -0,0 +1,115 @@
+package mps3
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadHooks(t *testing.T) {
+	assert := assert.New(t)
+
+	var started, completed []UploadedFile
+	cfg := Config{
+		OnUploadStart: func(req *http.Request, f UploadedFile) error {
+			started = append(started, f)
+			return nil
+		},
+		OnUploadComplete: func(req *http.Request, f UploadedFile, d time.Duration, err error) error {
+			assert.NoError(err)
+			assert.Greater(d, time.Duration(0))
+			completed = append(completed, f)
+			return nil
+		},
+	}
+	_, form, res := uploadToMemory(t, cfg, nil, "test_file1.png", "test_file2.txt")
+	assert.Equal(200, res.Code)
+
+	assert.Len(started, 2)
+	assert.Equal(form["file"][0], started[0].Key)
+	assert.Equal("image/png", started[0].ContentType)
+	assert.Zero(started[0].Size)
+
+	assert.Len(completed, 2)
+	assert.Equal(form["file"][1], completed[1].Key)
+	assert.Equal(int64(12), completed[1].Size)
+	assert.Equal(form["file_etag"][1], completed[1].ETag)
+}
+
+func TestUploadHooksReject(t *testing.T) {
+	assert := assert.New(t)
+
+	var completedErr error
+	cfg := Config{
+		OnUploadStart: func(req *http.Request, f UploadedFile) error {
+			if f.ContentType != "image/png" {
+				return ErrUnsupportedType
+			}
+			return nil
+		},
+		OnUploadComplete: func(req *http.Request, f UploadedFile, d time.Duration, err error) error {
+			completedErr = err
+			return nil
+		},
+	}
+	backend, _, res := uploadToMemory(t, cfg, nil, "test_file2.txt")
+	assert.Equal(http.StatusUnsupportedMediaType, res.Code)
+	assert.ErrorIs(completedErr, ErrUnsupportedType)
+	assert.Empty(backend.Objects())
+
+	cfg = Config{
+		OnUploadComplete: func(req *http.Request, f UploadedFile, d time.Duration, err error) error {
+			return errors.New("database is down")
+		},
+	}
+	_, _, res = uploadToMemory(t, cfg, nil, "test_file2.txt")
+	assert.Equal(http.StatusInternalServerError, res.Code)
+}
+
+func TestAuthorize(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, Authorize: func(req *http.Request) error {
+		if req.Header.Get("Authorization") == "" {
+			return errors.New("missing credentials")
+		}
+		return nil
+	}})
+	assert.NoError(err)
+	upload := func(auth string) (int, bool) {
+		req := newFileRequest(t, "a.txt", "hello")
+		req.Header.Set("Authorization", auth)
+		body := &readRecorder{Reader: req.Body}
+		req.Body = io.NopCloser(body)
+		res := httptest.NewRecorder()
+		wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})).ServeHTTP(res, req)
+		return res.Code, body.read
+	}
+
+	code, read := upload("")
+	assert.Equal(http.StatusUnauthorized, code)
+	assert.False(read)
+	assert.Empty(backend.Objects())
+
+	code, read = upload("Bearer token")
+	assert.Equal(http.StatusOK, code)
+	assert.True(read)
+}
+
+// readRecorder records if the reader was read.
+type readRecorder struct {
+	io.Reader
+	read bool
+}
+
+func (r *readRecorder) Read(p []byte) (int, error) {
+	r.read = true
+	return r.Reader.Read(p)
+}
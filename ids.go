@@ -0,0 +1,46 @@
+package mps3
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// crockford is the base32 alphabet of ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID returns a ULID (https://github.com/ulid/spec), a random ID that sorts by creation time,
+// to be used as Config.IDFunc so keys are listed in upload order.
+func NewULID() string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(time.Now().UnixMilli())<<16)
+	_, _ = rand.Read(b[6:])
+
+	// 128 bits encoded in 26 characters of 5 bits, the first one only has 3 bits
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+type fileIndexKey struct{}
+
+// withFileIndex returns the request with a counter of the files uploaded, see KeyData.Index.
+func withFileIndex(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), fileIndexKey{}, new(atomic.Int64)))
+}
+
+// nextFileIndex returns the index of the next file uploaded in the request, starting at zero.
+func nextFileIndex(req *http.Request) int {
+	if n, ok := req.Context().Value(fileIndexKey{}).(*atomic.Int64); ok {
+		return int(n.Add(1) - 1)
+	}
+	return 0
+}
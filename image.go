@@ -0,0 +1,97 @@
+package mps3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// ImageLimits are the limits of the dimensions of the uploaded images, zero values mean no limit.
+type ImageLimits struct {
+	MaxWidth      int
+	MaxHeight     int
+	MaxMegapixels float64
+}
+
+// imageHeaderLimit is how much of an image is read to decode its header, JPEG files can have
+// large metadata segments before the frame header.
+const imageHeaderLimit = 1 << 20
+
+// imageDecoders decode the header of the images that are checked.
+var imageDecoders = map[string]func(io.Reader) (image.Config, error){
+	"image/png":  png.DecodeConfig,
+	"image/jpeg": jpeg.DecodeConfig,
+	"image/gif":  gif.DecodeConfig,
+	"image/webp": webpConfig,
+}
+
+// checkImage decodes the header of the image and checks its dimensions against Config.ImageLimits,
+// files of other types are ignored. It returns the header read from body, which must be uploaded
+// before the rest of body.
+func (wr Wrapper) checkImage(f *file, head []byte, body io.Reader) ([]byte, error) {
+	decode, ok := imageDecoders[f.ftype]
+	if wr.imgLimits == nil || !ok {
+		return head, nil
+	}
+	var extra bytes.Buffer
+	r := io.MultiReader(bytes.NewReader(head), io.TeeReader(io.LimitReader(body, imageHeaderLimit), &extra))
+	cfg, err := decode(r)
+	head = append(head, extra.Bytes()...)
+	if err != nil {
+		return head, fmt.Errorf("%w: invalid image: %v", ErrUnsupportedType, err)
+	}
+
+	lim := wr.imgLimits
+	switch {
+	case cfg.Width <= 0 || cfg.Height <= 0:
+		return head, fmt.Errorf("%w: invalid image dimensions %dx%d", ErrUnsupportedType, cfg.Width, cfg.Height)
+	case lim.MaxWidth > 0 && cfg.Width > lim.MaxWidth:
+		return head, fmt.Errorf("%w: image wider than %d pixels", ErrTooLarge, lim.MaxWidth)
+	case lim.MaxHeight > 0 && cfg.Height > lim.MaxHeight:
+		return head, fmt.Errorf("%w: image taller than %d pixels", ErrTooLarge, lim.MaxHeight)
+	case lim.MaxMegapixels > 0 && float64(cfg.Width)*float64(cfg.Height) > lim.MaxMegapixels*1e6:
+		return head, fmt.Errorf("%w: image larger than %g megapixels", ErrTooLarge, lim.MaxMegapixels)
+	}
+	f.width, f.height = cfg.Width, cfg.Height
+	return head, nil
+}
+
+// webpConfig returns the dimensions of a WebP image, from the header of its lossy (VP8), lossless
+// (VP8L) or extended (VP8X) format.
+func webpConfig(r io.Reader) (image.Config, error) {
+	var h [30]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return image.Config{}, fmt.Errorf("failed to read WebP header: %w", err)
+	}
+	if string(h[0:4]) != "RIFF" || string(h[8:12]) != "WEBP" {
+		return image.Config{}, errors.New("missing WebP signature")
+	}
+	var w, hgt int
+	switch string(h[12:16]) {
+	case "VP8 ":
+		if h[23] != 0x9d || h[24] != 0x01 || h[25] != 0x2a {
+			return image.Config{}, errors.New("missing VP8 start code")
+		}
+		w = int(binary.LittleEndian.Uint16(h[26:28]) & 0x3fff)
+		hgt = int(binary.LittleEndian.Uint16(h[28:30]) & 0x3fff)
+	case "VP8L":
+		if h[20] != 0x2f {
+			return image.Config{}, errors.New("missing VP8L signature")
+		}
+		bits := binary.LittleEndian.Uint32(h[21:25])
+		w = int(bits&0x3fff) + 1
+		hgt = int(bits>>14&0x3fff) + 1
+	case "VP8X":
+		w = int(uint32(h[24])|uint32(h[25])<<8|uint32(h[26])<<16) + 1
+		hgt = int(uint32(h[27])|uint32(h[28])<<8|uint32(h[29])<<16) + 1
+	default:
+		return image.Config{}, fmt.Errorf("unknown WebP chunk %q", h[12:16])
+	}
+	return image.Config{Width: w, Height: hgt}, nil
+}
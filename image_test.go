@@ -0,0 +1,66 @@
+package mps3
+
+import (
+	"bytes"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImageLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	var pngImg, jpegImg, gifImg bytes.Buffer
+	assert.NoError(png.Encode(&pngImg, img))
+	assert.NoError(jpeg.Encode(&jpegImg, img, nil))
+	assert.NoError(gif.Encode(&gifImg, img, nil))
+	// an extended WebP header of a 40x20 canvas
+	webpImg := []byte("RIFF\x24\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00\x00\x00\x00\x00\x27\x00\x00\x13\x00\x00")
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, ImageLimits: &ImageLimits{MaxWidth: 100, MaxMegapixels: 0.001}, Logger: log.New(io.Discard, "", 0)})
+	assert.NoError(err)
+	upload := func(name string, content []byte) (url.Values, int) {
+		var form url.Values
+		res := httptest.NewRecorder()
+		wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			form = req.Form
+		})).ServeHTTP(res, newFileRequest(t, name, string(content)))
+		return form, res.Code
+	}
+
+	for name, content := range map[string][]byte{"a.png": pngImg.Bytes(), "a.jpg": jpegImg.Bytes(), "a.gif": gifImg.Bytes(), "a.webp": webpImg} {
+		form, code := upload(name, content)
+		assert.Equal(http.StatusOK, code, name)
+		assert.Equal("40", form.Get("file_width"), name)
+		assert.Equal("20", form.Get("file_height"), name)
+		obj, _ := backend.Object(bucket, form.Get("file"))
+		assert.Equal(content, obj.Body, name)
+	}
+
+	// other files don't have dimensions
+	form, code := upload("a.txt", []byte("hello"))
+	assert.Equal(http.StatusOK, code)
+	assert.Equal([]string{""}, form["file_width"])
+
+	// a PNG signature followed by garbage
+	_, code = upload("a.png", append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{1}, 100)...))
+	assert.Equal(http.StatusUnsupportedMediaType, code)
+
+	// 40x30 has more than 0.001 megapixels
+	var large bytes.Buffer
+	assert.NoError(png.Encode(&large, image.NewGray(image.Rect(0, 0, 40, 30))))
+	_, code = upload("a.png", large.Bytes())
+	assert.Equal(http.StatusRequestEntityTooLarge, code)
+}
@@ -0,0 +1,44 @@
+package mps3
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+)
+
+// readInline reads up to limit bytes of the part, returns true if the whole part was read.
+func readInline(part *multipart.Part, limit int64) ([]byte, bool, error) {
+	content, err := io.ReadAll(io.LimitReader(part, limit+1))
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: failed to read file part: %w", ErrMalformedMultipart, err)
+	}
+	return content, int64(len(content)) <= limit, nil
+}
+
+// inlineFile creates a multipart.FileHeader holding the content in memory. The only way to
+// create one is through multipart.Reader, so a single part form is written and parsed again.
+func inlineFile(part *multipart.Part, content []byte) (*multipart.FileHeader, error) {
+	buf := bytes.Buffer{}
+	mw := multipart.NewWriter(&buf)
+	w, err := mw.CreatePart(part.Header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create inline file: %w", err)
+	}
+	if _, err := w.Write(content); err != nil {
+		return nil, fmt.Errorf("failed to create inline file: %w", err)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to create inline file: %w", err)
+	}
+
+	form, err := multipart.NewReader(&buf, mw.Boundary()).ReadForm(int64(len(content)) + 1<<20)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create inline file: %w", err)
+	}
+	files := form.File[part.FormName()]
+	if len(files) == 0 {
+		return nil, fmt.Errorf("failed to create inline file %q", part.FileName())
+	}
+	return files[0], nil
+}
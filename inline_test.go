@@ -0,0 +1,43 @@
+package mps3
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInlineSmallFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{
+		Bucket:         bucket,
+		Backend:        backend,
+		InlineFileSize: 100,
+	})
+	assert.NoError(err)
+
+	req, err := newRequest(map[string]string{"name": "Gabriel"}, "test_file1.png", "test_file2.txt")
+	assert.NoError(err)
+	res := httptest.NewRecorder()
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(1, len(req.Form["file"]))
+		assert.Equal("test_file1.png", req.Form.Get("file_name"))
+		assert.Equal("15716", req.Form.Get("file_size"))
+
+		f, fh, err := req.FormFile("file")
+		assert.NoError(err)
+		content, _ := io.ReadAll(f)
+		assert.Equal("test_file2.txt", fh.Filename)
+		assert.Equal("hello world\n", string(content))
+
+		assert.Equal("Gabriel", req.FormValue("name"))
+	})).ServeHTTP(res, req)
+
+	assert.Equal(200, res.Result().StatusCode)
+	assert.Len(backend.Objects(), 1)
+}
@@ -0,0 +1,94 @@
+package mps3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// isJSONRequest returns true if the request body is a JSON document.
+func isJSONRequest(req *http.Request) bool {
+	ctype, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return ctype == "application/json" || strings.HasSuffix(ctype, "+json")
+}
+
+type jsonKey struct{}
+
+// JSONFromContext returns the JSON body of the request with the files of Config.JSONFields
+// replaced by their keys, use it with the request context:
+//
+//	doc := mps3.JSONFromContext(req.Context())
+//
+// It returns nil if the request body wasn't JSON. The request body is also replaced, so handlers
+// can decode it as usual.
+func JSONFromContext(ctx context.Context) map[string]any {
+	doc, _ := ctx.Value(jsonKey{}).(map[string]any)
+	return doc
+}
+
+// readJSON uploads the base64 content of the JSON fields of the request body, replacing it with
+// the keys of the objects. Fields can have a string or an array of strings.
+func (wr Wrapper) readJSON(req *http.Request, res *result) error {
+	dec := json.NewDecoder(req.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&res.json); err != nil {
+		return fmt.Errorf("%w: failed to decode JSON body: %w", ErrMalformedMultipart, err)
+	}
+
+	for _, name := range wr.jsonFields {
+		switch v := res.json[name].(type) {
+		case nil:
+		case string:
+			key, err := wr.storeJSONFile(req, name, v, res)
+			if err != nil {
+				return err
+			}
+			res.json[name] = key
+		case []any:
+			for i, item := range v {
+				s, ok := item.(string)
+				if !ok {
+					return fmt.Errorf("%w: JSON field %q must be an array of strings", ErrMalformedMultipart, name)
+				}
+				key, err := wr.storeJSONFile(req, name, s, res)
+				if err != nil {
+					return err
+				}
+				v[i] = key
+			}
+		default:
+			return fmt.Errorf("%w: JSON field %q must be a string or an array of strings", ErrMalformedMultipart, name)
+		}
+	}
+
+	// the body was consumed, the handler gets the document with the keys
+	body, err := json.Marshal(res.json)
+	if err != nil {
+		return fmt.Errorf("failed to encode JSON body: %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	return nil
+}
+
+// storeJSONFile uploads the base64 content, or data URI, of a JSON field and returns its key.
+func (wr Wrapper) storeJSONFile(req *http.Request, field, value string, res *result) (string, error) {
+	part, body := readDataURI(field, strings.NewReader(value))
+	if part == nil {
+		part, body = filePart(field, "file", ""), newBase64Reader(body)
+	}
+	n := len(res.uploaded)
+	if err := wr.storeFile(req, part, body, res); err != nil {
+		return "", err
+	}
+	if len(res.uploaded) == n {
+		// empty files are skipped with SkipEmptyFiles
+		return "", nil
+	}
+	return res.uploaded[len(res.uploaded)-1].Key, nil
+}
@@ -0,0 +1,66 @@
+package mps3
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONFields(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, JSONFields: []string{"avatar", "attachments"}})
+	assert.NoError(err)
+
+	doc, err := json.Marshal(map[string]any{
+		"title":       "hello",
+		"count":       json.Number("12345678901234567890"),
+		"avatar":      "data:text/plain;name=avatar.txt;base64," + base64.StdEncoding.EncodeToString([]byte("avatar")),
+		"attachments": []string{base64.StdEncoding.EncodeToString([]byte("first")), base64.StdEncoding.EncodeToString([]byte("second"))},
+	})
+	assert.NoError(err)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(doc)))
+	req.Header.Set("Content-Type", "application/json")
+	res := httptest.NewRecorder()
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		doc := JSONFromContext(req.Context())
+		assert.Equal("hello", doc["title"])
+		assert.Equal(json.Number("12345678901234567890"), doc["count"])
+
+		obj, ok := backend.Object(bucket, doc["avatar"].(string))
+		assert.True(ok)
+		assert.Equal("avatar", string(obj.Body))
+		assert.Equal("text/plain; charset=utf-8", aws.ToString(obj.Input.ContentType))
+		for i, content := range []string{"first", "second"} {
+			obj, ok := backend.Object(bucket, doc["attachments"].([]any)[i].(string))
+			assert.True(ok)
+			assert.Equal(content, string(obj.Body))
+		}
+
+		// the body has the keys too
+		var body map[string]any
+		assert.NoError(json.NewDecoder(req.Body).Decode(&body))
+		assert.Equal(doc["avatar"], body["avatar"])
+		assert.Len(FilesFromContext(req.Context()), 3)
+	})).ServeHTTP(res, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	for _, body := range []string{`{"avatar": 1}`, `{"avatar": "!!!"}`, `not json`} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			t.Error("handler should not be called")
+		})).ServeHTTP(res, req)
+		assert.Equal(http.StatusBadRequest, res.Code, body)
+	}
+}
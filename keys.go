@@ -0,0 +1,78 @@
+package mps3
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// KeyData holds the values available to a KeyTemplate.
+type KeyData struct {
+	// Year, Month and Day of the upload (UTC), zero padded (e.g. "2024", "01", "02")
+	Year, Month, Day string
+	// UUID is a random UUID generated for each file
+	UUID string
+	// ID is generated for each file by Config.IDFunc, a random UUID by default
+	ID string
+	// Index is the position of the file in the request, starting at zero
+	Index int
+	// Filename is the original file name, Name is the file name without the extension
+	// and Ext is the extension including the dot (e.g. ".png")
+	Filename, Name, Ext string
+	// ContentType is the detected content type of the file
+	ContentType string
+	// UserID is the value returned by Config.UserIDFunc, if set
+	UserID string
+	// Request is the request being handled
+	Request *http.Request
+}
+
+// newKeyData returns the template values for the given file.
+func newKeyData(req *http.Request, filename, contentType, userID, id string) KeyData {
+	now := time.Now().UTC()
+	ext := filepath.Ext(filename)
+	return KeyData{
+		Year:        now.Format("2006"),
+		Month:       now.Format("01"),
+		Day:         now.Format("02"),
+		UUID:        uuid.NewString(),
+		ID:          id,
+		Index:       nextFileIndex(req),
+		Filename:    filename,
+		Name:        strings.TrimSuffix(filename, ext),
+		Ext:         ext,
+		ContentType: contentType,
+		UserID:      userID,
+		Request:     req,
+	}
+}
+
+// templateKeyFunc parses the KeyTemplate and returns a key function that renders it for each file.
+func templateKeyFunc(text string, userIDFunc func(*http.Request) string, idFunc func() string) (func(*http.Request, string, string) (string, error), error) {
+	tmpl, err := template.New("key").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key template: %w", err)
+	}
+	// render once with sample values so unknown fields are reported right away
+	sample := newKeyData(&http.Request{Header: http.Header{}}, "file.txt", "text/plain", "", idFunc())
+	if err := tmpl.Execute(&strings.Builder{}, sample); err != nil {
+		return nil, fmt.Errorf("invalid key template: %w", err)
+	}
+
+	return func(req *http.Request, filename, contentType string) (string, error) {
+		var userID string
+		if userIDFunc != nil {
+			userID = userIDFunc(req)
+		}
+		var key strings.Builder
+		if err := tmpl.Execute(&key, newKeyData(req, filename, contentType, userID, idFunc())); err != nil {
+			return "", fmt.Errorf("failed to render key template: %w", err)
+		}
+		return key.String(), nil
+	}, nil
+}
@@ -0,0 +1,73 @@
+package mps3
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyTemplate(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := Config{
+		KeyTemplate: "/{{.Year}}/{{.UserID}}/{{.UUID}}{{.Ext}}",
+		UserIDFunc: func(*http.Request) string {
+			return "user-1"
+		},
+	}
+	backend, form, res := uploadToMemory(t, cfg, nil, "test_file2.txt")
+	assert.Equal(200, res.Code)
+
+	year := time.Now().UTC().Format("2006")
+	assert.Regexp(regexp.MustCompile(`^/`+year+`/user-1/[0-9a-f-]{36}\.txt$`), form.Get("file"))
+	_, ok := backend.Object(bucket, form.Get("file"))
+	assert.True(ok)
+}
+
+func TestKeyTemplateInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := New(Config{Bucket: bucket, Backend: mps3test.NewBackend(), KeyTemplate: "{{.Missing}}"})
+	assert.ErrorContains(err, "invalid key template")
+
+	_, err = New(Config{Bucket: bucket, Backend: mps3test.NewBackend(), KeyTemplate: "{{.UUID"})
+	assert.ErrorContains(err, "failed to parse key template")
+
+	_, err = New(Config{
+		Bucket:      bucket,
+		Backend:     mps3test.NewBackend(),
+		KeyTemplate: "{{.UUID}}",
+		KeyFunc:     func(*http.Request, string, string) string { return "" },
+	})
+	assert.Error(err)
+}
+
+func TestIDFunc(t *testing.T) {
+	assert := assert.New(t)
+
+	_, form, res := uploadToMemory(t, Config{IDFunc: func() string { return "id" }}, nil, "test_file2.txt")
+	assert.Equal(200, res.Code)
+	assert.Equal(time.Now().UTC().Format("/2006/01/02/")+"id", form.Get("file"))
+
+	_, form, res = uploadToMemory(t, Config{IDFunc: NewULID, KeyTemplate: "/{{.ID}}/{{.Index}}{{.Ext}}"}, nil, "test_file1.png", "test_file2.txt")
+	assert.Equal(200, res.Code)
+	assert.Regexp(regexp.MustCompile(`^/[0-9A-HJKMNP-TV-Z]{26}/0\.png$`), form["file"][0])
+	assert.Regexp(regexp.MustCompile(`^/[0-9A-HJKMNP-TV-Z]{26}/1\.txt$`), form["file"][1])
+}
+
+func TestNewULID(t *testing.T) {
+	assert := assert.New(t)
+
+	first := NewULID()
+	time.Sleep(2 * time.Millisecond)
+	second := NewULID()
+	assert.Len(first, 26)
+	assert.NotEqual(first, second)
+	assert.Less(first, second)
+	// the first character only has 3 bits until the year 10889
+	assert.LessOrEqual(first[0], byte('7'))
+}
@@ -0,0 +1,106 @@
+package mps3
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxFileSize(t *testing.T) {
+	assert := assert.New(t)
+
+	backend, _, res := uploadToMemory(t, Config{MaxFileSize: 100}, nil, "test_file2.txt", "test_file1.png")
+	assert.Equal(http.StatusRequestEntityTooLarge, res.Code)
+	assert.Empty(backend.Objects())
+
+	backend, form, res := uploadToMemory(t, Config{MaxFileSize: 12}, nil, "test_file2.txt")
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Len(backend.Objects(), 1)
+	assert.Equal("12", form.Get("file_size"))
+}
+
+func TestMaxFieldSize(t *testing.T) {
+	assert := assert.New(t)
+	cfg := Config{MaxFieldSize: 5, MaxFieldCount: 2}
+
+	_, form, res := uploadToMemory(t, cfg, map[string]string{"a": "hello", "b": "world"}, "test_file2.txt")
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal("hello", form.Get("a"))
+
+	backend, _, res := uploadToMemory(t, cfg, map[string]string{"a": "hello!"}, "test_file2.txt")
+	assert.Equal(http.StatusRequestEntityTooLarge, res.Code)
+	assert.Empty(backend.Objects())
+
+	backend, _, res = uploadToMemory(t, cfg, map[string]string{"a": "1", "b": "2", "c": "3"}, "test_file2.txt")
+	assert.Equal(http.StatusRequestEntityTooLarge, res.Code)
+	assert.Empty(backend.Objects())
+}
+
+func TestAllowedTypes(t *testing.T) {
+	assert := assert.New(t)
+
+	backend, _, res := uploadToMemory(t, Config{AllowedTypes: []string{"image/*"}}, nil, "test_file1.png", "test_file2.txt")
+	assert.Equal(http.StatusUnsupportedMediaType, res.Code)
+	assert.Empty(backend.Objects())
+
+	backend, _, res = uploadToMemory(t, Config{AllowedTypes: []string{"image/png", "text/plain"}}, nil, "test_file1.png", "test_file2.txt")
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Len(backend.Objects(), 2)
+
+	assert.True(typeAllowed([]string{"IMAGE/*"}, "image/jpeg"))
+	assert.True(typeAllowed([]string{"text/plain"}, "text/plain; charset=utf-8"))
+	assert.False(typeAllowed([]string{"image/*"}, "imagex/png"))
+	assert.True(typeAllowed(nil, "application/octet-stream"))
+}
+
+func TestMinFileSize(t *testing.T) {
+	assert := assert.New(t)
+
+	backend, _, res := uploadToMemory(t, Config{MinFileSize: 13}, nil, "test_file1.png", "test_file2.txt")
+	assert.Equal(http.StatusBadRequest, res.Code)
+	assert.Empty(backend.Objects())
+
+	backend, _, res = uploadToMemory(t, Config{MinFileSize: 12}, nil, "test_file1.png", "test_file2.txt")
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Len(backend.Objects(), 2)
+}
+
+func TestEmptyFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	serve := func(cfg Config) (*mps3test.Backend, url.Values, int) {
+		backend := mps3test.NewBackend()
+		cfg.Bucket = bucket
+		cfg.Backend = backend
+		cfg.Logger = log.New(io.Discard, "", 0)
+		wrapper, err := New(cfg)
+		assert.NoError(err)
+
+		res := httptest.NewRecorder()
+		var form url.Values
+		wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			form = req.Form
+		})).ServeHTTP(res, newFileRequest(t, "empty.txt", ""))
+		return backend, form, res.Code
+	}
+
+	backend, form, code := serve(Config{})
+	assert.Equal(http.StatusOK, code)
+	assert.Len(backend.Objects(), 1)
+	assert.Equal("0", form.Get("file_size"))
+
+	backend, form, code = serve(Config{SkipEmptyFiles: true})
+	assert.Equal(http.StatusOK, code)
+	assert.Empty(backend.Objects())
+	assert.Empty(form["file"])
+
+	backend, _, code = serve(Config{MinFileSize: 1})
+	assert.Equal(http.StatusBadRequest, code)
+	assert.Empty(backend.Objects())
+}
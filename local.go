@@ -0,0 +1,195 @@
+package mps3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// localBackend stores files in the local filesystem, used for development.
+type localBackend struct {
+	dir string
+}
+
+// NewLocalBackend creates a backend that stores files under `<dir>/<bucket>/<key>`.
+func NewLocalBackend(dir string) Backend {
+	return &localBackend{dir: dir}
+}
+
+func (b *localBackend) Upload(_ context.Context, in *s3.PutObjectInput) (*manager.UploadOutput, error) {
+	name := b.path(aws.ToString(in.Bucket), aws.ToString(in.Key))
+	if err := b.write(name, in.Body, aws.ToString(in.IfNoneMatch) == "*"); err != nil {
+		return nil, err
+	}
+	return &manager.UploadOutput{
+		Location: "file://" + filepath.ToSlash(name),
+		Key:      in.Key,
+	}, nil
+}
+
+func (b *localBackend) Copy(_ context.Context, in *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	bucket, key, err := ParseCopySource(aws.ToString(in.CopySource))
+	if err != nil {
+		return nil, err
+	}
+	src, err := os.Open(b.path(bucket, key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer src.Close()
+
+	if err := b.write(b.path(aws.ToString(in.Bucket), aws.ToString(in.Key)), src, false); err != nil {
+		return nil, err
+	}
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (b *localBackend) Delete(_ context.Context, in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	err := os.Remove(b.path(aws.ToString(in.Bucket), aws.ToString(in.Key)))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to delete file: %w", err)
+	}
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (b *localBackend) Head(_ context.Context, in *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	info, err := os.Stat(b.path(aws.ToString(in.Bucket), aws.ToString(in.Key)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, &types.NotFound{Message: aws.String(err.Error())}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(info.Size()),
+		LastModified:  aws.Time(info.ModTime()),
+	}, nil
+}
+
+func (b *localBackend) Get(_ context.Context, in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	f, err := os.Open(b.path(aws.ToString(in.Bucket), aws.ToString(in.Key)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, &types.NoSuchKey{Message: aws.String(err.Error())}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	return &s3.GetObjectOutput{
+		Body:          f,
+		ContentLength: aws.Int64(info.Size()),
+		LastModified:  aws.Time(info.ModTime()),
+	}, nil
+}
+
+// List walks the bucket directory, the keys start with "/" since the files don't keep the exact key
+// they were stored with. The continuation token is the last key of the previous page.
+func (b *localBackend) List(_ context.Context, in *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	root := b.path(aws.ToString(in.Bucket), "")
+	prefix := "/" + strings.TrimPrefix(aws.ToString(in.Prefix), "/")
+	after := aws.ToString(in.ContinuationToken)
+	limit := int(aws.ToInt32(in.MaxKeys))
+	if limit <= 0 || limit > 1000 {
+		limit = 1000
+	}
+
+	var objects []types.Object
+	err := filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".mps3-") {
+			return err
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		key := "/" + filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) || key <= after {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, types.Object{
+			Key:          aws.String(key),
+			Size:         aws.Int64(info.Size()),
+			LastModified: aws.Time(info.ModTime()),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	slices.SortFunc(objects, func(a, b types.Object) int {
+		return strings.Compare(*a.Key, *b.Key)
+	})
+	out := &s3.ListObjectsV2Output{Name: in.Bucket, Prefix: in.Prefix, IsTruncated: aws.Bool(len(objects) > limit)}
+	if len(objects) > limit {
+		objects = objects[:limit]
+		out.NextContinuationToken = objects[limit-1].Key
+	}
+	out.Contents = objects
+	out.KeyCount = aws.Int32(int32(len(objects)))
+	return out, nil
+}
+
+// write writes the file, through a temporary file so partially written files are never visible.
+// If exclusive it fails if the file already exists.
+func (b *localBackend) write(name string, r io.Reader, exclusive bool) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".mps3-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if exclusive {
+		// unlike rename, link doesn't replace an existing file
+		if err := os.Link(tmp.Name(), name); err != nil {
+			if errors.Is(err, fs.ErrExist) {
+				return errPreconditionFailed(name)
+			}
+			return fmt.Errorf("failed to link file: %w", err)
+		}
+		return nil
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+	return nil
+}
+
+// path returns the file path for the object, it never points outside of the base directory.
+func (b *localBackend) path(bucket, key string) string {
+	return filepath.Join(b.dir, filepath.FromSlash(path.Clean("/"+bucket)), filepath.FromSlash(path.Clean("/"+key)))
+}
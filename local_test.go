@@ -0,0 +1,107 @@
+package mps3
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadFilesToLocalDir(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	req, err := newRequest(nil, "test_file1.png", "test_file2.txt")
+	assert.NoError(err)
+	res := httptest.NewRecorder()
+
+	wrapper, err := New(Config{
+		Bucket:   bucket,
+		LocalDir: dir,
+	})
+	assert.NoError(err)
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(2, len(req.Form["file"]))
+
+		for i, name := range []string{"test_file1.png", "test_file2.txt"} {
+			expected, err := os.ReadFile(name)
+			assert.NoError(err)
+			actual, err := os.ReadFile(filepath.Join(dir, bucket, filepath.FromSlash(req.Form["file"][i])))
+			assert.NoError(err)
+			assert.Equal(expected, actual)
+		}
+
+		assert.Equal("image/png", req.Form["file_type"][0])
+	})
+	wrapper.Wrap(h).ServeHTTP(res, req)
+
+	assert.Equal(200, res.Result().StatusCode)
+}
+
+func TestLocalBackendPathStaysInsideDir(t *testing.T) {
+	b := localBackend{dir: "/data"}
+	assert.Equal(t, filepath.FromSlash("/data/test/a/b"), b.path("test", "/a/b"))
+	assert.Equal(t, filepath.FromSlash("/data/test/etc/passwd"), b.path("test", "../../etc/passwd"))
+	assert.Equal(t, filepath.FromSlash("/data/etc/passwd"), b.path("..", "etc/passwd"))
+}
+
+func TestLocalBackendCopyAndDelete(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	b := NewLocalBackend(t.TempDir())
+	_, err := b.Upload(ctx, &s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String("/a/src"), Body: strings.NewReader("data")})
+	assert.NoError(err)
+
+	_, err = b.Copy(ctx, &s3.CopyObjectInput{Bucket: aws.String(bucket), Key: aws.String("/b/dst"), CopySource: aws.String(CopySource(bucket, "/a/src"))})
+	assert.NoError(err)
+	content, err := os.ReadFile(b.(*localBackend).path(bucket, "/b/dst"))
+	assert.NoError(err)
+	assert.Equal("data", string(content))
+
+	_, err = b.Delete(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String("/a/src")})
+	assert.NoError(err)
+	_, err = os.Stat(b.(*localBackend).path(bucket, "/a/src"))
+	assert.True(os.IsNotExist(err))
+
+	_, err = b.Delete(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String("/a/src")})
+	assert.NoError(err)
+}
+
+func TestLocalBackendList(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	b := NewLocalBackend(t.TempDir())
+	for _, key := range []string{"/a/2", "/a/1", "/b/1"} {
+		_, err := b.Upload(ctx, &s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String(key), Body: strings.NewReader(key)})
+		assert.NoError(err)
+	}
+
+	in := &s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String("/a/"), MaxKeys: aws.Int32(1)}
+	out, err := b.(Lister).List(ctx, in)
+	assert.NoError(err)
+	assert.Len(out.Contents, 1)
+	assert.Equal("/a/1", *out.Contents[0].Key)
+	assert.Equal(int64(4), *out.Contents[0].Size)
+	assert.True(*out.IsTruncated)
+
+	in.ContinuationToken = out.NextContinuationToken
+	out, err = b.(Lister).List(ctx, in)
+	assert.NoError(err)
+	assert.Len(out.Contents, 1)
+	assert.Equal("/a/2", *out.Contents[0].Key)
+	assert.False(*out.IsTruncated)
+
+	out, err = b.(Lister).List(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("missing")})
+	assert.NoError(err)
+	assert.Empty(out.Contents)
+}
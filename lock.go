@@ -0,0 +1,53 @@
+package mps3
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ObjectLock defines the S3 Object Lock settings of uploaded files, the bucket must have Object
+// Lock enabled. The zero value doesn't lock files.
+type ObjectLock struct {
+	// Mode is the retention mode, "GOVERNANCE" or "COMPLIANCE". It requires RetainUntil or
+	// RetainFor, otherwise the files aren't retained.
+	Mode string
+
+	// RetainUntil is the date until the file is retained
+	RetainUntil time.Time
+
+	// RetainFor if RetainUntil is not set, the file is retained for this duration after the upload
+	RetainFor time.Duration
+
+	// LegalHold if true a legal hold is placed on the file
+	LegalHold bool
+}
+
+// setObjectLock sets the Object Lock settings of the upload.
+func (wr Wrapper) setObjectLock(req *http.Request, filename string, in *s3.PutObjectInput) {
+	lock := wr.objectLock
+	if wr.objectLockFunc != nil {
+		lock = wr.objectLockFunc(req, filename)
+	}
+
+	// without a retention period the date would have passed when the file is stored
+	if lock.Mode != "" && (!lock.RetainUntil.IsZero() || lock.RetainFor > 0) {
+		until := lock.RetainUntil
+		if until.IsZero() {
+			until = time.Now().Add(lock.RetainFor)
+		}
+		in.ObjectLockMode = types.ObjectLockMode(lock.Mode)
+		in.ObjectLockRetainUntilDate = aws.Time(until.UTC())
+	}
+	if lock.LegalHold {
+		in.ObjectLockLegalHoldStatus = types.ObjectLockLegalHoldStatusOn
+	}
+
+	// S3 requires an integrity check for uploads with Object Lock settings
+	if (in.ObjectLockMode != "" || in.ObjectLockLegalHoldStatus != "") && in.ChecksumAlgorithm == "" {
+		in.ChecksumAlgorithm = types.ChecksumAlgorithmCrc32
+	}
+}
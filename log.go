@@ -0,0 +1,68 @@
+package mps3
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// newPrintfHandler adapts a Logger to slog, the records are formatted like slog.TextHandler does
+// without the time, which the Logger usually adds.
+func newPrintfHandler(l Logger) slog.Handler {
+	return slog.NewTextHandler(printfWriter{l}, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+}
+
+// printfWriter writes each record of slog.TextHandler with the Logger.
+type printfWriter struct {
+	logger Logger
+}
+
+func (pw printfWriter) Write(p []byte) (int, error) {
+	pw.logger.Printf("%s", bytes.TrimSuffix(p, []byte("\n")))
+	return len(p), nil
+}
+
+// log returns the logger of a request, with its method, path and ID (see Config.RequestIDFunc),
+// if it has one.
+func (wr Wrapper) log(req *http.Request) *slog.Logger {
+	logger := wr.logger.With("method", req.Method, "path", req.URL.Path)
+	if id := wr.requestID(req); id != "" {
+		logger = logger.With("request_id", id)
+	}
+	return logger
+}
+
+// logFile logs, at debug level, that a file was uploaded or failed to upload.
+func (wr Wrapper) logFile(req *http.Request, field, name string, uf UploadedFile, d time.Duration, err error) {
+	attrs := []any{"field", field, "filename", name}
+	if err != nil {
+		wr.log(req).DebugContext(req.Context(), "failed to upload file", append(attrs, "duration", d, "error", err)...)
+		return
+	}
+	attrs = append(attrs, "bucket", uf.Bucket, "key", uf.Key, "size", uf.Size, "duration", d)
+	wr.log(req).DebugContext(req.Context(), "uploaded file", attrs...)
+}
+
+// logSummary logs the summary of a request, see Config.LogSummary.
+func (wr Wrapper) logSummary(req *http.Request, rm *requestMetrics, err error) {
+	rm.mu.Lock()
+	attrs := []any{"files", rm.files, "failed_files", rm.failed, "bytes", rm.bytes, "duration", time.Since(rm.start)}
+	if rm.slowest != "" {
+		attrs = append(attrs, "slowest_file", rm.slowest, "slowest_duration", rm.slowestD)
+	}
+	rm.mu.Unlock()
+	if err != nil {
+		attrs = append(attrs, "result", "error", "status", StatusCode(err))
+	} else {
+		attrs = append(attrs, "result", "ok")
+	}
+	wr.log(req).InfoContext(req.Context(), "upload summary", attrs...)
+}
@@ -0,0 +1,124 @@
+package mps3
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlog(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	upload := func(cfg Config, content string) []map[string]any {
+		buf.Reset()
+		cfg.Bucket, cfg.Backend, cfg.Slog = bucket, mps3test.NewBackend(), logger
+		wrapper, err := New(cfg)
+		assert.NoError(err)
+		req := newFileRequest(t, "a.txt", content)
+		req.Header.Set("X-Request-ID", "req-1")
+		wrapper.Wrap(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), req)
+
+		var records []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var record map[string]any
+			assert.NoError(json.Unmarshal([]byte(line), &record))
+			records = append(records, record)
+		}
+		return records
+	}
+
+	records := upload(Config{}, "hello")
+	assert.Len(records, 1)
+	assert.Equal("DEBUG", records[0]["level"])
+	assert.Equal("uploaded file", records[0]["msg"])
+	assert.Equal("req-1", records[0]["request_id"])
+	assert.Equal("file", records[0]["field"])
+	assert.Equal("a.txt", records[0]["filename"])
+	assert.NotEmpty(records[0]["key"])
+	assert.EqualValues(5, records[0]["size"])
+	assert.Contains(records[0], "duration")
+
+	records = upload(Config{MaxFileSize: 2}, "hello")
+	assert.Len(records, 2)
+	assert.Equal("failed to upload file", records[0]["msg"])
+	assert.Equal("ERROR", records[1]["level"])
+	assert.Equal("failed to handle request", records[1]["msg"])
+	assert.Equal("req-1", records[1]["request_id"])
+	assert.EqualValues(http.StatusRequestEntityTooLarge, records[1]["status"])
+	assert.Contains(records[1]["error"], "too large")
+}
+
+func TestLoggerAdapter(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	_, _, res := uploadToMemory(t, Config{MaxFileSize: 2, Logger: log.New(&buf, "", 0)}, nil, "test_file2.txt")
+	assert.Equal(http.StatusRequestEntityTooLarge, res.Code)
+	// debug records are dropped and the time is left to the Logger
+	assert.True(strings.HasPrefix(buf.String(), `level=ERROR msg="failed to handle request" method=POST path=/ status=413 error=`))
+	assert.Equal(1, strings.Count(buf.String(), "\n"))
+}
+
+func TestLogSummary(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	summary := func(cfg Config) map[string]any {
+		buf.Reset()
+		cfg.Bucket, cfg.Backend, cfg.Slog, cfg.LogSummary = bucket, mps3test.NewBackend(), logger, true
+		wrapper, err := New(cfg)
+		assert.NoError(err)
+		req, err := newRequest(nil, "test_file1.png", "test_file2.txt")
+		assert.NoError(err)
+		wrapper.Wrap(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), req)
+
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var record map[string]any
+			assert.NoError(json.Unmarshal([]byte(line), &record))
+			if record["msg"] == "upload summary" {
+				return record
+			}
+		}
+		t.Fatal("no summary logged")
+		return nil
+	}
+
+	sizes := int64(0)
+	for _, name := range []string{"test_file1.png", "test_file2.txt"} {
+		info, err := os.Stat(name)
+		assert.NoError(err)
+		sizes += info.Size()
+	}
+	record := summary(Config{})
+	assert.Equal("INFO", record["level"])
+	assert.EqualValues(2, record["files"])
+	assert.EqualValues(0, record["failed_files"])
+	assert.EqualValues(sizes, record["bytes"])
+	assert.Contains([]any{"test_file1.png", "test_file2.txt"}, record["slowest_file"])
+	assert.Contains(record, "slowest_duration")
+	assert.Contains(record, "duration")
+	assert.Equal("ok", record["result"])
+
+	record = summary(Config{AllowedTypes: []string{"image/png"}})
+	assert.EqualValues(1, record["files"])
+	assert.EqualValues(1, record["failed_files"])
+	assert.Equal("error", record["result"])
+	assert.EqualValues(http.StatusUnsupportedMediaType, record["status"])
+
+	// without LogSummary there's no summary
+	buf.Reset()
+	_, _, _ = uploadToMemory(t, Config{Slog: logger}, nil, "test_file2.txt")
+	assert.NotContains(buf.String(), "upload summary")
+}
@@ -0,0 +1,39 @@
+package mps3
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// PathPrefix returns a Config.ShouldHandle function that matches requests whose path starts with
+// any of the prefixes.
+func PathPrefix(prefixes ...string) func(*http.Request) bool {
+	return func(req *http.Request) bool {
+		return slices.ContainsFunc(prefixes, func(p string) bool {
+			return strings.HasPrefix(req.URL.Path, p)
+		})
+	}
+}
+
+// Methods returns a Config.ShouldHandle function that matches requests with any of the methods.
+func Methods(methods ...string) func(*http.Request) bool {
+	return func(req *http.Request) bool {
+		return slices.ContainsFunc(methods, func(m string) bool {
+			return strings.EqualFold(req.Method, m)
+		})
+	}
+}
+
+// MatchAll returns a Config.ShouldHandle function that matches requests matched by all the functions,
+// e.g. MatchAll(Methods(http.MethodPost), PathPrefix("/uploads/")).
+func MatchAll(matchers ...func(*http.Request) bool) func(*http.Request) bool {
+	return func(req *http.Request) bool {
+		for _, m := range matchers {
+			if !m(req) {
+				return false
+			}
+		}
+		return true
+	}
+}
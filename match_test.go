@@ -0,0 +1,40 @@
+package mps3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldHandle(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{
+		Bucket:       bucket,
+		Backend:      backend,
+		ShouldHandle: MatchAll(Methods(http.MethodPost), PathPrefix("/uploads/", "/avatars/")),
+	})
+	assert.NoError(err)
+
+	for path, handled := range map[string]bool{"/uploads/new": true, "/avatars/": true, "/other": false} {
+		backend.Reset()
+		req := newFileRequest(t, "notes.txt", "hello")
+		req.URL.Path = path
+
+		var parsed bool
+		wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			parsed = req.Form != nil
+		})).ServeHTTP(httptest.NewRecorder(), req)
+		assert.Equal(handled, parsed, path)
+		assert.Equal(handled, len(backend.Objects()) == 1, path)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/uploads/", nil)
+	assert.False(Methods(http.MethodPost, http.MethodPut)(req))
+	assert.True(Methods("get")(req))
+	assert.True(MatchAll()(req))
+}
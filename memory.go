@@ -0,0 +1,99 @@
+package mps3
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+)
+
+// bufferPool reuses the PartSize buffers of the middleware between requests.
+type bufferPool struct {
+	size int64
+	pool sync.Pool
+}
+
+func newBufferPool(size int64) *bufferPool {
+	bp := &bufferPool{size: size}
+	bp.pool.New = func() any {
+		buf := make([]byte, size)
+		return &buf
+	}
+	return bp
+}
+
+// get returns a buffer of PartSize bytes, it must be returned with put once it's not used.
+func (bp *bufferPool) get() *[]byte {
+	return bp.pool.Get().(*[]byte)
+}
+
+func (bp *bufferPool) put(buf *[]byte) {
+	bp.pool.Put(buf)
+}
+
+// budget limits the memory of the buffers of the uploads in progress, see Config.MemoryBudget.
+type budget struct {
+	total int64
+
+	mu   sync.Mutex
+	free int64
+	// released is closed when memory is released, so the uploads waiting for it try again
+	released chan struct{}
+}
+
+func newBudget(total int64) *budget {
+	return &budget{total: total, free: total, released: make(chan struct{})}
+}
+
+// reserve waits until n bytes (at most the whole budget) are free, or the context is done. The
+// returned function releases them, calling it more than once is fine. A nil budget doesn't limit
+// anything.
+func (b *budget) reserve(ctx context.Context, n int64) (func(), error) {
+	if b == nil {
+		return func() {}, nil
+	}
+	n = min(n, b.total)
+	for {
+		b.mu.Lock()
+		if b.free >= n {
+			b.free -= n
+			b.mu.Unlock()
+			return sync.OnceFunc(func() { b.release(n) }), nil
+		}
+		released := b.released
+		b.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			if err := ctx.Err(); isTimeout(err) {
+				return nil, timeoutError(err)
+			}
+			return nil, fmt.Errorf("%w: %w", ErrClientDisconnected, ctx.Err())
+		}
+	}
+}
+
+func (b *budget) release(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.free += n
+	close(b.released)
+	b.released = make(chan struct{})
+}
+
+// uploadMemory returns the memory a file can use while it's uploaded: the part buffers of the
+// uploader and the one of Config.Fallback.
+func uploadMemory(cfg Config) int64 {
+	parts := int64(cfg.Concurrency)
+	if parts <= 0 {
+		parts = manager.DefaultUploadConcurrency
+	}
+	// the uploader reads the next part while the others are sent
+	parts++
+	if cfg.Fallback != nil {
+		parts++
+	}
+	return parts * cfg.PartSize
+}
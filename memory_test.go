@@ -0,0 +1,95 @@
+package mps3
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBudget(t *testing.T) {
+	assert := assert.New(t)
+
+	b := newBudget(10)
+	first, err := b.reserve(context.Background(), 6)
+	assert.NoError(err)
+
+	// waits until enough memory is released
+	reserved := make(chan func())
+	go func() {
+		release, err := b.reserve(context.Background(), 6)
+		assert.NoError(err)
+		reserved <- release
+	}()
+	select {
+	case <-reserved:
+		t.Fatal("reserved more than the budget")
+	case <-time.After(20 * time.Millisecond):
+	}
+	first()
+	first()
+	second := <-reserved
+
+	// more than the budget waits for all of it
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = b.reserve(ctx, 20)
+	assert.ErrorIs(err, ErrTimeout)
+	second()
+	release, err := b.reserve(context.Background(), 20)
+	assert.NoError(err)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = b.reserve(ctx, 1)
+	assert.ErrorIs(err, ErrClientDisconnected)
+	release()
+	assert.Equal(int64(10), b.free)
+
+	// a nil budget doesn't limit anything
+	release, err = (*budget)(nil).reserve(context.Background(), 1<<40)
+	assert.NoError(err)
+	release()
+}
+
+func TestMemoryBudget(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := blockingBackend{mps3test.NewBackend(), make(chan struct{}, 3), make(chan struct{})}
+	wrapper, err := New(Config{
+		Bucket:       bucket,
+		Backend:      backend,
+		PartSize:     manager.MinUploadPartSize,
+		Concurrency:  1,
+		MemoryBudget: 2 * manager.MinUploadPartSize,
+	})
+	assert.NoError(err)
+	upload := func(done chan<- int) {
+		req, err := newRequest(nil, "test_file2.txt")
+		assert.NoError(err)
+		res := httptest.NewRecorder()
+		wrapper.Wrap(http.NotFoundHandler()).ServeHTTP(res, req)
+		done <- res.Code
+	}
+
+	// each upload reserves (Concurrency + 1) * PartSize, so the second waits for the first
+	done := make(chan int, 2)
+	go upload(done)
+	<-backend.started
+	go upload(done)
+	select {
+	case <-backend.started:
+		t.Fatal("uploaded over the memory budget")
+	case <-time.After(20 * time.Millisecond):
+	}
+	backend.unblock <- struct{}{}
+	assert.Equal(http.StatusNotFound, <-done)
+	<-backend.started
+	backend.unblock <- struct{}{}
+	assert.Equal(http.StatusNotFound, <-done)
+}
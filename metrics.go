@@ -0,0 +1,113 @@
+package mps3
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Metrics receives the metrics of the middleware, e.g. a StatsD, Datadog or CloudWatch EMF client.
+// Tags are "key:value" strings. The metrics are:
+//
+//   - mps3.requests (Incr) and mps3.request.duration (Timing) when the files of a request were
+//     uploaded or it failed, tagged "result:ok" or "result:error" and "status:<code>" if it failed
+//   - mps3.files (Incr) and mps3.file.duration (Timing) when a file was uploaded or failed to,
+//     tagged like the requests
+//   - mps3.uploads.active (Gauge) the number of files being uploaded, when it changes
+//   - mps3.files.slow and mps3.files.stalled (Incr) when a file is slower than Config.SlowUpload
+//     or stalls for Config.StallTimeout
+type Metrics interface {
+	Incr(name string, tags []string)
+	Timing(name string, d time.Duration, tags []string)
+	Gauge(name string, value float64, tags []string)
+}
+
+type metricsKey struct{}
+
+// requestMetrics are the metrics of a request, reported once to Config.Metrics and in the summary
+// log (see Config.LogSummary).
+type requestMetrics struct {
+	start time.Time
+	once  sync.Once
+
+	mu       sync.Mutex
+	files    int
+	failed   int
+	bytes    int64
+	slowest  string
+	slowestD time.Duration
+}
+
+// startMetrics returns the request with the start time of its metrics, if Config.Metrics or
+// Config.LogSummary is set.
+func (wr Wrapper) startMetrics(req *http.Request) *http.Request {
+	if wr.metrics == nil && !wr.summary {
+		return req
+	}
+	rm := &requestMetrics{start: time.Now()}
+	return req.WithContext(context.WithValue(req.Context(), metricsKey{}, rm))
+}
+
+// requestDone reports the metrics of the request and logs its summary, failed if err is not nil.
+func (wr Wrapper) requestDone(req *http.Request, err error) {
+	rm, ok := req.Context().Value(metricsKey{}).(*requestMetrics)
+	if !ok {
+		return
+	}
+	rm.once.Do(func() {
+		if wr.summary {
+			wr.logSummary(req, rm, err)
+		}
+		if wr.metrics == nil {
+			return
+		}
+		tags := resultTags(err)
+		wr.metrics.Incr("mps3.requests", tags)
+		wr.metrics.Timing("mps3.request.duration", time.Since(rm.start), tags)
+	})
+}
+
+// startUpload reports that a file started uploading, the returned function reports that it
+// finished.
+func (wr Wrapper) startUpload() func() {
+	if wr.metrics == nil {
+		return func() {}
+	}
+	wr.metrics.Gauge("mps3.uploads.active", float64(wr.active.Add(1)), nil)
+	return func() {
+		wr.metrics.Gauge("mps3.uploads.active", float64(wr.active.Add(-1)), nil)
+	}
+}
+
+// fileDone reports the metrics of a file that was uploaded, or failed to if err is not nil.
+func (wr Wrapper) fileDone(req *http.Request, name string, size int64, d time.Duration, err error) {
+	if rm, ok := req.Context().Value(metricsKey{}).(*requestMetrics); ok {
+		rm.mu.Lock()
+		if err != nil {
+			rm.failed++
+		} else {
+			rm.files++
+			rm.bytes += size
+		}
+		if d > rm.slowestD {
+			rm.slowest, rm.slowestD = name, d
+		}
+		rm.mu.Unlock()
+	}
+	if wr.metrics == nil {
+		return
+	}
+	tags := resultTags(err)
+	wr.metrics.Incr("mps3.files", tags)
+	wr.metrics.Timing("mps3.file.duration", d, tags)
+}
+
+// resultTags returns the tags of the result of a request or file.
+func resultTags(err error) []string {
+	if err == nil {
+		return []string{"result:ok"}
+	}
+	return []string{"result:error", "status:" + strconv.Itoa(StatusCode(err))}
+}
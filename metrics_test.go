@@ -0,0 +1,64 @@
+package mps3
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordedMetrics records the metrics as "<kind> <name> <tags>" lines, gauges with their value.
+type recordedMetrics struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (m *recordedMetrics) record(line string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lines = append(m.lines, line)
+}
+
+func (m *recordedMetrics) Incr(name string, tags []string) {
+	m.record(fmt.Sprintf("incr %s %s", name, strings.Join(tags, ",")))
+}
+
+func (m *recordedMetrics) Timing(name string, d time.Duration, tags []string) {
+	m.record(fmt.Sprintf("timing %s %s", name, strings.Join(tags, ",")))
+}
+
+func (m *recordedMetrics) Gauge(name string, value float64, tags []string) {
+	m.record(fmt.Sprintf("gauge %s %v", name, value))
+}
+
+func TestMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	metrics := &recordedMetrics{}
+	_, _, res := uploadToMemory(t, Config{Metrics: metrics}, nil, "test_file2.txt")
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal([]string{
+		"gauge mps3.uploads.active 1",
+		"incr mps3.files result:ok",
+		"timing mps3.file.duration result:ok",
+		"gauge mps3.uploads.active 0",
+		"incr mps3.requests result:ok",
+		"timing mps3.request.duration result:ok",
+	}, metrics.lines)
+
+	metrics.lines = nil
+	_, _, res = uploadToMemory(t, Config{Metrics: metrics, MaxFileSize: 10}, nil, "test_file2.txt")
+	assert.Equal(http.StatusRequestEntityTooLarge, res.Code)
+	assert.Equal([]string{
+		"gauge mps3.uploads.active 1",
+		"incr mps3.files result:error,status:413",
+		"timing mps3.file.duration result:error,status:413",
+		"gauge mps3.uploads.active 0",
+		"incr mps3.requests result:error,status:413",
+		"timing mps3.request.duration result:error,status:413",
+	}, metrics.lines)
+}
@@ -0,0 +1,85 @@
+package mps3
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/h2non/filetype"
+)
+
+// typeAliases are content types that have more than one name.
+var typeAliases = map[string]string{
+	"image/jpg":                    "image/jpeg",
+	"image/pjpeg":                  "image/jpeg",
+	"image/x-png":                  "image/png",
+	"text/xml":                     "application/xml",
+	"application/x-zip-compressed": "application/zip",
+	"audio/x-wav":                  "audio/wav",
+	"audio/wave":                   "audio/wav",
+	"audio/mp3":                    "audio/mpeg",
+}
+
+// checkMismatch fails with ErrUnsupportedType if the type detected from the content contradicts
+// the extension of the file name or the content type declared by the client.
+func checkMismatch(name, declared string, head []byte) error {
+	sniffed := sniffType(head)
+	if sniffed == "" {
+		return nil
+	}
+	if ext := mime.TypeByExtension(filepath.Ext(name)); ext != "" && !compatibleTypes(sniffed, ext) {
+		return fmt.Errorf("%w: content is %q but the extension is of %q", ErrUnsupportedType, sniffed, mediaType(ext))
+	}
+	if declared != "" && !compatibleTypes(sniffed, declared) {
+		return fmt.Errorf("%w: content is %q but %q was declared", ErrUnsupportedType, sniffed, mediaType(declared))
+	}
+	return nil
+}
+
+// sniffType returns the content type detected from the content, or an empty string if the content
+// doesn't have a recognizable format.
+func sniffType(head []byte) string {
+	if t, err := filetype.Match(head); err == nil && t.MIME.Value != "" {
+		return mediaType(t.MIME.Value)
+	}
+	switch t := mediaType(http.DetectContentType(head)); t {
+	case "text/plain", "application/octet-stream":
+		return ""
+	default:
+		return t
+	}
+}
+
+// compatibleTypes returns true if content of the sniffed type can have the expected type.
+func compatibleTypes(sniffed, expected string) bool {
+	expected = mediaType(expected)
+	if sniffed == expected || expected == "application/octet-stream" {
+		return true
+	}
+	stype, ssub, _ := strings.Cut(sniffed, "/")
+	etype, esub, _ := strings.Cut(expected, "/")
+	switch {
+	case sniffed == "application/xml":
+		// e.g. SVG, RSS and XHTML documents
+		return strings.HasSuffix(esub, "xml")
+	case sniffed == "application/zip":
+		// e.g. office documents, Java and Android archives
+		return etype == "application"
+	case (stype == "audio" || stype == "video") && (etype == "audio" || etype == "video"):
+		// containers like MP4 and WebM hold audio, video or both
+		return ssub == esub
+	}
+	return false
+}
+
+// mediaType returns the lowercase content type without parameters, with aliases resolved.
+func mediaType(ftype string) string {
+	ftype, _, _ = strings.Cut(ftype, ";")
+	ftype = strings.ToLower(strings.TrimSpace(ftype))
+	if alias, ok := typeAliases[ftype]; ok {
+		return alias
+	}
+	return ftype
+}
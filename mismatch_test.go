@@ -0,0 +1,59 @@
+package mps3
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRejectTypeMismatch(t *testing.T) {
+	assert := assert.New(t)
+
+	png, err := os.ReadFile("test_file1.png")
+	assert.NoError(err)
+
+	tests := []struct {
+		name     string
+		content  string
+		declared string
+		code     int
+	}{
+		{"photo.png", string(png), "", http.StatusOK},
+		{"photo.png", string(png), "image/png", http.StatusOK},
+		{"photo.jpg", string(png), "", http.StatusUnsupportedMediaType},
+		{"photo.png", string(png), "text/html", http.StatusUnsupportedMediaType},
+		{"photo.jpg", "<html><script>alert(1)</script></html>", "", http.StatusUnsupportedMediaType},
+		{"logo.svg", `<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`, "image/svg+xml", http.StatusOK},
+		{"notes.txt", "hello", "text/plain", http.StatusOK},
+		{"data.unknown", string(png), "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		backend := mps3test.NewBackend()
+		wrapper, err := New(Config{Bucket: bucket, Backend: backend, RejectTypeMismatch: true, Logger: log.New(io.Discard, "", 0)})
+		assert.NoError(err)
+
+		req := newFileRequest(t, tt.name, tt.content)
+		if tt.declared != "" {
+			req = newTypedFileRequest(t, tt.name, tt.declared, tt.content)
+		}
+		res := httptest.NewRecorder()
+		wrapper.Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(res, req)
+		assert.Equal(tt.code, res.Code, tt.name+" "+tt.declared)
+	}
+}
+
+func TestCompatibleTypes(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(compatibleTypes("image/jpeg", "image/jpg"))
+	assert.True(compatibleTypes("video/mp4", "audio/mp4"))
+	assert.True(compatibleTypes("application/zip", "application/vnd.oasis.opendocument.text"))
+	assert.True(compatibleTypes("image/png", "application/octet-stream"))
+	assert.False(compatibleTypes("text/html", "image/jpeg"))
+	assert.False(compatibleTypes("application/zip", "image/png"))
+}
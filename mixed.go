@@ -0,0 +1,51 @@
+package mps3
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+)
+
+// readMixed uploads the files of a multipart/mixed part, the legacy way of sending several files
+// in one field (RFC 2388). Each file is handled like a file sent in the field.
+func (wr Wrapper) readMixed(req *http.Request, part *multipart.Part, boundary string, res *result) error {
+	name := part.FormName()
+	mr := multipart.NewReader(part, boundary)
+	for {
+		np, err := mr.NextPart()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("%w: failed to read nested part of %q: %w", ErrMalformedMultipart, name, err)
+		}
+
+		// nested parts are "file" parts without a field name, the header is rewritten before the
+		// part parses it so it looks like a file sent in the field
+		filename := "file"
+		if _, params, err := mime.ParseMediaType(np.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+			filename = params["filename"]
+		}
+		np.Header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": name, "filename": filename}))
+
+		if err := wr.readPart(req, np, res); err != nil {
+			return err
+		}
+	}
+}
+
+// mixedBoundary returns the boundary of a multipart/mixed part, or an empty string if the part
+// isn't multipart/mixed.
+func mixedBoundary(part *multipart.Part) string {
+	if part.FileName() != "" {
+		return ""
+	}
+	mt, params, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+	if err != nil || mt != "multipart/mixed" {
+		return ""
+	}
+	return params["boundary"]
+}
@@ -0,0 +1,59 @@
+package mps3
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultipartMixed(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend})
+	assert.NoError(err)
+
+	// nested multipart/mixed body with two files
+	nested := &bytes.Buffer{}
+	nw := multipart.NewWriter(nested)
+	for _, f := range []struct{ name, content string }{{"a.txt", "first"}, {"b.txt", "second"}} {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", `file; filename="`+f.name+`"`)
+		h.Set("Content-Type", "text/plain")
+		pw, err := nw.CreatePart(h)
+		assert.NoError(err)
+		_, _ = pw.Write([]byte(f.content))
+	}
+	assert.NoError(nw.Close())
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	assert.NoError(mw.WriteField("title", "files"))
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", `form-data; name="files"`)
+	h.Set("Content-Type", "multipart/mixed; boundary="+nw.Boundary())
+	pw, err := mw.CreatePart(h)
+	assert.NoError(err)
+	_, _ = pw.Write(nested.Bytes())
+	assert.NoError(mw.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	res := httptest.NewRecorder()
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal("files", req.FormValue("title"))
+		assert.Equal([]string{"a.txt", "b.txt"}, req.Form["files_name"])
+		for i, content := range []string{"first", "second"} {
+			obj, ok := backend.Object(bucket, req.Form["files"][i])
+			assert.True(ok)
+			assert.Equal(content, string(obj.Body))
+		}
+	})).ServeHTTP(res, req)
+	assert.Equal(http.StatusOK, res.Code)
+}
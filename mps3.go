@@ -3,27 +3,31 @@ package mps3
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
-	"log"
+	"log/slog"
 	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/url"
-	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/google/uuid"
-	"github.com/h2non/filetype"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Logger is the interface of the loggers of the log package, see Config.Logger.
 type Logger interface {
 	Printf(format string, args ...any)
 }
@@ -32,6 +36,8 @@ type Config struct {
 	// S3Config specifies credentials and endpoint configuration. If not specified the middleware
 	// will load the default configuration with a background context.
 	//
+	// NewR2Config and NewSpacesConfig return configurations for Cloudflare R2 and DigitalOcean Spaces.
+	//
 	// To provide a custom endpoint (required when not using AWS S3 API) you can do something like this
 	// (more info at https://aws.github.io/aws-sdk-go-v2/docs/configuring-sdk/endpoints/):
 	//
@@ -50,261 +56,1824 @@ type Config struct {
 	//	s3cfg, err := config.LoadDefaultConfig(context.Background(), config.WithEndpointResolverWithOptions(resolver))
 	S3Config *aws.Config
 
-	// Bucket name of the bucket to use to store uploaded files
+	// Bucket name of the bucket to use to store uploaded files (required unless Buckets is set)
+	//
+	// S3 Express One Zone directory buckets (`<name>--<zone-id>--x-s3`) are supported, the
+	// SDK handles their session based authentication. They don't support ACLs, so BucketACL
+	// and FileACL are not used, nor object tags or Object Lock.
 	Bucket string
 
+	// Buckets if set, uploaded files are distributed between these buckets by a hash of the
+	// key, to spread the request rate of high volume deployments, and Bucket is ignored.
+	// The bucket used for each file is reported in the `<field>_bucket` form value.
+	Buckets []string
+
+	// ShardFunc chooses one of Buckets to store the file with the given key
+	// (default: FNV-1a hash of the key modulo the number of buckets)
+	ShardFunc func(key string, buckets []string) string
+
+	// BucketFunc selects the bucket for each request, e.g. a per-tenant bucket derived
+	// from the authentication context. When set Bucket and Buckets are ignored, the
+	// buckets are not created by CreateBucket and the bucket used for each file is
+	// reported in the `<field>_bucket` form value. An error fails the request.
+	BucketFunc func(*http.Request) (string, error)
+
 	// BucketACL if CreateBucket is true the bucket will be created with this ACL (default: "private")
 	BucketACL string
 
-	// CreateBucket if true it will try to create a bucket with the specified Bucket name.
+	// CreateBucket if true the buckets are created if they don't exist yet, see CheckBucket.
 	// Error of type BucketAlreadyOwnedByYou will be silently ignored (default: true)
 	CreateBucket bool
 
+	// CheckBucket if true (implied by CreateBucket) the buckets are checked with HeadBucket when
+	// the middleware is created, so a missing bucket fails right away. The region of the buckets is
+	// detected (even from the redirect S3 returns for the wrong region) and used instead of the
+	// region of S3Config. Buckets that can't be checked due to permissions are assumed to exist.
+	CheckBucket bool
+
+	// HealthCheckKey if set, Wrapper.HealthCheck also uploads and deletes a small file under this
+	// key in the buckets, verifying that files can be written (default: "", only the buckets are
+	// checked)
+	HealthCheckKey string
+
 	// FileACL defines ACL string to use for uploaded files (default: "private")
 	FileACL string
 
 	// PartSize defines the size of the chunk that is uploaded to S3, by default is 5 MB,
 	// which is the minimum part size. If a value smaller than the minimum is set, it
-	// will be silently adjusted to the minimum.
+	// will be silently adjusted to the minimum. Files that fit in a part are stored with a
+	// single PutObject by the manager.Uploader instead of a multipart upload.
 	PartSize int64
 
+	// Concurrency is the number of parts of each file uploaded in parallel, each one uses a
+	// PartSize buffer (default: 5)
+	Concurrency int
+
+	// BufferProvider is used to buffer the parts before they are uploaded, set it to reuse
+	// buffers between uploads (default: the manager.Uploader default)
+	BufferProvider manager.ReadSeekerWriteToProvider
+
+	// MemoryBudget if greater than zero, is the memory that the part buffers of all the files
+	// being uploaded can use, so memory use is predictable under load. Each file reserves the
+	// memory its parts can use ((Concurrency + 1) * PartSize, one more PartSize with Fallback)
+	// before it's uploaded, waiting while there isn't enough. The buffers of the middleware (of
+	// Fallback and TusHandler) are pooled whether it's set or not.
+	MemoryBudget int64
+
+	// LeavePartsOnError if true the parts already uploaded are not removed when a multipart
+	// upload fails, so they can be inspected or the upload resumed (default: false)
+	LeavePartsOnError bool
+
+	// AbortIncompleteUploadsAfterDays if set, a lifecycle rule is added to the buckets (unless one
+	// already exists) so S3 removes the parts of multipart uploads that were never completed after
+	// this many days. Failed uploads are always aborted, the rule covers the ones that couldn't be.
+	AbortIncompleteUploadsAfterDays int32
+
 	// PrefixFunc defines a function that gets executed to define the S3 key prefix
 	// for each uploaded file. By default it's a function that returns the current date
 	// in the format `/YYYY/MM/DD/`
 	PrefixFunc func(*http.Request) string
 
-	// Logger is used to log errors during request processing (default: log.Default())
+	// KeyFunc defines a function that gets executed to define the full S3 key for
+	// each uploaded file, it receives the original file name and the detected content
+	// type. By default it's the PrefixFunc result followed by the IDFunc result.
+	KeyFunc func(req *http.Request, filename, contentType string) string
+
+	// KeyTemplate is a text/template used to render the S3 key of each uploaded file,
+	// e.g. `/{{.Year}}/{{.UserID}}/{{.UUID}}{{.Ext}}`. See KeyData for the available
+	// values. It can't be used together with KeyFunc.
+	KeyTemplate string
+
+	// IDFunc generates the unique part of the default keys, available as `{{.ID}}` in the
+	// KeyTemplate (default: a random UUID). Use NewULID, or IDs like KSUIDs and xids, to have keys
+	// that sort by upload time.
+	IDFunc func() string
+
+	// PreventOverwrite if true, files are uploaded with conditional writes (If-None-Match: *) so
+	// keys that already exist aren't overwritten, which matters with KeyFunc and KeyTemplate keys
+	// that aren't unique. Uploading to an existing key fails the request with ErrKeyExists
+	// (409 Conflict). With StagingPrefix the staging key is checked.
+	PreventOverwrite bool
+
+	// UserIDFunc returns the ID of the user making the request, it's available as
+	// `{{.UserID}}` in the KeyTemplate.
+	UserIDFunc func(*http.Request) string
+
+	// RawUploadField if set, requests whose body isn't a form (e.g. `PUT /files/report.pdf` with
+	// the content of the file as the body) are uploaded as a single file, reported in the form
+	// values of this field. The file name is the one of the Content-Disposition header, if sent,
+	// or the last segment of the path. Use ShouldHandle to only handle the upload routes, since
+	// other bodies like JSON would be uploaded too.
+	RawUploadField string
+
+	// ShouldHandle if set, only the requests it returns true for are handled, the others are passed
+	// to the next handler untouched. See PathPrefix, Methods and MatchAll.
+	ShouldHandle func(*http.Request) bool
+
+	// Authorize if set is called before the request body is read, if it returns an error the
+	// request fails with ErrUnauthorized (401 Unauthorized) without reading or storing any file.
+	// It's also called for the requests of TusHandler and PostPolicyHandler.
+	Authorize func(*http.Request) error
+
+	// HoneypotFields are form fields hidden from users, e.g. with CSS, which bots fill in. Requests
+	// with a value in any of them fail with ErrSpam (400 Bad Request). They aren't reported in the
+	// form values.
+	HoneypotFields []string
+
+	// SpamCheck if set is called with the text fields sent before the first file of a multipart
+	// request (or all of them if it has no files), before any file is stored. If it returns an
+	// error the request fails with ErrSpam.
+	SpamCheck func(fields url.Values) error
+
+	// Quarantine if set, the hooks can quarantine files instead of failing the request, returning
+	// an error wrapping ErrQuarantine. See QuarantineConfig.
+	Quarantine *QuarantineConfig
+
+	// OnUploadStart if set is called before each file is uploaded, with the information known at
+	// that point (the key, bucket, name and content type). If it returns an error the file is not
+	// uploaded and the request fails with it.
+	OnUploadStart func(req *http.Request, f UploadedFile) error
+
+	// OnUploadComplete if set is called after each file is uploaded, or failed to (err is not nil),
+	// e.g. to record the upload in a database. If it returns an error the request fails with it.
+	OnUploadComplete func(req *http.Request, f UploadedFile, d time.Duration, err error) error
+
+	// OnProgress if set is called while each file is uploaded with the bytes read so far, at most
+	// twice a second and once more when the file was read completely, e.g. to show the progress
+	// of the uploads. total is the size of the file if known (raw uploads with a Content-Length),
+	// otherwise -1.
+	OnProgress func(req *http.Request, field string, read, total int64)
+
+	// ProgressStore if set, stores the progress of the requests with a progress token (see
+	// NewProgressToken), which Wrapper.ProgressHandler streams to the clients, e.g. to show
+	// progress bars. See NewMemoryProgressStore.
+	ProgressStore ProgressStore
+
+	// QuotaFunc if set is consulted to enforce storage quotas, e.g. per user or tenant, before the
+	// request is read with the size of its body (if the client sent it) and while its files are
+	// received with the bytes received so far, each MB and at the end of each file. If it returns
+	// an error the upload is aborted before the rest of the file is stored, the files uploaded by
+	// the request are removed and it fails with the error. Return an error wrapping
+	// ErrQuotaExceeded to respond with 402 Payment Required. TusHandler consults it with the
+	// Upload-Length of the uploads it creates, chunked uploads with the size of each chunk and
+	// Session.AppendPart with the size of each part.
+	QuotaFunc func(req *http.Request, pendingBytes int64) error
+
+	// RateLimit if set, limits the concurrent uploads and the bandwidth of each client
+	RateLimit *RateLimit
+
+	// MaxConcurrentUploads if greater than zero, is the maximum number of upload requests of all the
+	// clients handled at the same time, so many parallel large uploads can't use all the memory with
+	// the part buffers of the uploader. Other requests wait up to UploadQueueTimeout for one of them
+	// to upload its files, then fail with ErrOverloaded (503 Service Unavailable).
+	MaxConcurrentUploads int
+	UploadQueueTimeout   time.Duration
+
+	// RequireUploadToken if set, requests must have an upload token signed with SignUploadToken, which
+	// limits the fields, size and content types of their files. Files of requests without a valid
+	// token fail with ErrInvalidToken (401 Unauthorized) before they're read. It also applies to
+	// the chunks of ChunkedUploads and to TusHandler, which takes the token from the header.
+	RequireUploadToken *TokenConfig
+
+	// Encryption if set, the files are encrypted before they're sent to S3 with keys supplied by
+	// the application, see EncryptionConfig. It can't be used with ChunkedUploads or
+	// ExtractArchives, and sessions, TusHandler and PostPolicyHandler fail with it.
+	Encryption *EncryptionConfig
+
+	// ContinueOnError if true, a file that fails to upload (e.g. too large or of an unsupported
+	// type) doesn't fail the request: its field gets a `<field>_error` form value with the cause,
+	// the other files are uploaded and the handler is called. FileErrorsFromContext returns the
+	// failed files. Requests whose body can't be read still fail.
+	ContinueOnError bool
+
+	// ResponseFunc if set, returns the document Wrapper.Handler responds with, encoded as JSON,
+	// instead of an UploadResponse.
+	ResponseFunc func(req *http.Request, files []UploadedFile) any
+
+	// RequestTimeout if set, limits how long reading the request and uploading its files can take,
+	// the handler isn't limited. UploadTimeout if set, limits how long uploading each file can take.
+	// Requests that take longer fail with 408 Request Timeout, see ErrTimeout.
+	RequestTimeout time.Duration
+	UploadTimeout  time.Duration
+
+	// SlowUpload if set, logs a warning when uploading a file takes longer than this. StallTimeout
+	// if set, logs a warning when no data of a file is received from the client for this long,
+	// which fails the request with 408 Request Timeout if AbortStalled is true. Only the time the
+	// body is being read counts, not the time the backend doesn't read it (e.g. while sending the
+	// parts). They are counted by the mps3.files.slow and mps3.files.stalled metrics.
+	SlowUpload   time.Duration
+	StallTimeout time.Duration
+	AbortStalled bool
+
+	// KeepFilesOnError if true the files uploaded before a request fails are kept, by default they
+	// are deleted so failed requests don't leave orphan files. Files stored in content addressable
+	// mode are only deleted with Deduplicate, since otherwise they may belong to other requests.
+	KeepFilesOnError bool
+
+	// StagingPrefix if set, files are uploaded under this prefix and only moved to their keys after
+	// the handler responds with a 2xx status, otherwise they are deleted. The handler receives the
+	// final keys, the version and ETag of the files are the ones of the staged objects. It can't be
+	// used with ContentAddressable.
+	StagingPrefix string
+
+	// PanicPrefix if set, the files of a request whose handler panics are moved under this prefix
+	// to be inspected, instead of being deleted like the files of other failed requests.
+	PanicPrefix string
+
+	// ErrorHandler if set is called to respond to requests that failed, instead of logging the error
+	// and responding with the StatusCode of the error. Use errors.Is with the Err* errors of this
+	// package and errors.As with *FileError to find out what failed.
+	ErrorHandler func(w http.ResponseWriter, req *http.Request, err error)
+
+	// OnError if set is called with the errors of the requests that failed (before ErrorHandler),
+	// the files skipped by ContinueOnError and the panics of the handler (which are re-raised), to
+	// report them e.g. to Sentry. The context has the values of the request and isn't canceled.
+	// Client errors are reported too, use ErrorInfo.Status to filter them.
+	OnError func(ctx context.Context, err error, info ErrorInfo)
+
+	// Slog is used to log errors during request processing, with attributes like the request ID,
+	// key and error of the records, and the files uploaded at debug level (default: slog.Default())
+	Slog *slog.Logger
+
+	// Logger if set and Slog isn't, is used to log the records formatted like slog.TextHandler does
 	Logger Logger
+
+	// TracerProvider creates a span per request and a child span per file with its bucket, key,
+	// size and type, the requests of the S3 client are children of the spans of the files. The
+	// trace context of requests without a span is extracted from their headers with the global
+	// propagator (default: otel.GetTracerProvider())
+	TracerProvider trace.TracerProvider
+
+	// RequestIDFunc returns the ID of the request, added to the log records and spans and stored
+	// in the metadata of the uploaded files (MetaRequestID), to correlate the uploads with the
+	// traces of the application. IDs longer than 128 characters or that aren't printable ASCII
+	// are ignored (default: DefaultRequestID)
+	RequestIDFunc func(req *http.Request) string
+
+	// Metrics if set receives the counts and durations of the requests and files, see Metrics
+	Metrics Metrics
+
+	// LogSummary if true logs one record per request at info level, when its files were uploaded
+	// or it failed, with the number of files uploaded and failed, their total size, the duration
+	// of the request, its slowest file and the result (default: false)
+	LogSummary bool
+
+	// Notifier if set publishes an event for each file uploaded by the requests that succeeded,
+	// once the handler returned (and the files were committed, see StagingPrefix), e.g. to
+	// NewSNSNotifier, NewSQSNotifier or NewEventBridgeNotifier. The events are queued and
+	// published in the background, so the responses don't wait for them, call Wrapper.Close
+	// on shutdown to publish the queued events. Failures, also when the queue is full, are
+	// logged.
+	Notifier Notifier
+
+	// NotifyWorkers is the number of events of Notifier published at the same time (default: 4)
+	NotifyWorkers int
+
+	// NotifyQueueSize is the number of events of Notifier that can wait to be published, the
+	// events of the requests handled while it's full are dropped (default: 1000)
+	NotifyQueueSize int
+
+	// NotifyRetries is the number of times an event of Notifier is retried if it fails (default:
+	// 3, negative for none), after NotifyBackoff, doubled after each retry (default: 1s). The
+	// requests of NewWebhookNotifier that fail with a 4xx status other than 429 aren't retried.
+	NotifyRetries int
+	NotifyBackoff time.Duration
+
+	// LocalDir if set, uploaded files are stored in this directory instead of S3, under
+	// `<LocalDir>/<Bucket>/<key>`. This is meant for local development, S3Config,
+	// CreateBucket and the ACL options are ignored.
+	LocalDir string
+
+	// Backend if set is used to store the uploaded files instead of S3, it takes precedence
+	// over LocalDir. The mps3test package provides an in-memory Backend for unit tests.
+	Backend Backend
+
+	// Replica if set every uploaded file is also written to a second bucket or backend
+	// while it's being uploaded.
+	Replica *ReplicaConfig
+
+	// Fallback if set files that fail to upload are stored in a secondary bucket or backend,
+	// and its location is reported in the `<field>_fallback` form value.
+	Fallback *FallbackConfig
+
+	// Scan if set every uploaded file is scanned for malware while it's uploaded, e.g. with
+	// ClamdScanner. Files stored in parts (ChunkedUploads, TusHandler and sessions) are read back
+	// to be scanned once they're complete, the backend must implement Getter.
+	Scan *ScanConfig
+
+	// PII if set, the text fields are inspected for personal information like credit card numbers
+	// before the request is handled, and the files of PIIConfig.FileTypes while they're uploaded.
+	PII *PIIConfig
+
+	// InlineFileSize if greater than zero, files up to this size (in bytes) are not uploaded,
+	// they are kept in memory and made available through `req.FormFile` like the standard
+	// library does. Larger files are uploaded as usual (default: 0)
+	InlineFileSize int64
+
+	// DataURIFields if true, text fields whose value is a base64 data URI (e.g.
+	// `data:image/png;base64,...` sent by canvas or webcam captures) are decoded and uploaded like
+	// files, the field is set to the key of the object with the usual companion values. The file
+	// name is the `name` parameter of the data URI, if any, or "file".
+	DataURIFields bool
+
+	// JSONFields if set, the fields of application/json request bodies with base64 content, or
+	// data URIs, of files to upload, for clients that can't send multipart. A field can have a
+	// string or an array of strings, each file is replaced by the key of its object. The handler
+	// gets the resulting document with JSONFromContext and as the request body.
+	JSONFields []string
+
+	// ChunkedUploads if true, files sent in chunks by Dropzone, Resumable.js, Flow.js or with the
+	// uploadId, chunkIndex and totalChunks fields (sent before the file) are uploaded as the parts
+	// of a multipart upload. The request of the last chunk gets the form values of the file, the
+	// others don't. Chunks other than the last must have at least 5MB and at most 100MB, the state
+	// of the uploads is stored under ".chunks/" in the bucket. The content of the first chunk is
+	// checked like the files (executable content, the detected type and RejectTypeMismatch), if
+	// it fails the upload is aborted. The rest isn't inspected, so it can't be used with
+	// ArchiveLimits, ImageLimits, StripMetadata, SanitizeSVG or PII.FileTypes. The backend must
+	// implement MultipartUploader and Getter.
+	ChunkedUploads bool
+
+	// SessionStore stores the upload sessions of Wrapper.StartSession (default: JSON objects under
+	// ".sessions/" in Bucket, see NewMemorySessionStore)
+	SessionStore SessionStore
+
+	// TeeFiles if true, the content of the files is copied while it's uploaded so the handler can
+	// read it with req.FormFile (e.g. to create thumbnails). Files up to TeeMemoryLimit bytes
+	// (default: 10MB) are kept in memory, larger ones in temporary files that are removed after
+	// the handler returns.
+	TeeFiles       bool
+	TeeMemoryLimit int64
+
+	// Fields if set, only the files sent in these form fields are uploaded
+	Fields []string
+
+	// IgnoreFields the files sent in these form fields are not uploaded
+	IgnoreFields []string
+
+	// RejectIgnoredFields if true, requests sending files in form fields that aren't uploaded (see
+	// Fields and IgnoreFields) fail with ErrUnexpectedField, by default the files are discarded.
+	RejectIgnoredFields bool
+
+	// MaxFileSize if greater than zero, is the maximum size of each file in bytes. The upload of a
+	// larger file is aborted as soon as the limit is exceeded and the request fails with ErrTooLarge
+	// (413 Content Too Large).
+	MaxFileSize int64
+
+	// MaxFieldSize if greater than zero, is the maximum size in bytes of each form value that isn't
+	// a file, and MaxFieldCount the maximum number of them. Requests with larger or more values
+	// fail with ErrTooLarge (413 Content Too Large) before they're read into memory.
+	MaxFieldSize  int64
+	MaxFieldCount int
+
+	// AllowedTypes if set, only files of these content types are uploaded, wildcards like "image/*"
+	// match all the subtypes. The type is detected from the content before the upload starts, other
+	// files fail the request with ErrUnsupportedType (415 Unsupported Media Type).
+	AllowedTypes []string
+
+	// Detector detects the content type of the files, DefaultDetector if not set. Detectors can be
+	// chained to recognize other formats, e.g.
+	//
+	//	mps3.Detectors{parquetDetector, mps3.SignatureDetector, mps3.SniffDetector, mps3.ExtensionDetector}
+	Detector Detector
+
+	// SniffSize is how many bytes at the start of the files are read to detect their content type,
+	// 261 if not set, which is what SignatureDetector needs.
+	SniffSize int
+
+	// BlockedExtensions files with any of these extensions fail the request with ErrUnsupportedType,
+	// e.g. DangerousExtensions. All the extensions of the name are checked ("file.php.jpg").
+	BlockedExtensions []string
+
+	// BlockExecutables if true, files whose content is an executable (PE, ELF, Mach-O) or a script
+	// starting with a shebang fail the request with ErrUnsupportedType, whatever their name or type.
+	BlockExecutables bool
+
+	// RejectTypeMismatch if true, files whose content contradicts the extension of their name or
+	// the content type sent by the client (e.g. a ".jpg" file containing HTML) fail the request
+	// with ErrUnsupportedType.
+	RejectTypeMismatch bool
+
+	// ImageLimits if set, the header of PNG, JPEG, GIF and WebP files is decoded before the upload
+	// starts. Files that aren't valid images fail the request with ErrUnsupportedType and images
+	// larger than the limits, e.g. decompression bombs, with ErrTooLarge. The dimensions of the
+	// images are reported in the `<field>_width` and `<field>_height` form values.
+	ImageLimits *ImageLimits
+
+	// StripMetadata if true, the EXIF (including GPS locations), XMP, IPTC and text metadata of JPEG,
+	// PNG and WebP images is removed while they're uploaded. The orientation of JPEG images is in
+	// their EXIF metadata, so they're displayed as they were taken. The copies of TeeFiles and the
+	// content scanned by Scan are the original files.
+	StripMetadata bool
+
+	// SanitizeSVG if true, SVG images are stored without scripts, event handlers and external
+	// references, which would run when they're opened in a browser. SVG files that aren't well
+	// formed fail the request with ErrUnsupportedType.
+	SanitizeSVG bool
+
+	// ArchiveLimits if set, ZIP, tar and gzip files are inspected while they're uploaded, including
+	// the archives nested in them, and the request fails with ErrSuspiciousArchive as soon as they
+	// exceed the limits, e.g. zip bombs. Files that aren't archives aren't affected.
+	ArchiveLimits *ArchiveLimits
+
+	// EncryptedArchives if set, password-protected ZIP, RAR and 7z files, which malware scanners
+	// can't inspect, are detected while they're uploaded, including the ones nested in ZIP, tar
+	// and gzip files. With EncryptedReject the request fails with ErrEncryptedArchive, with
+	// EncryptedTag the file is tagged with "encrypted=true" (the backend must implement Tagger) and
+	// reported in UploadedFile.Encrypted and the `<field>_encrypted` form value. 7z files are only
+	// detected if their file list isn't compressed, e.g. when it's encrypted too.
+	EncryptedArchives string
+
+	// ExtractArchives if true, after a ZIP or tar file (optionally compressed with gzip) is
+	// uploaded, each of its files is stored as its own object under the key of the archive
+	// followed by its path, e.g. `<key>/docs/readme.txt`. The archive is kept, the keys of its
+	// files are reported in UploadedFile.Entries and the `<field>_entries` form value (a JSON
+	// array). Directories, links and encrypted files aren't stored. The files are checked like
+	// the ones of the field of the archive (blocked extensions and executables, AllowedTypes and
+	// the upload token) and transformed with StripMetadata and SanitizeSVG, a file that fails
+	// fails the archive. The backend must implement Getter, use it with ArchiveLimits to refuse
+	// zip bombs.
+	ExtractArchives bool
+
+	// MinFileSize is the minimum size of each file in bytes, smaller files fail the request with
+	// ErrTooSmall (400 Bad Request) and aren't stored. Set it to 1 to reject empty files.
+	MinFileSize int64
+
+	// SkipEmptyFiles if true, empty files are ignored as if they weren't sent, instead of being
+	// stored (or rejected with MinFileSize)
+	SkipEmptyFiles bool
+
+	// FilenameSanitizer cleans the names of the files sent by clients, which are reported in the
+	// `<field>_name` form value and used in keys and content dispositions (default: SanitizeFilename)
+	FilenameSanitizer func(name string) string
+
+	// MaxFilenameLength is the maximum length of the file names in bytes, longer names are
+	// truncated keeping their extension (default: 255)
+	MaxFilenameLength int
+
+	// FieldConfigs overrides the settings of the files sent in some form fields, e.g. to store
+	// avatars in a public bucket and documents in a private one.
+	FieldConfigs map[string]FieldConfig
+
+	// PublicURL if set, is the base URL where uploaded files are publicly available (e.g. a CDN
+	// or a public R2 bucket domain), the URL of each file is reported in the `<field>_public_url`
+	// form value as `<PublicURL>/<key>`.
+	PublicURL string
+
+	// PresignExpiry if set, a presigned GET URL valid for this duration is generated for each
+	// uploaded file and reported in the `<field>_url` form value. The backend must implement
+	// Presigner. Files stored in the fallback backend get an empty URL, and files encrypted
+	// with CustomerKeyFunc can only be downloaded sending the key headers.
+	PresignExpiry time.Duration
+
+	// FormSuffixes customizes the names of the form values with information about each file,
+	// e.g. to avoid collisions with existing form fields.
+	FormSuffixes FormSuffixes
+
+	// FormMeta if true, instead of a form value for each piece of information about a file a
+	// single `<field>_meta` form value is added with the UploadedFile encoded as JSON.
+	FormMeta bool
+
+	// FormUploadTime if true adds the `<field>_upload_ms` form value with the milliseconds spent
+	// receiving and storing each file, see UploadedFile.UploadMS.
+	FormUploadTime bool
+
+	// ConcurrentUploads if greater than 1, is the number of files of a multipart request that are
+	// uploaded at the same time: once a file was received the next parts are read while its upload
+	// completes (default: 0, one file at a time). The form values of the files are added in the
+	// order of the parts, the hooks must be safe for concurrent use.
+	ConcurrentUploads int
+
+	// ServerSideEncryption algorithm used to encrypt uploaded files in S3, "AES256" or "aws:kms"
+	// (default: "aws:kms" if KMSKeyID is set, otherwise the bucket default)
+	ServerSideEncryption string
+
+	// KMSKeyID is the ID of the KMS key used when ServerSideEncryption is "aws:kms"
+	KMSKeyID string
+
+	// CustomerKeyFunc if set, returns the 256-bit AES key used to encrypt the files of the
+	// request with SSE-C. The key is not stored by S3, the same key must be provided to
+	// download the files. It can't be used with ServerSideEncryption or KMSKeyID.
+	CustomerKeyFunc func(*http.Request) ([]byte, error)
+
+	// StorageClass of the uploaded files, e.g. "STANDARD_IA", "INTELLIGENT_TIERING" or
+	// "GLACIER_IR" (default: the bucket default, usually "STANDARD")
+	StorageClass string
+
+	// StorageClassFunc if set, defines the storage class of each uploaded file, an empty
+	// string means StorageClass is used.
+	StorageClassFunc func(req *http.Request, filename string) string
+
+	// TagFunc if set, returns the tags attached to each uploaded file (e.g. user ID or tenant),
+	// which can be used by lifecycle rules and cost allocation reports.
+	TagFunc func(req *http.Request, filename string) map[string]string
+
+	// MetadataFunc if set, returns the metadata stored with each uploaded file as
+	// `x-amz-meta-*` headers (e.g. original filename or uploader identity).
+	MetadataFunc func(req *http.Request, filename string) map[string]string
+
+	// ContentDisposition if set to "attachment" or "inline", the Content-Disposition of the
+	// uploaded files is set with the original filename, so browsers use it when downloading.
+	ContentDisposition string
+
+	// CacheControl defines the Cache-Control header of the uploaded files
+	CacheControl string
+
+	// ChecksumAlgorithm if set, S3 verifies the integrity of the uploaded files with this
+	// algorithm ("CRC32", "CRC32C", "CRC64NVME", "SHA1" or "SHA256"), the base64 encoded
+	// checksum is reported in the `<field>_<algorithm>` form value, e.g. `file_sha256`. For
+	// files uploaded in multiple parts it is a checksum of the parts checksums.
+	//
+	// If ComputeSHA256 is also set the `<field>_sha256` form value contains the digest
+	// calculated by the middleware instead.
+	ChecksumAlgorithm string
+
+	// ComputeSHA256 if true, the SHA-256 digest of the files is calculated while they are
+	// uploaded and reported hex encoded in the `<field>_sha256` form value.
+	ComputeSHA256 bool
+
+	// ComputeMD5 if true, the MD5 digest of the files is calculated while they are
+	// uploaded and reported hex encoded in the `<field>_md5` form value.
+	ComputeMD5 bool
+
+	// ContentAddressable if true, files are stored under a key derived from their SHA-256
+	// digest (`/sha256/ab/cd/<digest>`) so identical files are stored only once. Since the
+	// digest is only known at the end, files are uploaded with the PrefixFunc key and then
+	// copied to the final key, S3 can copy objects up to 5 GB.
+	ContentAddressable bool
+
+	// Deduplicate if true, when ContentAddressable is enabled, files that were already stored
+	// are not copied again and `<field>_duplicate` is reported as "true" in the form values.
+	//
+	// To also skip the upload the client can send the hex encoded SHA-256 digest of the file
+	// in a `<field>_sha256` field before the file, if a file with that digest exists the file
+	// is read to verify the digest but not uploaded.
+	Deduplicate bool
+
+	// ObjectLock defines the Object Lock retention and legal hold of the uploaded files, for
+	// WORM (write once, read many) storage.
+	ObjectLock ObjectLock
+
+	// ObjectLockFunc if set, defines the Object Lock settings of each uploaded file instead of ObjectLock
+	ObjectLockFunc func(req *http.Request, filename string) ObjectLock
+
+	// UseAccelerateEndpoint if true, files are uploaded using the S3 Transfer Acceleration
+	// endpoint, it must be enabled in the bucket.
+	UseAccelerateEndpoint bool
+
+	// RequestPayer set to "requester" to upload to Requester Pays buckets, confirming the
+	// requester knows it will be charged for the request.
+	RequestPayer string
 }
 
 type Wrapper struct {
-	uploader   *manager.Uploader
-	logger     Logger
+	backend    Backend
+	logger     *slog.Logger
+	tracer     trace.Tracer
+	reqID      func(req *http.Request) string
+	metrics    Metrics
+	summary    bool
+	publisher  *publisher
+	active     *atomic.Int64
 	bucket     string
+	buckets    []string
+	allBuckets []string
+	healthKey  string
+	shardFunc  func(key string, buckets []string) string
+	bucketFunc func(*http.Request) (string, error)
 	fileACL    string
 	prefixFunc func(*http.Request) string
+	idFunc     func() string
+	exclusive  bool
+	keyFunc    func(req *http.Request, filename, contentType string) (string, error)
+	partSize   int64
+	buffers    *bufferPool
+	memory     *budget
+	uploadMem  int64
+	fallback   *FallbackConfig
+	scan       *ScanConfig
+	pii        *PIIConfig
+	inlineSize int64
+	teeLimit   int64
+	dataURIs   bool
+	jsonFields []string
+	chunked    bool
+	sessions   SessionStore
+	partial    bool
+	respond    func(*http.Request, []UploadedFile) any
+	reqTimeout time.Duration
+	upTimeout  time.Duration
+	slowAfter  time.Duration
+	stallAfter time.Duration
+	abortStall bool
+	fields     []string
+	ignored    []string
+	reject     bool
+	fieldCfgs  map[string]FieldConfig
+	maxSize    int64
+	fieldSize  int64
+	fieldCount int
+	allowed    []string
+	detector   Detector
+	sniffSize  int
+	blockExts  []string
+	blockExec  bool
+	mismatch   bool
+	imgLimits  *ImageLimits
+	stripMeta  bool
+	cleanSVG   bool
+	archLimits *ArchiveLimits
+	encArchive string
+	extract    bool
+	minSize    int64
+	skipEmpty  bool
+	sanitize   func(name string) string
+	maxNameLen int
+	publicURL  string
+	presignTTL time.Duration
+	suffixes   FormSuffixes
+	errHandler func(w http.ResponseWriter, req *http.Request, err error)
+	onError    func(ctx context.Context, err error, info ErrorInfo)
+	keepFiles  bool
+	staging    string
+	panicDir   string
+	handles    func(*http.Request) bool
+	rawField   string
+	authorize  func(*http.Request) error
+	honeypots  []string
+	spamCheck  func(fields url.Values) error
+	onStart    func(req *http.Request, f UploadedFile) error
+	onComplete func(req *http.Request, f UploadedFile, d time.Duration, err error) error
+	onProgress func(req *http.Request, field string, read, total int64)
+	progress   ProgressStore
+	quarantine *QuarantineConfig
+	quotaFunc  func(req *http.Request, pendingBytes int64) error
+	limiter    *limiter
+	gate       *gate
+	token      *TokenConfig
+	formMeta   bool
+	uploadMS   bool
+	concurrent int
+	sse        string
+	kmsKeyID   string
+	sseKeyFunc func(*http.Request) ([]byte, error)
+	encryption *EncryptionConfig
+
+	storageClass     string
+	storageClassFunc func(req *http.Request, filename string) string
+	tagFunc          func(req *http.Request, filename string) map[string]string
+	metadataFunc     func(req *http.Request, filename string) map[string]string
+	disposition      string
+	cacheControl     string
+	checksumAlgo     string
+	computeSHA256    bool
+	computeMD5       bool
+	contentKeys      bool
+	dedup            bool
+	objectLock       ObjectLock
+	objectLockFunc   func(req *http.Request, filename string) ObjectLock
+	requestPayer     string
 }
 
 type file struct {
-	name  string
-	ftype string
-	key   string
-	size  int64
+	name      string
+	ftype     string
+	key       string
+	bucket    string
+	size      int64
+	fallback  string
+	checksum  string
+	sha256    string
+	md5       string
+	duplicate bool
+	width     int
+	height    int
+	version   string
+	etag      string
+	url       string
+	sse       customerKey
+	staged    *staged
+	// quarantine is the reason the file was quarantined by OnUploadStart
+	quarantine string
+	// stored is true once the file was written to the backend, so it's removed if a later step
+	// fails
+	stored bool
 }
 
 func New(cfg Config) (*Wrapper, error) {
-	if cfg.S3Config == nil {
-		s3cfg, err := config.LoadDefaultConfig(context.Background())
-		if err != nil {
-			return nil, fmt.Errorf("failed to create S3 configuration: %w", err)
-		}
-		cfg.S3Config = &s3cfg
+	if cfg.Bucket == "" && len(cfg.Buckets) == 0 && cfg.BucketFunc == nil {
+		return nil, fmt.Errorf("bucket name is required")
 	}
 
-	cli := s3.NewFromConfig(*cfg.S3Config)
+	if cfg.PartSize < manager.MinUploadPartSize {
+		cfg.PartSize = manager.MinUploadPartSize
+	}
 
-	if cfg.Bucket == "" {
-		return nil, fmt.Errorf("bucket name is required")
+	backend := cfg.Backend
+	if backend == nil && cfg.LocalDir != "" {
+		backend = NewLocalBackend(cfg.LocalDir)
 	}
-	if cfg.CreateBucket {
-		if cfg.BucketACL == "" {
-			cfg.BucketACL = "private"
-		}
-		if err := createBucket(cli, cfg.Bucket, cfg.BucketACL); err != nil {
+	if backend == nil {
+		b, err := NewS3Backend(cfg)
+		if err != nil {
 			return nil, err
 		}
-	}
-
-	if cfg.PartSize < manager.MinUploadPartSize {
-		cfg.PartSize = manager.MinUploadPartSize
+		backend = b
 	}
 
 	w := Wrapper{
-		uploader: manager.NewUploader(cli, func(u *manager.Uploader) {
-			u.PartSize = cfg.PartSize
-		}),
-		logger:     cfg.Logger,
+		backend:    backend,
+		logger:     cfg.Slog,
+		tracer:     tracerProvider(cfg).Tracer(tracerName),
+		reqID:      cfg.RequestIDFunc,
+		metrics:    cfg.Metrics,
+		summary:    cfg.LogSummary,
+		active:     new(atomic.Int64),
+		errHandler: cfg.ErrorHandler,
+		onError:    cfg.OnError,
+		keepFiles:  cfg.KeepFilesOnError,
+		staging:    cfg.StagingPrefix,
+		panicDir:   cfg.PanicPrefix,
+		handles:    cfg.ShouldHandle,
+		rawField:   cfg.RawUploadField,
+		authorize:  cfg.Authorize,
+		honeypots:  cfg.HoneypotFields,
+		spamCheck:  cfg.SpamCheck,
+		onStart:    cfg.OnUploadStart,
+		onComplete: cfg.OnUploadComplete,
+		onProgress: cfg.OnProgress,
+		progress:   cfg.ProgressStore,
+		quotaFunc:  cfg.QuotaFunc,
 		bucket:     cfg.Bucket,
+		buckets:    cfg.Buckets,
+		allBuckets: bucketNames(cfg),
+		healthKey:  cfg.HealthCheckKey,
+		shardFunc:  cfg.ShardFunc,
+		bucketFunc: cfg.BucketFunc,
 		fileACL:    cfg.FileACL,
 		prefixFunc: cfg.PrefixFunc,
+		idFunc:     cfg.IDFunc,
+		exclusive:  cfg.PreventOverwrite,
+		partSize:   cfg.PartSize,
+		buffers:    newBufferPool(cfg.PartSize),
+		uploadMem:  uploadMemory(cfg),
+		inlineSize: cfg.InlineFileSize,
+		teeLimit:   cfg.TeeMemoryLimit,
+		dataURIs:   cfg.DataURIFields,
+		jsonFields: cfg.JSONFields,
+		chunked:    cfg.ChunkedUploads,
+		sessions:   cfg.SessionStore,
+		partial:    cfg.ContinueOnError,
+		respond:    cfg.ResponseFunc,
+		reqTimeout: cfg.RequestTimeout,
+		upTimeout:  cfg.UploadTimeout,
+		slowAfter:  cfg.SlowUpload,
+		stallAfter: cfg.StallTimeout,
+		abortStall: cfg.AbortStalled,
+		fields:     cfg.Fields,
+		ignored:    cfg.IgnoreFields,
+		reject:     cfg.RejectIgnoredFields,
+		fieldCfgs:  cfg.FieldConfigs,
+		maxSize:    cfg.MaxFileSize,
+		fieldSize:  cfg.MaxFieldSize,
+		fieldCount: cfg.MaxFieldCount,
+		allowed:    cfg.AllowedTypes,
+		detector:   cfg.Detector,
+		sniffSize:  cfg.SniffSize,
+		blockExec:  cfg.BlockExecutables,
+		mismatch:   cfg.RejectTypeMismatch,
+		imgLimits:  cfg.ImageLimits,
+		stripMeta:  cfg.StripMetadata,
+		cleanSVG:   cfg.SanitizeSVG,
+		archLimits: cfg.ArchiveLimits,
+		encArchive: cfg.EncryptedArchives,
+		extract:    cfg.ExtractArchives,
+		minSize:    cfg.MinFileSize,
+		skipEmpty:  cfg.SkipEmptyFiles,
+		sanitize:   cfg.FilenameSanitizer,
+		maxNameLen: cfg.MaxFilenameLength,
+		publicURL:  cfg.PublicURL,
+		presignTTL: cfg.PresignExpiry,
+		suffixes:   cfg.FormSuffixes.withDefaults(cfg.ChecksumAlgorithm),
+		formMeta:   cfg.FormMeta,
+		uploadMS:   cfg.FormUploadTime,
+		concurrent: cfg.ConcurrentUploads,
+		sse:        cfg.ServerSideEncryption,
+		kmsKeyID:   cfg.KMSKeyID,
+		sseKeyFunc: cfg.CustomerKeyFunc,
+		encryption: cfg.Encryption,
+
+		storageClass:     cfg.StorageClass,
+		storageClassFunc: cfg.StorageClassFunc,
+		tagFunc:          cfg.TagFunc,
+		metadataFunc:     cfg.MetadataFunc,
+		disposition:      cfg.ContentDisposition,
+		cacheControl:     cfg.CacheControl,
+		checksumAlgo:     strings.ToUpper(cfg.ChecksumAlgorithm),
+		computeSHA256:    cfg.ComputeSHA256,
+		computeMD5:       cfg.ComputeMD5,
+		contentKeys:      cfg.ContentAddressable,
+		dedup:            cfg.Deduplicate,
+		objectLock:       cfg.ObjectLock,
+		objectLockFunc:   cfg.ObjectLockFunc,
+		requestPayer:     cfg.RequestPayer,
+	}
+	if w.reqID == nil {
+		w.reqID = DefaultRequestID
 	}
-	if w.logger == nil {
-		w.logger = log.Default()
+	if w.logger == nil && cfg.Logger != nil {
+		w.logger = slog.New(newPrintfHandler(cfg.Logger))
+	} else if w.logger == nil {
+		w.logger = slog.Default()
 	}
 	if w.fileACL == "" {
 		w.fileACL = "private"
 	}
+	if w.sseKeyFunc != nil && (w.sse != "" || w.kmsKeyID != "") {
+		return nil, fmt.Errorf("CustomerKeyFunc can't be used with ServerSideEncryption or KMSKeyID")
+	}
+	switch w.encArchive {
+	case "", EncryptedReject:
+	case EncryptedTag:
+		if _, ok := w.backend.(Tagger); !ok {
+			return nil, fmt.Errorf("EncryptedTag requires a backend that implements Tagger")
+		}
+	default:
+		return nil, fmt.Errorf("invalid EncryptedArchives action %q", w.encArchive)
+	}
+	if w.encryption != nil {
+		if w.encryption.KeyFunc == nil {
+			return nil, fmt.Errorf("Encryption requires a KeyFunc")
+		}
+		if w.chunked || w.extract {
+			return nil, fmt.Errorf("Encryption can't be used with ChunkedUploads or ExtractArchives")
+		}
+	}
+	if w.checksumAlgo != "" && !validChecksumAlgorithm(w.checksumAlgo) {
+		return nil, fmt.Errorf("invalid checksum algorithm %q", cfg.ChecksumAlgorithm)
+	}
+	if _, ok := w.backend.(Presigner); w.presignTTL > 0 && !ok {
+		return nil, fmt.Errorf("PresignExpiry requires a backend that implements Presigner")
+	}
+	if lock := cfg.ObjectLock; lock.Mode != "" && lock.RetainUntil.IsZero() && lock.RetainFor <= 0 {
+		return nil, fmt.Errorf("ObjectLock.Mode requires RetainUntil or RetainFor")
+	}
+	if w.dedup && !w.contentKeys {
+		return nil, fmt.Errorf("Deduplicate requires ContentAddressable")
+	}
+	for _, ext := range cfg.BlockedExtensions {
+		w.blockExts = append(w.blockExts, "."+strings.ToLower(strings.TrimPrefix(ext, ".")))
+	}
+	if w.staging != "" && w.contentKeys {
+		return nil, fmt.Errorf("StagingPrefix can't be used with ContentAddressable")
+	}
+	if w.chunked {
+		if _, ok := w.backend.(MultipartUploader); !ok {
+			return nil, fmt.Errorf("ChunkedUploads requires a backend that implements MultipartUploader")
+		}
+		if _, ok := w.backend.(Getter); !ok {
+			return nil, fmt.Errorf("ChunkedUploads requires a backend that implements Getter")
+		}
+		if w.contentKeys || w.staging != "" || w.sseKeyFunc != nil {
+			return nil, fmt.Errorf("ChunkedUploads can't be used with ContentAddressable, StagingPrefix or CustomerKeyFunc")
+		}
+		if w.archLimits != nil || w.imgLimits != nil || w.stripMeta || w.cleanSVG || (cfg.PII != nil && len(cfg.PII.FileTypes) > 0) {
+			// the chunks are stored as they're received, the whole content isn't inspected
+			return nil, fmt.Errorf("ChunkedUploads can't be used with ArchiveLimits, ImageLimits, StripMetadata, SanitizeSVG or PII.FileTypes")
+		}
+	}
+	if w.extract {
+		if _, ok := w.backend.(Getter); !ok {
+			return nil, fmt.Errorf("ExtractArchives requires a backend that implements Getter")
+		}
+		if w.contentKeys || w.staging != "" {
+			return nil, fmt.Errorf("ExtractArchives can't be used with ContentAddressable or StagingPrefix")
+		}
+	}
+	if w.sessions == nil {
+		w.sessions = objectSessionStore{wr: &w, bucket: w.bucket}
+	}
+	if w.sse == "" && w.kmsKeyID != "" {
+		w.sse = string(types.ServerSideEncryptionAwsKms)
+	}
+	if !cfg.TeeFiles {
+		w.teeLimit = 0
+	} else if w.teeLimit <= 0 {
+		w.teeLimit = defaultTeeMemory
+	}
+	if w.detector == nil {
+		w.detector = DefaultDetector
+	}
+	if w.sniffSize <= 0 {
+		w.sniffSize = defaultSniffSize
+	}
+	if w.sanitize == nil {
+		w.sanitize = SanitizeFilename
+	}
+	if w.maxNameLen <= 0 {
+		w.maxNameLen = defaultMaxFilenameLength
+	}
+	if w.shardFunc == nil {
+		w.shardFunc = hashShard
+	}
+	if w.idFunc == nil {
+		w.idFunc = uuid.NewString
+	}
 	if w.prefixFunc == nil {
 		w.prefixFunc = func(*http.Request) string {
 			return time.Now().UTC().Format("/2006/01/02/")
 		}
 	}
+	switch {
+	case cfg.KeyFunc != nil && cfg.KeyTemplate != "":
+		return nil, fmt.Errorf("KeyFunc can't be used with KeyTemplate")
+	case cfg.KeyTemplate != "":
+		kf, err := templateKeyFunc(cfg.KeyTemplate, cfg.UserIDFunc, w.idFunc)
+		if err != nil {
+			return nil, err
+		}
+		w.keyFunc = kf
+	case cfg.KeyFunc != nil:
+		w.keyFunc = func(req *http.Request, filename, contentType string) (string, error) {
+			return cfg.KeyFunc(req, filename, contentType), nil
+		}
+	default:
+		w.keyFunc = func(req *http.Request, _, _ string) (string, error) {
+			return w.prefixFunc(req) + w.idFunc(), nil
+		}
+	}
+	if cfg.Replica != nil {
+		rb, err := newReplicatedBackend(w.backend, *cfg.Replica, w.logger)
+		if err != nil {
+			return nil, err
+		}
+		w.backend = rb
+	}
+	if cfg.Fallback != nil {
+		if cfg.Fallback.Backend == nil && cfg.Fallback.Bucket == "" {
+			return nil, fmt.Errorf("fallback bucket or backend is required")
+		}
+		fb := *cfg.Fallback
+		if fb.Backend == nil {
+			fb.Backend = w.backend
+		}
+		w.fallback = &fb
+	}
+	if cfg.RequireUploadToken != nil {
+		tc := *cfg.RequireUploadToken
+		if len(tc.Secret) == 0 {
+			return nil, fmt.Errorf("RequireUploadToken requires a secret")
+		}
+		if tc.Header == "" {
+			tc.Header = "X-Upload-Token"
+		}
+		if tc.Field == "" {
+			tc.Field = "upload_token"
+		}
+		w.token = &tc
+	}
+	if cfg.MemoryBudget > 0 {
+		w.memory = newBudget(cfg.MemoryBudget)
+	}
+	if cfg.MaxConcurrentUploads > 0 {
+		w.gate = newGate(cfg.MaxConcurrentUploads, cfg.UploadQueueTimeout)
+	}
+	if cfg.RateLimit != nil {
+		w.limiter = newLimiter(*cfg.RateLimit)
+	}
+	if cfg.Scan != nil {
+		sc := *cfg.Scan
+		if err := sc.validate(w.backend, w.contentKeys); err != nil {
+			return nil, err
+		}
+		w.scan = &sc
+	}
+	if cfg.Quarantine != nil {
+		if w.contentKeys {
+			return nil, fmt.Errorf("Quarantine can't be used with ContentAddressable")
+		}
+		qc := cfg.Quarantine.withDefaults()
+		w.quarantine = &qc
+	}
+	if cfg.PII != nil {
+		pc := *cfg.PII
+		if err := pc.validate(); err != nil {
+			return nil, err
+		}
+		w.pii = &pc
+	}
+	if cfg.Notifier != nil {
+		if cfg.NotifyWorkers <= 0 {
+			cfg.NotifyWorkers = 4
+		}
+		if cfg.NotifyQueueSize <= 0 {
+			cfg.NotifyQueueSize = 1000
+		}
+		if cfg.NotifyRetries == 0 {
+			cfg.NotifyRetries = 3
+		}
+		if cfg.NotifyBackoff <= 0 {
+			cfg.NotifyBackoff = time.Second
+		}
+		w.publisher = newPublisher(cfg.Notifier, cfg.NotifyWorkers, cfg.NotifyQueueSize, cfg.NotifyRetries, cfg.NotifyBackoff)
+	}
 
 	return &w, nil
 }
 
 func (wr Wrapper) Wrap(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		if !strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+		isJSON := len(wr.jsonFields) > 0 && isJSONRequest(req)
+		raw := !isJSON && wr.rawField != "" && isRawUpload(req)
+		if (!isJSON && !raw && !strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data")) ||
+			(wr.handles != nil && !wr.handles(req)) {
 			next.ServeHTTP(w, req)
 			return
 		}
+		req, span := wr.startRequest(req)
+		defer span.End()
+		req = wr.trackProgress(req)
+		req = wr.startMetrics(req)
+		if err := wr.checkAuthorized(req); err != nil {
+			wr.handleError(w, req, err)
+			return
+		}
+
+		body := &clientBody{ReadCloser: req.Body}
+		req.Body = body
+		req = withFileIndex(req)
 
-		mr, err := req.MultipartReader()
+		parent := req.Context()
+		req, deadlines := wr.withTimeout(w, req)
+		defer deadlines.cancel()
+
+		req, err := wr.withToken(req)
 		if err != nil {
-			wr.logAndErr(w, fmt.Errorf("failed create multipart reader: %w", err))
+			wr.handleError(w, req, err)
 			return
 		}
-
-		f := make(url.Values)
-		for {
-			part, err := mr.NextPart()
+		releaseGate := func() {}
+		if wr.limiter != nil {
+			release, err := wr.limiter.acquire(req)
 			if err != nil {
-				if errors.Is(err, io.EOF) {
-					break
-				}
-				wr.logAndErr(w, fmt.Errorf("failed to read request part: %w", err))
+				wr.handleError(w, req, err)
 				return
 			}
-
-			if err := wr.readPart(req, part, f); err != nil {
-				wr.logAndErr(w, err)
+			defer release()
+		}
+		if wr.gate != nil {
+			release, err := wr.gate.acquire(req)
+			if err != nil {
+				if errors.Is(err, ErrOverloaded) {
+					w.Header().Set("Retry-After", wr.gate.retryAfter())
+				}
+				wr.handleError(w, req, err)
 				return
 			}
+			defer release()
+			releaseGate = release
+		}
+		if req, err = wr.withQuota(req); err != nil {
+			wr.handleError(w, req, err)
+			return
 		}
 
+		res := result{
+			form:      make(url.Values),
+			inline:    make(map[string][]*multipart.FileHeader),
+			stubs:     make(map[string][]*multipart.FileHeader),
+			deadlines: deadlines,
+		}
+		defer wr.removeCopies(res.stubs)
+		switch {
+		case isJSON:
+			err = wr.readJSON(req, &res)
+		case raw:
+			err = wr.readRaw(req, &res)
+		default:
+			err = wr.readMultipart(req, &res)
+		}
+		releaseGate()
+		if err != nil {
+			wr.rollback(req, res.uploaded)
+			wr.handleError(w, req, timeoutError(body.classify(err)))
+			return
+		}
+		deadlines.stop()
+		progressOf(req).finish(req, nil)
+		wr.requestDone(req, nil)
+
 		if req.Form == nil {
 			req.Form = make(url.Values)
 		}
 		if req.PostForm == nil {
 			req.PostForm = make(url.Values)
 		}
-		for k, v := range f {
+		for k, v := range res.form {
 			req.PostForm[k] = append(req.PostForm[k], v...)
 			req.Form[k] = append(req.Form[k], v...)
 		}
+		// the body was read with MultipartReader, so ParseMultipartForm and FormFile would fail.
+		// Inline files come first so FormFile returns them.
+		files := res.inline
+		for k, v := range res.stubs {
+			files[k] = append(files[k], v...)
+		}
+		req.MultipartForm = &multipart.Form{Value: res.form, File: files}
 
-		next.ServeHTTP(w, req)
+		ctx := context.WithValue(parent, filesKey{}, res.uploaded)
+		if wr.partial {
+			ctx = context.WithValue(ctx, failedKey{}, res.failed)
+		}
+		if isJSON {
+			ctx = context.WithValue(ctx, jsonKey{}, res.json)
+		}
+		wr.serve(next, w, req.WithContext(ctx), res.uploaded)
 	})
 }
 
-func (wr Wrapper) readPart(req *http.Request, part *multipart.Part, frm url.Values) error {
+// checkAuthorized returns ErrUnauthorized if Config.Authorize refuses the request.
+func (wr Wrapper) checkAuthorized(req *http.Request) error {
+	if wr.authorize == nil {
+		return nil
+	}
+	if err := wr.authorize(req); err != nil {
+		return fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	}
+	return nil
+}
+
+// readMultipart uploads the files of a multipart request.
+func (wr Wrapper) readMultipart(req *http.Request, res *result) error {
+	mr, err := req.MultipartReader()
+	if err != nil {
+		return fmt.Errorf("%w: failed to create multipart reader: %w", ErrMalformedMultipart, err)
+	}
+	if wr.concurrent > 1 {
+		res.pipeline = newPipeline(wr.concurrent)
+	}
+	return res.pipeline.wait(wr, req, res, wr.readParts(req, mr, res))
+}
+
+// readParts reads the parts of a multipart request.
+func (wr Wrapper) readParts(req *http.Request, mr *multipart.Reader, res *result) error {
+	for {
+		if res.pipeline.failed(wr.partial) {
+			return nil
+		}
+		part, err := mr.NextPart()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return wr.checkSpam(res)
+			}
+			return fmt.Errorf("%w: failed to read request part: %w", ErrMalformedMultipart, err)
+		}
+		if err := wr.readPart(req, part, res); err != nil && !wr.skipFailed(req, err, res) {
+			return err
+		}
+	}
+}
+
+// serve calls the handler after the files were uploaded, cleaning them up if it panics and
+// committing or discarding them depending on the response when StagingPrefix is set.
+func (wr Wrapper) serve(next http.Handler, w http.ResponseWriter, req *http.Request, files []UploadedFile) {
+	if len(files) == 0 && wr.onError == nil {
+		next.ServeHTTP(w, req)
+		return
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			wr.reportPanic(req, v)
+			wr.cleanupPanic(req, files)
+			panic(v)
+		}
+	}()
+
+	if wr.staging == "" {
+		next.ServeHTTP(w, req)
+		wr.notify(req, files)
+		return
+	}
+	sw := &statusWriter{ResponseWriter: w}
+	next.ServeHTTP(sw, req)
+	if sw.success() {
+		wr.commit(req, files)
+		wr.notify(req, files)
+	} else {
+		wr.rollback(req, files)
+	}
+}
+
+// result collects the values of the parts of a request.
+type result struct {
+	form     url.Values
+	inline   map[string][]*multipart.FileHeader
+	stubs    map[string][]*multipart.FileHeader
+	uploaded []UploadedFile
+	failed   []*FileError
+	json     map[string]any
+
+	deadlines   *deadlines
+	pipeline    *pipeline
+	spamChecked bool
+	// fields is the number of form values that aren't files
+	fields int
+}
+
+func (wr Wrapper) readPart(req *http.Request, part *multipart.Part, res *result) error {
 	defer func() {
 		if err := part.Close(); err != nil {
-			wr.logger.Printf("failed to close part: %v", err)
+			wr.log(req).ErrorContext(req.Context(), "failed to close part", "field", part.FormName(), "error", err)
 		}
 	}()
 
 	name := part.FormName()
+	frm := res.form
+
+	// read nested files
+
+	if boundary := mixedBoundary(part); boundary != "" {
+		return wr.readMixed(req, part, boundary, res)
+	}
 
 	// read file
 
 	if part.FileName() != "" {
-		f, err := wr.readFile(req, part)
-		if err != nil {
+		if err := wr.checkSpam(res); err != nil {
 			return err
 		}
+	}
 
-		// if couldn't find type based on file header, try based on extension
-		if f.ftype == "application/octet-stream" {
-			if t := mime.TypeByExtension(filepath.Ext(f.name)); t != "" {
-				f.ftype = t
-			}
+	if part.FileName() != "" && !wr.uploads(name) {
+		if wr.reject {
+			return &FileError{Field: name, Name: part.FileName(), Err: ErrUnexpectedField}
+		}
+		if _, err := io.Copy(io.Discard, part); err != nil {
+			return fmt.Errorf("%w: failed to read file part: %w", ErrMalformedMultipart, err)
 		}
-		frm[name] = append(frm[name], f.key)
-		frm[name+"_name"] = append(frm[name+"_name"], f.name)
-		frm[name+"_type"] = append(frm[name+"_type"], f.ftype)
-		frm[name+"_size"] = append(frm[name+"_size"], fmt.Sprintf("%d", f.size))
 		return nil
 	}
 
+	if part.FileName() != "" && wr.chunked {
+		c, ok, err := chunkOf(frm)
+		if err != nil {
+			return &FileError{Field: name, Name: part.FileName(), Err: err}
+		}
+		if ok {
+			return wr.storeChunk(req, part, part, res, c)
+		}
+	}
+
+	if part.FileName() != "" {
+		body := io.Reader(part)
+		if wr.inlineSize > 0 {
+			content, inline, err := readInline(part, wr.inlineSize)
+			if err != nil {
+				return &FileError{Field: name, Name: part.FileName(), Err: err}
+			}
+			if inline {
+				fh, err := inlineFile(part, content)
+				if err != nil {
+					return &FileError{Field: name, Name: part.FileName(), Err: err}
+				}
+				res.inline[name] = append(res.inline[name], fh)
+				return nil
+			}
+			body = io.MultiReader(bytes.NewReader(content), part)
+		}
+		return wr.store(req, part, body, res)
+	}
+
 	// read string
 
-	val, err := wr.readString(part)
+	body := io.Reader(part)
+	if wr.dataURIs && wr.uploads(name) {
+		var file *multipart.Part
+		if file, body = readDataURI(name, part); file != nil {
+			return wr.store(req, file, body, res)
+		}
+	}
+	if res.fields++; wr.fieldCount > 0 && res.fields > wr.fieldCount {
+		return fmt.Errorf("%w: more than %d form fields", ErrTooLarge, wr.fieldCount)
+	}
+	val, err := wr.readString(name, body)
 	if err != nil {
 		return err
 	}
+	if wr.token != nil {
+		if ok, err := wr.readToken(req, name, val); ok {
+			return err
+		}
+	}
+	if ok, err := wr.readHoneypot(name, val); ok {
+		return err
+	}
+	if wr.pii != nil {
+		kinds, err := wr.inspectField(req, name, val)
+		if err != nil {
+			return err
+		}
+		if len(kinds) > 0 {
+			frm[name+wr.suffixes.PII] = append(frm[name+wr.suffixes.PII], strings.Join(kinds, ","))
+		}
+	}
 	frm[name] = append(frm[name], val)
 	return nil
 }
 
-func (wr Wrapper) readFile(req *http.Request, part *multipart.Part) (file, error) {
-	f := file{
-		name: filepath.Clean(part.FileName()),
-		key:  wr.prefixFunc(req) + uuid.NewString(),
+// storeFile uploads the file of the part, reading its content from body, and adds it to the result.
+func (wr Wrapper) storeFile(req *http.Request, part *multipart.Part, body io.Reader, res *result) error {
+	name := part.FormName()
+	req, span := wr.startFile(req, name, part.FileName())
+	defer span.End()
+	if err := wr.checkSpam(res); err != nil {
+		return err
+	}
+	if err := wr.checkToken(req, name); err != nil {
+		return &FileError{Field: name, Name: part.FileName(), Err: err}
+	}
+	var t *tee
+	if wr.teeLimit > 0 {
+		var err error
+		if t, body, err = newTee(part, body, wr.teeLimit); err != nil {
+			return &FileError{Field: name, Name: part.FileName(), Err: err}
+		}
 	}
 
-	counter := &bytesCounter{r: part}
-	_, err := wr.uploader.Upload(req.Context(), &s3.PutObjectInput{
-		ACL:    types.ObjectCannedACL(wr.fileACL),
-		Key:    aws.String(f.key),
-		Body:   counter,
-		Bucket: aws.String(wr.bucket),
-	})
+	var taps []*tap
+	var threat string
+	if wr.scan != nil {
+		var sc *tap
+		sc, body = wr.startScan(req.Context(), wr.filename(part), body, &threat)
+		taps = append(taps, sc)
+	}
+	if wr.archLimits != nil {
+		var at *tap
+		at, body = newTap(body, wr.inspectArchive)
+		taps = append(taps, at)
+	}
+	var pii []string
+	if wr.pii != nil && len(wr.pii.FileTypes) > 0 {
+		var pt *tap
+		pt, body = wr.startPII(req.Context(), wr.filename(part), body, &pii)
+		taps = append(taps, pt)
+	}
+	var encrypted bool
+	if wr.encArchive != "" {
+		var et *tap
+		et, body = newTap(body, wr.inspectEncryption(&encrypted))
+		taps = append(taps, et)
+	}
+
+	defer wr.startUpload()()
+	start := time.Now()
+	ureq, restore := res.deadlines.upload(req, wr.upTimeout)
+	ureq, body, unwatch := wr.watchFile(ureq, res.deadlines, name, part.FileName(), body)
+	f, err := wr.readFile(ureq, part, body, res.form.Get(name+wr.suffixes.SHA256))
+	uploadTime := time.Since(start)
+	unwatch()
+	restore()
+	err = timeoutError(err)
+	if err != nil && f.stored {
+		// a step after the upload failed, e.g. moving it to its content addressable key
+		wr.rollback(req, []UploadedFile{wr.uploadedFile(name, f)})
+	}
+	for _, t := range taps {
+		if terr := t.finish(err != nil); terr != nil {
+			if err == nil {
+				wr.rollback(req, []UploadedFile{wr.uploadedFile(name, f)})
+			}
+			err = terr
+		}
+	}
+	var fh *multipart.FileHeader
+	if t != nil {
+		var terr error
+		if fh, terr = t.finish(err != nil); terr != nil && err == nil {
+			wr.rollback(req, []UploadedFile{wr.uploadedFile(name, f)})
+			err = terr
+		}
+	}
+	if errors.Is(err, errEmptyFile) {
+		wr.removeCopy(fh)
+		return nil
+	}
+	uf := wr.uploadedFile(name, f)
+	uf.PII = pii
+	if wr.uploadMS {
+		uf.UploadMS = uploadTime.Milliseconds()
+	}
+	if err == nil {
+		uf, err = wr.infected(req, uf, threat)
+	}
+	if err == nil {
+		uf, err = wr.encryptedArchive(req, uf, encrypted)
+	}
+	if err == nil && wr.extract {
+		if uf, err = wr.extractArchive(req, uf); err != nil {
+			wr.rollback(req, []UploadedFile{uf})
+		}
+	}
+	if wr.onComplete != nil {
+		herr := wr.onComplete(req, uf, time.Since(start), err)
+		switch {
+		case herr == nil || err != nil:
+		case wr.quarantined(herr):
+			uf, err = wr.quarantineFile(req, uf, herr)
+		default:
+			wr.rollback(req, []UploadedFile{uf})
+			err = fmt.Errorf("file rejected by OnUploadComplete: %w", herr)
+		}
+	}
+	wr.logFile(req, name, part.FileName(), uf, time.Since(start), err)
+	endFile(span, uf, err)
+	wr.fileDone(req, part.FileName(), uf.Size, time.Since(start), err)
+	if err != nil {
+		wr.removeCopy(fh)
+		return &FileError{Field: name, Name: part.FileName(), Err: err}
+	}
+	res.uploaded = append(res.uploaded, uf)
+	res.stubs[name] = append(res.stubs[name], fileHeader(uf, fh))
+	return wr.appendFile(res.form, uf)
+}
+
+// readFile uploads the file, declared is the SHA-256 digest the client sent for the file, if any.
+// If it fails the file has the information known at that point.
+func (wr Wrapper) readFile(req *http.Request, part *multipart.Part, body io.Reader, declared string) (file, error) {
+	f := file{name: wr.filename(part)}
+
+	// the content type is detected before the upload starts so it can be set in the object
+	head, err := readHead(body, wr.sniffSize)
 	if err != nil {
-		return file{}, fmt.Errorf("failed to upload file to S3: %w", err)
+		return f, fmt.Errorf("%w: failed to read file part: %w", ErrMalformedMultipart, err)
+	}
+	if len(head) == 0 && wr.skipEmpty {
+		return f, errEmptyFile
+	}
+	if err := wr.checkBlocked(f.name, head); err != nil {
+		return f, err
+	}
+	if wr.mismatch {
+		if err := checkMismatch(f.name, part.Header.Get("Content-Type"), head); err != nil {
+			return f, err
+		}
+	}
+	f.ftype = wr.detectType(head, f.name)
+	fc := wr.fieldCfgs[part.FormName()]
+	if err := wr.checkType(part.FormName(), f.ftype); err != nil {
+		return f, err
+	}
+	if err := checkTokenType(req, f.ftype); err != nil {
+		return f, err
+	}
+	if head, err = wr.checkImage(&f, head, body); err != nil {
+		return f, err
+	}
+	if err := wr.locate(req, part.FormName(), &f); err != nil {
+		return f, err
+	}
+
+	content := io.MultiReader(bytes.NewReader(head), body)
+	if wr.stripMeta {
+		content = stripMetadata(f.ftype, content)
+	}
+	if wr.cleanSVG && f.ftype == "image/svg+xml" {
+		content = sanitizeSVG(content)
+	}
+	content = wr.withProgress(req, part, content)
+	counter := &bytesCounter{r: content, limit: tokenSizeLimit(req, wr.sizeLimit(part.FormName())), min: wr.minSize, quota: quotaOf(req)}
+	if wr.computeSHA256 || wr.contentKeys {
+		counter.sha256 = sha256.New()
+	}
+	if wr.computeMD5 {
+		counter.md5 = md5.New()
+	}
+	in := wr.objectInput(req, f, fc)
+	in.Body = counter
+	if wr.exclusive {
+		in.IfNoneMatch = aws.String("*")
+	}
+	if wr.sseKeyFunc != nil {
+		if err := wr.setCustomerKey(req, in, &f); err != nil {
+			return f, err
+		}
+	}
+	if wr.encryption != nil {
+		if err := wr.encrypt(req, in, counter); err != nil {
+			return f, err
+		}
+	}
+	if wr.staging != "" && f.quarantine == "" {
+		f.staged = wr.stage(in)
+	}
+
+	if wr.dedup && declared != "" {
+		f.duplicate, err = wr.discardDuplicate(req, in, counter, declared, &f)
+		if err != nil {
+			return f, err
+		}
+	}
+	if !f.duplicate {
+		if err := wr.upload(req.Context(), in, counter, &f); err != nil {
+			return f, err
+		}
+		f.stored = true
 	}
 
 	f.size = counter.count
-	f.ftype = counter.fileType
+	if counter.sha256 != nil {
+		f.sha256 = hex.EncodeToString(counter.sha256.Sum(nil))
+	}
+	if counter.md5 != nil {
+		f.md5 = hex.EncodeToString(counter.md5.Sum(nil))
+	}
+
+	switch {
+	case f.duplicate:
+		f.key = contentKey(f.sha256)
+		if f.bucket, err = wr.bucketFor(req, f.key); err != nil {
+			return f, err
+		}
+	case wr.contentKeys && f.fallback == "":
+		if err := wr.moveToContentKey(req, in, &f); err != nil {
+			return f, err
+		}
+	}
+	if wr.presignTTL > 0 {
+		if f.url, err = wr.presign(req.Context(), f, wr.presignTTL); err != nil {
+			return f, err
+		}
+	}
 
 	return f, nil
 }
 
-func (Wrapper) readString(p *multipart.Part) (string, error) {
-	buf := bytes.Buffer{}
-	if _, err := buf.ReadFrom(p); err != nil {
-		return "", fmt.Errorf("failed to read string part: %w", err)
+// checkType returns ErrUnsupportedType if files of the type can't be uploaded in the field.
+func (wr Wrapper) checkType(field, ftype string) error {
+	allowed := wr.allowed
+	if fc := wr.fieldCfgs[field]; len(fc.AllowedTypes) > 0 {
+		allowed = fc.AllowedTypes
 	}
-	return buf.String(), nil
+	if !typeAllowed(allowed, ftype) {
+		return fmt.Errorf("%w: %q", ErrUnsupportedType, ftype)
+	}
+	return nil
 }
 
-func createBucket(cli *s3.Client, name, acl string) error {
-	_, err := cli.CreateBucket(context.Background(), &s3.CreateBucketInput{
-		Bucket: aws.String(name),
-		ACL:    types.BucketCannedACL(acl),
-	})
+// sizeLimit returns the maximum size of the files of the field, zero if there's no limit.
+func (wr Wrapper) sizeLimit(field string) int64 {
+	if fc := wr.fieldCfgs[field]; fc.MaxSize > 0 {
+		return fc.MaxSize
+	}
+	return wr.maxSize
+}
+
+// locate sets the key and bucket of the file of the field, and calls the OnUploadStart hook.
+func (wr Wrapper) locate(req *http.Request, field string, f *file) error {
+	if err := wr.place(req, field, f); err != nil {
+		return err
+	}
+	if wr.onStart != nil {
+		err := wr.onStart(req, wr.uploadedFile(field, *f))
+		if wr.quarantined(err) {
+			wr.quarantineLocation(f, err)
+		} else if err != nil {
+			return fmt.Errorf("file rejected by OnUploadStart: %w", err)
+		}
+	}
+	return nil
+}
+
+// place sets the key and bucket of the file of the field.
+func (wr Wrapper) place(req *http.Request, field string, f *file) error {
+	var err error
+	f.key, err = wr.keyFunc(req, f.name, f.ftype)
 	if err != nil {
-		var aerr *types.BucketAlreadyOwnedByYou
-		if errors.As(err, &aerr) {
-			return nil
+		return err
+	}
+	fc := wr.fieldCfgs[field]
+	if fc.Prefix != "" {
+		f.key = prefixKey(fc.Prefix, f.key)
+	}
+	if f.bucket = fc.Bucket; f.bucket == "" {
+		if f.bucket, err = wr.bucketFor(req, f.key); err != nil {
+			return err
 		}
-		return fmt.Errorf("failed to create bucket %q: %w", name, err)
 	}
 	return nil
 }
 
-func (wr Wrapper) logAndErr(w http.ResponseWriter, err error) {
-	wr.logger.Printf("failed to read request part: %v", err)
-	http.Error(w, http.StatusText(500), 500)
+// objectInput returns the input to upload the file, without its body.
+func (wr Wrapper) objectInput(req *http.Request, f file, fc FieldConfig) *s3.PutObjectInput {
+	in := &s3.PutObjectInput{
+		Key:         aws.String(f.key),
+		Bucket:      aws.String(f.bucket),
+		ContentType: aws.String(f.ftype),
+	}
+	if !isDirectoryBucket(f.bucket) {
+		in.ACL = types.ObjectCannedACL(wr.fileACL)
+		if fc.ACL != "" {
+			in.ACL = types.ObjectCannedACL(fc.ACL)
+		}
+	}
+	if wr.disposition != "" {
+		in.ContentDisposition = aws.String(contentDisposition(wr.disposition, f.name))
+	}
+	if wr.cacheControl != "" {
+		in.CacheControl = aws.String(wr.cacheControl)
+	}
+	if wr.checksumAlgo != "" {
+		in.ChecksumAlgorithm = types.ChecksumAlgorithm(wr.checksumAlgo)
+	}
+	if wr.sse != "" {
+		in.ServerSideEncryption = types.ServerSideEncryption(wr.sse)
+	}
+	if wr.kmsKeyID != "" {
+		in.SSEKMSKeyId = aws.String(wr.kmsKeyID)
+	}
+	if class := wr.storageClassFor(req, f.name); class != "" {
+		in.StorageClass = types.StorageClass(class)
+	}
+	// directory buckets don't support object tags nor Object Lock
+	directory := isDirectoryBucket(f.bucket)
+	if wr.tagFunc != nil && !directory {
+		if tags := wr.tagFunc(req, f.name); len(tags) > 0 {
+			in.Tagging = aws.String(encodeTags(tags))
+		}
+	}
+	if wr.metadataFunc != nil {
+		in.Metadata = wr.metadataFunc(req, f.name)
+	}
+	in.Metadata = wr.setRequestID(req, in.Metadata)
+	if !directory {
+		wr.setObjectLock(req, f.name, in)
+	}
+	if wr.requestPayer != "" {
+		in.RequestPayer = types.RequestPayer(wr.requestPayer)
+	}
+	if f.quarantine != "" {
+		wr.quarantineInput(req, f, in)
+	}
+	return in
+}
+
+// payer returns the RequestPayer of the requests to S3.
+func (wr Wrapper) payer() types.RequestPayer {
+	return types.RequestPayer(wr.requestPayer)
+}
+
+// upload sends the file to the backend, or the fallback backend if that fails.
+func (wr Wrapper) upload(ctx context.Context, in *s3.PutObjectInput, counter *bytesCounter, f *file) error {
+	release, err := wr.memory.reserve(ctx, wr.uploadMem)
+	if err != nil {
+		return fmt.Errorf("failed to wait for memory: %w", err)
+	}
+	defer release()
+
+	var replay *replayReader
+	if wr.fallback != nil {
+		buf := wr.buffers.get()
+		defer wr.buffers.put(buf)
+		replay = &replayReader{r: in.Body, buf: *buf}
+		in.Body = replay
+	}
+
+	out, err := wr.backend.Upload(ctx, in)
+	if err != nil && counter.invalid != nil {
+		return counter.invalid
+	}
+	if err != nil && isPreconditionFailed(err) {
+		return fmt.Errorf("%w: %q", ErrKeyExists, aws.ToString(in.Key))
+	}
+	if err != nil && replay != nil {
+		out, f.fallback, err = wr.uploadFallback(ctx, in, replay, err)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrS3Upload, err)
+	}
+
+	f.checksum = checksumOf(out, types.ChecksumAlgorithm(wr.checksumAlgo))
+	f.version = aws.ToString(out.VersionID)
+	f.etag = aws.ToString(out.ETag)
+	return nil
+}
+
+func (wr Wrapper) storageClassFor(req *http.Request, filename string) string {
+	if wr.storageClassFunc != nil {
+		if class := wr.storageClassFunc(req, filename); class != "" {
+			return class
+		}
+	}
+	return wr.storageClass
+}
+
+// encodeTags encodes the tags in the URL query format expected by S3.
+func encodeTags(tags map[string]string) string {
+	v := make(url.Values, len(tags))
+	for k, t := range tags {
+		v.Set(k, t)
+	}
+	return v.Encode()
 }
 
+// readString reads the value of the field, up to Config.MaxFieldSize bytes.
+func (wr Wrapper) readString(name string, r io.Reader) (string, error) {
+	if wr.fieldSize > 0 {
+		r = io.LimitReader(r, wr.fieldSize+1)
+	}
+	buf := bytes.Buffer{}
+	if _, err := buf.ReadFrom(r); err != nil {
+		return "", fmt.Errorf("%w: failed to read string part: %w", ErrMalformedMultipart, err)
+	}
+	if wr.fieldSize > 0 && int64(buf.Len()) > wr.fieldSize {
+		return "", fmt.Errorf("%w: field %q is larger than %d bytes", ErrTooLarge, name, wr.fieldSize)
+	}
+	return buf.String(), nil
+}
+
+func validChecksumAlgorithm(algo string) bool {
+	switch types.ChecksumAlgorithm(algo) {
+	case types.ChecksumAlgorithmCrc32, types.ChecksumAlgorithmCrc32c, types.ChecksumAlgorithmCrc64nvme,
+		types.ChecksumAlgorithmSha1, types.ChecksumAlgorithmSha256:
+		return true
+	}
+	return false
+}
+
+// checksumOf returns the checksum calculated by S3 with the algorithm.
+func checksumOf(out *manager.UploadOutput, algo types.ChecksumAlgorithm) string {
+	switch algo {
+	case types.ChecksumAlgorithmCrc32:
+		return aws.ToString(out.ChecksumCRC32)
+	case types.ChecksumAlgorithmCrc32c:
+		return aws.ToString(out.ChecksumCRC32C)
+	case types.ChecksumAlgorithmCrc64nvme:
+		return aws.ToString(out.ChecksumCRC64NVME)
+	case types.ChecksumAlgorithmSha1:
+		return aws.ToString(out.ChecksumSHA1)
+	case types.ChecksumAlgorithmSha256:
+		return aws.ToString(out.ChecksumSHA256)
+	}
+	return ""
+}
+
+// bytesCounter counts the bytes read and optionally calculates their digests. Reading more than
+// limit bytes (if greater than zero) fails with ErrTooLarge and reaching the end before min bytes
+// with ErrTooSmall.
 type bytesCounter struct {
-	r        io.Reader
-	count    int64
-	typeBuf  []byte
-	fileType string
+	r       io.Reader
+	count   int64
+	limit   int64
+	min     int64
+	invalid error
+	sha256  hash.Hash
+	md5     hash.Hash
+	quota   *quota
 }
 
 func (bc *bytesCounter) Read(b []byte) (int, error) {
 	n, err := bc.r.Read(b)
 	bc.count += int64(n)
+	if bc.sha256 != nil {
+		bc.sha256.Write(b[:n])
+	}
+	if bc.md5 != nil {
+		bc.md5.Write(b[:n])
+	}
+	switch {
+	case bc.limit > 0 && bc.count > bc.limit:
+		bc.invalid = fmt.Errorf("%w: larger than %d bytes", ErrTooLarge, bc.limit)
+	case errors.Is(err, io.EOF) && bc.count < bc.min:
+		bc.invalid = fmt.Errorf("%w: smaller than %d bytes", ErrTooSmall, bc.min)
+	case errors.Is(err, ErrMalformedMultipart), errors.Is(err, ErrUnsupportedType):
+		bc.invalid = err
+	}
+	if bc.invalid == nil && bc.quota != nil {
+		bc.invalid = bc.quota.add(n, errors.Is(err, io.EOF))
+	}
+	if bc.invalid != nil {
+		return n, bc.invalid
+	}
+	return n, err
+}
 
-	// accumulate a few bytes (at most 261 according to https://github.com/h2non/filetype)
-	// so we can try to detect the content type via the file header
-	if bc.fileType == "" {
-		bc.typeBuf = append(bc.typeBuf, b...)
-
-		if errors.Is(err, io.EOF) || len(bc.typeBuf) >= 261 {
-			t, err := filetype.Match(bc.typeBuf)
-			if err != nil || t.MIME.Value == "" {
-				bc.fileType = "application/octet-stream"
-			} else {
-				bc.fileType = t.MIME.Value
-			}
-			bc.typeBuf = nil
-		}
+// defaultSniffSize is how many bytes are read to detect the content type, see Config.SniffSize.
+const defaultSniffSize = 261
+
+// readHead reads the first size bytes of the file, used to detect its content type.
+func readHead(r io.Reader, size int) ([]byte, error) {
+	head := make([]byte, size)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
 	}
+	return head[:n], nil
+}
 
-	return n, err
+// contentDisposition returns the Content-Disposition header value with the filename.
+func contentDisposition(disposition, filename string) string {
+	if v := mime.FormatMediaType(disposition, map[string]string{"filename": filename}); v != "" {
+		return v
+	}
+	return disposition
 }
@@ -3,18 +3,28 @@ package mps3
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"io"
+	"log"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gabrielhora/mps3/mps3test"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -61,11 +71,256 @@ func TestUploadFilesToS3(t *testing.T) {
 	assert.Equal(200, res.Result().StatusCode)
 }
 
+func TestServerSideEncryption(t *testing.T) {
+	assert := assert.New(t)
+
+	backend, form, res := uploadToMemory(t, Config{KMSKeyID: "key-id"}, nil, "test_file2.txt")
+	assert.Equal(200, res.Code)
+
+	obj, _ := backend.Object(bucket, form.Get("file"))
+	assert.Equal(types.ServerSideEncryptionAwsKms, obj.Input.ServerSideEncryption)
+	assert.Equal("key-id", aws.ToString(obj.Input.SSEKMSKeyId))
+}
+
+func TestCustomerProvidedKey(t *testing.T) {
+	assert := assert.New(t)
+
+	key := bytes.Repeat([]byte{1}, 32)
+	backend, form, res := uploadToMemory(t, Config{
+		CustomerKeyFunc: func(*http.Request) ([]byte, error) { return key, nil },
+	}, nil, "test_file2.txt")
+	assert.Equal(200, res.Code)
+
+	obj, _ := backend.Object(bucket, form.Get("file"))
+	sum := md5.Sum(key)
+	assert.Equal("AES256", aws.ToString(obj.Input.SSECustomerAlgorithm))
+	assert.Equal(base64.StdEncoding.EncodeToString(key), aws.ToString(obj.Input.SSECustomerKey))
+	assert.Equal(base64.StdEncoding.EncodeToString(sum[:]), aws.ToString(obj.Input.SSECustomerKeyMD5))
+
+	_, _, res = uploadToMemory(t, Config{
+		CustomerKeyFunc: func(*http.Request) ([]byte, error) { return []byte("short"), nil },
+	}, nil, "test_file2.txt")
+	assert.Equal(500, res.Code)
+}
+
+func TestStorageClass(t *testing.T) {
+	assert := assert.New(t)
+
+	backend, form, res := uploadToMemory(t, Config{
+		StorageClass: "STANDARD_IA",
+		StorageClassFunc: func(_ *http.Request, filename string) string {
+			if strings.HasSuffix(filename, ".png") {
+				return "GLACIER_IR"
+			}
+			return ""
+		},
+	}, nil, "test_file1.png", "test_file2.txt")
+	assert.Equal(200, res.Code)
+
+	obj, _ := backend.Object(bucket, form["file"][0])
+	assert.Equal(types.StorageClassGlacierIr, obj.Input.StorageClass)
+	obj, _ = backend.Object(bucket, form["file"][1])
+	assert.Equal(types.StorageClassStandardIa, obj.Input.StorageClass)
+}
+
+func TestObjectTags(t *testing.T) {
+	assert := assert.New(t)
+
+	backend, form, res := uploadToMemory(t, Config{
+		TagFunc: func(_ *http.Request, filename string) map[string]string {
+			return map[string]string{"user": "42", "file name": filename}
+		},
+	}, nil, "test_file2.txt")
+	assert.Equal(200, res.Code)
+
+	obj, _ := backend.Object(bucket, form.Get("file"))
+	assert.Equal("file+name=test_file2.txt&user=42", aws.ToString(obj.Input.Tagging))
+}
+
+func TestObjectMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	backend, form, res := uploadToMemory(t, Config{
+		MetadataFunc: func(_ *http.Request, filename string) map[string]string {
+			return map[string]string{"filename": filename}
+		},
+	}, nil, "test_file2.txt")
+	assert.Equal(200, res.Code)
+
+	obj, _ := backend.Object(bucket, form.Get("file"))
+	assert.Equal(map[string]string{"filename": "test_file2.txt"}, obj.Metadata)
+}
+
+func TestObjectHeaders(t *testing.T) {
+	assert := assert.New(t)
+
+	backend, form, res := uploadToMemory(t, Config{
+		ContentDisposition: "attachment",
+		CacheControl:       "max-age=3600",
+	}, nil, "test_file1.png", "test_file2.txt")
+	assert.Equal(200, res.Code)
+
+	obj, _ := backend.Object(bucket, form["file"][0])
+	assert.Equal("image/png", aws.ToString(obj.Input.ContentType))
+	assert.Equal(`attachment; filename=test_file1.png`, aws.ToString(obj.Input.ContentDisposition))
+	assert.Equal("max-age=3600", aws.ToString(obj.Input.CacheControl))
+
+	obj, _ = backend.Object(bucket, form["file"][1])
+	assert.Equal("text/plain; charset=utf-8", aws.ToString(obj.Input.ContentType))
+	assert.Equal("text/plain; charset=utf-8", form["file_type"][1])
+}
+
+func TestChecksum(t *testing.T) {
+	assert := assert.New(t)
+
+	backend, form, res := uploadToMemory(t, Config{ChecksumAlgorithm: "sha256"}, nil, "test_file2.txt")
+	assert.Equal(200, res.Code)
+
+	obj, _ := backend.Object(bucket, form.Get("file"))
+	assert.Equal(types.ChecksumAlgorithmSha256, obj.Input.ChecksumAlgorithm)
+	sum := sha256.Sum256(obj.Body)
+	assert.Equal(base64.StdEncoding.EncodeToString(sum[:]), form.Get("file_sha256"))
+
+	_, err := New(Config{Bucket: bucket, Backend: backend, ChecksumAlgorithm: "md5"})
+	assert.Error(err)
+}
+
+func TestComputeDigests(t *testing.T) {
+	assert := assert.New(t)
+
+	_, form, res := uploadToMemory(t, Config{ComputeSHA256: true, ComputeMD5: true}, nil, "test_file1.png", "test_file2.txt")
+	assert.Equal(200, res.Code)
+
+	for i, name := range []string{"test_file1.png", "test_file2.txt"} {
+		content, _ := os.ReadFile(name)
+		sha := sha256.Sum256(content)
+		sum := md5.Sum(content)
+		assert.Equal(hex.EncodeToString(sha[:]), form["file_sha256"][i])
+		assert.Equal(hex.EncodeToString(sum[:]), form["file_md5"][i])
+	}
+}
+
+func TestObjectLock(t *testing.T) {
+	assert := assert.New(t)
+
+	until := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	backend, form, res := uploadToMemory(t, Config{
+		ObjectLock: ObjectLock{Mode: "GOVERNANCE", RetainFor: time.Hour},
+		ObjectLockFunc: func(_ *http.Request, filename string) ObjectLock {
+			if filename == "test_file1.png" {
+				return ObjectLock{Mode: "COMPLIANCE", RetainUntil: until, LegalHold: true}
+			}
+			return ObjectLock{}
+		},
+	}, nil, "test_file1.png", "test_file2.txt")
+	assert.Equal(200, res.Code)
+
+	obj, _ := backend.Object(bucket, form["file"][0])
+	assert.Equal(types.ObjectLockModeCompliance, obj.Input.ObjectLockMode)
+	assert.Equal(until, *obj.Input.ObjectLockRetainUntilDate)
+	assert.Equal(types.ObjectLockLegalHoldStatusOn, obj.Input.ObjectLockLegalHoldStatus)
+	assert.Equal(types.ChecksumAlgorithmCrc32, obj.Input.ChecksumAlgorithm)
+	assert.Empty(form["file_crc32"])
+
+	obj, _ = backend.Object(bucket, form["file"][1])
+	assert.Empty(obj.Input.ObjectLockMode)
+	assert.Empty(obj.Input.ChecksumAlgorithm)
+
+	// a mode without a retention period doesn't retain the files
+	_, err := New(Config{Bucket: bucket, Backend: backend, ObjectLock: ObjectLock{Mode: "GOVERNANCE"}})
+	assert.Error(err)
+	backend, form, res = uploadToMemory(t, Config{
+		ObjectLockFunc: func(*http.Request, string) ObjectLock { return ObjectLock{Mode: "GOVERNANCE"} },
+	}, nil, "test_file2.txt")
+	assert.Equal(200, res.Code)
+	obj, _ = backend.Object(bucket, form.Get("file"))
+	assert.Empty(obj.Input.ObjectLockMode)
+	assert.Nil(obj.Input.ObjectLockRetainUntilDate)
+}
+
+func TestRequestPayer(t *testing.T) {
+	assert := assert.New(t)
+
+	backend, form, res := uploadToMemory(t, Config{RequestPayer: "requester"}, nil, "test_file2.txt")
+	assert.Equal(200, res.Code)
+
+	obj, _ := backend.Object(bucket, form.Get("file"))
+	assert.Equal(types.RequestPayerRequester, obj.Input.RequestPayer)
+}
+
+func TestVersionAndETag(t *testing.T) {
+	assert := assert.New(t)
+
+	_, form, res := uploadToMemory(t, Config{}, nil, "test_file2.txt")
+	assert.Equal(200, res.Code)
+
+	content, _ := os.ReadFile("test_file2.txt")
+	sum := md5.Sum(content)
+	assert.Equal(`"`+hex.EncodeToString(sum[:])+`"`, form.Get("file_etag"))
+	assert.Equal([]string{""}, form["file_version"])
+}
+
+func TestKeyFunc(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := Config{
+		KeyFunc: func(req *http.Request, filename, contentType string) string {
+			return "/custom/" + contentType + "/" + filename
+		},
+	}
+	backend, form, res := uploadToMemory(t, cfg, nil, "test_file2.txt")
+	assert.Equal(200, res.Code)
+	assert.Equal("/custom/text/plain; charset=utf-8/test_file2.txt", form.Get("file"))
+
+	_, ok := backend.Object(bucket, form.Get("file"))
+	assert.True(ok)
+}
+
+// uploadToMemory sends the fields and files through the middleware using an in-memory backend,
+// it returns the backend, the form values received by the handler and the response.
+func uploadToMemory(t *testing.T, cfg Config, fields map[string]string, files ...string) (*mps3test.Backend, url.Values, *httptest.ResponseRecorder) {
+	t.Helper()
+
+	if cfg.Bucket == "" && len(cfg.Buckets) == 0 {
+		cfg.Bucket = bucket
+	}
+	if cfg.Backend == nil {
+		cfg.Backend = mps3test.NewBackend()
+	}
+	backend, _ := cfg.Backend.(*mps3test.Backend)
+	if cfg.Logger == nil {
+		cfg.Logger = log.New(io.Discard, "", 0)
+	}
+	wrapper, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := newRequest(fields, files...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := httptest.NewRecorder()
+
+	var form url.Values
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		form = req.Form
+	})).ServeHTTP(res, req)
+
+	return backend, form, res
+}
+
 func newRequest(fields map[string]string, files ...string) (*http.Request, error) {
 	buf := &bytes.Buffer{}
 	writer := multipart.NewWriter(buf)
 	defer writer.Close()
 
+	for k, v := range fields {
+		if err := writer.WriteField(k, v); err != nil {
+			return nil, err
+		}
+	}
+
 	for _, fname := range files {
 		err := func() error {
 			f, err := os.Open(fname)
@@ -90,12 +345,6 @@ func newRequest(fields map[string]string, files ...string) (*http.Request, error
 		}
 	}
 
-	for k, v := range fields {
-		if err := writer.WriteField(k, v); err != nil {
-			return nil, err
-		}
-	}
-
 	req := httptest.NewRequest("POST", "/", buf)
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 	return req, nil
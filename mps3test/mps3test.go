@@ -0,0 +1,496 @@
+// Package mps3test provides an in-memory mps3.Backend so handlers wrapped by the
+// middleware can be unit tested without a running S3 compatible server.
+//
+//	backend := mps3test.NewBackend()
+//	wrapper, _ := mps3.New(mps3.Config{Bucket: "test", Backend: backend})
+//	wrapper.Wrap(handler).ServeHTTP(res, req)
+//
+//	obj, ok := backend.Object("test", req.Form.Get("file"))
+package mps3test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// Object is a file stored in the Backend.
+type Object struct {
+	Bucket   string
+	Key      string
+	Body     []byte
+	Metadata map[string]string
+
+	// Input is the input used to upload the object, without the Body.
+	Input s3.PutObjectInput
+}
+
+// Backend stores uploaded files in memory. It's safe for concurrent use.
+type Backend struct {
+	mu      sync.Mutex
+	objects []Object
+	uploads map[string]*multipartUpload
+	lastID  int
+}
+
+// multipartUpload is an upload started by CreateMultipartUpload.
+type multipartUpload struct {
+	in    s3.PutObjectInput
+	parts map[int32][]byte
+}
+
+// minPartSize is the minimum size of the parts of a multipart upload, except the last one.
+const minPartSize = 5 << 20
+
+// NewBackend returns an empty Backend.
+func NewBackend() *Backend {
+	return &Backend{}
+}
+
+// Upload reads the whole body and stores it in memory, replacing an object with the same key.
+func (b *Backend) Upload(_ context.Context, in *s3.PutObjectInput) (*manager.UploadOutput, error) {
+	buf := bytes.Buffer{}
+	if _, err := buf.ReadFrom(in.Body); err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+
+	obj := Object{
+		Bucket:   aws.ToString(in.Bucket),
+		Key:      aws.ToString(in.Key),
+		Body:     buf.Bytes(),
+		Metadata: in.Metadata,
+		Input:    *in,
+	}
+	obj.Input.Body = nil
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.find(obj.Bucket, obj.Key); ok && aws.ToString(in.IfNoneMatch) == "*" {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed", Message: "object " + obj.Key + " already exists"}
+	}
+	b.remove(obj.Bucket, obj.Key)
+	b.objects = append(b.objects, obj)
+
+	out := &manager.UploadOutput{
+		Location: "mem://" + obj.Bucket + "/" + obj.Key,
+		Key:      in.Key,
+		ETag:     aws.String(etag(obj.Body)),
+	}
+	setChecksum(out, in.ChecksumAlgorithm, obj.Body)
+	return out, nil
+}
+
+// Copy copies a stored object, the Input of the copy is the one of the source object with the
+// ACL of the copy, and its tags with the REPLACE tagging directive.
+func (b *Backend) Copy(_ context.Context, in *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	bucket, key, err := parseCopySource(aws.ToString(in.CopySource))
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	src, ok := b.find(bucket, key)
+	if !ok {
+		return nil, &types.NoSuchKey{Message: aws.String("object " + key + " not found")}
+	}
+
+	obj := src
+	obj.Bucket = aws.ToString(in.Bucket)
+	obj.Key = aws.ToString(in.Key)
+	obj.Input.Bucket = in.Bucket
+	obj.Input.Key = in.Key
+	if in.ACL != "" {
+		obj.Input.ACL = types.ObjectCannedACL(in.ACL)
+	}
+	if in.TaggingDirective == types.TaggingDirectiveReplace {
+		obj.Input.Tagging = in.Tagging
+	}
+	b.remove(obj.Bucket, obj.Key)
+	b.objects = append(b.objects, obj)
+
+	return &s3.CopyObjectOutput{
+		CopyObjectResult: &types.CopyObjectResult{ETag: aws.String(etag(obj.Body))},
+	}, nil
+}
+
+// Delete removes a stored object.
+func (b *Backend) Delete(_ context.Context, in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remove(aws.ToString(in.Bucket), aws.ToString(in.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+// Head returns the size and content type of a stored object.
+func (b *Backend) Head(_ context.Context, in *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	obj, ok := b.find(aws.ToString(in.Bucket), aws.ToString(in.Key))
+	if !ok {
+		return nil, &types.NotFound{Message: aws.String("object " + aws.ToString(in.Key) + " not found")}
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(obj.Body))),
+		ContentType:   obj.Input.ContentType,
+		ETag:          aws.String(etag(obj.Body)),
+		Metadata:      obj.Metadata,
+	}, nil
+}
+
+// Get returns a stored object.
+func (b *Backend) Get(_ context.Context, in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	obj, ok := b.find(aws.ToString(in.Bucket), aws.ToString(in.Key))
+	if !ok {
+		return nil, &types.NoSuchKey{Message: aws.String("object " + aws.ToString(in.Key) + " not found")}
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(obj.Body)),
+		ContentLength: aws.Int64(int64(len(obj.Body))),
+		ContentType:   obj.Input.ContentType,
+		ETag:          aws.String(etag(obj.Body)),
+		Metadata:      obj.Metadata,
+	}, nil
+}
+
+// PutObjectTagging replaces the tags of a stored object, they're kept URL encoded in
+// Input.Tagging like the tags set when the object is uploaded.
+func (b *Backend) PutObjectTagging(_ context.Context, in *s3.PutObjectTaggingInput) (*s3.PutObjectTaggingOutput, error) {
+	tags := url.Values{}
+	if in.Tagging != nil {
+		for _, t := range in.Tagging.TagSet {
+			tags.Set(aws.ToString(t.Key), aws.ToString(t.Value))
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, o := range b.objects {
+		if o.Bucket == aws.ToString(in.Bucket) && o.Key == aws.ToString(in.Key) {
+			b.objects[i].Input.Tagging = aws.String(tags.Encode())
+			return &s3.PutObjectTaggingOutput{}, nil
+		}
+	}
+	return nil, &types.NoSuchKey{Message: aws.String("object " + aws.ToString(in.Key) + " not found")}
+}
+
+// List returns the objects of the bucket whose key starts with the prefix, sorted by key. The
+// continuation token is the last key of the previous page.
+func (b *Backend) List(_ context.Context, in *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	limit := int(aws.ToInt32(in.MaxKeys))
+	if limit <= 0 || limit > 1000 {
+		limit = 1000
+	}
+
+	b.mu.Lock()
+	var objects []types.Object
+	for _, o := range b.objects {
+		if o.Bucket != aws.ToString(in.Bucket) || !strings.HasPrefix(o.Key, aws.ToString(in.Prefix)) ||
+			o.Key <= aws.ToString(in.ContinuationToken) {
+			continue
+		}
+		objects = append(objects, types.Object{
+			Key:  aws.String(o.Key),
+			Size: aws.Int64(int64(len(o.Body))),
+			ETag: aws.String(etag(o.Body)),
+		})
+	}
+	b.mu.Unlock()
+
+	slices.SortFunc(objects, func(a, b types.Object) int {
+		return strings.Compare(*a.Key, *b.Key)
+	})
+	out := &s3.ListObjectsV2Output{Name: in.Bucket, Prefix: in.Prefix, IsTruncated: aws.Bool(len(objects) > limit)}
+	if len(objects) > limit {
+		objects = objects[:limit]
+		out.NextContinuationToken = objects[limit-1].Key
+	}
+	out.Contents = objects
+	out.KeyCount = aws.Int32(int32(len(objects)))
+	return out, nil
+}
+
+// CreateMultipartUpload starts a multipart upload, the object is stored with the settings of in by
+// CompleteMultipartUpload.
+func (b *Backend) CreateMultipartUpload(_ context.Context, in *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.uploads == nil {
+		b.uploads = make(map[string]*multipartUpload)
+	}
+	b.lastID++
+	id := strconv.Itoa(b.lastID)
+	b.uploads[id] = &multipartUpload{
+		in: s3.PutObjectInput{
+			Bucket:             in.Bucket,
+			Key:                in.Key,
+			ACL:                in.ACL,
+			CacheControl:       in.CacheControl,
+			ChecksumAlgorithm:  in.ChecksumAlgorithm,
+			ContentDisposition: in.ContentDisposition,
+			ContentType:        in.ContentType,
+			Metadata:           in.Metadata,
+			StorageClass:       in.StorageClass,
+			Tagging:            in.Tagging,
+		},
+		parts: make(map[int32][]byte),
+	}
+	return &s3.CreateMultipartUploadOutput{Bucket: in.Bucket, Key: in.Key, UploadId: aws.String(id)}, nil
+}
+
+// UploadPart reads the whole body and stores it as a part of the upload. Part checksums are not
+// calculated.
+func (b *Backend) UploadPart(_ context.Context, in *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+	buf := bytes.Buffer{}
+	if _, err := buf.ReadFrom(in.Body); err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	u, err := b.upload(in.UploadId)
+	if err != nil {
+		return nil, err
+	}
+	u.parts[aws.ToInt32(in.PartNumber)] = buf.Bytes()
+	return &s3.UploadPartOutput{ETag: aws.String(etag(buf.Bytes()))}, nil
+}
+
+// ListParts returns the parts of the upload sorted by part number, the continuation marker is the
+// last part number of the previous page.
+func (b *Backend) ListParts(_ context.Context, in *s3.ListPartsInput) (*s3.ListPartsOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	u, err := b.upload(in.UploadId)
+	if err != nil {
+		return nil, err
+	}
+	limit := int(aws.ToInt32(in.MaxParts))
+	if limit <= 0 || limit > 1000 {
+		limit = 1000
+	}
+	marker, _ := strconv.Atoi(aws.ToString(in.PartNumberMarker))
+
+	var nums []int32
+	for n := range u.parts {
+		if int(n) > marker {
+			nums = append(nums, n)
+		}
+	}
+	slices.Sort(nums)
+	out := &s3.ListPartsOutput{Bucket: in.Bucket, Key: in.Key, UploadId: in.UploadId, IsTruncated: aws.Bool(false)}
+	if len(nums) > limit {
+		nums = nums[:limit]
+		out.IsTruncated = aws.Bool(true)
+		out.NextPartNumberMarker = aws.String(strconv.Itoa(int(nums[limit-1])))
+	}
+	for _, n := range nums {
+		body := u.parts[n]
+		out.Parts = append(out.Parts, types.Part{PartNumber: aws.Int32(n), Size: aws.Int64(int64(len(body))), ETag: aws.String(etag(body))})
+	}
+	return out, nil
+}
+
+// CompleteMultipartUpload stores the object made of the listed parts. Like S3, it fails if a part
+// doesn't match its ETag or if a part other than the last has less than 5MB.
+func (b *Backend) CompleteMultipartUpload(_ context.Context, in *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	u, err := b.upload(in.UploadId)
+	if err != nil {
+		return nil, err
+	}
+	var parts []types.CompletedPart
+	if in.MultipartUpload != nil {
+		parts = in.MultipartUpload.Parts
+	}
+	if len(parts) == 0 {
+		return nil, &smithy.GenericAPIError{Code: "MalformedXML", Message: "no parts"}
+	}
+
+	buf := bytes.Buffer{}
+	for i, p := range parts {
+		body, ok := u.parts[aws.ToInt32(p.PartNumber)]
+		switch {
+		case !ok || aws.ToString(p.ETag) != etag(body):
+			return nil, &smithy.GenericAPIError{Code: "InvalidPart", Message: "part " + strconv.Itoa(int(aws.ToInt32(p.PartNumber))) + " not found"}
+		case i > 0 && aws.ToInt32(p.PartNumber) <= aws.ToInt32(parts[i-1].PartNumber):
+			return nil, &smithy.GenericAPIError{Code: "InvalidPartOrder", Message: "parts must be in ascending order"}
+		case i < len(parts)-1 && len(body) < minPartSize:
+			return nil, &smithy.GenericAPIError{Code: "EntityTooSmall", Message: "part " + strconv.Itoa(int(aws.ToInt32(p.PartNumber))) + " is too small"}
+		}
+		buf.Write(body)
+	}
+
+	obj := Object{
+		Bucket:   aws.ToString(in.Bucket),
+		Key:      aws.ToString(in.Key),
+		Body:     buf.Bytes(),
+		Metadata: u.in.Metadata,
+		Input:    u.in,
+	}
+	if _, ok := b.find(obj.Bucket, obj.Key); ok && aws.ToString(in.IfNoneMatch) == "*" {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed", Message: "object " + obj.Key + " already exists"}
+	}
+	delete(b.uploads, aws.ToString(in.UploadId))
+	b.remove(obj.Bucket, obj.Key)
+	b.objects = append(b.objects, obj)
+	return &s3.CompleteMultipartUploadOutput{Bucket: in.Bucket, Key: in.Key, ETag: aws.String(etag(obj.Body))}, nil
+}
+
+// AbortMultipartUpload removes the upload and its parts.
+func (b *Backend) AbortMultipartUpload(_ context.Context, in *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, err := b.upload(in.UploadId); err != nil {
+		return nil, err
+	}
+	delete(b.uploads, aws.ToString(in.UploadId))
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+// Uploads returns the number of multipart uploads that were not completed or aborted.
+func (b *Backend) Uploads() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.uploads)
+}
+
+// PresignGet returns a fake URL of the object that includes the expiration, e.g.
+// `mem://bucket/key?X-Amz-Expires=900`. The object doesn't need to exist.
+func (b *Backend) PresignGet(_ context.Context, in *s3.GetObjectInput, expires time.Duration) (string, error) {
+	q := url.Values{"X-Amz-Expires": {strconv.Itoa(int(expires.Seconds()))}}
+	return "mem://" + aws.ToString(in.Bucket) + "/" + aws.ToString(in.Key) + "?" + q.Encode(), nil
+}
+
+// PresignPost returns a fake URL of the bucket, e.g. `mem://bucket`, the fields are the key and the
+// JSON encoded conditions in the "policy" field.
+func (b *Backend) PresignPost(_ context.Context, in *s3.PutObjectInput, _ time.Duration, conditions []any) (string, map[string]string, error) {
+	policy, err := json.Marshal(conditions)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encode policy: %w", err)
+	}
+	fields := map[string]string{"key": aws.ToString(in.Key), "policy": string(policy)}
+	return "mem://" + aws.ToString(in.Bucket), fields, nil
+}
+
+// etag returns the quoted MD5 digest of the body, like S3 does for single part uploads.
+func etag(body []byte) string {
+	sum := md5.Sum(body)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// setChecksum calculates the checksum of the body like S3 does for single part uploads.
+func setChecksum(out *manager.UploadOutput, algo types.ChecksumAlgorithm, body []byte) {
+	var h hash.Hash
+	switch algo {
+	case types.ChecksumAlgorithmCrc32:
+		h = crc32.NewIEEE()
+	case types.ChecksumAlgorithmCrc32c:
+		h = crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case types.ChecksumAlgorithmSha1:
+		h = sha1.New()
+	case types.ChecksumAlgorithmSha256:
+		h = sha256.New()
+	default:
+		return
+	}
+	h.Write(body)
+	sum := aws.String(base64.StdEncoding.EncodeToString(h.Sum(nil)))
+
+	switch algo {
+	case types.ChecksumAlgorithmCrc32:
+		out.ChecksumCRC32 = sum
+	case types.ChecksumAlgorithmCrc32c:
+		out.ChecksumCRC32C = sum
+	case types.ChecksumAlgorithmSha1:
+		out.ChecksumSHA1 = sum
+	case types.ChecksumAlgorithmSha256:
+		out.ChecksumSHA256 = sum
+	}
+}
+
+// Object returns the object stored under bucket and key.
+func (b *Backend) Object(bucket, key string) (Object, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.find(bucket, key)
+}
+
+// Objects returns all stored objects in the order they were uploaded.
+func (b *Backend) Objects() []Object {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Object(nil), b.objects...)
+}
+
+// Reset removes all stored objects and multipart uploads.
+func (b *Backend) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects = nil
+	b.uploads = nil
+}
+
+func (b *Backend) upload(id *string) (*multipartUpload, error) {
+	u, ok := b.uploads[aws.ToString(id)]
+	if !ok {
+		return nil, &types.NoSuchUpload{Message: aws.String("upload " + aws.ToString(id) + " not found")}
+	}
+	return u, nil
+}
+
+func (b *Backend) find(bucket, key string) (Object, bool) {
+	for _, o := range b.objects {
+		if o.Bucket == bucket && o.Key == key {
+			return o, true
+		}
+	}
+	return Object{}, false
+}
+
+func (b *Backend) remove(bucket, key string) {
+	for i, o := range b.objects {
+		if o.Bucket == bucket && o.Key == key {
+			b.objects = append(b.objects[:i], b.objects[i+1:]...)
+			return
+		}
+	}
+}
+
+// parseCopySource is the same as mps3.ParseCopySource, which can't be imported here
+// because mps3 tests use this package.
+func parseCopySource(src string) (string, string, error) {
+	src, err := url.PathUnescape(src)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid copy source: %w", err)
+	}
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(src, "/"), "/")
+	if !ok || bucket == "" || key == "" {
+		return "", "", fmt.Errorf("invalid copy source %q", src)
+	}
+	return bucket, key, nil
+}
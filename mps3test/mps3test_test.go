@@ -0,0 +1,51 @@
+package mps3test_test
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gabrielhora/mps3"
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ mps3.Backend = (*mps3test.Backend)(nil)
+
+func TestBackendRecordsUploads(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := &bytes.Buffer{}
+	writer := multipart.NewWriter(buf)
+	part, err := writer.CreateFormFile("file", "hello.txt")
+	assert.NoError(err)
+	_, err = part.Write([]byte("hello world!"))
+	assert.NoError(err)
+	assert.NoError(writer.Close())
+
+	req := httptest.NewRequest("POST", "/", buf)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	res := httptest.NewRecorder()
+
+	backend := mps3test.NewBackend()
+	wrapper, err := mps3.New(mps3.Config{Bucket: "test", Backend: backend})
+	assert.NoError(err)
+
+	var key string
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key = req.Form.Get("file")
+	})
+	wrapper.Wrap(h).ServeHTTP(res, req)
+	assert.Equal(200, res.Result().StatusCode)
+
+	obj, ok := backend.Object("test", key)
+	assert.True(ok)
+	assert.Equal("hello world!", string(obj.Body))
+	assert.Equal("private", string(obj.Input.ACL))
+	assert.Len(backend.Objects(), 1)
+
+	backend.Reset()
+	assert.Empty(backend.Objects())
+}
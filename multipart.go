@@ -0,0 +1,253 @@
+package mps3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// abortRuleID is the ID of the lifecycle rule created by AbortIncompleteUploadsAfterDays.
+const abortRuleID = "mps3-abort-incomplete-multipart-uploads"
+
+// abortTimeout limits how long aborting a failed multipart upload can take.
+const abortTimeout = 30 * time.Second
+
+// MultipartUploader is implemented by backends that can upload a file in parts sent by several
+// requests, it's required by TusHandler. The default S3 backend implements it.
+type MultipartUploader interface {
+	// CreateMultipartUpload starts the upload of in.Bucket and in.Key, it's stored by
+	// CompleteMultipartUpload with the settings of in.
+	CreateMultipartUpload(ctx context.Context, in *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error)
+
+	// UploadPart stores the part in.PartNumber of the upload in.UploadId, replacing it if it exists.
+	UploadPart(ctx context.Context, in *s3.UploadPartInput) (*s3.UploadPartOutput, error)
+
+	// ListParts returns a page of the parts of the upload, sorted by part number. If the upload
+	// doesn't exist the error is a *types.NoSuchUpload.
+	ListParts(ctx context.Context, in *s3.ListPartsInput) (*s3.ListPartsOutput, error)
+
+	// CompleteMultipartUpload stores the object made of the parts, all parts but the last must
+	// have at least 5MB.
+	CompleteMultipartUpload(ctx context.Context, in *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error)
+
+	// AbortMultipartUpload removes the upload and its parts.
+	AbortMultipartUpload(ctx context.Context, in *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error)
+}
+
+func (b *s3Backend) CreateMultipartUpload(ctx context.Context, in *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+	return b.client.CreateMultipartUpload(ctx, in)
+}
+
+func (b *s3Backend) UploadPart(ctx context.Context, in *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+	return b.client.UploadPart(ctx, in)
+}
+
+func (b *s3Backend) ListParts(ctx context.Context, in *s3.ListPartsInput) (*s3.ListPartsOutput, error) {
+	return b.client.ListParts(ctx, in)
+}
+
+func (b *s3Backend) CompleteMultipartUpload(ctx context.Context, in *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+	return b.client.CompleteMultipartUpload(ctx, in)
+}
+
+func (b *s3Backend) AbortMultipartUpload(ctx context.Context, in *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	return b.client.AbortMultipartUpload(ctx, in)
+}
+
+// multipart returns the primary backend as a MultipartUploader, the parts aren't replicated.
+func (b *replicatedBackend) multipart() (MultipartUploader, error) {
+	mu, ok := b.primary.(MultipartUploader)
+	if !ok {
+		return nil, fmt.Errorf("backend doesn't support multipart uploads")
+	}
+	return mu, nil
+}
+
+func (b *replicatedBackend) CreateMultipartUpload(ctx context.Context, in *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+	mu, err := b.multipart()
+	if err != nil {
+		return nil, err
+	}
+	return mu.CreateMultipartUpload(ctx, in)
+}
+
+func (b *replicatedBackend) UploadPart(ctx context.Context, in *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+	mu, err := b.multipart()
+	if err != nil {
+		return nil, err
+	}
+	return mu.UploadPart(ctx, in)
+}
+
+func (b *replicatedBackend) ListParts(ctx context.Context, in *s3.ListPartsInput) (*s3.ListPartsOutput, error) {
+	mu, err := b.multipart()
+	if err != nil {
+		return nil, err
+	}
+	return mu.ListParts(ctx, in)
+}
+
+func (b *replicatedBackend) CompleteMultipartUpload(ctx context.Context, in *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+	mu, err := b.multipart()
+	if err != nil {
+		return nil, err
+	}
+	return mu.CompleteMultipartUpload(ctx, in)
+}
+
+func (b *replicatedBackend) AbortMultipartUpload(ctx context.Context, in *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	mu, err := b.multipart()
+	if err != nil {
+		return nil, err
+	}
+	return mu.AbortMultipartUpload(ctx, in)
+}
+
+// createMultipartInput returns the input to start a multipart upload with the settings of in.
+func createMultipartInput(in *s3.PutObjectInput) *s3.CreateMultipartUploadInput {
+	return &s3.CreateMultipartUploadInput{
+		Bucket:                    in.Bucket,
+		Key:                       in.Key,
+		ACL:                       in.ACL,
+		CacheControl:              in.CacheControl,
+		ChecksumAlgorithm:         in.ChecksumAlgorithm,
+		ContentDisposition:        in.ContentDisposition,
+		ContentType:               in.ContentType,
+		Metadata:                  in.Metadata,
+		ObjectLockLegalHoldStatus: in.ObjectLockLegalHoldStatus,
+		ObjectLockMode:            in.ObjectLockMode,
+		ObjectLockRetainUntilDate: in.ObjectLockRetainUntilDate,
+		RequestPayer:              in.RequestPayer,
+		SSEKMSKeyId:               in.SSEKMSKeyId,
+		ServerSideEncryption:      in.ServerSideEncryption,
+		StorageClass:              in.StorageClass,
+		Tagging:                   in.Tagging,
+	}
+}
+
+// completedPart returns the part to list in CompleteMultipartUpload, with its checksums.
+func completedPart(p types.Part) types.CompletedPart {
+	return types.CompletedPart{
+		PartNumber:        p.PartNumber,
+		ETag:              p.ETag,
+		ChecksumCRC32:     p.ChecksumCRC32,
+		ChecksumCRC32C:    p.ChecksumCRC32C,
+		ChecksumCRC64NVME: p.ChecksumCRC64NVME,
+		ChecksumSHA1:      p.ChecksumSHA1,
+		ChecksumSHA256:    p.ChecksumSHA256,
+	}
+}
+
+// listParts returns all parts of the upload.
+func listParts(ctx context.Context, mu MultipartUploader, bucket, key, uploadID string, payer types.RequestPayer) ([]types.Part, error) {
+	var parts []types.Part
+	in := &s3.ListPartsInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		UploadId:     aws.String(uploadID),
+		RequestPayer: payer,
+	}
+	for {
+		out, err := mu.ListParts(ctx, in)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list parts of upload %q: %w", uploadID, err)
+		}
+		parts = append(parts, out.Parts...)
+		if !aws.ToBool(out.IsTruncated) {
+			return parts, nil
+		}
+		in.PartNumberMarker = out.NextPartNumberMarker
+	}
+}
+
+// abortUpload aborts the multipart upload that failed with err, if any, so its parts are not kept
+// (and billed) by S3. It's not bound to ctx since the upload usually fails because ctx was canceled.
+func (b *s3Backend) abortUpload(ctx context.Context, in *s3.PutObjectInput, err error) error {
+	var mf manager.MultiUploadFailure
+	if b.leaveParts || !errors.As(err, &mf) || mf.UploadID() == "" {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), abortTimeout)
+	defer cancel()
+	_, aerr := b.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:       in.Bucket,
+		Key:          in.Key,
+		UploadId:     aws.String(mf.UploadID()),
+		RequestPayer: in.RequestPayer,
+	})
+	if aerr != nil {
+		return fmt.Errorf("%w (failed to abort multipart upload %q: %v)", err, mf.UploadID(), aerr)
+	}
+	return err
+}
+
+// ensureAbortRule makes sure the bucket has a lifecycle rule that removes incomplete multipart
+// uploads after the given number of days, existing rules are kept.
+func ensureAbortRule(cli *s3.Client, bucket string, days int32) error {
+	ctx := context.Background()
+	var rules []types.LifecycleRule
+	out, err := cli.GetBucketLifecycleConfiguration(ctx, &s3.GetBucketLifecycleConfigurationInput{Bucket: aws.String(bucket)})
+	var aerr smithy.APIError
+	switch {
+	case err == nil:
+		rules = out.Rules
+	case errors.As(err, &aerr) && aerr.ErrorCode() == "NoSuchLifecycleConfiguration":
+	default:
+		return fmt.Errorf("failed to get lifecycle configuration of %q: %w", bucket, err)
+	}
+
+	rules, changed := withAbortRule(rules, days)
+	if !changed {
+		return nil
+	}
+	_, err = cli.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(bucket),
+		LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: rules},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update lifecycle configuration of %q: %w", bucket, err)
+	}
+	return nil
+}
+
+// withAbortRule adds the rule to abort incomplete multipart uploads to the rules, unless an enabled
+// rule for the whole bucket already does it. It returns false if the rules were not changed.
+func withAbortRule(rules []types.LifecycleRule, days int32) ([]types.LifecycleRule, bool) {
+	for _, r := range rules {
+		if r.Status == types.ExpirationStatusEnabled && r.AbortIncompleteMultipartUpload != nil && wholeBucket(r) {
+			return rules, false
+		}
+	}
+
+	rule := types.LifecycleRule{
+		ID:                             aws.String(abortRuleID),
+		Status:                         types.ExpirationStatusEnabled,
+		Filter:                         &types.LifecycleRuleFilter{Prefix: aws.String("")},
+		AbortIncompleteMultipartUpload: &types.AbortIncompleteMultipartUpload{DaysAfterInitiation: aws.Int32(days)},
+	}
+	for i, r := range rules {
+		if aws.ToString(r.ID) == abortRuleID {
+			rules[i] = rule
+			return rules, true
+		}
+	}
+	return append(rules, rule), true
+}
+
+// wholeBucket returns true if the lifecycle rule applies to all objects of the bucket.
+func wholeBucket(r types.LifecycleRule) bool {
+	if aws.ToString(r.Prefix) != "" {
+		return false
+	}
+	f := r.Filter
+	return f == nil || (aws.ToString(f.Prefix) == "" && f.And == nil && f.Tag == nil &&
+		f.ObjectSizeGreaterThan == nil && f.ObjectSizeLessThan == nil)
+}
@@ -0,0 +1,93 @@
+package mps3
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAbortFailedMultipartUpload(t *testing.T) {
+	assert := assert.New(t)
+
+	var mu sync.Mutex
+	var aborted []string
+	var cancel context.CancelFunc
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		switch {
+		case req.Method == http.MethodPost && q.Has("uploads"):
+			w.Write([]byte(`<InitiateMultipartUploadResult><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>`))
+		case req.Method == http.MethodDelete && q.Has("uploadId"):
+			mu.Lock()
+			aborted = append(aborted, q.Get("uploadId"))
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			// the request context is usually canceled when an upload fails mid-way
+			mu.Lock()
+			cancel()
+			mu.Unlock()
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`<Error><Code>AccessDenied</Code></Error>`))
+		}
+	}))
+	defer server.Close()
+
+	s3cfg, err := newEndpointConfig(server.URL, "us-east-1", "key", "secret")
+	assert.NoError(err)
+	s3cfg.RetryMaxAttempts = 1
+
+	upload := func(leaveParts bool) error {
+		ctx, c := context.WithCancel(context.Background())
+		defer c()
+		mu.Lock()
+		cancel, aborted = c, nil
+		mu.Unlock()
+
+		b, err := NewS3Backend(Config{S3Config: s3cfg, Bucket: bucket, LeavePartsOnError: leaveParts})
+		assert.NoError(err)
+		body := bytes.NewReader(make([]byte, manager.MinUploadPartSize+1))
+		_, err = b.Upload(ctx, &s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String("key"), Body: struct{ *bytes.Reader }{body}})
+		return err
+	}
+
+	assert.Error(upload(false))
+	assert.Equal([]string{"upload-1"}, aborted)
+
+	assert.Error(upload(true))
+	assert.Empty(aborted)
+}
+
+func TestAbortLifecycleRule(t *testing.T) {
+	assert := assert.New(t)
+
+	rules, changed := withAbortRule(nil, 7)
+	assert.True(changed)
+	assert.Len(rules, 1)
+	assert.Equal(abortRuleID, *rules[0].ID)
+	assert.Equal(int32(7), *rules[0].AbortIncompleteMultipartUpload.DaysAfterInitiation)
+
+	_, changed = withAbortRule(rules, 7)
+	assert.False(changed)
+
+	// rules limited to a prefix don't cover the whole bucket
+	existing := []types.LifecycleRule{{
+		ID:                             aws.String("logs"),
+		Status:                         types.ExpirationStatusEnabled,
+		Filter:                         &types.LifecycleRuleFilter{Prefix: aws.String("logs/")},
+		AbortIncompleteMultipartUpload: &types.AbortIncompleteMultipartUpload{DaysAfterInitiation: aws.Int32(1)},
+	}}
+	rules, changed = withAbortRule(existing, 3)
+	assert.True(changed)
+	assert.Len(rules, 2)
+	assert.Equal("logs", *rules[0].ID)
+}
@@ -0,0 +1,181 @@
+package mps3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// UploadEvent is published by Config.Notifier for each file uploaded by a request.
+type UploadEvent struct {
+	// Event is always "mps3.upload"
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+
+	Field       string `json:"field"`
+	Name        string `json:"name"`
+	Bucket      string `json:"bucket"`
+	Key         string `json:"key"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"type"`
+	ETag        string `json:"etag,omitempty"`
+	VersionID   string `json:"version,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
+	Quarantine  string `json:"quarantine,omitempty"`
+
+	Request EventRequest `json:"request"`
+}
+
+// EventRequest is the request that uploaded the file of an UploadEvent, or failed (see ErrorInfo).
+type EventRequest struct {
+	// ID of Config.RequestIDFunc, if any
+	ID         string `json:"id,omitempty"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	RemoteAddr string `json:"remote_addr"`
+	UserAgent  string `json:"user_agent,omitempty"`
+}
+
+// Notifier publishes the events of the uploaded files, e.g. NewSNSNotifier. It must be safe for
+// concurrent use.
+type Notifier interface {
+	Notify(ctx context.Context, e UploadEvent) error
+}
+
+// NotifierFunc is a function that implements Notifier.
+type NotifierFunc func(ctx context.Context, e UploadEvent) error
+
+func (f NotifierFunc) Notify(ctx context.Context, e UploadEvent) error {
+	return f(ctx, e)
+}
+
+// notify queues the events of the files of a request that succeeded, they are published in the
+// background. Failures are only logged since the request was handled already.
+func (wr Wrapper) notify(req *http.Request, files []UploadedFile) {
+	if wr.publisher == nil {
+		return
+	}
+	ctx := context.WithoutCancel(req.Context())
+	for _, f := range files {
+		job := publishJob{ctx: ctx, logger: wr.log(req), event: wr.uploadEvent(req, f)}
+		if err := wr.publisher.publish(job); err != nil {
+			wr.log(req).ErrorContext(ctx, "failed to publish upload event", "key", f.Key, "error", err)
+		}
+	}
+}
+
+// uploadEvent returns the event of an uploaded file.
+func (wr Wrapper) uploadEvent(req *http.Request, f UploadedFile) UploadEvent {
+	return UploadEvent{
+		Event:       "mps3.upload",
+		Time:        time.Now().UTC(),
+		Field:       f.Field,
+		Name:        f.Name,
+		Bucket:      f.Bucket,
+		Key:         f.Key,
+		Size:        f.Size,
+		ContentType: f.ContentType,
+		ETag:        f.ETag,
+		VersionID:   f.VersionID,
+		SHA256:      f.SHA256,
+		Quarantine:  f.Quarantine,
+		Request:     wr.eventRequest(req),
+	}
+}
+
+// eventRequest returns the request of an event.
+func (wr Wrapper) eventRequest(req *http.Request) EventRequest {
+	return EventRequest{
+		ID:         wr.requestID(req),
+		Method:     req.Method,
+		Path:       req.URL.Path,
+		RemoteAddr: req.RemoteAddr,
+		UserAgent:  req.UserAgent(),
+	}
+}
+
+// SNSPublisher is the method of *sns.Client used by NewSNSNotifier.
+type SNSPublisher interface {
+	Publish(ctx context.Context, in *sns.PublishInput, opts ...func(*sns.Options)) (*sns.PublishOutput, error)
+}
+
+// NewSNSNotifier returns a Notifier that publishes the events as JSON messages to the SNS topic.
+func NewSNSNotifier(client SNSPublisher, topicARN string) Notifier {
+	return NotifierFunc(func(ctx context.Context, e UploadEvent) error {
+		body, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = client.Publish(ctx, &sns.PublishInput{TopicArn: aws.String(topicARN), Message: aws.String(string(body))})
+		if err != nil {
+			return fmt.Errorf("failed to publish to SNS: %w", err)
+		}
+		return nil
+	})
+}
+
+// SQSSender is the method of *sqs.Client used by NewSQSNotifier.
+type SQSSender interface {
+	SendMessage(ctx context.Context, in *sqs.SendMessageInput, opts ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+}
+
+// NewSQSNotifier returns a Notifier that sends the events as JSON messages to the SQS queue.
+func NewSQSNotifier(client SQSSender, queueURL string) Notifier {
+	return NotifierFunc(func(ctx context.Context, e UploadEvent) error {
+		body, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = client.SendMessage(ctx, &sqs.SendMessageInput{QueueUrl: aws.String(queueURL), MessageBody: aws.String(string(body))})
+		if err != nil {
+			return fmt.Errorf("failed to send to SQS: %w", err)
+		}
+		return nil
+	})
+}
+
+// EventBridgePutter is the method of *eventbridge.Client used by NewEventBridgeNotifier.
+type EventBridgePutter interface {
+	PutEvents(ctx context.Context, in *eventbridge.PutEventsInput, opts ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+}
+
+// NewEventBridgeNotifier returns a Notifier that puts the events in the EventBridge event bus
+// (the default one if bus is empty), with the source and the "mps3.upload" detail type.
+func NewEventBridgeNotifier(client EventBridgePutter, bus, source string) Notifier {
+	return NotifierFunc(func(ctx context.Context, e UploadEvent) error {
+		detail, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		entry := types.PutEventsRequestEntry{
+			Source:     aws.String(source),
+			DetailType: aws.String(e.Event),
+			Detail:     aws.String(string(detail)),
+		}
+		if bus != "" {
+			entry.EventBusName = aws.String(bus)
+		}
+		out, err := client.PutEvents(ctx, &eventbridge.PutEventsInput{Entries: []types.PutEventsRequestEntry{entry}})
+		if err != nil {
+			return fmt.Errorf("failed to put event to EventBridge: %w", err)
+		}
+		// the request succeeds when the entries fail, their errors are in the output
+		if out.FailedEntryCount > 0 {
+			for _, r := range out.Entries {
+				if r.ErrorCode != nil {
+					return fmt.Errorf("failed to put event to EventBridge: %s: %s", aws.ToString(r.ErrorCode), aws.ToString(r.ErrorMessage))
+				}
+			}
+			return fmt.Errorf("failed to put event to EventBridge")
+		}
+		return nil
+	})
+}
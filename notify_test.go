@@ -0,0 +1,173 @@
+package mps3
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifier(t *testing.T) {
+	assert := assert.New(t)
+
+	var events []UploadEvent
+	notifier := NotifierFunc(func(ctx context.Context, e UploadEvent) error {
+		events = append(events, e)
+		return nil
+	})
+	upload := func(cfg Config, status int, content string) {
+		events = nil
+		cfg.Bucket, cfg.Backend, cfg.Notifier = bucket, mps3test.NewBackend(), notifier
+		wrapper, err := New(cfg)
+		assert.NoError(err)
+		req := newFileRequest(t, "a.txt", content)
+		req.Header.Set("X-Request-ID", "req-1")
+		wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(status)
+		})).ServeHTTP(httptest.NewRecorder(), req)
+		assert.NoError(wrapper.Close(context.Background()))
+	}
+
+	upload(Config{}, http.StatusOK, "hello")
+	assert.Len(events, 1)
+	assert.Equal("mps3.upload", events[0].Event)
+	assert.Equal("file", events[0].Field)
+	assert.Equal("a.txt", events[0].Name)
+	assert.Equal(bucket, events[0].Bucket)
+	assert.NotEmpty(events[0].Key)
+	assert.EqualValues(5, events[0].Size)
+	assert.Equal(EventRequest{ID: "req-1", Method: http.MethodPost, Path: "/", RemoteAddr: "192.0.2.1:1234"}, events[0].Request)
+
+	// only the files of requests that succeeded, once they're committed
+	upload(Config{MaxFileSize: 2}, http.StatusOK, "hello")
+	assert.Empty(events)
+	upload(Config{StagingPrefix: "staging/"}, http.StatusBadRequest, "hello")
+	assert.Empty(events)
+	upload(Config{StagingPrefix: "staging/"}, http.StatusOK, "hello")
+	assert.Len(events, 1)
+	assert.False(strings.HasPrefix(events[0].Key, "staging/"))
+}
+
+func TestNotifierInBackground(t *testing.T) {
+	assert := assert.New(t)
+
+	started := make(chan struct{}, 2)
+	published := make(chan string, 2)
+	unblock := make(chan struct{})
+	notifier := NotifierFunc(func(ctx context.Context, e UploadEvent) error {
+		started <- struct{}{}
+		select {
+		case <-unblock:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		published <- e.Name
+		return nil
+	})
+	wrapper, err := New(Config{Bucket: bucket, Backend: mps3test.NewBackend(), Notifier: notifier, NotifyWorkers: 1, NotifyQueueSize: 1})
+	assert.NoError(err)
+	upload := func(name string) {
+		res := httptest.NewRecorder()
+		wrapper.Wrap(http.NotFoundHandler()).ServeHTTP(res, newFileRequest(t, name, "hello"))
+		assert.Equal(http.StatusNotFound, res.Code)
+	}
+
+	// the responses don't wait for the events, which are dropped when the queue is full
+	upload("a.txt")
+	<-started
+	upload("b.txt")
+	upload("c.txt")
+	close(unblock)
+	assert.NoError(wrapper.Close(context.Background()))
+	close(published)
+	var names []string
+	for name := range published {
+		names = append(names, name)
+	}
+	assert.Equal([]string{"a.txt", "b.txt"}, names)
+
+	// the events of the requests handled after Close are dropped
+	upload("d.txt")
+
+	// Close cancels the events that are not published in time
+	wrapper, err = New(Config{Bucket: bucket, Backend: mps3test.NewBackend(), Notifier: NotifierFunc(func(ctx context.Context, e UploadEvent) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})})
+	assert.NoError(err)
+	upload("a.txt")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(wrapper.Close(ctx), context.DeadlineExceeded)
+}
+
+type fakeSNS struct{ in *sns.PublishInput }
+
+func (f *fakeSNS) Publish(ctx context.Context, in *sns.PublishInput, opts ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	f.in = in
+	return &sns.PublishOutput{}, nil
+}
+
+type fakeSQS struct{ in *sqs.SendMessageInput }
+
+func (f *fakeSQS) SendMessage(ctx context.Context, in *sqs.SendMessageInput, opts ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	f.in = in
+	return &sqs.SendMessageOutput{}, nil
+}
+
+type fakeEventBridge struct {
+	in  *eventbridge.PutEventsInput
+	out *eventbridge.PutEventsOutput
+}
+
+func (f *fakeEventBridge) PutEvents(ctx context.Context, in *eventbridge.PutEventsInput, opts ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	f.in = in
+	return f.out, nil
+}
+
+func TestAWSNotifiers(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	event := UploadEvent{Event: "mps3.upload", Bucket: bucket, Key: "a.txt", Size: 5}
+	decode := func(s string) UploadEvent {
+		var e UploadEvent
+		assert.NoError(json.Unmarshal([]byte(s), &e))
+		return e
+	}
+
+	topic := &fakeSNS{}
+	assert.NoError(NewSNSNotifier(topic, "arn:aws:sns:us-east-1:123456789012:uploads").Notify(ctx, event))
+	assert.Equal("arn:aws:sns:us-east-1:123456789012:uploads", aws.ToString(topic.in.TopicArn))
+	assert.Equal(event, decode(aws.ToString(topic.in.Message)))
+
+	queue := &fakeSQS{}
+	assert.NoError(NewSQSNotifier(queue, "https://sqs.us-east-1.amazonaws.com/123456789012/uploads").Notify(ctx, event))
+	assert.Equal("https://sqs.us-east-1.amazonaws.com/123456789012/uploads", aws.ToString(queue.in.QueueUrl))
+	assert.Equal(event, decode(aws.ToString(queue.in.MessageBody)))
+
+	bus := &fakeEventBridge{out: &eventbridge.PutEventsOutput{Entries: []ebtypes.PutEventsResultEntry{{EventId: aws.String("1")}}}}
+	notifier := NewEventBridgeNotifier(bus, "uploads", "com.example.uploads")
+	assert.NoError(notifier.Notify(ctx, event))
+	entry := bus.in.Entries[0]
+	assert.Equal("uploads", aws.ToString(entry.EventBusName))
+	assert.Equal("com.example.uploads", aws.ToString(entry.Source))
+	assert.Equal("mps3.upload", aws.ToString(entry.DetailType))
+	assert.Equal(event, decode(aws.ToString(entry.Detail)))
+
+	bus.out = &eventbridge.PutEventsOutput{
+		FailedEntryCount: 1,
+		Entries:          []ebtypes.PutEventsResultEntry{{ErrorCode: aws.String("InternalFailure"), ErrorMessage: aws.String("try again")}},
+	}
+	assert.ErrorContains(notifier.Notify(ctx, event), "InternalFailure: try again")
+}
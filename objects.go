@@ -0,0 +1,218 @@
+package mps3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Delete removes an uploaded file, e.g. when the transaction that stores its key fails. It's not
+// an error if the file doesn't exist. It can't be used with BucketFunc since the bucket of the
+// file depends on the request.
+func (wr Wrapper) Delete(ctx context.Context, key string) error {
+	bucket, err := wr.keyBucket(key)
+	if err != nil {
+		return err
+	}
+	in := &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+	if wr.requestPayer != "" {
+		in.RequestPayer = types.RequestPayer(wr.requestPayer)
+	}
+	if _, err := wr.backend.Delete(ctx, in); err != nil {
+		return fmt.Errorf("failed to delete %q: %w", key, err)
+	}
+	return nil
+}
+
+// DeleteAll removes all the uploaded files, it tries to remove every file even if some fail and
+// returns all the errors.
+func (wr Wrapper) DeleteAll(ctx context.Context, keys []string) error {
+	var errs []error
+	for _, key := range keys {
+		if err := wr.Delete(ctx, key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Move moves an uploaded file to another key with a server side copy, e.g. to promote a file
+// uploaded to a staging prefix after it was validated. The storage class, encryption and object
+// lock settings of the file are kept. It can't be used with BucketFunc or CustomerKeyFunc.
+func (wr Wrapper) Move(ctx context.Context, srcKey, dstKey string) error {
+	srcBucket, err := wr.keyBucket(srcKey)
+	if err != nil {
+		return err
+	}
+	dstBucket, err := wr.keyBucket(dstKey)
+	if err != nil {
+		return err
+	}
+
+	return wr.move(ctx, wr.backend, srcBucket, srcKey, dstBucket, dstKey)
+}
+
+// move copies the object to another location of the backend and deletes the original.
+func (wr Wrapper) move(ctx context.Context, backend Backend, srcBucket, srcKey, dstBucket, dstKey string) error {
+	return wr.moveWith(ctx, backend, srcBucket, srcKey, dstBucket, dstKey, nil)
+}
+
+// moveWith is like move, set if not nil changes the input of the copy.
+func (wr Wrapper) moveWith(ctx context.Context, backend Backend, srcBucket, srcKey, dstBucket, dstKey string, set func(*s3.CopyObjectInput)) error {
+	var payer types.RequestPayer
+	if wr.requestPayer != "" {
+		payer = types.RequestPayer(wr.requestPayer)
+	}
+	head, err := backend.Head(ctx, &s3.HeadObjectInput{Bucket: aws.String(srcBucket), Key: aws.String(srcKey), RequestPayer: payer})
+	if err != nil {
+		return fmt.Errorf("failed to move %q: %w", srcKey, err)
+	}
+
+	// S3 doesn't keep these settings when copying unless they are set again
+	in := &s3.PutObjectInput{
+		Bucket:                    aws.String(srcBucket),
+		Key:                       aws.String(srcKey),
+		StorageClass:              head.StorageClass,
+		ServerSideEncryption:      head.ServerSideEncryption,
+		SSEKMSKeyId:               head.SSEKMSKeyId,
+		ObjectLockMode:            head.ObjectLockMode,
+		ObjectLockRetainUntilDate: head.ObjectLockRetainUntilDate,
+		ObjectLockLegalHoldStatus: head.ObjectLockLegalHoldStatus,
+		ChecksumAlgorithm:         types.ChecksumAlgorithm(wr.checksumAlgo),
+		RequestPayer:              payer,
+	}
+	if !isDirectoryBucket(dstBucket) {
+		in.ACL = types.ObjectCannedACL(wr.fileACL)
+	}
+	cin := copyInput(in, dstBucket, dstKey)
+	if set != nil {
+		set(cin)
+	}
+	if _, err := backend.Copy(ctx, cin); err != nil {
+		return fmt.Errorf("failed to copy %q to %q: %w", srcKey, dstKey, err)
+	}
+
+	din := &s3.DeleteObjectInput{Bucket: in.Bucket, Key: in.Key, RequestPayer: payer}
+	if _, err := backend.Delete(ctx, din); err != nil {
+		return fmt.Errorf("copied %q to %q but failed to delete it: %w", srcKey, dstKey, err)
+	}
+	return nil
+}
+
+// keyBucket returns the bucket of a file uploaded through the middleware, outside of a request.
+func (wr Wrapper) keyBucket(key string) (string, error) {
+	if wr.bucketFunc != nil {
+		return "", fmt.Errorf("the bucket of %q is unknown, it's chosen per request by BucketFunc", key)
+	}
+	return wr.bucketFor(nil, key)
+}
+
+// Lister is implemented by backends that can list the stored files.
+type Lister interface {
+	// List returns a page of the objects of in.Bucket whose key starts with in.Prefix, sorted by key.
+	List(ctx context.Context, in *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error)
+}
+
+// ListOptions configures Wrapper.List.
+type ListOptions struct {
+	// Bucket to list, required when files are stored in several buckets (default: Config.Bucket)
+	Bucket string
+
+	// Limit is the maximum number of files returned (default: 1000, which is also the maximum)
+	Limit int32
+
+	// Token is the ListResult.NextToken of the previous page, empty for the first page
+	Token string
+
+	// Metadata if true the metadata of each file is also returned, it costs a HEAD request per file
+	Metadata bool
+}
+
+// ObjectSummary describes a stored file.
+type ObjectSummary struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+
+	// Metadata is only set if ListOptions.Metadata is true
+	Metadata map[string]string
+}
+
+// ListResult is a page of files returned by Wrapper.List.
+type ListResult struct {
+	Objects []ObjectSummary
+
+	// NextToken is used to get the next page, it's empty if this is the last page
+	NextToken string
+}
+
+// List returns a page of the files whose key starts with prefix, sorted by key, e.g. to browse the
+// uploads in an admin UI. The backend must implement Lister.
+func (wr Wrapper) List(ctx context.Context, prefix string, opts ListOptions) (ListResult, error) {
+	lister, ok := wr.backend.(Lister)
+	if !ok {
+		return ListResult{}, fmt.Errorf("backend doesn't support listing files")
+	}
+	bucket := opts.Bucket
+	if bucket == "" {
+		bucket = wr.bucket
+	}
+	if bucket == "" || (opts.Bucket == "" && (len(wr.buckets) > 0 || wr.bucketFunc != nil)) {
+		return ListResult{}, fmt.Errorf("ListOptions.Bucket is required when files are stored in several buckets")
+	}
+
+	in := &s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(prefix)}
+	if opts.Limit > 0 {
+		in.MaxKeys = aws.Int32(opts.Limit)
+	}
+	if opts.Token != "" {
+		in.ContinuationToken = aws.String(opts.Token)
+	}
+	if wr.requestPayer != "" {
+		in.RequestPayer = types.RequestPayer(wr.requestPayer)
+	}
+	out, err := lister.List(ctx, in)
+	if err != nil {
+		return ListResult{}, fmt.Errorf("failed to list %q: %w", prefix, err)
+	}
+
+	res := ListResult{Objects: make([]ObjectSummary, 0, len(out.Contents))}
+	if aws.ToBool(out.IsTruncated) {
+		res.NextToken = aws.ToString(out.NextContinuationToken)
+	}
+	for _, o := range out.Contents {
+		obj := ObjectSummary{
+			Key:          aws.ToString(o.Key),
+			Size:         aws.ToInt64(o.Size),
+			LastModified: aws.ToTime(o.LastModified),
+			ETag:         aws.ToString(o.ETag),
+		}
+		if opts.Metadata {
+			head, err := wr.backend.Head(ctx, &s3.HeadObjectInput{Bucket: in.Bucket, Key: o.Key, RequestPayer: in.RequestPayer})
+			if err != nil {
+				return ListResult{}, fmt.Errorf("failed to get metadata of %q: %w", obj.Key, err)
+			}
+			obj.Metadata = head.Metadata
+		}
+		res.Objects = append(res.Objects, obj)
+	}
+	return res, nil
+}
+
+func (b *s3Backend) List(ctx context.Context, in *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	return b.client.ListObjectsV2(ctx, in)
+}
+
+func (b *replicatedBackend) List(ctx context.Context, in *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	l, ok := b.primary.(Lister)
+	if !ok {
+		return nil, fmt.Errorf("backend doesn't support listing files")
+	}
+	return l.List(ctx, in)
+}
@@ -0,0 +1,96 @@
+package mps3
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelete(t *testing.T) {
+	assert := assert.New(t)
+
+	backend, form, res := uploadToMemory(t, Config{Buckets: []string{"a", "b", "c"}}, nil, "test_file1.png", "test_file2.txt")
+	assert.Equal(200, res.Code)
+	assert.Len(backend.Objects(), 2)
+
+	wrapper, err := New(Config{Buckets: []string{"a", "b", "c"}, Backend: backend})
+	assert.NoError(err)
+	assert.NoError(wrapper.Delete(context.Background(), form["file"][0]))
+	assert.Len(backend.Objects(), 1)
+
+	assert.NoError(wrapper.DeleteAll(context.Background(), append(form["file"], "missing")))
+	assert.Empty(backend.Objects())
+
+	wrapper, err = New(Config{Bucket: bucket, Backend: failingBackend{}})
+	assert.NoError(err)
+	err = wrapper.DeleteAll(context.Background(), []string{"one", "two"})
+	assert.ErrorContains(err, `"one"`)
+	assert.ErrorContains(err, `"two"`)
+
+	wrapper, err = New(Config{
+		Backend:    backend,
+		BucketFunc: func(*http.Request) (string, error) { return "tenant", nil },
+	})
+	assert.NoError(err)
+	assert.Error(wrapper.Delete(context.Background(), "key"))
+}
+
+func TestMove(t *testing.T) {
+	assert := assert.New(t)
+
+	backend, form, res := uploadToMemory(t, Config{FileACL: "public-read"}, nil, "test_file2.txt")
+	assert.Equal(200, res.Code)
+	src := form.Get("file")
+
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, FileACL: "public-read"})
+	assert.NoError(err)
+	assert.NoError(wrapper.Move(context.Background(), src, "/final/file.txt"))
+
+	_, ok := backend.Object(bucket, src)
+	assert.False(ok)
+	obj, ok := backend.Object(bucket, "/final/file.txt")
+	assert.True(ok)
+	content, _ := os.ReadFile("test_file2.txt")
+	assert.Equal(content, obj.Body)
+	assert.Equal("text/plain; charset=utf-8", *obj.Input.ContentType)
+
+	assert.Error(wrapper.Move(context.Background(), "missing", "/final/missing"))
+}
+
+func TestList(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := Config{MetadataFunc: func(*http.Request, string) map[string]string {
+		return map[string]string{"user": "1"}
+	}}
+	backend, form, res := uploadToMemory(t, cfg, nil, "test_file1.png", "test_file2.txt")
+	assert.Equal(200, res.Code)
+	keys := slices.Clone(form["file"])
+	slices.Sort(keys)
+
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend})
+	assert.NoError(err)
+
+	page, err := wrapper.List(context.Background(), "/", ListOptions{Limit: 1, Metadata: true})
+	assert.NoError(err)
+	assert.Len(page.Objects, 1)
+	assert.Equal(keys[0], page.Objects[0].Key)
+	assert.Equal(map[string]string{"user": "1"}, page.Objects[0].Metadata)
+	assert.NotEmpty(page.NextToken)
+
+	page, err = wrapper.List(context.Background(), "/", ListOptions{Limit: 1, Token: page.NextToken})
+	assert.NoError(err)
+	assert.Len(page.Objects, 1)
+	assert.Equal(keys[1], page.Objects[0].Key)
+	assert.Nil(page.Objects[0].Metadata)
+	assert.Empty(page.NextToken)
+
+	wrapper, err = New(Config{Buckets: []string{"a", "b"}, Backend: backend})
+	assert.NoError(err)
+	_, err = wrapper.List(context.Background(), "/", ListOptions{})
+	assert.Error(err)
+}
@@ -0,0 +1,39 @@
+package mps3
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+type failedKey struct{}
+
+// FileErrorsFromContext returns the files that failed to upload in the order they were sent, with
+// Config.ContinueOnError. Use it with the request context:
+//
+//	for _, ferr := range mps3.FileErrorsFromContext(req.Context()) {
+//		log.Printf("%s failed: %v (%d)", ferr.Name, ferr.Err, mps3.StatusCode(ferr))
+//	}
+func FileErrorsFromContext(ctx context.Context) []*FileError {
+	failed, _ := ctx.Value(failedKey{}).([]*FileError)
+	return failed
+}
+
+// skipFailed records the error of a file that failed to upload and returns true if the request
+// can continue, see Config.ContinueOnError.
+func (wr Wrapper) skipFailed(req *http.Request, err error, res *result) bool {
+	var ferr *FileError
+	if !wr.partial || !errors.As(err, &ferr) || errors.Is(err, ErrMalformedMultipart) {
+		return false
+	}
+	// the rest of the body can't be read if the client went away
+	if body, ok := req.Body.(*clientBody); ok && body.err != nil {
+		return false
+	}
+
+	wr.reportError(req, err, ErrorInfo{Skipped: true})
+	res.failed = append(res.failed, ferr)
+	name := ferr.Field + wr.suffixes.Error
+	res.form[name] = append(res.form[name], ferr.Err.Error())
+	return true
+}
@@ -0,0 +1,62 @@
+package mps3
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContinueOnError(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, MaxFileSize: 1000, ContinueOnError: true})
+	assert.NoError(err)
+
+	// the png is too large, the text file is uploaded
+	req, err := newRequest(map[string]string{"title": "files"}, "test_file1.png", "test_file2.txt")
+	assert.NoError(err)
+	res := httptest.NewRecorder()
+	called := false
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		called = true
+		assert.Equal("files", req.FormValue("title"))
+		assert.Equal([]string{"test_file2.txt"}, req.Form["file_name"])
+		assert.Len(req.Form["file_error"], 1)
+		assert.Contains(req.FormValue("file_error"), ErrTooLarge.Error())
+
+		failed := FileErrorsFromContext(req.Context())
+		assert.Len(failed, 1)
+		assert.Equal("test_file1.png", failed[0].Name)
+		assert.Equal(http.StatusRequestEntityTooLarge, StatusCode(failed[0]))
+		assert.Len(FilesFromContext(req.Context()), 1)
+	})).ServeHTTP(res, req)
+	assert.True(called)
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Len(backend.Objects(), 1)
+
+	// files rejected by OnUploadComplete are removed
+	wrapper, err = New(Config{Bucket: bucket, Backend: backend, ContinueOnError: true,
+		OnUploadComplete: func(req *http.Request, f UploadedFile, _ time.Duration, err error) error {
+			if f.Name == "test_file1.png" {
+				return errors.New("no images")
+			}
+			return nil
+		}})
+	assert.NoError(err)
+	backend.Reset()
+	req, err = newRequest(nil, "test_file1.png", "test_file2.txt")
+	assert.NoError(err)
+	res = httptest.NewRecorder()
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Contains(req.FormValue("file_error"), "no images")
+		assert.Len(FilesFromContext(req.Context()), 1)
+	})).ServeHTTP(res, req)
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Len(backend.Objects(), 1)
+}
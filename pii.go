@@ -0,0 +1,189 @@
+package mps3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Actions of PIIConfig.Action on personal information.
+const (
+	// PIIReject fails the request with ErrPII.
+	PIIReject = "reject"
+	// PIIFlag reports the kinds of personal information found in the `<field>_pii` form value.
+	PIIFlag = "flag"
+)
+
+// PIIInspector finds personal information, like credit card numbers, in the text fields and files
+// of the requests, e.g. PatternInspector. Inspectors of other services, like DLP APIs, can
+// implement it too.
+type PIIInspector interface {
+	// Inspect reads the text from r and returns the kinds of personal information found, e.g.
+	// "credit_card", or nil if there is none. When inspecting files, reading r fails if the upload
+	// fails.
+	Inspect(ctx context.Context, r io.Reader) ([]string, error)
+}
+
+// PIIConfig inspects the text fields of the requests before they're handled, and the text files
+// while they're uploaded, for personal information.
+type PIIConfig struct {
+	// Inspector finds the personal information (default: DefaultPIIInspector)
+	Inspector PIIInspector
+
+	// Action on the fields and files with personal information, PIIReject or PIIFlag (default:
+	// PIIReject). Flagged files are reported in UploadedFile.PII and the `<field>_pii` form value,
+	// which is empty for the other files. Flagged text fields get the form value only when
+	// something is found.
+	Action string
+
+	// FileTypes are the content types of the files inspected, wildcards like "text/*" match all the
+	// subtypes. Files aren't inspected if it's empty.
+	FileTypes []string
+}
+
+// validate checks the settings and sets the default values.
+func (pc *PIIConfig) validate() error {
+	if pc.Inspector == nil {
+		pc.Inspector = DefaultPIIInspector
+	}
+	switch pc.Action {
+	case "":
+		pc.Action = PIIReject
+	case PIIReject, PIIFlag:
+	default:
+		return fmt.Errorf("invalid PII action %q", pc.Action)
+	}
+	return nil
+}
+
+// inspectField inspects the value of a text field, returning the kinds of personal information
+// that are flagged.
+func (wr Wrapper) inspectField(req *http.Request, name, value string) ([]string, error) {
+	kinds, err := wr.pii.Inspector.Inspect(req.Context(), strings.NewReader(value))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect field %q: %w", name, err)
+	}
+	if len(kinds) > 0 && wr.pii.Action == PIIReject {
+		return nil, fmt.Errorf("%w in field %q: %s", ErrPII, name, strings.Join(kinds, ", "))
+	}
+	return kinds, nil
+}
+
+// startPII inspects the file while body is read through the returned reader, if its content type
+// is one of PIIConfig.FileTypes. The kinds of personal information flagged are set when the tap
+// finishes.
+func (wr Wrapper) startPII(ctx context.Context, filename string, body io.Reader, kinds *[]string) (*tap, io.Reader) {
+	return newTap(body, func(r io.Reader) error {
+		// the type is detected like readFile does, from the same head
+		head, err := readHead(r, wr.sniffSize)
+		if err != nil || !typeAllowed(wr.pii.FileTypes, wr.detectType(head, filename)) {
+			return nil
+		}
+		found, err := wr.pii.Inspector.Inspect(ctx, io.MultiReader(bytes.NewReader(head), r))
+		if err != nil {
+			return fmt.Errorf("failed to inspect file: %w", err)
+		}
+		if len(found) > 0 && wr.pii.Action == PIIReject {
+			return fmt.Errorf("%w: %s", ErrPII, strings.Join(found, ", "))
+		}
+		*kinds = found
+		return nil
+	})
+}
+
+// PIIPattern is a kind of personal information found by PatternInspector.
+type PIIPattern struct {
+	Kind    string
+	Pattern *regexp.Regexp
+	// Valid if set, checks the matches of Pattern, e.g. the check digit of credit card numbers
+	Valid func(match string) bool
+}
+
+// PatternInspector finds personal information with regular expressions. Text is inspected in
+// windows of 64KB, so matches must be shorter than 256 bytes.
+type PatternInspector []PIIPattern
+
+// DefaultPIIInspector finds credit card numbers (with a valid check digit, optionally separated
+// by spaces or dashes) and US social security numbers (formatted as 123-45-6789).
+var DefaultPIIInspector = PatternInspector{
+	{Kind: "credit_card", Pattern: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), Valid: luhn},
+	{Kind: "ssn", Pattern: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), Valid: validSSN},
+}
+
+const (
+	// piiWindow is the size of the text PatternInspector matches at once.
+	piiWindow = 64 << 10
+	// piiOverlap is the end of a window that is matched again with the next one.
+	piiOverlap = 256
+)
+
+// Inspect returns the kinds of the patterns that match the text.
+func (p PatternInspector) Inspect(_ context.Context, r io.Reader) ([]string, error) {
+	var found []string
+	buf := make([]byte, piiWindow+piiOverlap)
+	keep := 0
+	for {
+		n, err := io.ReadFull(r, buf[keep:])
+		eof := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !eof {
+			return nil, err
+		}
+		window := buf[:keep+n]
+		for _, pat := range p {
+			if !slices.Contains(found, pat.Kind) && pat.match(window, eof) {
+				found = append(found, pat.Kind)
+			}
+		}
+		if eof || len(found) == len(p) {
+			return found, nil
+		}
+		keep = min(piiOverlap, len(window))
+		copy(buf, window[len(window)-keep:])
+	}
+}
+
+// match returns true if the pattern has a valid match in the window. Matches at the end of the
+// window may continue in the next one, they're only checked at the end of the text.
+func (p PIIPattern) match(window []byte, eof bool) bool {
+	for _, m := range p.Pattern.FindAllIndex(window, -1) {
+		if m[1] == len(window) && !eof {
+			continue
+		}
+		if p.Valid == nil || p.Valid(string(window[m[0]:m[1]])) {
+			return true
+		}
+	}
+	return false
+}
+
+// luhn returns true if the digits of the number have a valid Luhn check digit.
+func luhn(number string) bool {
+	sum, double := 0, false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// validSSN returns false for the social security numbers that are never assigned.
+func validSSN(ssn string) bool {
+	area, group, serial := ssn[:3], ssn[4:6], ssn[7:]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
@@ -0,0 +1,95 @@
+package mps3
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatternInspector(t *testing.T) {
+	assert := assert.New(t)
+	inspect := func(text string) []string {
+		kinds, err := DefaultPIIInspector.Inspect(context.Background(), strings.NewReader(text))
+		assert.NoError(err)
+		return kinds
+	}
+
+	assert.Empty(inspect("hello world, call 555-1234 on 2024-01-31"))
+	assert.Equal([]string{"credit_card"}, inspect("card: 4111 1111 1111 1111."))
+	assert.Equal([]string{"credit_card"}, inspect("4111-1111-1111-1111"))
+	// invalid check digit
+	assert.Empty(inspect("card: 4111 1111 1111 1112"))
+	assert.Equal([]string{"ssn"}, inspect("ssn 123-45-6789"))
+	assert.Empty(inspect("ssn 000-45-6789, 666-45-6789, 123-00-6789"))
+	assert.ElementsMatch([]string{"credit_card", "ssn"}, inspect("123-45-6789\n5500000000000004"))
+
+	// a number at the end of a window is only matched as a whole
+	prefix := strings.Repeat("a", piiWindow-17) + " "
+	assert.Equal([]string{"credit_card"}, inspect(prefix+"4111111111111111 "))
+	assert.Empty(inspect(prefix + "41111111111111112 "))
+}
+
+func TestPII(t *testing.T) {
+	assert := assert.New(t)
+	backend := mps3test.NewBackend()
+	upload := func(cfg PIIConfig, fields map[string]string, name, content string) (url.Values, int) {
+		wrapper, err := New(Config{Bucket: bucket, Backend: backend, PII: &cfg})
+		assert.NoError(err)
+		buf := &bytes.Buffer{}
+		writer := multipart.NewWriter(buf)
+		for k, v := range fields {
+			_ = writer.WriteField(k, v)
+		}
+		part, _ := writer.CreateFormFile("file", name)
+		_, _ = part.Write([]byte(content))
+		_ = writer.Close()
+		req := httptest.NewRequest(http.MethodPost, "/", buf)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		var form url.Values
+		res := httptest.NewRecorder()
+		wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			form = req.Form
+		})).ServeHTTP(res, req)
+		return form, res.Code
+	}
+
+	// text fields are inspected before the files are stored
+	_, code := upload(PIIConfig{}, map[string]string{"comment": "my card is 4111111111111111"}, "a.txt", "hello")
+	assert.Equal(http.StatusUnprocessableEntity, code)
+	assert.Empty(backend.Objects())
+
+	form, code := upload(PIIConfig{Action: PIIFlag}, map[string]string{"comment": "my ssn is 123-45-6789"}, "a.txt", "hello")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal("ssn", form.Get("comment_pii"))
+	_, ok := form["file_pii"]
+	assert.False(ok)
+
+	// files of FileTypes
+	backend.Reset()
+	cfg := PIIConfig{FileTypes: []string{"text/csv"}}
+	_, code = upload(cfg, nil, "cards.csv", "name,card\nalice,4111111111111111\n")
+	assert.Equal(http.StatusUnprocessableEntity, code)
+	assert.Empty(backend.Objects())
+	_, code = upload(cfg, nil, "cards.txt", "name,card\nalice,4111111111111111\n")
+	assert.Equal(http.StatusOK, code)
+	assert.Len(backend.Objects(), 1)
+
+	cfg.Action = PIIFlag
+	form, code = upload(cfg, nil, "cards.csv", "name,card\nalice,4111111111111111\n")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal("credit_card", form.Get("file_pii"))
+	form, code = upload(cfg, nil, "names.csv", "name\nalice\n")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal([]string{""}, form["file_pii"])
+
+	_, err := New(Config{Bucket: bucket, Backend: backend, PII: &PIIConfig{Action: "delete"}})
+	assert.Error(err)
+}
@@ -0,0 +1,148 @@
+package mps3
+
+import (
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+)
+
+// pipeline uploads the files of a multipart request concurrently, see Config.ConcurrentUploads.
+// The parts are still read in order: once the body of a file was read, the next parts are read
+// while its upload completes.
+type pipeline struct {
+	slots chan struct{}
+	jobs  []*pipelineJob
+}
+
+// pipelineJob is a file being uploaded, with its own result that is added to the result of the
+// request once all the files finished.
+type pipelineJob struct {
+	res  *result
+	err  error
+	done chan struct{}
+}
+
+func newPipeline(size int) *pipeline {
+	return &pipeline{slots: make(chan struct{}, size)}
+}
+
+// store uploads a file, concurrently with the next parts if Config.ConcurrentUploads is set.
+func (wr Wrapper) store(req *http.Request, part *multipart.Part, body io.Reader, res *result) error {
+	p := res.pipeline
+	if p == nil {
+		return wr.storeFile(req, part, body, res)
+	}
+
+	p.slots <- struct{}{}
+	job := &pipelineJob{res: res.fork(), done: make(chan struct{})}
+	read := make(chan struct{})
+	body = &eofReader{r: body, eof: read}
+	go func() {
+		defer func() {
+			<-p.slots
+			close(job.done)
+		}()
+		job.err = wr.storeFile(req, part, body, job.res)
+	}()
+
+	select {
+	case <-read:
+	case <-job.done:
+	}
+	select {
+	case <-job.done:
+		// the file failed before its body was read, like when uploading it sequentially
+		if job.err != nil {
+			return job.err
+		}
+	default:
+	}
+	p.jobs = append(p.jobs, job)
+	return nil
+}
+
+// failed returns true if a file failed and the request can't continue, see
+// Config.ContinueOnError.
+func (p *pipeline) failed(partial bool) bool {
+	if p == nil || partial {
+		return false
+	}
+	for _, job := range p.jobs {
+		select {
+		case <-job.done:
+			if job.err != nil {
+				return true
+			}
+		default:
+		}
+	}
+	return false
+}
+
+// wait waits for the files being uploaded and adds them to the result in the order of the parts.
+// It returns the error of the first file that failed, unless it was skipped (see
+// Config.ContinueOnError), otherwise err, the error reading the parts.
+func (p *pipeline) wait(wr Wrapper, req *http.Request, res *result, err error) error {
+	if p == nil {
+		return err
+	}
+	var failed error
+	for _, job := range p.jobs {
+		<-job.done
+		if job.err != nil {
+			if failed == nil && !wr.skipFailed(req, job.err, res) {
+				failed = job.err
+			}
+			continue
+		}
+		for _, uf := range job.res.uploaded {
+			res.uploaded = append(res.uploaded, uf)
+			if aerr := wr.appendFile(res.form, uf); aerr != nil && failed == nil {
+				failed = aerr
+			}
+		}
+		for name, fhs := range job.res.stubs {
+			res.stubs[name] = append(res.stubs[name], fhs...)
+		}
+	}
+	p.jobs = nil
+	if failed != nil {
+		return failed
+	}
+	return err
+}
+
+// fork returns the result of a file uploaded concurrently, with a copy of the form values read so
+// far.
+func (res *result) fork() *result {
+	form := make(url.Values, len(res.form))
+	for k, v := range res.form {
+		form[k] = slices.Clone(v)
+	}
+	return &result{
+		form:        form,
+		inline:      make(map[string][]*multipart.FileHeader),
+		stubs:       make(map[string][]*multipart.FileHeader),
+		deadlines:   res.deadlines,
+		spamChecked: res.spamChecked,
+		fields:      res.fields,
+	}
+}
+
+// eofReader closes eof once the reader returns an error, usually io.EOF.
+type eofReader struct {
+	r    io.Reader
+	eof  chan struct{}
+	once sync.Once
+}
+
+func (er *eofReader) Read(p []byte) (int, error) {
+	n, err := er.r.Read(p)
+	if err != nil {
+		er.once.Do(func() { close(er.eof) })
+	}
+	return n, err
+}
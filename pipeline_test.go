@@ -0,0 +1,76 @@
+package mps3
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+// overlapBackend records how many uploads complete at the same time after their body was read.
+type overlapBackend struct {
+	*mps3test.Backend
+	mu      sync.Mutex
+	active  int
+	overlap int
+}
+
+func (b *overlapBackend) Upload(ctx context.Context, in *s3.PutObjectInput) (*manager.UploadOutput, error) {
+	body, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	b.active++
+	b.overlap = max(b.overlap, b.active)
+	b.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	b.mu.Lock()
+	b.active--
+	b.mu.Unlock()
+	in.Body = bytes.NewReader(body)
+	return b.Backend.Upload(ctx, in)
+}
+
+func TestConcurrentUploads(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := &overlapBackend{Backend: mps3test.NewBackend()}
+	cfg := Config{Backend: backend, ConcurrentUploads: 2}
+	files := []string{"test_file1.png", "test_file2.txt", "test_file1.png"}
+	_, form, res := uploadToMemory(t, cfg, map[string]string{"title": "hi"}, files...)
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(2, backend.overlap)
+	assert.Equal("hi", form.Get("title"))
+	assert.Equal(files, form["file_name"])
+	assert.Len(form["file"], 3)
+	for i, key := range form["file"] {
+		obj, ok := backend.Object(bucket, key)
+		assert.True(ok)
+		assert.Equal(form["file_size"][i], strconv.Itoa(len(obj.Body)))
+	}
+
+	// a file that fails fails the request and the other files are removed
+	backend = &overlapBackend{Backend: mps3test.NewBackend()}
+	cfg = Config{Backend: backend, ConcurrentUploads: 2, AllowedTypes: []string{"image/png"}}
+	_, _, res = uploadToMemory(t, cfg, nil, files...)
+	assert.Equal(http.StatusUnsupportedMediaType, res.Code)
+	assert.Empty(backend.Objects())
+
+	// unless the request continues on errors
+	cfg.ContinueOnError = true
+	_, form, res = uploadToMemory(t, cfg, nil, files...)
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal([]string{"test_file1.png", "test_file1.png"}, form["file_name"])
+	assert.Len(form["file_error"], 1)
+	assert.Len(backend.Objects(), 2)
+}
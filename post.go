@@ -0,0 +1,227 @@
+package mps3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// PostPresigner is implemented by backends that can issue presigned POST policies, so browsers
+// can upload files directly to the bucket.
+type PostPresigner interface {
+	// PresignPost returns the URL and the form fields of a POST upload of in.Bucket and in.Key that
+	// is valid for the given duration, restricted by the policy conditions.
+	PresignPost(ctx context.Context, in *s3.PutObjectInput, expires time.Duration, conditions []any) (string, map[string]string, error)
+}
+
+func (b *s3Backend) PresignPost(ctx context.Context, in *s3.PutObjectInput, expires time.Duration, conditions []any) (string, map[string]string, error) {
+	req, err := s3.NewPresignClient(b.client).PresignPostObject(ctx, in, func(o *s3.PresignPostOptions) {
+		o.Expires = expires
+		o.Conditions = conditions
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return req.URL, req.Values, nil
+}
+
+// PostPolicy configures the presigned POST policies issued by PostPolicyHandler. The files must
+// also pass the checks of the Config (MaxFileSize, AllowedTypes, BlockedExtensions and the
+// FieldConfigs of Field), the options of PostPolicy can only restrict them further.
+type PostPolicy struct {
+	// Expires is how long the policy is valid (default: 15 minutes)
+	Expires time.Duration
+
+	// Field is the field of Config.FieldConfigs whose limits, Bucket, Prefix and ACL apply to the
+	// files (default: "file")
+	Field string
+
+	// MaxSize is the maximum size of the uploaded file in bytes, enforced by S3 (default: the
+	// limit of the Config)
+	MaxSize int64
+
+	// ContentTypes if set, only files of these content types can be uploaded. The content type
+	// is the one sent by the client or detected from the file name, S3 doesn't inspect the content.
+	ContentTypes []string
+}
+
+// allowed returns true if files of the content type can be uploaded.
+func (p PostPolicy) allowed(ftype string) bool {
+	return typeAllowed(p.ContentTypes, ftype)
+}
+
+// postMaxSize returns the maximum size of the files uploaded with the policy, the smallest of the
+// limits of the policy and of the field, zero if there's no limit.
+func (wr Wrapper) postMaxSize(p PostPolicy) int64 {
+	limit := wr.sizeLimit(p.Field)
+	if p.MaxSize > 0 && (limit <= 0 || p.MaxSize < limit) {
+		limit = p.MaxSize
+	}
+	return limit
+}
+
+// checkPost returns an error if the file can't be uploaded with the policy, size is negative if
+// it's not known. Executable content can't be detected since the file isn't sent through the
+// middleware.
+func (wr Wrapper) checkPost(p PostPolicy, name, ftype string, size int64) error {
+	if !p.allowed(ftype) {
+		return fmt.Errorf("%w: content type %q is not allowed", ErrUnsupportedType, ftype)
+	}
+	if err := wr.checkType(p.Field, ftype); err != nil {
+		return err
+	}
+	if err := wr.checkBlocked(name, nil); err != nil {
+		return err
+	}
+	if limit := wr.postMaxSize(p); limit > 0 && size > limit {
+		return fmt.Errorf("%w: file is larger than %d bytes", ErrTooLarge, limit)
+	}
+	return nil
+}
+
+// PostPolicyResponse is the JSON response of PostPolicyHandler. The browser uploads the file
+// sending a multipart POST request to URL with all the Fields followed by the "file" field.
+type PostPolicyResponse struct {
+	URL    string            `json:"url"`
+	Fields map[string]string `json:"fields"`
+	Bucket string            `json:"bucket"`
+	Key    string            `json:"key"`
+}
+
+// PostPolicyHandler returns a handler that issues presigned POST policies for direct browser
+// uploads. The request must send the "filename" and optionally the "content_type" of the file as
+// query or form values, the name is sanitized and the key and bucket are chosen like the ones of
+// files uploaded through the middleware. The backend must implement PostPresigner.
+//
+// After the browser uploads the file, use VerifyUpload to make sure it matches the policy.
+func (wr Wrapper) PostPolicyHandler(p PostPolicy) http.Handler {
+	if p.Expires <= 0 {
+		p.Expires = 15 * time.Minute
+	}
+	if p.Field == "" {
+		p.Field = "file"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := wr.checkAuthorized(req); err != nil {
+			wr.handleError(w, req, err)
+			return
+		}
+		presigner, ok := wr.backend.(PostPresigner)
+		if !ok {
+			wr.handleError(w, req, fmt.Errorf("backend doesn't support presigned POST policies"))
+			return
+		}
+		if wr.encryption != nil {
+			// the files would be stored as they're sent by the clients
+			wr.handleError(w, req, fmt.Errorf("presigned POST policies can't be used with Encryption"))
+			return
+		}
+
+		filename := req.FormValue("filename")
+		if filename == "" {
+			http.Error(w, "filename is required", http.StatusBadRequest)
+			return
+		}
+		filename = truncateFilename(wr.sanitize(filename), wr.maxNameLen)
+		ftype := req.FormValue("content_type")
+		if ftype == "" {
+			ftype = wr.detectType(nil, filename)
+		}
+		if err := wr.checkPost(p, filename, ftype, -1); err != nil {
+			wr.handleError(w, req, &FileError{Field: p.Field, Name: filename, Err: err})
+			return
+		}
+
+		f := file{name: filename, ftype: ftype}
+		if err := wr.place(req, p.Field, &f); err != nil {
+			wr.handleError(w, req, err)
+			return
+		}
+
+		in := &s3.PutObjectInput{Bucket: aws.String(f.bucket), Key: aws.String(f.key)}
+		fields, conditions := wr.postFields(f, p)
+		url, values, err := presigner.PresignPost(req.Context(), in, p.Expires, conditions)
+		if err != nil {
+			wr.handleError(w, req, fmt.Errorf("failed to presign POST policy: %w", err))
+			return
+		}
+		for k, v := range values {
+			fields[k] = v
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(PostPolicyResponse{URL: url, Fields: fields, Bucket: f.bucket, Key: f.key})
+		if err != nil {
+			wr.log(req).ErrorContext(req.Context(), "failed to write POST policy", "error", err)
+		}
+	})
+}
+
+// postFields returns the form fields the browser must send and the policy conditions enforcing them.
+func (wr Wrapper) postFields(f file, p PostPolicy) (map[string]string, []any) {
+	fields := map[string]string{"Content-Type": f.ftype}
+	if !isDirectoryBucket(f.bucket) {
+		fields["acl"] = wr.fileACL
+		if acl := wr.fieldCfgs[p.Field].ACL; acl != "" {
+			fields["acl"] = acl
+		}
+	}
+	if wr.disposition != "" {
+		fields["Content-Disposition"] = contentDisposition(wr.disposition, f.name)
+	}
+	if wr.cacheControl != "" {
+		fields["Cache-Control"] = wr.cacheControl
+	}
+	if wr.sse != "" {
+		fields["x-amz-server-side-encryption"] = wr.sse
+	}
+	if wr.kmsKeyID != "" {
+		fields["x-amz-server-side-encryption-aws-kms-key-id"] = wr.kmsKeyID
+	}
+	if wr.storageClass != "" {
+		fields["x-amz-storage-class"] = wr.storageClass
+	}
+
+	var conditions []any
+	for k, v := range fields {
+		conditions = append(conditions, map[string]string{k: v})
+	}
+	if limit := wr.postMaxSize(p); limit > 0 {
+		conditions = append(conditions, []any{"content-length-range", 0, limit})
+	}
+	return fields, conditions
+}
+
+// VerifyUpload checks that a file uploaded directly to the bucket with a presigned POST policy
+// exists and matches the policy and the limits of the Config, otherwise the file is deleted and
+// an error is returned. The blocked extensions are checked against the key.
+func (wr Wrapper) VerifyUpload(ctx context.Context, p PostPolicy, bucket, key string) (*s3.HeadObjectOutput, error) {
+	if p.Field == "" {
+		p.Field = "file"
+	}
+	in := &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+	if wr.requestPayer != "" {
+		in.RequestPayer = types.RequestPayer(wr.requestPayer)
+	}
+	out, err := wr.backend.Head(ctx, in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify %q: %w", key, err)
+	}
+
+	problem := wr.checkPost(p, key, aws.ToString(out.ContentType), aws.ToInt64(out.ContentLength))
+	if problem == nil {
+		return out, nil
+	}
+
+	din := &s3.DeleteObjectInput{Bucket: in.Bucket, Key: in.Key, RequestPayer: in.RequestPayer}
+	if _, err := wr.backend.Delete(ctx, din); err != nil {
+		wr.logger.ErrorContext(ctx, "failed to delete file", "key", key, "error", err)
+	}
+	return nil, fmt.Errorf("invalid upload %q: %w", key, problem)
+}
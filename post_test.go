@@ -0,0 +1,190 @@
+package mps3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostPolicyHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	wrapper, err := New(Config{Bucket: bucket, Backend: mps3test.NewBackend(), FileACL: "public-read"})
+	assert.NoError(err)
+	handler := wrapper.PostPolicyHandler(PostPolicy{MaxSize: 1024, ContentTypes: []string{"image/png"}})
+
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest("GET", "/?filename=photo.png", nil))
+	assert.Equal(200, res.Code)
+
+	var policy PostPolicyResponse
+	assert.NoError(json.NewDecoder(res.Body).Decode(&policy))
+	assert.Equal("mem://"+bucket, policy.URL)
+	assert.Equal(bucket, policy.Bucket)
+	assert.Equal(policy.Key, policy.Fields["key"])
+	assert.Equal("image/png", policy.Fields["Content-Type"])
+	assert.Equal("public-read", policy.Fields["acl"])
+	assert.Contains(policy.Fields["policy"], `["content-length-range",0,1024]`)
+	assert.Contains(policy.Fields["policy"], `{"Content-Type":"image/png"}`)
+
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest("GET", "/?filename=notes.txt", nil))
+	assert.Equal(http.StatusUnsupportedMediaType, res.Code)
+
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(400, res.Code)
+
+	// the name is sanitized like the ones of the files sent to the middleware
+	wrapper, err = New(Config{Bucket: bucket, Backend: mps3test.NewBackend(), ContentDisposition: "attachment"})
+	assert.NoError(err)
+	res = httptest.NewRecorder()
+	wrapper.PostPolicyHandler(PostPolicy{}).ServeHTTP(res, httptest.NewRequest("GET", "/?filename="+url.QueryEscape("../../a\"b\r\n.png"), nil))
+	assert.Equal(200, res.Code)
+	assert.NoError(json.NewDecoder(res.Body).Decode(&policy))
+	assert.Equal("attachment; filename=a_b.png", policy.Fields["Content-Disposition"])
+	assert.NotContains(policy.Key, "..")
+
+	// the bucket, prefix and ACL of the field apply
+	wrapper, err = New(Config{
+		Bucket:       bucket,
+		Backend:      mps3test.NewBackend(),
+		FieldConfigs: map[string]FieldConfig{"avatar": {Bucket: "avatars", Prefix: "users/", ACL: "public-read"}},
+	})
+	assert.NoError(err)
+	res = httptest.NewRecorder()
+	wrapper.PostPolicyHandler(PostPolicy{Field: "avatar"}).ServeHTTP(res, httptest.NewRequest("GET", "/?filename=photo.png", nil))
+	assert.Equal(200, res.Code)
+	assert.NoError(json.NewDecoder(res.Body).Decode(&policy))
+	assert.Equal("avatars", policy.Bucket)
+	assert.True(strings.HasPrefix(policy.Key, "users/"))
+	assert.Equal("public-read", policy.Fields["acl"])
+}
+
+func TestPostPolicyAuthorize(t *testing.T) {
+	assert := assert.New(t)
+
+	wrapper, err := New(Config{Bucket: bucket, Backend: mps3test.NewBackend(), Authorize: func(req *http.Request) error {
+		if req.Header.Get("Authorization") == "" {
+			return errors.New("missing credentials")
+		}
+		return nil
+	}})
+	assert.NoError(err)
+	policy := func(auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/?filename=photo.png", nil)
+		req.Header.Set("Authorization", auth)
+		res := httptest.NewRecorder()
+		wrapper.PostPolicyHandler(PostPolicy{}).ServeHTTP(res, req)
+		return res
+	}
+
+	res := policy("")
+	assert.Equal(http.StatusUnauthorized, res.Code)
+	assert.NotContains(res.Body.String(), "policy")
+	assert.Equal(200, policy("Bearer token").Code)
+}
+
+func TestPostPolicyValidation(t *testing.T) {
+	assert := assert.New(t)
+
+	wrapper, err := New(Config{
+		Bucket:            bucket,
+		Backend:           mps3test.NewBackend(),
+		MaxFileSize:       2048,
+		AllowedTypes:      []string{"image/*", "application/octet-stream"},
+		BlockedExtensions: []string{".exe"},
+		FieldConfigs:      map[string]FieldConfig{"avatar": {MaxSize: 512, AllowedTypes: []string{"image/png"}}},
+	})
+	assert.NoError(err)
+	policy := func(p PostPolicy, query string) (int, string) {
+		res := httptest.NewRecorder()
+		wrapper.PostPolicyHandler(p).ServeHTTP(res, httptest.NewRequest("GET", "/?"+query, nil))
+		return res.Code, res.Body.String()
+	}
+
+	// the limits of the config apply
+	code, body := policy(PostPolicy{}, "filename=photo.jpg")
+	assert.Equal(200, code)
+	assert.Contains(body, `[\"content-length-range\",0,2048]`)
+	code, _ = policy(PostPolicy{}, "filename=payload.exe")
+	assert.Equal(http.StatusUnsupportedMediaType, code)
+	code, _ = policy(PostPolicy{}, "filename=notes.txt")
+	assert.Equal(http.StatusUnsupportedMediaType, code)
+
+	// the ones of the field and the policy restrict them further
+	code, _ = policy(PostPolicy{Field: "avatar"}, "filename=photo.jpg")
+	assert.Equal(http.StatusUnsupportedMediaType, code)
+	code, body = policy(PostPolicy{Field: "avatar"}, "filename=photo.png")
+	assert.Equal(200, code)
+	assert.Contains(body, `[\"content-length-range\",0,512]`)
+	code, body = policy(PostPolicy{MaxSize: 100}, "filename=photo.png")
+	assert.Equal(200, code)
+	assert.Contains(body, `[\"content-length-range\",0,100]`)
+	code, body = policy(PostPolicy{MaxSize: 1 << 20}, "filename=photo.png")
+	assert.Equal(200, code)
+	assert.Contains(body, `[\"content-length-range\",0,2048]`)
+
+	upload := func(key, ftype string, size int) error {
+		_, err := wrapper.backend.Upload(context.Background(), &s3.PutObjectInput{
+			Bucket:      aws.String(bucket),
+			Key:         aws.String(key),
+			ContentType: aws.String(ftype),
+			Body:        strings.NewReader(strings.Repeat("a", size)),
+		})
+		assert.NoError(err)
+		_, err = wrapper.VerifyUpload(context.Background(), PostPolicy{}, bucket, key)
+		return err
+	}
+	assert.NoError(upload("photo.png", "image/png", 100))
+	assert.ErrorIs(upload("large.png", "image/png", 4096), ErrTooLarge)
+	assert.ErrorIs(upload("notes.txt", "text/plain", 10), ErrUnsupportedType)
+	assert.ErrorIs(upload("payload.exe", "application/octet-stream", 10), ErrUnsupportedType)
+}
+
+func TestVerifyUpload(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend})
+	assert.NoError(err)
+	policy := PostPolicy{MaxSize: 5, ContentTypes: []string{"text/plain"}}
+
+	upload := func(key, ftype, content string) {
+		_, err := backend.Upload(context.Background(), &s3.PutObjectInput{
+			Bucket:      aws.String(bucket),
+			Key:         aws.String(key),
+			ContentType: aws.String(ftype),
+			Body:        strings.NewReader(content),
+		})
+		assert.NoError(err)
+	}
+
+	upload("ok", "text/plain; charset=utf-8", "hello")
+	out, err := wrapper.VerifyUpload(context.Background(), policy, bucket, "ok")
+	assert.NoError(err)
+	assert.Equal(int64(5), aws.ToInt64(out.ContentLength))
+
+	upload("large", "text/plain", "hello world")
+	_, err = wrapper.VerifyUpload(context.Background(), policy, bucket, "large")
+	assert.ErrorContains(err, "larger than 5 bytes")
+	_, ok := backend.Object(bucket, "large")
+	assert.False(ok)
+
+	upload("html", "text/html", "<b/>")
+	_, err = wrapper.VerifyUpload(context.Background(), policy, bucket, "html")
+	assert.ErrorContains(err, "not allowed")
+
+	_, err = wrapper.VerifyUpload(context.Background(), policy, bucket, "missing")
+	assert.Error(err)
+}
@@ -0,0 +1,59 @@
+package mps3
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// NewR2Config returns a configuration for Cloudflare R2 to use as Config.S3Config. The access keys
+// are the ones of an R2 API token. Public buckets can be used with Config.PublicURL.
+func NewR2Config(accountID, accessKeyID, secretAccessKey string) (*aws.Config, error) {
+	if accountID == "" {
+		return nil, fmt.Errorf("R2 account ID is required")
+	}
+	return newEndpointConfig(fmt.Sprintf("https://%s.r2.cloudflarestorage.com", accountID), "auto", accessKeyID, secretAccessKey)
+}
+
+// NewSpacesConfig returns a configuration for DigitalOcean Spaces in the given region (e.g. "nyc3")
+// to use as Config.S3Config. A Spaces CDN endpoint can be used with Config.PublicURL.
+func NewSpacesConfig(region, accessKeyID, secretAccessKey string) (*aws.Config, error) {
+	if region == "" {
+		return nil, fmt.Errorf("spaces region is required")
+	}
+	return newEndpointConfig(fmt.Sprintf("https://%s.digitaloceanspaces.com", region), region, accessKeyID, secretAccessKey)
+}
+
+// newEndpointConfig returns a configuration with static credentials for an S3 compatible API,
+// requests use path-style addressing with the bucket name in the URL path.
+func newEndpointConfig(url, region, accessKeyID, secretAccessKey string) (*aws.Config, error) {
+	resolver := aws.EndpointResolverWithOptionsFunc(func(service, _ string, _ ...interface{}) (aws.Endpoint, error) {
+		if service == s3.ServiceID {
+			return aws.Endpoint{
+				URL:               url,
+				SigningRegion:     region,
+				HostnameImmutable: true,
+			}, nil
+		}
+		return aws.Endpoint{}, &aws.EndpointNotFoundError{}
+	})
+
+	cfg, err := config.LoadDefaultConfig(context.Background(),
+		config.WithRegion(region),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, "")),
+		config.WithEndpointResolverWithOptions(resolver))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 configuration: %w", err)
+	}
+	return &cfg, nil
+}
+
+// joinURL appends the key to the base URL.
+func joinURL(base, key string) string {
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(key, "/")
+}
@@ -0,0 +1,52 @@
+package mps3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProviderPresets(t *testing.T) {
+	assert := assert.New(t)
+
+	r2, err := NewR2Config("abc123", "key", "secret")
+	assert.NoError(err)
+	endpoint, err := r2.EndpointResolverWithOptions.ResolveEndpoint(s3.ServiceID, r2.Region)
+	assert.NoError(err)
+	assert.Equal("https://abc123.r2.cloudflarestorage.com", endpoint.URL)
+	assert.Equal("auto", endpoint.SigningRegion)
+
+	spaces, err := NewSpacesConfig("nyc3", "key", "secret")
+	assert.NoError(err)
+	endpoint, err = spaces.EndpointResolverWithOptions.ResolveEndpoint(s3.ServiceID, spaces.Region)
+	assert.NoError(err)
+	assert.Equal("https://nyc3.digitaloceanspaces.com", endpoint.URL)
+	assert.Equal("nyc3", spaces.Region)
+
+	_, err = NewR2Config("", "key", "secret")
+	assert.Error(err)
+}
+
+func TestPublicURL(t *testing.T) {
+	assert := assert.New(t)
+
+	wrapper, err := New(Config{
+		Bucket:    bucket,
+		Backend:   mps3test.NewBackend(),
+		PublicURL: "https://cdn.example.com/",
+	})
+	assert.NoError(err)
+
+	req, err := newRequest(nil, "test_file2.txt")
+	assert.NoError(err)
+	res := httptest.NewRecorder()
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal("https://cdn.example.com"+req.Form.Get("file"), req.Form.Get("file_public_url"))
+	})).ServeHTTP(res, req)
+
+	assert.Equal(200, res.Result().StatusCode)
+}
@@ -0,0 +1,63 @@
+package mps3
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Presigner is implemented by backends that can create temporary download links.
+type Presigner interface {
+	// PresignGet returns a URL to download the object in.Bucket and in.Key that is valid for
+	// the given duration.
+	PresignGet(ctx context.Context, in *s3.GetObjectInput, expires time.Duration) (string, error)
+}
+
+func (b *s3Backend) PresignGet(ctx context.Context, in *s3.GetObjectInput, expires time.Duration) (string, error) {
+	req, err := s3.NewPresignClient(b.client).PresignGetObject(ctx, in, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+// PresignGet returns the file URL, local files don't expire.
+func (b *localBackend) PresignGet(_ context.Context, in *s3.GetObjectInput, _ time.Duration) (string, error) {
+	return "file://" + filepath.ToSlash(b.path(aws.ToString(in.Bucket), aws.ToString(in.Key))), nil
+}
+
+func (b *replicatedBackend) PresignGet(ctx context.Context, in *s3.GetObjectInput, expires time.Duration) (string, error) {
+	p, ok := b.primary.(Presigner)
+	if !ok {
+		return "", fmt.Errorf("backend doesn't support presigned URLs")
+	}
+	return p.PresignGet(ctx, in, expires)
+}
+
+// presign returns a download URL for the uploaded file, files stored in the fallback aren't signed.
+func (wr Wrapper) presign(ctx context.Context, f file, expires time.Duration) (string, error) {
+	if f.fallback != "" {
+		return "", nil
+	}
+	p, ok := wr.backend.(Presigner)
+	if !ok {
+		return "", fmt.Errorf("backend doesn't support presigned URLs")
+	}
+	in := &s3.GetObjectInput{
+		Bucket: aws.String(f.bucket),
+		Key:    aws.String(f.key),
+	}
+	if wr.requestPayer != "" {
+		in.RequestPayer = types.RequestPayer(wr.requestPayer)
+	}
+	u, err := p.PresignGet(ctx, in, expires)
+	if err != nil {
+		return "", fmt.Errorf("failed to presign %q: %w", f.key, err)
+	}
+	return u, nil
+}
@@ -0,0 +1,24 @@
+package mps3
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresignedURL(t *testing.T) {
+	assert := assert.New(t)
+
+	_, form, res := uploadToMemory(t, Config{PresignExpiry: 15 * time.Minute}, nil, "test_file2.txt")
+	assert.Equal(200, res.Code)
+	assert.Equal("mem://"+bucket+"/"+form.Get("file")+"?X-Amz-Expires=900", form.Get("file_url"))
+
+	_, form, _ = uploadToMemory(t, Config{}, nil, "test_file2.txt")
+	assert.NotContains(form, "file_url")
+}
+
+func TestPresignedURLUnsupported(t *testing.T) {
+	_, err := New(Config{Bucket: bucket, Backend: failingBackend{}, PresignExpiry: time.Minute})
+	assert.Error(t, err)
+}
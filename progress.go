@@ -0,0 +1,294 @@
+package mps3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// progressInterval is the minimum time between the calls of Config.OnProgress for a file, and
+// between the events of ProgressHandler.
+const progressInterval = 500 * time.Millisecond
+
+// progressReader reports the bytes read from r, at most every progressInterval and once more
+// when the file was read completely.
+type progressReader struct {
+	r      io.Reader
+	report func(read int64, done bool)
+	read   int64
+	last   time.Time
+	done   bool
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	pr.read += int64(n)
+	eof := errors.Is(err, io.EOF)
+	if pr.done || (!eof && (n == 0 || time.Since(pr.last) < progressInterval)) {
+		return n, err
+	}
+	pr.done = eof
+	pr.last = time.Now()
+	pr.report(pr.read, pr.done)
+	return n, err
+}
+
+// withProgress returns the content of the file reporting its progress to Config.OnProgress and
+// Config.ProgressStore, if set. The total size is known if the part has a Content-Length header,
+// as raw uploads do.
+func (wr Wrapper) withProgress(req *http.Request, part *multipart.Part, content io.Reader) io.Reader {
+	pt := progressOf(req)
+	if wr.onProgress == nil && pt == nil {
+		return content
+	}
+	total, err := strconv.ParseInt(part.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		total = -1
+	}
+	field := part.FormName()
+	file := pt.add(req, FileProgress{Field: field, Name: wr.filename(part), Total: total})
+	return &progressReader{r: content, report: func(read int64, done bool) {
+		if wr.onProgress != nil {
+			wr.onProgress(req, field, read, total)
+		}
+		pt.update(req, file, read, done)
+	}, last: time.Now()}
+}
+
+// Progress of the files of an upload request, see Config.ProgressStore.
+type Progress struct {
+	Files []FileProgress `json:"files"`
+	// Done is true when the request finished, Error is set if it failed
+	Done  bool   `json:"done"`
+	Error string `json:"error,omitempty"`
+}
+
+// FileProgress is the progress of a file of an upload request.
+type FileProgress struct {
+	Field string `json:"field"`
+	Name  string `json:"name"`
+	// Read is the number of bytes read so far, Total is the size of the file if known, otherwise -1
+	Read  int64 `json:"read"`
+	Total int64 `json:"total"`
+	// Done is true when the file was read completely
+	Done bool `json:"done"`
+}
+
+// ProgressStore stores the progress of the upload requests by their progress token, it must be
+// safe for concurrent use. NewMemoryProgressStore keeps them in memory.
+type ProgressStore interface {
+	// Load returns the progress, or nil if there is none for the token.
+	Load(ctx context.Context, token string) (*Progress, error)
+
+	// Save stores the progress, replacing it if it exists.
+	Save(ctx context.Context, token string, p *Progress) error
+}
+
+// NewProgressToken returns a token to follow the progress of an upload request, sent in the
+// X-Progress-Token header or the progress_token query parameter of the upload and the token query
+// parameter of ProgressHandler. The token is random so other clients can't guess it.
+func NewProgressToken() string {
+	return uuid.NewString()
+}
+
+// maxProgressToken is the maximum length of the progress tokens sent by the clients.
+const maxProgressToken = 128
+
+// progressToken returns the progress token of an upload request, if any.
+func progressToken(req *http.Request) string {
+	token := req.Header.Get("X-Progress-Token")
+	if token == "" {
+		token = req.URL.Query().Get("progress_token")
+	}
+	if len(token) > maxProgressToken {
+		return ""
+	}
+	return token
+}
+
+type progressKey struct{}
+
+// progressTracker saves the progress of a request to Config.ProgressStore.
+type progressTracker struct {
+	wr       Wrapper
+	token    string
+	mu       sync.Mutex
+	progress Progress
+}
+
+// trackProgress returns the request tracking its progress, if Config.ProgressStore is set and the
+// request has a progress token.
+func (wr Wrapper) trackProgress(req *http.Request) *http.Request {
+	token := progressToken(req)
+	if wr.progress == nil || token == "" {
+		return req
+	}
+	pt := &progressTracker{wr: wr, token: token, progress: Progress{Files: []FileProgress{}}}
+	return req.WithContext(context.WithValue(req.Context(), progressKey{}, pt))
+}
+
+// progressOf returns the progress tracker of the request, nil if it's not tracked.
+func progressOf(req *http.Request) *progressTracker {
+	pt, _ := req.Context().Value(progressKey{}).(*progressTracker)
+	return pt
+}
+
+// add adds a file to the progress, returning its index.
+func (pt *progressTracker) add(req *http.Request, f FileProgress) int {
+	if pt == nil {
+		return 0
+	}
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.progress.Files = append(pt.progress.Files, f)
+	pt.save(req)
+	return len(pt.progress.Files) - 1
+}
+
+// update sets the bytes read of a file.
+func (pt *progressTracker) update(req *http.Request, file int, read int64, done bool) {
+	if pt == nil {
+		return
+	}
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.progress.Files[file].Read = read
+	pt.progress.Files[file].Done = done
+	pt.save(req)
+}
+
+// finish marks the request as done, failed if err is not nil.
+func (pt *progressTracker) finish(req *http.Request, err error) {
+	if pt == nil {
+		return
+	}
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	if pt.progress.Done {
+		return
+	}
+	pt.progress.Done = true
+	if err != nil {
+		pt.progress.Error = http.StatusText(StatusCode(err))
+	}
+	pt.save(req)
+}
+
+// save stores the progress, errors are only logged since they don't affect the upload.
+func (pt *progressTracker) save(req *http.Request) {
+	p := pt.progress
+	p.Files = append([]FileProgress(nil), p.Files...)
+	if err := pt.wr.progress.Save(context.WithoutCancel(req.Context()), pt.token, &p); err != nil {
+		pt.wr.log(req).ErrorContext(req.Context(), "failed to save upload progress", "error", err)
+	}
+}
+
+// ProgressHandler streams the progress of the upload request with the token of the "token" query
+// parameter as Server-Sent Events, each event has the Progress as JSON. The stream ends when the
+// request is done, the client can connect before or while the request is uploading.
+// Config.ProgressStore is required, otherwise it responds with 404.
+//
+//	token := mps3.NewProgressToken()
+//	// <form action="/upload?progress_token={{token}}"> and new EventSource("/progress?token={{token}}")
+//	mux.Handle("/progress", wrapper.ProgressHandler())
+func (wr Wrapper) ProgressHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token := req.URL.Query().Get("token")
+		if wr.progress == nil || token == "" || len(token) > maxProgressToken {
+			http.NotFound(w, req)
+			return
+		}
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			wr.log(req).ErrorContext(req.Context(), "failed to stream upload progress", "error", err)
+			return
+		}
+
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		var last []byte
+		for {
+			p, err := wr.progress.Load(req.Context(), token)
+			if err != nil {
+				wr.log(req).ErrorContext(req.Context(), "failed to load upload progress", "error", err)
+				return
+			}
+			if p != nil {
+				data, err := json.Marshal(p)
+				if err != nil {
+					return
+				}
+				if !bytes.Equal(data, last) {
+					last = data
+					if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+						return
+					}
+					if err := rc.Flush(); err != nil {
+						return
+					}
+				}
+				if p.Done {
+					return
+				}
+			}
+			select {
+			case <-req.Context().Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// progressTTL is how long NewMemoryProgressStore keeps the progress after it was last saved.
+const progressTTL = time.Hour
+
+type memoryProgressStore struct {
+	mu       sync.Mutex
+	progress map[string]Progress
+	saved    map[string]time.Time
+}
+
+// NewMemoryProgressStore returns a ProgressStore that keeps the progress in memory for an hour,
+// it's not shared by several instances of the application.
+func NewMemoryProgressStore() ProgressStore {
+	return &memoryProgressStore{progress: make(map[string]Progress), saved: make(map[string]time.Time)}
+}
+
+func (st *memoryProgressStore) Load(_ context.Context, token string) (*Progress, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	p, ok := st.progress[token]
+	if !ok {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+func (st *memoryProgressStore) Save(_ context.Context, token string, p *Progress) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	now := time.Now()
+	for t, saved := range st.saved {
+		if now.Sub(saved) > progressTTL {
+			delete(st.progress, t)
+			delete(st.saved, t)
+		}
+	}
+	st.progress[token] = *p
+	st.saved[token] = now
+	return nil
+}
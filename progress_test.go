@@ -0,0 +1,113 @@
+package mps3
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnProgress(t *testing.T) {
+	assert := assert.New(t)
+
+	type call struct {
+		field       string
+		read, total int64
+	}
+	var calls []call
+	cfg := Config{
+		Backend: mps3test.NewBackend(),
+		OnProgress: func(req *http.Request, field string, read, total int64) {
+			calls = append(calls, call{field, read, total})
+		},
+	}
+	_, _, res := uploadToMemory(t, cfg, nil, "test_file1.png", "test_file2.txt")
+	assert.Equal(http.StatusOK, res.Code)
+	png, _ := os.Stat("test_file1.png")
+	txt, _ := os.Stat("test_file2.txt")
+	assert.Equal([]call{{"file", png.Size(), -1}, {"file", txt.Size(), -1}}, calls)
+
+	// the size of raw uploads is known
+	calls = nil
+	wrapper, err := New(Config{Bucket: bucket, Backend: cfg.Backend, RawUploadField: "upload", OnProgress: cfg.OnProgress})
+	assert.NoError(err)
+	req := httptest.NewRequest(http.MethodPut, "/notes.txt", strings.NewReader("hello"))
+	req.Header.Set("Content-Type", "text/plain")
+	wrapper.Wrap(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal([]call{{"upload", 5, 5}}, calls)
+}
+
+func TestProgressReader(t *testing.T) {
+	assert := assert.New(t)
+
+	var reported []int64
+	pr := &progressReader{r: iotest.OneByteReader(strings.NewReader("abc")), report: func(read int64, _ bool) {
+		reported = append(reported, read)
+	}}
+	// reported when the interval passed, and at the end
+	_, _ = pr.Read(make([]byte, 1))
+	_, _ = pr.Read(make([]byte, 1))
+	assert.Equal([]int64{1}, reported)
+	_, _ = io.ReadAll(pr)
+	_, _ = pr.Read(make([]byte, 1))
+	assert.Equal([]int64{1, 3}, reported)
+}
+
+func TestProgressHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMemoryProgressStore()
+	wrapper, err := New(Config{Bucket: bucket, Backend: mps3test.NewBackend(), ProgressStore: store, MaxFileSize: 10})
+	assert.NoError(err)
+	server := httptest.NewServer(wrapper.ProgressHandler())
+	defer server.Close()
+
+	// the client connects before the upload starts
+	token := NewProgressToken()
+	events := make(chan string)
+	go func() {
+		res, err := http.Get(server.URL + "?token=" + token)
+		if err != nil {
+			events <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		events <- res.Header.Get("Content-Type") + "\n" + string(body)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	req := newFileRequest(t, "a.txt", "hello")
+	req.Header.Set("X-Progress-Token", token)
+	wrapper.Wrap(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), req)
+	stream := <-events
+	assert.True(strings.HasPrefix(stream, "text/event-stream\n"))
+	assert.True(strings.HasSuffix(stream,
+		`data: {"files":[{"field":"file","name":"a.txt","read":5,"total":-1,"done":true}],"done":true}`+"\n\n"), stream)
+
+	// failed requests
+	token = NewProgressToken()
+	req = newFileRequest(t, "a.txt", strings.Repeat("a", 100))
+	req.URL.RawQuery = "progress_token=" + token
+	wrapper.Wrap(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), req)
+	p, err := store.Load(context.Background(), token)
+	assert.NoError(err)
+	assert.True(p.Done)
+	assert.Equal("Request Entity Too Large", p.Error)
+	assert.Len(p.Files, 1)
+
+	// the handler requires the store
+	wrapper, err = New(Config{Bucket: bucket, Backend: mps3test.NewBackend()})
+	assert.NoError(err)
+	res := httptest.NewRecorder()
+	wrapper.ProgressHandler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/?token="+token, nil))
+	assert.Equal(http.StatusNotFound, res.Code)
+}
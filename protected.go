@@ -0,0 +1,275 @@
+package mps3
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Actions of Config.EncryptedArchives on password-protected archives.
+const (
+	// EncryptedReject fails the request with ErrEncryptedArchive.
+	EncryptedReject = "reject"
+	// EncryptedTag tags the file with "encrypted=true".
+	EncryptedTag = "tag"
+)
+
+// encryptedTagKey is the tag of the password-protected archives with EncryptedTag.
+const encryptedTagKey = "encrypted"
+
+var (
+	rar4Signature     = []byte("Rar!\x1a\x07\x00")
+	rar5Signature     = []byte("Rar!\x1a\x07\x01\x00")
+	sevenZipSignature = []byte("7z\xbc\xaf\x27\x1c")
+	// sevenZipAES is the ID of the AES-256 + SHA-256 coder of 7z files
+	sevenZipAES = []byte{0x06, 0xf1, 0x07, 0x01}
+)
+
+// maxSevenZipHeader is the size of the header of 7z files that is searched for encryption.
+const maxSevenZipHeader = 1 << 20
+
+// errProtected stops the inspection of an archive when an encrypted entry is found.
+var errProtected = errors.New("password-protected archive")
+
+// inspectEncryption returns the function of the tap that detects password-protected archives,
+// encrypted is set if the archive is tagged.
+func (wr Wrapper) inspectEncryption(encrypted *bool) func(io.Reader) error {
+	return func(r io.Reader) error {
+		// archives that can't be read are rejected by ArchiveLimits
+		if !errors.Is(findProtected(r, 0), errProtected) {
+			return nil
+		}
+		if wr.encArchive == EncryptedTag {
+			*encrypted = true
+			return nil
+		}
+		return ErrEncryptedArchive
+	}
+}
+
+// encryptedArchive tags the file if it's a password-protected archive.
+func (wr Wrapper) encryptedArchive(req *http.Request, uf UploadedFile, encrypted bool) (UploadedFile, error) {
+	if !encrypted {
+		return uf, nil
+	}
+	if err := wr.tagFile(context.WithoutCancel(req.Context()), req, uf, encryptedTagKey, "true"); err != nil {
+		wr.rollback(req, []UploadedFile{uf})
+		return uf, fmt.Errorf("failed to tag encrypted archive: %w", err)
+	}
+	uf.Encrypted = true
+	return uf, nil
+}
+
+// findProtected returns errProtected if the file is a password-protected ZIP, RAR or 7z file, or
+// an archive that contains one, consuming it.
+func findProtected(r io.Reader, depth int) error {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if depth > maxArchiveDepth {
+		return nil
+	}
+	switch {
+	case bytes.HasPrefix(head, rar4Signature):
+		return walkRAR4(br)
+	case bytes.HasPrefix(head, rar5Signature):
+		return walkRAR5(br)
+	case bytes.HasPrefix(head, sevenZipSignature):
+		return findSevenZipAES(br)
+	}
+
+	entry := func(e archiveEntry) error {
+		if e.encrypted {
+			return errProtected
+		}
+		if e.content == nil {
+			return nil
+		}
+		return findProtected(e.content, depth+1)
+	}
+	switch archiveKind(head) {
+	case "zip":
+		return walkZip(br, entry)
+	case "tar":
+		return walkTar(br, entry)
+	case "gzip":
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		return findProtected(gz, depth+1)
+	}
+	return nil
+}
+
+// RAR 4 header types and flags.
+const (
+	rar4MainHeader = 0x73
+	rar4FileHeader = 0x74
+	rar4EndHeader  = 0x7b
+	rar4Password   = 0x80   // of the main header, the headers are encrypted
+	rar4Encrypted  = 0x04   // of file headers
+	rar4Large      = 0x100  // of file headers, the sizes have a high part
+	rar4HasData    = 0x8000 // the header is followed by data
+)
+
+// walkRAR4 reads the headers of a RAR 4 file, returning errProtected if its headers or a file
+// are encrypted.
+func walkRAR4(r *bufio.Reader) error {
+	if _, err := r.Discard(len(rar4Signature)); err != nil {
+		return err
+	}
+	for {
+		// CRC, type, flags and size of the header
+		var h [7]byte
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			return err
+		}
+		typ, flags, size := h[2], binary.LittleEndian.Uint16(h[3:]), binary.LittleEndian.Uint16(h[5:])
+		if size < 7 {
+			return errors.New("invalid RAR header")
+		}
+		rest := make([]byte, size-7)
+		if _, err := io.ReadFull(r, rest); err != nil {
+			return err
+		}
+		switch {
+		case typ == rar4MainHeader && flags&rar4Password != 0,
+			typ == rar4FileHeader && flags&rar4Encrypted != 0:
+			return errProtected
+		case typ == rar4EndHeader:
+			return nil
+		}
+		var data int64
+		if (typ == rar4FileHeader || flags&rar4HasData != 0) && len(rest) >= 4 {
+			data = int64(binary.LittleEndian.Uint32(rest))
+			if typ == rar4FileHeader && flags&rar4Large != 0 && len(rest) >= 29 {
+				data |= int64(binary.LittleEndian.Uint32(rest[25:])) << 32
+			}
+		}
+		if _, err := io.CopyN(io.Discard, r, data); err != nil {
+			return err
+		}
+	}
+}
+
+// RAR 5 header types and flags.
+const (
+	rar5FileHeader       = 2
+	rar5EncryptionHeader = 4
+	rar5EndHeader        = 5
+	rar5HasExtra         = 0x1
+	rar5HasData          = 0x2
+	rar5EncryptionRecord = 1
+	// maxRAR5Header is the maximum size of RAR 5 headers
+	maxRAR5Header = 2 << 20
+)
+
+// walkRAR5 reads the headers of a RAR 5 file, returning errProtected if its headers or a file
+// are encrypted.
+func walkRAR5(r *bufio.Reader) error {
+	if _, err := r.Discard(len(rar5Signature)); err != nil {
+		return err
+	}
+	for {
+		var crc [4]byte
+		if _, err := io.ReadFull(r, crc[:]); err != nil {
+			return err
+		}
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		if size > maxRAR5Header {
+			return errors.New("invalid RAR header")
+		}
+		h := make([]byte, size)
+		if _, err := io.ReadFull(r, h); err != nil {
+			return err
+		}
+		p := h
+		typ, flags := uvarint(&p), uvarint(&p)
+		var extra, data uint64
+		if flags&rar5HasExtra != 0 {
+			extra = uvarint(&p)
+		}
+		if flags&rar5HasData != 0 {
+			data = uvarint(&p)
+		}
+		switch typ {
+		case rar5EncryptionHeader:
+			return errProtected
+		case rar5FileHeader:
+			if extra <= size && rar5Encrypted(h[size-extra:]) {
+				return errProtected
+			}
+		case rar5EndHeader:
+			return nil
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(data)); err != nil {
+			return err
+		}
+	}
+}
+
+// rar5Encrypted returns true if the extra area of a RAR 5 file header has an encryption record.
+func rar5Encrypted(area []byte) bool {
+	for len(area) > 0 {
+		size := uvarint(&area)
+		if size == 0 || size > uint64(len(area)) {
+			return false
+		}
+		record := area[:size]
+		area = area[size:]
+		if uvarint(&record) == rar5EncryptionRecord {
+			return true
+		}
+	}
+	return false
+}
+
+// uvarint reads a variable-length integer from the start of p.
+func uvarint(p *[]byte) uint64 {
+	v, n := binary.Uvarint(*p)
+	if n <= 0 {
+		*p = nil
+		return 0
+	}
+	*p = (*p)[n:]
+	return v
+}
+
+// findSevenZipAES searches the header at the end of a 7z file for the AES coder, returning
+// errProtected if it's found. Only headers that aren't compressed can be searched: encrypted ones
+// (7-Zip's -mhe option), and the ones that are stored.
+func findSevenZipAES(r *bufio.Reader) error {
+	// signature, version, CRC, and the offset and size of the header
+	var h [32]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return err
+	}
+	offset := binary.LittleEndian.Uint64(h[12:])
+	size := min(binary.LittleEndian.Uint64(h[20:]), maxSevenZipHeader)
+	if offset > 1<<62 {
+		return errors.New("invalid 7z header")
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(offset)); err != nil {
+		return err
+	}
+	header := make([]byte, size)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	if bytes.Contains(header, sevenZipAES) {
+		return errProtected
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package mps3
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindProtected(t *testing.T) {
+	assert := assert.New(t)
+	protected := func(content []byte) bool {
+		return findProtected(bytes.NewReader(content), 0) == errProtected
+	}
+
+	assert.False(protected([]byte("hello")))
+	assert.False(protected(newZip(t, false)))
+	assert.True(protected(newZip(t, true)))
+
+	// nested in a tar.gz file
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	inner := newZip(t, true)
+	assert.NoError(tw.WriteHeader(&tar.Header{Name: "inner.zip", Mode: 0o644, Size: int64(len(inner))}))
+	_, _ = tw.Write(inner)
+	assert.NoError(tw.Close())
+	assert.NoError(gz.Close())
+	assert.True(protected(buf.Bytes()))
+
+	// RAR 4 files with encrypted headers, or an encrypted file
+	rar4 := func(mainFlags, fileFlags uint16) []byte {
+		b := bytes.NewBuffer(bytes.Clone(rar4Signature))
+		header := func(typ byte, flags uint16, rest []byte) {
+			b.Write([]byte{0, 0, typ})
+			_ = binary.Write(b, binary.LittleEndian, flags)
+			_ = binary.Write(b, binary.LittleEndian, uint16(7+len(rest)))
+			b.Write(rest)
+		}
+		header(rar4MainHeader, mainFlags, make([]byte, 6))
+		file := make([]byte, 25+5)
+		binary.LittleEndian.PutUint32(file, 3) // packed size
+		binary.LittleEndian.PutUint16(file[19:], 5)
+		copy(file[25:], "a.txt")
+		header(rar4FileHeader, fileFlags|rar4HasData, file)
+		b.WriteString("abc")
+		header(rar4EndHeader, 0, nil)
+		return b.Bytes()
+	}
+	assert.False(protected(rar4(0, 0)))
+	assert.True(protected(rar4(rar4Password, 0)))
+	assert.True(protected(rar4(0, rar4Encrypted)))
+
+	// RAR 5 files with an encryption header, or an encryption record in a file header
+	rar5 := func(encryptedHeaders, encryptedFile bool) []byte {
+		b := bytes.NewBuffer(bytes.Clone(rar5Signature))
+		header := func(fields ...[]byte) {
+			h := bytes.Join(fields, nil)
+			b.Write([]byte{0, 0, 0, 0})
+			b.Write(binary.AppendUvarint(nil, uint64(len(h))))
+			b.Write(h)
+		}
+		v := func(n uint64) []byte { return binary.AppendUvarint(nil, n) }
+		if encryptedHeaders {
+			header(v(rar5EncryptionHeader), v(0), v(0), v(0), make([]byte, 16))
+		}
+		extra := append(v(2), v(rar5EncryptionRecord)...)
+		extra = append(extra, 0)
+		if !encryptedFile {
+			extra = []byte{}
+		}
+		// file flags, unpacked size, attributes, compression, host OS and name
+		file := bytes.Join([][]byte{v(0), v(3), v(0), v(0), v(0), v(5), []byte("a.txt")}, nil)
+		header(v(rar5FileHeader), v(rar5HasExtra|rar5HasData), v(uint64(len(extra))), v(3), file, extra)
+		b.WriteString("abc")
+		header(v(rar5EndHeader), v(0), v(0))
+		return b.Bytes()
+	}
+	assert.False(protected(rar5(false, false)))
+	assert.True(protected(rar5(true, false)))
+	assert.True(protected(rar5(false, true)))
+
+	// 7z files whose header uses the AES coder
+	sevenZip := func(header []byte) []byte {
+		b := bytes.NewBuffer(bytes.Clone(sevenZipSignature))
+		b.Write([]byte{0, 4, 0, 0, 0, 0})
+		_ = binary.Write(b, binary.LittleEndian, uint64(5))
+		_ = binary.Write(b, binary.LittleEndian, uint64(len(header)))
+		b.Write([]byte{0, 0, 0, 0})
+		b.WriteString("data!")
+		b.Write(header)
+		return b.Bytes()
+	}
+	assert.False(protected(sevenZip([]byte{0x17, 0x06, 0x01, 0x0b, 0x01, 0x00, 0x01, 0x23, 0x03, 0x01, 0x01, 0x05})))
+	assert.True(protected(sevenZip(append([]byte{0x17, 0x06, 0x01, 0x0b, 0x01, 0x00, 0x02, 0x24}, sevenZipAES...))))
+}
+
+func TestEncryptedArchives(t *testing.T) {
+	assert := assert.New(t)
+	backend := mps3test.NewBackend()
+	upload := func(action string, content []byte) (url.Values, int) {
+		wrapper, err := New(Config{Bucket: bucket, Backend: backend, EncryptedArchives: action})
+		assert.NoError(err)
+		var form url.Values
+		res := httptest.NewRecorder()
+		wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			form = req.Form
+		})).ServeHTTP(res, newFileRequest(t, "archive.zip", string(content)))
+		return form, res.Code
+	}
+
+	_, code := upload(EncryptedReject, newZip(t, true))
+	assert.Equal(http.StatusUnprocessableEntity, code)
+	assert.Empty(backend.Objects())
+
+	form, code := upload(EncryptedReject, newZip(t, false))
+	assert.Equal(http.StatusOK, code)
+	assert.Len(backend.Objects(), 1)
+	_, ok := form["file_encrypted"]
+	assert.False(ok)
+
+	backend.Reset()
+	form, code = upload(EncryptedTag, newZip(t, true))
+	assert.Equal(http.StatusOK, code)
+	assert.Equal("true", form.Get("file_encrypted"))
+	obj, _ := backend.Object(bucket, form.Get("file"))
+	assert.Equal("encrypted=true", aws.ToString(obj.Input.Tagging))
+
+	form, code = upload(EncryptedTag, newZip(t, false))
+	assert.Equal(http.StatusOK, code)
+	assert.Equal("false", form.Get("file_encrypted"))
+
+	_, err := New(Config{Bucket: bucket, Backend: backend, EncryptedArchives: "delete"})
+	assert.Error(err)
+	_, err = New(Config{Bucket: bucket, Backend: NewLocalBackend(t.TempDir()), EncryptedArchives: EncryptedTag})
+	assert.Error(err)
+}
+
+// newZip returns a ZIP file with a file, encrypted or not. The content of encrypted files is
+// random bytes, as only the flag of the header is checked.
+func newZip(t *testing.T, encrypted bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if encrypted {
+		content := []byte("0123456789abcdefghij")
+		w, err := zw.CreateRaw(&zip.FileHeader{Name: "secret.txt", Method: zip.Store, Flags: 0x1,
+			CompressedSize64: uint64(len(content)), UncompressedSize64: 8})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write(content)
+	} else {
+		w, err := zw.Create("readme.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte("hello"))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
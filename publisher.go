@@ -0,0 +1,148 @@
+package mps3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// publisher delivers the events of Config.Notifier in the background, so the responses don't
+// wait for them. The events are queued and published by a fixed number of workers until
+// Wrapper.Close, the failures are retried (see Config.NotifyRetries).
+type publisher struct {
+	notifier Notifier
+	retries  int
+	backoff  time.Duration
+	queue    chan publishJob
+	wg       sync.WaitGroup
+
+	// mu guards closed, events are not queued once the queue was closed
+	mu     sync.RWMutex
+	closed bool
+
+	// ctx is canceled when Close gives up waiting, which stops the events being published
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// publishJob is an event waiting to be published.
+type publishJob struct {
+	ctx    context.Context
+	logger *slog.Logger
+	event  UploadEvent
+}
+
+// permanentError is an error of a Notifier that isn't retried, e.g. a 4xx response of a webhook.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+func newPublisher(notifier Notifier, workers, size, retries int, backoff time.Duration) *publisher {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &publisher{
+		notifier: notifier,
+		retries:  retries,
+		backoff:  backoff,
+		queue:    make(chan publishJob, size),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	p.wg.Add(workers)
+	for range workers {
+		go p.run()
+	}
+	return p
+}
+
+// publish queues the event, it returns an error if the queue is full or closed.
+func (p *publisher) publish(job publishJob) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return fmt.Errorf("the notifier is closed")
+	}
+	select {
+	case p.queue <- job:
+		return nil
+	default:
+		return fmt.Errorf("the queue of events is full (%d events)", cap(p.queue))
+	}
+}
+
+func (p *publisher) run() {
+	defer p.wg.Done()
+	for job := range p.queue {
+		p.deliver(job)
+	}
+}
+
+// deliver publishes the event, retrying it after a backoff that doubles after each attempt.
+// Failures are logged.
+func (p *publisher) deliver(job publishJob) {
+	ctx, cancel := context.WithCancel(job.ctx)
+	defer cancel()
+	defer context.AfterFunc(p.ctx, cancel)()
+
+	backoff := p.backoff
+	for attempt := 1; ; attempt++ {
+		err := p.notifier.Notify(ctx, job.event)
+		if err == nil {
+			return
+		}
+		if attempt > p.retries || errors.As(err, new(permanentError)) || !sleep(ctx, backoff) {
+			job.logger.ErrorContext(ctx, "failed to publish upload event", "key", job.event.Key, "attempts", attempt, "error", err)
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// sleep waits for d, it returns false if ctx was done before.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// close stops queueing events and waits for the queued ones to be published, up to ctx.
+func (p *publisher) close(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		return fmt.Errorf("failed to publish the upload events: %w", ctx.Err())
+	}
+}
+
+// Close waits for the events of Config.Notifier that are queued to be published, up to ctx, and
+// stops publishing the events of the requests handled afterwards. Call it once the server was
+// shut down, e.g. after http.Server.Shutdown. The events not published when ctx is done are
+// canceled.
+func (wr Wrapper) Close(ctx context.Context) error {
+	if wr.publisher == nil {
+		return nil
+	}
+	return wr.publisher.close(ctx)
+}
@@ -0,0 +1,120 @@
+package mps3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// QuarantineConfig is where the files are stored when Config.OnUploadStart or
+// Config.OnUploadComplete return an error wrapping ErrQuarantine, e.g. files that need to be
+// reviewed. Instead of failing the request, the file is stored in the quarantine (directly, when
+// OnUploadStart quarantines it, or moved once it's uploaded) with its own ACL and a tag with the
+// reason, and the reason is reported in UploadedFile.Quarantine and the `<field>_quarantine` form
+// value. Quarantined files are kept when the request fails.
+type QuarantineConfig struct {
+	// Bucket and Prefix where the files are stored (default: the bucket of the file and
+	// "quarantine/")
+	Bucket string
+	Prefix string
+
+	// ACL of the quarantined files (default: "private")
+	ACL string
+
+	// TagKey is the tag set to the reason, the message of the error of the hook without the
+	// message of ErrQuarantine (default: "quarantine")
+	TagKey string
+}
+
+// withDefaults returns the settings with the default value of the empty ones.
+func (qc QuarantineConfig) withDefaults() QuarantineConfig {
+	if qc.Prefix == "" {
+		qc.Prefix = "quarantine/"
+	}
+	if qc.ACL == "" {
+		qc.ACL = string(types.ObjectCannedACLPrivate)
+	}
+	if qc.TagKey == "" {
+		qc.TagKey = "quarantine"
+	}
+	return qc
+}
+
+// quarantined returns true if the error of a hook is a quarantine verdict, which is handled
+// instead of failing the request.
+func (wr Wrapper) quarantined(err error) bool {
+	return wr.quarantine != nil && errors.Is(err, ErrQuarantine)
+}
+
+// quarantineReason returns the reason of a quarantine verdict, e.g. "unverified sender" for
+// fmt.Errorf("%w: unverified sender", ErrQuarantine).
+func quarantineReason(err error) string {
+	return strings.TrimPrefix(err.Error(), ErrQuarantine.Error()+": ")
+}
+
+// quarantineLocation sets the location of a file quarantined before it's uploaded.
+func (wr Wrapper) quarantineLocation(f *file, reason error) {
+	f.quarantine = quarantineReason(reason)
+	if wr.quarantine.Bucket != "" {
+		f.bucket = wr.quarantine.Bucket
+	}
+	f.key = prefixKey(wr.quarantine.Prefix, f.key)
+}
+
+// quarantineInput sets the ACL and the tags of a file quarantined before it's uploaded.
+func (wr Wrapper) quarantineInput(req *http.Request, f file, in *s3.PutObjectInput) {
+	if !isDirectoryBucket(f.bucket) {
+		in.ACL = types.ObjectCannedACL(wr.quarantine.ACL)
+		in.Tagging = aws.String(encodeTags(wr.quarantineTags(req, f.name, f.quarantine)))
+	}
+}
+
+// quarantineTags returns the tags of Config.TagFunc with the reason the file was quarantined.
+func (wr Wrapper) quarantineTags(req *http.Request, filename, reason string) map[string]string {
+	tags := map[string]string{}
+	if wr.tagFunc != nil {
+		for k, v := range wr.tagFunc(req, filename) {
+			tags[k] = v
+		}
+	}
+	tags[wr.quarantine.TagKey] = tagValue(reason)
+	return tags
+}
+
+// quarantineFile moves an uploaded file to the quarantine.
+func (wr Wrapper) quarantineFile(req *http.Request, uf UploadedFile, reason error) (UploadedFile, error) {
+	if uf.Quarantine != "" {
+		return uf, nil
+	}
+	backend, bucket, key, err := uf.location()
+	if err != nil {
+		return uf, err
+	}
+	dst := wr.quarantine.Bucket
+	if dst == "" {
+		dst = bucket
+	}
+	dstKey := prefixKey(wr.quarantine.Prefix, uf.Key)
+	tags := wr.quarantineTags(req, uf.Name, quarantineReason(reason))
+	err = wr.moveWith(context.WithoutCancel(req.Context()), backend, bucket, key, dst, dstKey, func(in *s3.CopyObjectInput) {
+		if !isDirectoryBucket(dst) {
+			in.ACL = types.ObjectCannedACL(wr.quarantine.ACL)
+			in.Tagging = aws.String(encodeTags(tags))
+			in.TaggingDirective = types.TaggingDirectiveReplace
+		}
+	})
+	if err != nil {
+		wr.rollback(req, []UploadedFile{uf})
+		return uf, fmt.Errorf("failed to quarantine file: %w", err)
+	}
+	uf.Bucket, uf.Key, uf.staged = dst, dstKey, nil
+	uf.URL, uf.PublicURL = "", ""
+	uf.Quarantine = quarantineReason(reason)
+	return uf, nil
+}
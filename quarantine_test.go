@@ -0,0 +1,71 @@
+package mps3
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuarantine(t *testing.T) {
+	assert := assert.New(t)
+	cfg := Config{
+		FileACL:    "public-read",
+		Quarantine: &QuarantineConfig{Bucket: "review"},
+		OnUploadStart: func(req *http.Request, f UploadedFile) error {
+			if f.Name == "test_file1.png" {
+				return fmt.Errorf("%w: unverified sender", ErrQuarantine)
+			}
+			return nil
+		},
+		OnUploadComplete: func(req *http.Request, f UploadedFile, d time.Duration, err error) error {
+			if f.Name == "test_file2.txt" {
+				return fmt.Errorf("%w: needs review", ErrQuarantine)
+			}
+			return nil
+		},
+	}
+	backend, form, res := uploadToMemory(t, cfg, nil, "test_file1.png", "test_file2.txt")
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal([]string{"unverified sender", "needs review"}, form["file_quarantine"])
+	assert.Len(backend.Objects(), 2)
+
+	// routed to the quarantine when it's uploaded
+	assert.True(strings.HasPrefix(form["file"][0], "quarantine/"))
+	obj, ok := backend.Object("review", form["file"][0])
+	assert.True(ok)
+	assert.Equal(types.ObjectCannedACLPrivate, obj.Input.ACL)
+	assert.Equal("quarantine=unverified+sender", aws.ToString(obj.Input.Tagging))
+
+	// moved to the quarantine after it's uploaded
+	assert.True(strings.HasPrefix(form["file"][1], "quarantine/"))
+	obj, ok = backend.Object("review", form["file"][1])
+	assert.True(ok)
+	assert.Equal(types.ObjectCannedACLPrivate, obj.Input.ACL)
+	assert.Equal("quarantine=needs+review", aws.ToString(obj.Input.Tagging))
+
+	// quarantined files are kept when the request fails
+	backend = mps3test.NewBackend()
+	cfg.Backend = backend
+	cfg.OnUploadComplete = func(req *http.Request, f UploadedFile, d time.Duration, err error) error {
+		if f.Name == "test_file2.txt" {
+			return errors.New("rejected")
+		}
+		return nil
+	}
+	_, _, res = uploadToMemory(t, cfg, nil, "test_file1.png", "test_file2.txt")
+	assert.Equal(http.StatusInternalServerError, res.Code)
+	assert.Len(backend.Objects(), 1)
+
+	// without Quarantine the hooks fail the request
+	backend, _, res = uploadToMemory(t, Config{OnUploadStart: cfg.OnUploadStart}, nil, "test_file1.png")
+	assert.Equal(http.StatusInternalServerError, res.Code)
+	assert.Empty(backend.Objects())
+}
@@ -0,0 +1,72 @@
+package mps3
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// quotaInterval is how many bytes of files are received between the calls to Config.QuotaFunc.
+const quotaInterval = 1 << 20
+
+type quotaKey struct{}
+
+// quota consults Config.QuotaFunc while the files of a request are received.
+type quota struct {
+	req *http.Request
+	fn  func(req *http.Request, pendingBytes int64) error
+
+	mu       sync.Mutex
+	received int64
+	next     int64
+}
+
+// withQuota consults Config.QuotaFunc with the size of the request body, if it's known, and
+// returns the request with the quota consulted while its files are received.
+func (wr Wrapper) withQuota(req *http.Request) (*http.Request, error) {
+	if wr.quotaFunc == nil {
+		return req, nil
+	}
+	if req.ContentLength >= 0 {
+		if err := wr.checkQuota(req, req.ContentLength); err != nil {
+			return req, err
+		}
+	}
+	q := &quota{req: req, fn: wr.quotaFunc, next: quotaInterval}
+	return req.WithContext(context.WithValue(req.Context(), quotaKey{}, q)), nil
+}
+
+// checkQuota consults Config.QuotaFunc with the size of a file, or a part of it, received outside
+// of the middleware.
+func (wr Wrapper) checkQuota(req *http.Request, size int64) error {
+	if wr.quotaFunc == nil {
+		return nil
+	}
+	if err := wr.quotaFunc(req, size); err != nil {
+		return fmt.Errorf("upload rejected by QuotaFunc: %w", err)
+	}
+	return nil
+}
+
+// quotaOf returns the quota of the request, nil if Config.QuotaFunc isn't set.
+func quotaOf(req *http.Request) *quota {
+	q, _ := req.Context().Value(quotaKey{}).(*quota)
+	return q
+}
+
+// add counts n more bytes of a file and consults the quota each quotaInterval bytes and at the
+// end of the file.
+func (q *quota) add(n int, eof bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.received += int64(n)
+	if q.received < q.next && !eof {
+		return nil
+	}
+	q.next = q.received + quotaInterval
+	if err := q.fn(q.req, q.received); err != nil {
+		return fmt.Errorf("upload rejected by QuotaFunc: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package mps3
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuotaFunc(t *testing.T) {
+	assert := assert.New(t)
+	var calls []int64
+	limit := int64(0)
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, QuotaFunc: func(req *http.Request, pendingBytes int64) error {
+		calls = append(calls, pendingBytes)
+		if pendingBytes > limit {
+			return fmt.Errorf("%w: %d bytes left", ErrQuotaExceeded, limit)
+		}
+		return nil
+	}})
+	assert.NoError(err)
+	upload := func(content string, knownSize bool) int {
+		calls = nil
+		req := newFileRequest(t, "file.txt", content)
+		if !knownSize {
+			req.ContentLength = -1
+		}
+		res := httptest.NewRecorder()
+		wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})).ServeHTTP(res, req)
+		return res.Code
+	}
+
+	// the request is refused before it's read
+	limit = 100
+	assert.Equal(http.StatusPaymentRequired, upload("hello", true))
+	assert.Len(calls, 1)
+	assert.Greater(calls[0], int64(5))
+
+	limit = 1000
+	assert.Equal(http.StatusOK, upload("hello", true))
+	assert.Equal(int64(5), calls[len(calls)-1])
+	assert.Len(backend.Objects(), 1)
+
+	// the file is refused while it's received
+	backend.Reset()
+	limit = 3 << 20
+	assert.Equal(http.StatusPaymentRequired, upload(strings.Repeat("a", 5<<20), false))
+	// consulted each MB
+	assert.Len(calls, 3)
+	assert.Greater(calls[2], limit)
+	assert.Empty(backend.Objects())
+
+	// the errors that don't wrap ErrQuotaExceeded are internal errors
+	wrapper, err = New(Config{Bucket: bucket, Backend: backend, QuotaFunc: func(*http.Request, int64) error {
+		return errors.New("database unavailable")
+	}})
+	assert.NoError(err)
+	res := httptest.NewRecorder()
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})).
+		ServeHTTP(res, newFileRequest(t, "file.txt", "hello"))
+	assert.Equal(http.StatusInternalServerError, res.Code)
+}
+
+func TestQuotaFuncOtherUploads(t *testing.T) {
+	assert := assert.New(t)
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, ChunkedUploads: true, QuotaFunc: func(req *http.Request, pendingBytes int64) error {
+		if pendingBytes > 5 {
+			return fmt.Errorf("%w: 5 bytes left", ErrQuotaExceeded)
+		}
+		return nil
+	}})
+	assert.NoError(err)
+
+	// tus uploads are refused when they're created
+	tus, err := wrapper.TusHandler(TusOptions{})
+	assert.NoError(err)
+	req := newTusRequest(http.MethodPost, "/files", nil)
+	req.Header.Set("Upload-Length", "10")
+	res := httptest.NewRecorder()
+	tus.ServeHTTP(res, req)
+	assert.Equal(http.StatusPaymentRequired, res.Code)
+
+	// the chunks and the parts of sessions when they're received
+	req = newChunkRequest(t, []byte("hello world"), "uploadId", "a", "chunkIndex", "0", "totalChunks", "2")
+	req.ContentLength = -1
+	res = httptest.NewRecorder()
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})).ServeHTTP(res, req)
+	assert.Equal(http.StatusPaymentRequired, res.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/", nil)
+	session, err := wrapper.StartSession(req, "file", "notes.txt", "")
+	assert.NoError(err)
+	assert.NoError(session.AppendPart(req, 1, strings.NewReader("hello")))
+	assert.ErrorIs(session.AppendPart(req, 2, strings.NewReader("hello world")), ErrQuotaExceeded)
+	assert.NoError(session.Abort(req.Context()))
+
+	assert.Equal(0, backend.Uploads())
+	assert.Empty(backend.Objects())
+}
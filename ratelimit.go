@@ -0,0 +1,173 @@
+package mps3
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimit limits the uploads of each client, so one client can't use all the memory and the S3
+// throughput of the server with parallel large uploads, see Config.RateLimit.
+type RateLimit struct {
+	// ClientFunc returns the identity of the client of a request, e.g. the user or tenant ID. The
+	// IP address of the client (from req.RemoteAddr) is used if not set.
+	ClientFunc func(req *http.Request) string
+
+	// MaxConcurrent if greater than zero, is the maximum number of upload requests of a client
+	// handled at the same time, other requests fail with ErrRateLimited (429 Too Many Requests).
+	MaxConcurrent int
+
+	// BytesPerSecond if greater than zero, is the rate the request bodies of a client are read,
+	// shared by its concurrent and consecutive requests.
+	BytesPerSecond int64
+
+	// Burst is how many bytes can be read at once above BytesPerSecond, it's the same as
+	// BytesPerSecond if not set.
+	Burst int64
+
+	// LimitFunc if set, returns the limits of the client instead of MaxConcurrent and
+	// BytesPerSecond, e.g. depending on their plan. Zero values mean no limit.
+	LimitFunc func(req *http.Request, client string) (maxConcurrent int, bytesPerSecond int64)
+}
+
+// limiterSweep is how often the clients without uploads whose token bucket is full again are
+// removed, so the map doesn't grow.
+const limiterSweep = time.Minute
+
+// limiter keeps the uploads in progress and the token bucket of each client.
+type limiter struct {
+	RateLimit
+
+	mu      sync.Mutex
+	clients map[string]*clientLimit
+	swept   time.Time
+}
+
+// clientLimit is the state of the uploads of a client, kept after its last upload until its
+// token bucket is full again, so sequential uploads share the rate too.
+type clientLimit struct {
+	active int
+	// tokens are the bytes that can be read without waiting, negative when the client has to wait
+	tokens float64
+	last   time.Time
+	// rate is the BytesPerSecond of the last upload
+	rate int64
+}
+
+func newLimiter(cfg RateLimit) *limiter {
+	return &limiter{RateLimit: cfg, clients: make(map[string]*clientLimit)}
+}
+
+// acquire counts an upload of the client of the request, throttling its body. It fails with
+// ErrRateLimited if the client has too many uploads in progress, otherwise release must be called
+// when the request is handled.
+func (l *limiter) acquire(req *http.Request) (release func(), err error) {
+	client := l.client(req)
+	maxConcurrent, rate := l.MaxConcurrent, l.BytesPerSecond
+	if l.LimitFunc != nil {
+		maxConcurrent, rate = l.LimitFunc(req, client)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.swept) >= limiterSweep {
+		for k, c := range l.clients {
+			if c.active == 0 && l.refilled(c, now) {
+				delete(l.clients, k)
+			}
+		}
+		l.swept = now
+	}
+	c, ok := l.clients[client]
+	if !ok {
+		c = &clientLimit{tokens: float64(l.burst(rate)), last: now}
+		l.clients[client] = c
+	}
+	if maxConcurrent > 0 && c.active >= maxConcurrent {
+		return nil, fmt.Errorf("%w: more than %d uploads in progress", ErrRateLimited, maxConcurrent)
+	}
+	c.active++
+	c.rate = rate
+	if rate > 0 {
+		req.Body = &throttledBody{ReadCloser: req.Body, ctx: req.Context(), l: l, c: c, rate: rate}
+	}
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if c.active--; c.active == 0 && l.refilled(c, time.Now()) {
+			delete(l.clients, client)
+		}
+	}, nil
+}
+
+// refilled returns true if the token bucket of the client is full, so its state is the same as
+// the one of a new client.
+func (l *limiter) refilled(c *clientLimit, now time.Time) bool {
+	return c.rate <= 0 || c.tokens+now.Sub(c.last).Seconds()*float64(c.rate) >= float64(l.burst(c.rate))
+}
+
+// client returns the identity of the client of the request.
+func (l *limiter) client(req *http.Request) string {
+	if l.ClientFunc != nil {
+		return l.ClientFunc(req)
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// burst returns the size of the token bucket.
+func (l *limiter) burst(rate int64) int64 {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return rate
+}
+
+// take takes n tokens of the bucket of the client, returning how long to wait until they're
+// available.
+func (l *limiter) take(c *clientLimit, n int, rate int64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	c.tokens = min(c.tokens+now.Sub(c.last).Seconds()*float64(rate), float64(l.burst(rate)))
+	c.last = now
+	c.tokens -= float64(n)
+	if c.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-c.tokens / float64(rate) * float64(time.Second))
+}
+
+// throttledBody reads a request body at the rate of its client.
+type throttledBody struct {
+	io.ReadCloser
+	ctx  context.Context
+	l    *limiter
+	c    *clientLimit
+	rate int64
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	if burst := b.l.burst(b.rate); int64(len(p)) > burst {
+		p = p[:burst]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if wait := b.l.take(b.c, n, b.rate); wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-b.ctx.Done():
+			return n, b.ctx.Err()
+		}
+	}
+	return n, err
+}
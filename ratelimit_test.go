@@ -0,0 +1,100 @@
+package mps3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	assert := assert.New(t)
+	wrapper, err := New(Config{Bucket: bucket, Backend: mps3test.NewBackend(), RateLimit: &RateLimit{
+		ClientFunc: func(req *http.Request) string { return req.Header.Get("X-User") },
+		LimitFunc: func(req *http.Request, client string) (int, int64) {
+			if client == "premium" {
+				return 2, 0
+			}
+			return 1, 0
+		},
+	}})
+	assert.NoError(err)
+	started, done := make(chan struct{}), make(chan struct{})
+	handler := wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.FormValue("file_name") == "slow.txt" {
+			started <- struct{}{}
+			<-done
+		}
+	}))
+	upload := func(user, name string) int {
+		req := newFileRequest(t, name, "hello")
+		req.Header.Set("X-User", user)
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	go upload("free", "slow.txt")
+	<-started
+	assert.Equal(http.StatusTooManyRequests, upload("free", "a.txt"))
+	go upload("premium", "slow.txt")
+	<-started
+	assert.Equal(http.StatusOK, upload("premium", "a.txt"))
+	assert.Equal(http.StatusOK, upload("other", "a.txt"))
+	close(done)
+
+	// the state of the clients without a rate is removed with their last upload
+	assert.Eventually(func() bool {
+		wrapper.limiter.mu.Lock()
+		defer wrapper.limiter.mu.Unlock()
+		return len(wrapper.limiter.clients) == 0
+	}, time.Second, time.Millisecond)
+	assert.Equal(http.StatusOK, upload("free", "a.txt"))
+
+	// the bodies are read at the rate of the client
+	wrapper, err = New(Config{Bucket: bucket, Backend: mps3test.NewBackend(), RateLimit: &RateLimit{BytesPerSecond: 1 << 20, Burst: 64 << 10}})
+	assert.NoError(err)
+	start := time.Now()
+	res := httptest.NewRecorder()
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})).
+		ServeHTTP(res, newFileRequest(t, "file.txt", strings.Repeat("a", 320<<10)))
+	assert.Equal(http.StatusOK, res.Code)
+	assert.GreaterOrEqual(time.Since(start), 200*time.Millisecond)
+}
+
+func TestRateLimitSequential(t *testing.T) {
+	assert := assert.New(t)
+	wrapper, err := New(Config{Bucket: bucket, Backend: mps3test.NewBackend(), RateLimit: &RateLimit{
+		ClientFunc:     func(req *http.Request) string { return req.Header.Get("X-User") },
+		BytesPerSecond: 1 << 20,
+		Burst:          256 << 10,
+	}})
+	assert.NoError(err)
+	upload := func(user string) time.Duration {
+		req := newFileRequest(t, "file.txt", strings.Repeat("a", 256<<10))
+		req.Header.Set("X-User", user)
+		start := time.Now()
+		res := httptest.NewRecorder()
+		wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})).ServeHTTP(res, req)
+		assert.Equal(http.StatusOK, res.Code)
+		return time.Since(start)
+	}
+
+	// the first upload takes the burst, the next one waits for the rate
+	assert.Less(upload("a"), 150*time.Millisecond)
+	assert.GreaterOrEqual(upload("a"), 200*time.Millisecond)
+
+	// the clients without uploads are removed once their bucket is full again
+	time.Sleep(300 * time.Millisecond)
+	wrapper.limiter.mu.Lock()
+	wrapper.limiter.swept = time.Time{}
+	wrapper.limiter.mu.Unlock()
+	upload("b")
+	wrapper.limiter.mu.Lock()
+	defer wrapper.limiter.mu.Unlock()
+	assert.NotContains(wrapper.limiter.clients, "a")
+}
@@ -0,0 +1,46 @@
+package mps3
+
+import (
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strconv"
+)
+
+// isRawUpload returns true if the request body is the content of a file instead of a form.
+func isRawUpload(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		return false
+	}
+	if req.Body == nil || req.Body == http.NoBody || req.ContentLength == 0 {
+		return false
+	}
+	ctype, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return ctype != "multipart/form-data" && ctype != "application/x-www-form-urlencoded"
+}
+
+// readRaw uploads the body of the request as a single file.
+func (wr Wrapper) readRaw(req *http.Request, res *result) error {
+	return wr.storeFile(req, rawPart(req, wr.rawField), req.Body, res)
+}
+
+// rawPart returns a part describing the file sent as the request body, with its Content-Length
+// if known. The part has no content.
+func rawPart(req *http.Request, field string) *multipart.Part {
+	name := path.Base(req.URL.Path)
+	if _, params, err := mime.ParseMediaType(req.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		name = params["filename"]
+	}
+	if name == "/" || name == "." {
+		name = "file"
+	}
+
+	part := filePart(field, name, req.Header.Get("Content-Type"))
+	if req.ContentLength > 0 {
+		part.Header.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+	}
+	return part
+}
@@ -0,0 +1,78 @@
+package mps3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultipartPutAndPatch(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, method := range []string{http.MethodPut, http.MethodPatch} {
+		backend := mps3test.NewBackend()
+		wrapper, err := New(Config{Bucket: bucket, Backend: backend})
+		assert.NoError(err)
+
+		req := newFileRequest(t, "notes.txt", "hello")
+		req.Method = method
+		var form url.Values
+		wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			form = req.Form
+		})).ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Equal("notes.txt", form.Get("file_name"), method)
+		assert.Len(backend.Objects(), 1, method)
+	}
+}
+
+func TestRawUploads(t *testing.T) {
+	assert := assert.New(t)
+
+	png, err := os.ReadFile("test_file1.png")
+	assert.NoError(err)
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, RawUploadField: "upload"})
+	assert.NoError(err)
+
+	serve := func(req *http.Request) url.Values {
+		var form url.Values
+		wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			form = req.Form
+		})).ServeHTTP(httptest.NewRecorder(), req)
+		return form
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/files/report%20final.png", strings.NewReader(string(png)))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	form := serve(req)
+	assert.Equal("report final.png", form.Get("upload_name"))
+	assert.Equal("image/png", form.Get("upload_type"))
+	assert.Equal("15716", form.Get("upload_size"))
+	obj, ok := backend.Object(bucket, form.Get("upload"))
+	assert.True(ok)
+	assert.Equal(png, obj.Body)
+
+	req = httptest.NewRequest(http.MethodPost, "/files", strings.NewReader("hello"))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Content-Disposition", `attachment; filename="notes.txt"`)
+	form = serve(req)
+	assert.Equal("notes.txt", form.Get("upload_name"))
+	assert.Equal("5", form.Get("upload_size"))
+
+	// forms and requests without a body are passed through
+	backend.Reset()
+	req = httptest.NewRequest(http.MethodPost, "/files", strings.NewReader("a=b"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	assert.Nil(serve(req))
+	assert.Nil(serve(httptest.NewRequest(http.MethodPut, "/files/empty.txt", nil)))
+	assert.Nil(serve(httptest.NewRequest(http.MethodGet, "/files/report.pdf", strings.NewReader("x"))))
+	assert.Empty(backend.Objects())
+}
@@ -0,0 +1,179 @@
+package mps3
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ReplicaConfig configures the dual-write replication of uploaded files.
+type ReplicaConfig struct {
+	// Backend where the copies are written to, if not specified the main backend is used,
+	// in which case Bucket is required.
+	//
+	// To replicate to a bucket in another region use NewS3Backend with a different S3Config.
+	Backend Backend
+
+	// Bucket name of the bucket to store the copies (default: Config.Bucket)
+	Bucket string
+
+	// Required if true the upload fails when the copy can't be written, otherwise the error
+	// is only logged and the request continues with the primary upload (default: false)
+	Required bool
+}
+
+// replicatedBackend streams each file to two backends at the same time.
+type replicatedBackend struct {
+	primary   Backend
+	secondary Backend
+	bucket    string
+	required  bool
+	logger    *slog.Logger
+}
+
+func newReplicatedBackend(primary Backend, cfg ReplicaConfig, logger *slog.Logger) (*replicatedBackend, error) {
+	if cfg.Backend == nil && cfg.Bucket == "" {
+		return nil, fmt.Errorf("replica bucket or backend is required")
+	}
+	b := replicatedBackend{
+		primary:   primary,
+		secondary: cfg.Backend,
+		bucket:    cfg.Bucket,
+		required:  cfg.Required,
+		logger:    logger,
+	}
+	if b.secondary == nil {
+		b.secondary = primary
+	}
+	return &b, nil
+}
+
+func (b *replicatedBackend) Upload(ctx context.Context, in *s3.PutObjectInput) (*manager.UploadOutput, error) {
+	pr, pw := io.Pipe()
+
+	replicaIn := *in
+	replicaIn.Body = pr
+	if b.bucket != "" {
+		replicaIn.Bucket = aws.String(b.bucket)
+	}
+
+	replicaErr := make(chan error, 1)
+	go func() {
+		_, err := b.secondary.Upload(ctx, &replicaIn)
+		// unblock the primary upload if the replica stopped reading
+		pr.CloseWithError(err)
+		replicaErr <- err
+	}()
+
+	primaryIn := *in
+	primaryIn.Body = io.TeeReader(in.Body, &replicaWriter{w: pw, required: b.required})
+	out, err := b.primary.Upload(ctx, &primaryIn)
+	if err != nil {
+		pw.CloseWithError(err)
+	} else {
+		pw.Close()
+	}
+
+	rerr := <-replicaErr
+	if err != nil {
+		return nil, err
+	}
+	if rerr != nil {
+		if err := b.replicaFailed(in.Key, rerr); err != nil {
+			return nil, b.removePrimary(ctx, in.Bucket, in.Key, in.RequestPayer, err)
+		}
+	}
+	return out, nil
+}
+
+func (b *replicatedBackend) Copy(ctx context.Context, in *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	out, err := b.primary.Copy(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	replicaIn := *in
+	if b.bucket != "" {
+		_, key, err := ParseCopySource(aws.ToString(in.CopySource))
+		if err != nil {
+			return nil, err
+		}
+		replicaIn.CopySource = aws.String(CopySource(b.bucket, key))
+		replicaIn.Bucket = aws.String(b.bucket)
+	}
+	if _, err := b.secondary.Copy(ctx, &replicaIn); err != nil {
+		if err := b.replicaFailed(in.Key, err); err != nil {
+			return nil, b.removePrimary(ctx, in.Bucket, in.Key, in.RequestPayer, err)
+		}
+	}
+	return out, nil
+}
+
+func (b *replicatedBackend) Delete(ctx context.Context, in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	out, err := b.primary.Delete(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	replicaIn := *in
+	if b.bucket != "" {
+		replicaIn.Bucket = aws.String(b.bucket)
+	}
+	if _, err := b.secondary.Delete(ctx, &replicaIn); err != nil {
+		if err := b.replicaFailed(in.Key, err); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (b *replicatedBackend) Head(ctx context.Context, in *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	return b.primary.Head(ctx, in)
+}
+
+// replicaFailed returns the error if the replica is required, otherwise it's only logged.
+func (b *replicatedBackend) replicaFailed(key *string, err error) error {
+	if b.required {
+		return fmt.Errorf("failed to update replica: %w", err)
+	}
+	b.logger.Error("failed to update replica", "key", aws.ToString(key), "error", err)
+	return nil
+}
+
+// removePrimary deletes the object written to the primary backend when the required replica
+// failed, since the caller doesn't know it was written. It's not bound to ctx since the replica
+// usually fails because ctx was canceled.
+func (b *replicatedBackend) removePrimary(ctx context.Context, bucket, key *string, payer types.RequestPayer, err error) error {
+	in := &s3.DeleteObjectInput{Bucket: bucket, Key: key, RequestPayer: payer}
+	if _, derr := b.primary.Delete(context.WithoutCancel(ctx), in); derr != nil {
+		return fmt.Errorf("%w (failed to delete %q: %v)", err, aws.ToString(key), derr)
+	}
+	return err
+}
+
+// replicaWriter writes to the replica pipe, unless the replica is required errors are ignored
+// so a failing replica doesn't interrupt the primary upload.
+type replicaWriter struct {
+	w        io.Writer
+	required bool
+	failed   bool
+}
+
+func (rw *replicaWriter) Write(b []byte) (int, error) {
+	if rw.failed {
+		return len(b), nil
+	}
+	if _, err := rw.w.Write(b); err != nil {
+		if rw.required {
+			return 0, err
+		}
+		rw.failed = true
+	}
+	return len(b), nil
+}
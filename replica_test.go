@@ -0,0 +1,125 @@
+package mps3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingBackend reads part of the body and fails.
+type failingBackend struct{}
+
+func (failingBackend) Upload(_ context.Context, in *s3.PutObjectInput) (*manager.UploadOutput, error) {
+	_, _ = in.Body.Read(make([]byte, 10))
+	return nil, errors.New("upload failed")
+}
+
+func (failingBackend) Copy(context.Context, *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	return nil, errors.New("copy failed")
+}
+
+func (failingBackend) Delete(context.Context, *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	return nil, errors.New("delete failed")
+}
+
+func (failingBackend) Head(context.Context, *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	return nil, errors.New("head failed")
+}
+
+// lateFailingBackend reads the whole body and fails.
+type lateFailingBackend struct{ failingBackend }
+
+func (lateFailingBackend) Upload(_ context.Context, in *s3.PutObjectInput) (*manager.UploadOutput, error) {
+	_, _ = io.Copy(io.Discard, in.Body)
+	return nil, errors.New("upload failed")
+}
+
+func TestReplicaWritesBothBackends(t *testing.T) {
+	assert := assert.New(t)
+
+	primary, secondary := mps3test.NewBackend(), mps3test.NewBackend()
+	wrapper, err := New(Config{
+		Bucket:  bucket,
+		Backend: primary,
+		Replica: &ReplicaConfig{Backend: secondary, Bucket: "replica"},
+	})
+	assert.NoError(err)
+
+	req, err := newRequest(nil, "test_file1.png", "test_file2.txt")
+	assert.NoError(err)
+	res := httptest.NewRecorder()
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for i, name := range []string{"test_file1.png", "test_file2.txt"} {
+			expected, _ := os.ReadFile(name)
+			p, ok := primary.Object(bucket, req.Form["file"][i])
+			assert.True(ok)
+			assert.Equal(expected, p.Body)
+			s, ok := secondary.Object("replica", req.Form["file"][i])
+			assert.True(ok)
+			assert.Equal(expected, s.Body)
+		}
+	})).ServeHTTP(res, req)
+
+	assert.Equal(200, res.Result().StatusCode)
+}
+
+func TestReplicaFailureSemantics(t *testing.T) {
+	for _, required := range []bool{false, true} {
+		primary := mps3test.NewBackend()
+		wrapper, err := New(Config{
+			Bucket:  bucket,
+			Backend: primary,
+			Logger:  log.New(io.Discard, "", 0),
+			Replica: &ReplicaConfig{Backend: failingBackend{}, Required: required},
+		})
+		assert.NoError(t, err)
+
+		req, err := newRequest(nil, "test_file1.png")
+		assert.NoError(t, err)
+		res := httptest.NewRecorder()
+		wrapper.Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(res, req)
+
+		if required {
+			assert.Equal(t, 500, res.Result().StatusCode)
+			assert.Empty(t, primary.Objects())
+		} else {
+			assert.Equal(t, 200, res.Result().StatusCode)
+			assert.Len(t, primary.Objects(), 1)
+		}
+	}
+}
+
+func TestRequiredReplicaFailsLate(t *testing.T) {
+	assert := assert.New(t)
+
+	// the primary object is removed when the replica fails after the primary upload finished
+	primary := mps3test.NewBackend()
+	b, err := newReplicatedBackend(primary, ReplicaConfig{Backend: lateFailingBackend{}, Required: true}, slog.New(slog.DiscardHandler))
+	assert.NoError(err)
+	_, err = b.Upload(context.Background(), &s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String("a.txt"), Body: strings.NewReader("hello")})
+	assert.ErrorContains(err, "upload failed")
+	assert.Empty(primary.Objects())
+
+	// and when copying
+	_, err = primary.Upload(context.Background(), &s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String("a.txt"), Body: strings.NewReader("hello")})
+	assert.NoError(err)
+	_, err = b.Copy(context.Background(), &s3.CopyObjectInput{Bucket: aws.String(bucket), Key: aws.String("b.txt"), CopySource: aws.String(CopySource(bucket, "a.txt"))})
+	assert.ErrorContains(err, "copy failed")
+	_, ok := primary.Object(bucket, "b.txt")
+	assert.False(ok)
+	_, ok = primary.Object(bucket, "a.txt")
+	assert.True(ok)
+}
@@ -0,0 +1,57 @@
+package mps3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+// ErrorInfo is the context of an error reported to Config.OnError.
+type ErrorInfo struct {
+	Request EventRequest
+
+	// Status is the StatusCode of the error, the request failed with it unless Skipped
+	Status int
+
+	// Field and Name of the file that failed, if any
+	Field string
+	Name  string
+
+	// Skipped is true for files that failed while the request continued, see
+	// Config.ContinueOnError
+	Skipped bool
+
+	// Panic is the value the handler panicked with and Stack where, if it did
+	Panic any
+	Stack []byte
+}
+
+// reportError reports the error of a request to Config.OnError, if set.
+func (wr Wrapper) reportError(req *http.Request, err error, info ErrorInfo) {
+	if wr.onError == nil {
+		return
+	}
+	info.Request = wr.eventRequest(req)
+	info.Status = StatusCode(err)
+	var ferr *FileError
+	if errors.As(err, &ferr) {
+		info.Field, info.Name = ferr.Field, ferr.Name
+	}
+	// the reporter usually needs the values of the context even if the client went away
+	wr.onError(context.WithoutCancel(req.Context()), err, info)
+}
+
+// reportPanic reports that the handler panicked with v, except with http.ErrAbortHandler which
+// is used to abort responses on purpose.
+func (wr Wrapper) reportPanic(req *http.Request, v any) {
+	if wr.onError == nil || v == http.ErrAbortHandler {
+		return
+	}
+	err := fmt.Errorf("handler panicked: %v", v)
+	if verr, ok := v.(error); ok {
+		err = fmt.Errorf("handler panicked: %w", verr)
+	}
+	wr.reportError(req, err, ErrorInfo{Panic: v, Stack: debug.Stack()})
+}
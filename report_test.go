@@ -0,0 +1,67 @@
+package mps3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnError(t *testing.T) {
+	assert := assert.New(t)
+
+	type report struct {
+		ctx  context.Context
+		err  error
+		info ErrorInfo
+	}
+	var reports []report
+	onError := func(ctx context.Context, err error, info ErrorInfo) {
+		reports = append(reports, report{ctx, err, info})
+	}
+
+	_, _, res := uploadToMemory(t, Config{OnError: onError, MaxFileSize: 10}, nil, "test_file2.txt")
+	assert.Equal(http.StatusRequestEntityTooLarge, res.Code)
+	assert.Len(reports, 1)
+	assert.ErrorIs(reports[0].err, ErrTooLarge)
+	assert.NoError(reports[0].ctx.Err())
+	assert.Equal(http.StatusRequestEntityTooLarge, reports[0].info.Status)
+	assert.Equal("file", reports[0].info.Field)
+	assert.Equal("test_file2.txt", reports[0].info.Name)
+	assert.Equal(http.MethodPost, reports[0].info.Request.Method)
+	assert.False(reports[0].info.Skipped)
+
+	reports = nil
+	cfg := Config{OnError: onError, AllowedTypes: []string{"image/png"}, ContinueOnError: true}
+	_, _, res = uploadToMemory(t, cfg, nil, "test_file1.png", "test_file2.txt")
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Len(reports, 1)
+	assert.ErrorIs(reports[0].err, ErrUnsupportedType)
+	assert.True(reports[0].info.Skipped)
+	assert.Equal("test_file2.txt", reports[0].info.Name)
+
+	// panics are reported and re-raised
+	reports = nil
+	wrapper, err := New(Config{Bucket: bucket, Backend: mps3test.NewBackend(), OnError: onError, Logger: log.New(io.Discard, "", 0)})
+	assert.NoError(err)
+	boom := errors.New("boom")
+	for _, v := range []any{boom, http.ErrAbortHandler} {
+		req, err := newRequest(nil, "test_file2.txt")
+		assert.NoError(err)
+		handler := wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			panic(v)
+		}))
+		assert.PanicsWithValue(v, func() { handler.ServeHTTP(httptest.NewRecorder(), req) })
+	}
+	assert.Len(reports, 1)
+	assert.ErrorIs(reports[0].err, boom)
+	assert.Equal(boom, reports[0].info.Panic)
+	assert.Contains(string(reports[0].info.Stack), "TestOnError")
+	assert.Equal(http.StatusInternalServerError, reports[0].info.Status)
+}
@@ -0,0 +1,50 @@
+package mps3
+
+import (
+	"maps"
+	"net/http"
+	"strings"
+)
+
+// MetaRequestID is the metadata of the uploaded files with the ID of the request that uploaded
+// them, see Config.RequestIDFunc.
+const MetaRequestID = "mps3-request-id"
+
+// requestIDHeaders are the headers DefaultRequestID looks for, in order.
+var requestIDHeaders = []string{"X-Request-ID", "X-Correlation-ID", "Request-Id", "X-Amzn-Trace-Id"}
+
+// maxRequestID is the maximum length of the request IDs, longer ones are ignored.
+const maxRequestID = 128
+
+// DefaultRequestID returns the ID of the request from the X-Request-ID, X-Correlation-ID,
+// Request-Id or X-Amzn-Trace-Id header, usually set by proxies and load balancers.
+func DefaultRequestID(req *http.Request) string {
+	for _, h := range requestIDHeaders {
+		if id := req.Header.Get(h); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// requestID returns the ID of the request of Config.RequestIDFunc, or an empty string if it has
+// none or it's not a short printable ASCII string, which can't be stored in the metadata.
+func (wr Wrapper) requestID(req *http.Request) string {
+	id := wr.reqID(req)
+	if len(id) > maxRequestID || strings.IndexFunc(id, func(r rune) bool { return r < ' ' || r > '~' }) >= 0 {
+		return ""
+	}
+	return id
+}
+
+// setRequestID adds the ID of the request to the metadata of the object.
+func (wr Wrapper) setRequestID(req *http.Request, metadata map[string]string) map[string]string {
+	id := wr.requestID(req)
+	if id == "" {
+		return metadata
+	}
+	meta := make(map[string]string, len(metadata)+1)
+	maps.Copy(meta, metadata)
+	meta[MetaRequestID] = id
+	return meta
+}
@@ -0,0 +1,49 @@
+package mps3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := mps3test.NewBackend()
+	meta := map[string]string{"owner": "alice"}
+	upload := func(cfg Config, header, id string) map[string]string {
+		cfg.Bucket, cfg.Backend = bucket, backend
+		cfg.MetadataFunc = func(req *http.Request, filename string) map[string]string { return meta }
+		wrapper, err := New(cfg)
+		assert.NoError(err)
+		req := newFileRequest(t, "a.txt", "hello")
+		if header != "" {
+			req.Header.Set(header, id)
+		}
+		var form url.Values
+		wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			form = req.Form
+		})).ServeHTTP(httptest.NewRecorder(), req)
+		obj, ok := backend.Object(bucket, form.Get("file"))
+		assert.True(ok)
+		return obj.Metadata
+	}
+
+	assert.Equal(map[string]string{"owner": "alice", MetaRequestID: "req-1"}, upload(Config{}, "X-Request-ID", "req-1"))
+	assert.Equal("corr-1", upload(Config{}, "X-Correlation-ID", "corr-1")[MetaRequestID])
+	assert.Equal(map[string]string{"owner": "alice"}, upload(Config{}, "", ""))
+	assert.Equal(map[string]string{"owner": "alice"}, meta)
+
+	// IDs that can't be stored in the metadata are ignored
+	assert.NotContains(upload(Config{}, "X-Request-ID", strings.Repeat("a", 200)), MetaRequestID)
+	assert.NotContains(upload(Config{}, "X-Request-ID", "req-é"), MetaRequestID)
+
+	custom := Config{RequestIDFunc: func(req *http.Request) string { return req.Header.Get("X-Trace") }}
+	assert.Equal("trace-1", upload(custom, "X-Trace", "trace-1")[MetaRequestID])
+	assert.NotContains(upload(custom, "X-Request-ID", "req-1"), MetaRequestID)
+}
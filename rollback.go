@@ -0,0 +1,61 @@
+package mps3
+
+import (
+	"context"
+	"net/http"
+)
+
+// rollback deletes the files uploaded by a request that failed and the files extracted from them,
+// unless KeepFilesOnError is set.
+func (wr Wrapper) rollback(req *http.Request, files []UploadedFile) {
+	if wr.keepFiles {
+		return
+	}
+	// the request context is usually canceled already when the client went away
+	ctx := context.WithoutCancel(req.Context())
+	for _, f := range files {
+		if wr.kept(f) {
+			continue
+		}
+		for _, uf := range append(f.entryFiles(), f) {
+			if err := uf.Delete(ctx); err != nil {
+				wr.log(req).ErrorContext(ctx, "failed to rollback upload", "key", uf.Key, "error", err)
+			}
+		}
+	}
+}
+
+// cleanupPanic removes the files uploaded by a request whose handler panicked, or moves them
+// under PanicPrefix.
+func (wr Wrapper) cleanupPanic(req *http.Request, files []UploadedFile) {
+	if wr.panicDir == "" {
+		wr.rollback(req, files)
+		return
+	}
+	ctx := context.WithoutCancel(req.Context())
+	for _, f := range files {
+		if wr.kept(f) {
+			continue
+		}
+		for _, uf := range append(f.entryFiles(), f) {
+			backend, bucket, key, err := uf.location()
+			if err != nil {
+				wr.log(req).ErrorContext(ctx, "failed to move upload of panicked request", "key", uf.Key, "error", err)
+				continue
+			}
+			if err := wr.move(ctx, backend, bucket, key, bucket, prefixKey(wr.panicDir, uf.Key)); err != nil {
+				wr.log(req).ErrorContext(ctx, "failed to move upload of panicked request", "key", uf.Key, "error", err)
+			}
+		}
+	}
+}
+
+// kept returns true if the file isn't removed when its request fails: duplicates and content
+// addressable files without deduplication may be used by other requests, and quarantined files
+// (see ScanQuarantine and Config.Quarantine) are kept to be inspected.
+func (wr Wrapper) kept(f UploadedFile) bool {
+	if f.Duplicate || (wr.contentKeys && !wr.dedup) {
+		return true
+	}
+	return f.Quarantine != "" || (f.Threat != "" && wr.scan != nil && wr.scan.Action == ScanQuarantine)
+}
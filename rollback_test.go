@@ -0,0 +1,65 @@
+package mps3
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRollbackOnError(t *testing.T) {
+	assert := assert.New(t)
+
+	rejectText := func(req *http.Request, f UploadedFile) error {
+		if f.Name == "test_file2.txt" {
+			return ErrUnsupportedType
+		}
+		return nil
+	}
+
+	backend, _, res := uploadToMemory(t, Config{OnUploadStart: rejectText}, nil, "test_file1.png", "test_file2.txt")
+	assert.Equal(http.StatusUnsupportedMediaType, res.Code)
+	assert.Empty(backend.Objects())
+
+	backend, _, res = uploadToMemory(t, Config{OnUploadStart: rejectText, KeepFilesOnError: true}, nil, "test_file1.png", "test_file2.txt")
+	assert.Equal(http.StatusUnsupportedMediaType, res.Code)
+	assert.Len(backend.Objects(), 1)
+
+	// content addressable files may belong to other requests
+	backend, _, res = uploadToMemory(t, Config{OnUploadStart: rejectText, ContentAddressable: true}, nil, "test_file1.png", "test_file2.txt")
+	assert.Equal(http.StatusUnsupportedMediaType, res.Code)
+	assert.Len(backend.Objects(), 1)
+}
+
+func TestCleanupOnPanic(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, prefix := range []string{"", "panicked/"} {
+		backend := mps3test.NewBackend()
+		wrapper, err := New(Config{Bucket: bucket, Backend: backend, PanicPrefix: prefix, Logger: log.New(io.Discard, "", 0)})
+		assert.NoError(err)
+
+		req, err := newRequest(nil, "test_file1.png", "test_file2.txt")
+		assert.NoError(err)
+		var keys []string
+		handler := wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			keys = req.Form["file"]
+			panic("boom")
+		}))
+		assert.PanicsWithValue("boom", func() { handler.ServeHTTP(httptest.NewRecorder(), req) })
+
+		if prefix == "" {
+			assert.Empty(backend.Objects())
+			continue
+		}
+		assert.Len(backend.Objects(), 2)
+		for _, key := range keys {
+			_, ok := backend.Object(bucket, prefixKey(prefix, key))
+			assert.True(ok, key)
+		}
+	}
+}
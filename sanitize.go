@@ -0,0 +1,76 @@
+package mps3
+
+import (
+	"mime"
+	"mime/multipart"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// defaultMaxFilenameLength is the maximum length of file names in bytes, the limit of most file systems.
+const defaultMaxFilenameLength = 255
+
+// SanitizeFilename is the default Config.FilenameSanitizer. It decodes RFC 2047 encoded names
+// ("=?UTF-8?B?...?="), normalizes Unicode to NFC, removes directories, control and invisible
+// formatting characters (like right-to-left overrides), replaces characters reserved by Windows
+// with "_" and trims spaces and dots. Names that end up empty are replaced by "file".
+func SanitizeFilename(name string) string {
+	dec := mime.WordDecoder{}
+	if decoded, err := dec.DecodeHeader(name); err == nil {
+		name = decoded
+	}
+	name = norm.NFC.String(strings.ToValidUTF8(name, ""))
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r):
+			return -1
+		case strings.ContainsRune(`<>:"|?*`, r):
+			return '_'
+		case unicode.IsSpace(r):
+			return ' '
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, " .")
+	if name == "" {
+		return "file"
+	}
+	return name
+}
+
+// truncateFilename shortens the name to max bytes keeping its extension, without splitting
+// UTF-8 characters.
+func truncateFilename(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	ext := filepath.Ext(name)
+	if len(ext) > max/2 {
+		ext = ""
+	}
+	base := name[:max-len(ext)]
+	for !utf8.ValidString(base) {
+		base = base[:len(base)-1]
+	}
+	return strings.TrimRight(base, " .") + ext
+}
+
+// filename returns the sanitized name of the file sent in the part.
+func (wr Wrapper) filename(part *multipart.Part) string {
+	// the raw name is used because part.FileName removes everything up to the last slash, which
+	// is also part of the base64 alphabet of RFC 2047 encoded names. RFC 2231 encoded names
+	// (filename*=UTF-8''...) are decoded by mime.ParseMediaType.
+	name := part.FileName()
+	if _, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		name = params["filename"]
+	}
+	return truncateFilename(wr.sanitize(name), wr.maxNameLen)
+}
@@ -0,0 +1,62 @@
+package mps3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]string{
+		"report.pdf":                       "report.pdf",
+		"../../etc/passwd":                 "passwd",
+		`C:\Users\me\photo.jpg`:            "photo.jpg",
+		"evil\u202egnp.exe":                "evilgnp.exe",
+		"new\nline\x00.txt":                "newline.txt",
+		`what?<is>"this"|*.txt`:            "what__is__this___.txt",
+		"  .hidden. ":                      "hidden",
+		"cafe\u0301.txt":                   "café.txt",
+		"=?UTF-8?B?w6lsw6g/LnR4dA==?=":     "élè_.txt",
+		"=?UTF-8?Q?r=C3=A9sum=C3=A9.pdf?=": "résumé.pdf",
+		"..":                               "file",
+		"":                                 "file",
+	}
+	for in, want := range tests {
+		assert.Equal(want, SanitizeFilename(in), in)
+	}
+
+	assert.Equal("abc.txt", truncateFilename("abc.txt", 10))
+	assert.Equal("abcde.txt", truncateFilename("abcdefghij.txt", 9))
+	assert.Equal("ééé.txt", truncateFilename("éééé.txt", 10))
+	assert.Equal("abcdefgh", truncateFilename("abcdefgh.verylongext", 9))
+}
+
+func TestFilenameConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	wrapper, err := New(Config{Bucket: bucket, Backend: mps3test.NewBackend(), MaxFilenameLength: 12})
+	assert.NoError(err)
+
+	var name string
+	handler := wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name = req.Form.Get("file_name")
+	}))
+
+	// sent as filename*=utf-8''r%C3%A9sum%C3%A9%20final.pdf
+	handler.ServeHTTP(httptest.NewRecorder(), newFileRequest(t, "résumé final.pdf", "hello"))
+	assert.Equal("résumé.pdf", name)
+
+	wrapper, err = New(Config{Bucket: bucket, Backend: mps3test.NewBackend(), FilenameSanitizer: strings.ToUpper})
+	assert.NoError(err)
+	handler = wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name = req.Form.Get("file_name")
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), newFileRequest(t, "notes.txt", "hello"))
+	assert.Equal("NOTES.TXT", name)
+}
@@ -0,0 +1,296 @@
+package mps3
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Actions of ScanConfig.Action on infected files.
+const (
+	// ScanReject deletes the file and fails the request with ErrInfected.
+	ScanReject = "reject"
+	// ScanQuarantine moves the file under ScanConfig.QuarantinePrefix.
+	ScanQuarantine = "quarantine"
+	// ScanTag tags the file with the name of the threat.
+	ScanTag = "tag"
+)
+
+// Scanner scans the content of the uploaded files for malware, e.g. ClamdScanner. Scanners of
+// other services, like ICAP servers, can implement it too.
+type Scanner interface {
+	// Scan reads the content of the file from r while it's uploaded, and returns the name of the
+	// threat found or an empty string if the file is clean. Reading r fails if the upload fails.
+	Scan(ctx context.Context, filename string, r io.Reader) (threat string, err error)
+}
+
+// ScanConfig scans the uploaded files, files that can't be scanned fail the request.
+type ScanConfig struct {
+	Scanner Scanner
+
+	// Action on infected files, ScanReject, ScanQuarantine or ScanTag (default: ScanReject).
+	// Quarantined and tagged files are passed to the handler with the name of the threat, in
+	// UploadedFile.Threat and the `<field>_threat` form value. Quarantined files are kept when
+	// the request fails.
+	Action string
+
+	// QuarantineBucket and QuarantinePrefix where infected files are moved with ScanQuarantine
+	// (default: the bucket of the file and "quarantine/")
+	QuarantineBucket string
+	QuarantinePrefix string
+
+	// TagKey is the tag set to the name of the threat with ScanTag, the backend must implement
+	// Tagger (default: "threat")
+	TagKey string
+}
+
+// Tagger is implemented by backends that can change the tags of stored files.
+type Tagger interface {
+	// PutObjectTagging replaces the tags of the object stored under in.Bucket and in.Key.
+	PutObjectTagging(ctx context.Context, in *s3.PutObjectTaggingInput) (*s3.PutObjectTaggingOutput, error)
+}
+
+func (b *s3Backend) PutObjectTagging(ctx context.Context, in *s3.PutObjectTaggingInput) (*s3.PutObjectTaggingOutput, error) {
+	return b.client.PutObjectTagging(ctx, in)
+}
+
+func (b *replicatedBackend) PutObjectTagging(ctx context.Context, in *s3.PutObjectTaggingInput) (*s3.PutObjectTaggingOutput, error) {
+	t, ok := b.primary.(Tagger)
+	if !ok {
+		return nil, fmt.Errorf("backend doesn't support tagging files")
+	}
+	return t.PutObjectTagging(ctx, in)
+}
+
+// validate checks the settings and sets the default values.
+func (sc *ScanConfig) validate(backend Backend, contentKeys bool) error {
+	if sc.Scanner == nil {
+		return fmt.Errorf("ScanConfig.Scanner is required")
+	}
+	if sc.QuarantinePrefix == "" {
+		sc.QuarantinePrefix = "quarantine/"
+	}
+	if sc.TagKey == "" {
+		sc.TagKey = "threat"
+	}
+	switch sc.Action {
+	case "":
+		sc.Action = ScanReject
+	case ScanReject:
+	case ScanQuarantine:
+		if contentKeys {
+			return fmt.Errorf("ScanQuarantine can't be used with ContentAddressable")
+		}
+	case ScanTag:
+		if _, ok := backend.(Tagger); !ok {
+			return fmt.Errorf("ScanTag requires a backend that implements Tagger")
+		}
+	default:
+		return fmt.Errorf("invalid scan action %q", sc.Action)
+	}
+	return nil
+}
+
+// startScan scans the file while body is read through the returned reader, the name of the threat
+// found is set when the tap finishes.
+func (wr Wrapper) startScan(ctx context.Context, filename string, body io.Reader, threat *string) (*tap, io.Reader) {
+	return newTap(body, func(r io.Reader) error {
+		var err error
+		if *threat, err = wr.scan.Scanner.Scan(ctx, filename, r); err != nil {
+			return fmt.Errorf("failed to scan file: %w", err)
+		}
+		return nil
+	})
+}
+
+// scanStored scans a file that was stored without being streamed through the middleware, e.g.
+// the chunks of ChunkedUploads, and handles it if it's infected.
+func (wr Wrapper) scanStored(req *http.Request, uf UploadedFile) (UploadedFile, error) {
+	if wr.scan == nil {
+		return uf, nil
+	}
+	r, err := uf.Open(req.Context())
+	if err != nil {
+		return uf, fmt.Errorf("failed to scan file: %w", err)
+	}
+	defer r.Close()
+	threat, err := wr.scan.Scanner.Scan(req.Context(), uf.Name, r)
+	if err != nil {
+		wr.rollback(req, []UploadedFile{uf})
+		return uf, fmt.Errorf("failed to scan file: %w", err)
+	}
+	return wr.infected(req, uf, threat)
+}
+
+// infected applies ScanConfig.Action to the file if the threat isn't empty.
+func (wr Wrapper) infected(req *http.Request, uf UploadedFile, threat string) (UploadedFile, error) {
+	if threat == "" {
+		return uf, nil
+	}
+	ctx := context.WithoutCancel(req.Context())
+	switch wr.scan.Action {
+	case ScanQuarantine:
+		backend, bucket, key, err := uf.location()
+		if err != nil {
+			return uf, err
+		}
+		dst := wr.scan.QuarantineBucket
+		if dst == "" {
+			dst = bucket
+		}
+		dstKey := prefixKey(wr.scan.QuarantinePrefix, uf.Key)
+		if err := wr.move(ctx, backend, bucket, key, dst, dstKey); err != nil {
+			wr.rollback(req, []UploadedFile{uf})
+			return uf, fmt.Errorf("failed to quarantine infected file: %w", err)
+		}
+		uf.Bucket, uf.Key, uf.staged = dst, dstKey, nil
+		uf.URL, uf.PublicURL = "", ""
+	case ScanTag:
+		if err := wr.tagFile(ctx, req, uf, wr.scan.TagKey, tagValue(threat)); err != nil {
+			wr.rollback(req, []UploadedFile{uf})
+			return uf, fmt.Errorf("failed to tag infected file: %w", err)
+		}
+	default:
+		if !uf.Duplicate {
+			if err := uf.Delete(ctx); err != nil {
+				wr.log(req).ErrorContext(ctx, "failed to delete infected file", "key", uf.Key, "error", err)
+			}
+		}
+		return uf, fmt.Errorf("%w: %s", ErrInfected, threat)
+	}
+	uf.Threat = threat
+	return uf, nil
+}
+
+// tagFile adds the tag to the tags of Config.TagFunc of the stored file, the backend must implement
+// Tagger.
+func (wr Wrapper) tagFile(ctx context.Context, req *http.Request, uf UploadedFile, key, value string) error {
+	backend, bucket, objKey, err := uf.location()
+	if err != nil {
+		return err
+	}
+	tags := map[string]string{}
+	if wr.tagFunc != nil {
+		for k, v := range wr.tagFunc(req, uf.Name) {
+			tags[k] = v
+		}
+	}
+	tags[key] = value
+	in := &s3.PutObjectTaggingInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(objKey),
+		Tagging:      &types.Tagging{TagSet: tagSet(tags)},
+		RequestPayer: wr.payer(),
+	}
+	_, err = backend.(Tagger).PutObjectTagging(ctx, in)
+	return err
+}
+
+// tagSet returns the tags as a S3 tag set.
+func tagSet(tags map[string]string) []types.Tag {
+	set := make([]types.Tag, 0, len(tags))
+	for k, v := range tags {
+		set = append(set, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	return set
+}
+
+// tagValue replaces the characters not allowed in tag values and truncates the value to the
+// maximum length.
+func tagValue(v string) string {
+	v = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune(" +-=._:/@", r) {
+			return r
+		}
+		return '_'
+	}, v)
+	if len(v) > 256 {
+		v = v[:256]
+	}
+	return v
+}
+
+// ClamdScanner scans files with the INSTREAM command of a ClamAV daemon. The size of the files is
+// limited by the StreamMaxLength setting of clamd, larger files fail to be scanned.
+type ClamdScanner struct {
+	// Network and Address of clamd, e.g. "tcp" and "localhost:3310" or "unix" and
+	// "/run/clamav/clamd.ctl"
+	Network string
+	Address string
+}
+
+// clamdChunkSize is the size of the chunks sent to clamd.
+const clamdChunkSize = 32 << 10
+
+// Scan sends the content to clamd and returns the name of the signature it found, if any.
+func (c ClamdScanner) Scan(ctx context.Context, _ string, r io.Reader) (string, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	werr, rerr := clamdStream(conn, r)
+	if rerr != nil {
+		return "", rerr
+	}
+	// clamd replies early with an error, e.g. when the file is larger than StreamMaxLength
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil {
+		if werr != nil {
+			return "", werr
+		}
+		return "", fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+	reply = strings.TrimSuffix(reply, "\x00")
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return "", werr
+	case strings.HasSuffix(reply, " FOUND"):
+		return strings.TrimSuffix(reply, " FOUND"), nil
+	default:
+		return "", fmt.Errorf("clamd failed to scan file: %s", reply)
+	}
+}
+
+// clamdStream sends the content in INSTREAM chunks, prefixed by their length. It returns the
+// errors sending and reading the content separately, since clamd replies only to the former.
+func clamdStream(w io.Writer, r io.Reader) (sendErr, readErr error) {
+	if _, err := io.WriteString(w, "zINSTREAM\x00"); err != nil {
+		return fmt.Errorf("failed to send file to clamd: %w", err), nil
+	}
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, werr := w.Write(buf[:4+n]); werr != nil {
+				return fmt.Errorf("failed to send file to clamd: %w", werr), nil
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+	}
+	if _, err := w.Write(make([]byte, 4)); err != nil {
+		return fmt.Errorf("failed to send file to clamd: %w", err), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,158 @@
+package mps3
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// eicarScanner finds the EICAR test file.
+type eicarScanner struct{}
+
+func (eicarScanner) Scan(_ context.Context, _ string, r io.Reader) (string, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	if bytes.Contains(content, []byte("EICAR-STANDARD-ANTIVIRUS-TEST-FILE")) {
+		return "Eicar-Signature", nil
+	}
+	return "", nil
+}
+
+type failingScanner struct{}
+
+func (failingScanner) Scan(context.Context, string, io.Reader) (string, error) {
+	return "", errors.New("scanner unavailable")
+}
+
+// scanUpload sends the file through the middleware, it returns the form values received by the
+// handler and the response.
+func scanUpload(t *testing.T, backend *mps3test.Backend, sc ScanConfig, content string) (url.Values, *httptest.ResponseRecorder) {
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, Scan: &sc, Logger: log.New(io.Discard, "", 0)})
+	assert.NoError(t, err)
+	var form url.Values
+	res := httptest.NewRecorder()
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		form = req.Form
+	})).ServeHTTP(res, newFileRequest(t, "file.txt", content))
+	return form, res
+}
+
+func TestScanReject(t *testing.T) {
+	assert := assert.New(t)
+	backend := mps3test.NewBackend()
+
+	form, res := scanUpload(t, backend, ScanConfig{Scanner: eicarScanner{}}, "hello")
+	assert.Equal(http.StatusOK, res.Code)
+	_, ok := backend.Object(bucket, form.Get("file"))
+	assert.True(ok)
+	assert.NotContains(form, "file_threat")
+
+	backend.Reset()
+	_, res = scanUpload(t, backend, ScanConfig{Scanner: eicarScanner{}}, eicar)
+	assert.Equal(http.StatusUnprocessableEntity, res.Code)
+	assert.Empty(backend.Objects())
+
+	// files that can't be scanned aren't trusted
+	_, res = scanUpload(t, backend, ScanConfig{Scanner: failingScanner{}}, "hello")
+	assert.Equal(http.StatusInternalServerError, res.Code)
+	assert.Empty(backend.Objects())
+}
+
+func TestScanQuarantine(t *testing.T) {
+	assert := assert.New(t)
+	backend := mps3test.NewBackend()
+
+	form, res := scanUpload(t, backend, ScanConfig{Scanner: eicarScanner{}, Action: ScanQuarantine}, eicar)
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal("Eicar-Signature", form.Get("file_threat"))
+	assert.True(strings.HasPrefix(form.Get("file"), "quarantine/"))
+	assert.Len(backend.Objects(), 1)
+	obj, ok := backend.Object(bucket, form.Get("file"))
+	assert.True(ok)
+	assert.Equal(eicar, string(obj.Body))
+}
+
+func TestScanTag(t *testing.T) {
+	assert := assert.New(t)
+	backend := mps3test.NewBackend()
+
+	form, res := scanUpload(t, backend, ScanConfig{Scanner: eicarScanner{}, Action: ScanTag}, eicar)
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal("Eicar-Signature", form.Get("file_threat"))
+	obj, ok := backend.Object(bucket, form.Get("file"))
+	assert.True(ok)
+	assert.Equal("threat=Eicar-Signature", aws.ToString(obj.Input.Tagging))
+
+	_, err := New(Config{Bucket: bucket, Backend: NewLocalBackend(t.TempDir()), Scan: &ScanConfig{Scanner: eicarScanner{}, Action: ScanTag}})
+	assert.Error(err)
+}
+
+func TestClamdScanner(t *testing.T) {
+	assert := assert.New(t)
+
+	// a fake clamd that finds the EICAR test file
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				if cmd, err := r.ReadString(0); err != nil || cmd != "zINSTREAM\x00" {
+					return
+				}
+				var content []byte
+				for {
+					var size uint32
+					if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+						return
+					}
+					if size == 0 {
+						break
+					}
+					chunk := make([]byte, size)
+					if _, err := io.ReadFull(r, chunk); err != nil {
+						return
+					}
+					content = append(content, chunk...)
+				}
+				reply := "stream: OK\x00"
+				if bytes.Contains(content, []byte("EICAR")) {
+					reply = "stream: Eicar-Signature FOUND\x00"
+				}
+				_, _ = io.WriteString(conn, reply)
+			}()
+		}
+	}()
+
+	scanner := ClamdScanner{Network: "tcp", Address: ln.Addr().String()}
+	threat, err := scanner.Scan(context.Background(), "file.txt", strings.NewReader(strings.Repeat("a", 100<<10)))
+	assert.NoError(err)
+	assert.Empty(threat)
+	threat, err = scanner.Scan(context.Background(), "file.txt", strings.NewReader(eicar))
+	assert.NoError(err)
+	assert.Equal("Eicar-Signature", threat)
+}
@@ -0,0 +1,338 @@
+package mps3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// sessionStatePrefix is where the default SessionStore stores the sessions.
+const sessionStatePrefix = ".sessions/"
+
+// Session is a file uploaded in parts by several requests, see Wrapper.StartSession. The parts are
+// stored in a multipart upload of the backend and the session in the Config.SessionStore.
+type Session struct {
+	ID string `json:"id"`
+	// Field is the form field reported for the file
+	Field string `json:"field"`
+	// Key and Bucket where the file is stored when the session completes
+	Key         string `json:"key"`
+	Bucket      string `json:"bucket"`
+	Name        string `json:"name"`
+	ContentType string `json:"type"`
+	// UploadID is the ID of the multipart upload
+	UploadID string `json:"upload_id"`
+	// Size is the sum of the sizes of the parts appended by this process, parts appended in
+	// parallel by other processes may be missing until the session completes
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+
+	wr *Wrapper
+}
+
+// SessionStore stores the upload sessions, it must be safe for concurrent use. The default store
+// keeps them as JSON objects under ".sessions/" in Config.Bucket, NewMemorySessionStore keeps them
+// in memory.
+type SessionStore interface {
+	// Load returns the session, or ErrSessionNotFound if it doesn't exist.
+	Load(ctx context.Context, id string) (*Session, error)
+
+	// Save stores the session, replacing it if it exists.
+	Save(ctx context.Context, s *Session) error
+
+	// Delete removes the session, it's not an error if it doesn't exist.
+	Delete(ctx context.Context, id string) error
+}
+
+// StartSession starts the upload of a file that is sent in parts, e.g. by a custom resumable
+// upload protocol. The file is validated and gets its key and bucket like the files sent to the
+// middleware, before its content is known. The parts are added with AppendPart, possibly by other
+// requests after loading the session with Wrapper.Session, and the file is stored by Complete.
+// The backend must implement MultipartUploader, sessions can't be used with Encryption,
+// CustomerKeyFunc, StagingPrefix or ContentAddressable.
+func (wr Wrapper) StartSession(req *http.Request, field, filename, contentType string) (*Session, error) {
+	mu, err := wr.multipart()
+	if err != nil {
+		return nil, err
+	}
+	f := file{name: wr.filename(filePart(field, filename, ""))}
+	if f.ftype = mediaType(contentType); f.ftype == "" {
+		f.ftype = wr.detectType(nil, f.name)
+	}
+	if err := wr.checkBlocked(f.name, nil); err != nil {
+		return nil, err
+	}
+	if err := wr.checkType(field, f.ftype); err != nil {
+		return nil, err
+	}
+	if err := wr.locate(req, field, &f); err != nil {
+		return nil, err
+	}
+
+	in := wr.objectInput(req, f, wr.fieldCfgs[field])
+	out, err := mu.CreateMultipartUpload(req.Context(), createMultipartInput(in))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to create multipart upload: %w", ErrS3Upload, err)
+	}
+	s := &Session{
+		ID:          NewULID(),
+		Field:       field,
+		Key:         f.key,
+		Bucket:      f.bucket,
+		Name:        f.name,
+		ContentType: f.ftype,
+		UploadID:    aws.ToString(out.UploadId),
+		Created:     time.Now().UTC(),
+		wr:          &wr,
+	}
+	if err := wr.sessions.Save(req.Context(), s); err != nil {
+		return nil, errors.Join(err, s.abort(req.Context()))
+	}
+	return s, nil
+}
+
+// Session returns the upload session with the ID, or ErrSessionNotFound if it doesn't exist.
+func (wr Wrapper) Session(ctx context.Context, id string) (*Session, error) {
+	s, err := wr.sessions.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.wr = &wr
+	return s, nil
+}
+
+// AppendPart uploads the part with the number, from 1 to 10000, replacing it if it was already
+// uploaded. Parts can be uploaded in any order and in parallel, all but the last must have at least
+// 5MB. Parts are kept in memory while they're uploaded, they can have at most 100MB. req is passed
+// to Config.QuotaFunc with the size of the part.
+func (s *Session) AppendPart(req *http.Request, number int, r io.Reader) error {
+	wr := s.wr
+	ctx := req.Context()
+	mu, err := wr.multipart()
+	if err != nil {
+		return err
+	}
+	if number < 1 || number > maxChunks {
+		return fmt.Errorf("%w: invalid part number %d", ErrMalformedMultipart, number)
+	}
+	content, err := io.ReadAll(io.LimitReader(r, maxChunkSize+1))
+	if err != nil {
+		return fmt.Errorf("%w: failed to read part: %w", ErrMalformedMultipart, err)
+	}
+	if len(content) > maxChunkSize {
+		return fmt.Errorf("%w: part larger than %d bytes", ErrTooLarge, maxChunkSize)
+	}
+	if limit := wr.sizeLimit(s.Field); limit > 0 && s.Size+int64(len(content)) > limit {
+		return fmt.Errorf("%w: larger than %d bytes", ErrTooLarge, limit)
+	}
+	if err := wr.checkQuota(req, int64(len(content))); err != nil {
+		return err
+	}
+
+	_, err = mu.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:            aws.String(s.Bucket),
+		Key:               aws.String(s.Key),
+		UploadId:          aws.String(s.UploadID),
+		PartNumber:        aws.Int32(int32(number)),
+		Body:              bytes.NewReader(content),
+		ContentLength:     aws.Int64(int64(len(content))),
+		ChecksumAlgorithm: types.ChecksumAlgorithm(wr.checksumAlgo),
+		RequestPayer:      wr.payer(),
+	})
+	if err != nil {
+		return fmt.Errorf("%w: failed to upload part %d: %w", ErrS3Upload, number, err)
+	}
+	s.Size += int64(len(content))
+	return wr.sessions.Save(ctx, s)
+}
+
+// Complete stores the file made of the uploaded parts and removes the session, req is passed to
+// the OnUploadComplete hook. If the file is too large or too small the session is aborted.
+func (s *Session) Complete(req *http.Request) (UploadedFile, error) {
+	wr := s.wr
+	ctx := req.Context()
+	mu, err := wr.multipart()
+	if err != nil {
+		return UploadedFile{}, err
+	}
+	parts, err := listParts(ctx, mu, s.Bucket, s.Key, s.UploadID, wr.payer())
+	if err != nil {
+		return UploadedFile{}, fmt.Errorf("%w: %w", ErrS3Upload, err)
+	}
+	if len(parts) == 0 {
+		return UploadedFile{}, fmt.Errorf("%w: session %q has no parts", ErrMalformedMultipart, s.ID)
+	}
+	f := file{name: s.Name, ftype: s.ContentType, key: s.Key, bucket: s.Bucket}
+	completed := make([]types.CompletedPart, 0, len(parts))
+	for _, p := range parts {
+		f.size += aws.ToInt64(p.Size)
+		completed = append(completed, completedPart(p))
+	}
+
+	var invalid error
+	if limit := wr.sizeLimit(s.Field); limit > 0 && f.size > limit {
+		invalid = fmt.Errorf("%w: larger than %d bytes", ErrTooLarge, limit)
+	} else if f.size < wr.minSize {
+		invalid = fmt.Errorf("%w: smaller than %d bytes", ErrTooSmall, wr.minSize)
+	}
+	if invalid != nil {
+		return UploadedFile{}, errors.Join(invalid, s.Abort(ctx))
+	}
+
+	in := &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.Bucket),
+		Key:             aws.String(s.Key),
+		UploadId:        aws.String(s.UploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+		RequestPayer:    wr.payer(),
+	}
+	if wr.exclusive {
+		in.IfNoneMatch = aws.String("*")
+	}
+	out, err := mu.CompleteMultipartUpload(ctx, in)
+	if err != nil {
+		if isPreconditionFailed(err) {
+			return UploadedFile{}, fmt.Errorf("%w: %q", ErrKeyExists, s.Key)
+		}
+		return UploadedFile{}, fmt.Errorf("%w: failed to complete upload: %w", ErrS3Upload, err)
+	}
+	if err := wr.sessions.Delete(ctx, s.ID); err != nil {
+		wr.log(req).ErrorContext(ctx, "failed to delete upload session", "session", s.ID, "error", err)
+	}
+
+	f.etag = aws.ToString(out.ETag)
+	f.version = aws.ToString(out.VersionId)
+	if wr.presignTTL > 0 {
+		if f.url, err = wr.presign(ctx, f, wr.presignTTL); err != nil {
+			return UploadedFile{}, err
+		}
+	}
+	uf, err := wr.scanStored(req, wr.uploadedFile(s.Field, f))
+	if err != nil {
+		return uf, err
+	}
+	if wr.onComplete != nil {
+		if err := wr.onComplete(req, uf, time.Since(s.Created), nil); err != nil {
+			return uf, fmt.Errorf("file rejected by OnUploadComplete: %w", err)
+		}
+	}
+	return uf, nil
+}
+
+// Abort removes the uploaded parts and the session.
+func (s *Session) Abort(ctx context.Context) error {
+	if err := s.abort(ctx); err != nil {
+		return err
+	}
+	return s.wr.sessions.Delete(ctx, s.ID)
+}
+
+// abort removes the multipart upload of the session.
+func (s *Session) abort(ctx context.Context) error {
+	mu, err := s.wr.multipart()
+	if err != nil {
+		return err
+	}
+	_, err = mu.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+		Bucket:       aws.String(s.Bucket),
+		Key:          aws.String(s.Key),
+		UploadId:     aws.String(s.UploadID),
+		RequestPayer: s.wr.payer(),
+	})
+	var nsu *types.NoSuchUpload
+	if err != nil && !errors.As(err, &nsu) {
+		return fmt.Errorf("failed to abort multipart upload %q: %w", s.UploadID, err)
+	}
+	return nil
+}
+
+// multipart returns the backend as a MultipartUploader.
+func (wr Wrapper) multipart() (MultipartUploader, error) {
+	mu, ok := wr.backend.(MultipartUploader)
+	if !ok {
+		return nil, fmt.Errorf("backend doesn't support multipart uploads")
+	}
+	if wr.encryption != nil || wr.sseKeyFunc != nil || wr.staging != "" || wr.contentKeys {
+		// the parts are stored as they're received, at the key of the file
+		return nil, fmt.Errorf("sessions can't be used with Encryption, CustomerKeyFunc, StagingPrefix or ContentAddressable")
+	}
+	return mu, nil
+}
+
+// objectSessionStore stores the sessions as JSON objects in the backend.
+type objectSessionStore struct {
+	wr     *Wrapper
+	bucket string
+}
+
+func (st objectSessionStore) Load(ctx context.Context, id string) (*Session, error) {
+	if st.bucket == "" {
+		return nil, fmt.Errorf("Config.SessionStore is required when Config.Bucket is not set")
+	}
+	var s Session
+	if err := st.wr.loadState(ctx, st.bucket, sessionStatePrefix+id+".json", &s); err != nil {
+		if errors.Is(err, errNoState) {
+			return nil, fmt.Errorf("%w: %q", ErrSessionNotFound, id)
+		}
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (st objectSessionStore) Save(ctx context.Context, s *Session) error {
+	if st.bucket == "" {
+		return fmt.Errorf("Config.SessionStore is required when Config.Bucket is not set")
+	}
+	return st.wr.saveState(ctx, st.bucket, sessionStatePrefix+s.ID+".json", s, false)
+}
+
+func (st objectSessionStore) Delete(ctx context.Context, id string) error {
+	if st.bucket == "" {
+		return fmt.Errorf("Config.SessionStore is required when Config.Bucket is not set")
+	}
+	return st.wr.deleteState(ctx, st.bucket, sessionStatePrefix+id+".json")
+}
+
+type memorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+// NewMemorySessionStore returns a SessionStore that keeps the sessions in memory, they're lost
+// when the process exits and are not shared by several instances of the application.
+func NewMemorySessionStore() SessionStore {
+	return &memorySessionStore{sessions: make(map[string]Session)}
+}
+
+func (st *memorySessionStore) Load(_ context.Context, id string) (*Session, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s, ok := st.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrSessionNotFound, id)
+	}
+	return &s, nil
+}
+
+func (st *memorySessionStore) Save(_ context.Context, s *Session) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.sessions[s.ID] = *s
+	return nil
+}
+
+func (st *memorySessionStore) Delete(_ context.Context, id string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.sessions, id)
+	return nil
+}
@@ -0,0 +1,98 @@
+package mps3
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSession(t *testing.T) {
+	for _, store := range []SessionStore{nil, NewMemorySessionStore()} {
+		assert := assert.New(t)
+		ctx := context.Background()
+
+		backend := mps3test.NewBackend()
+		wrapper, err := New(Config{Bucket: bucket, Backend: backend, SessionStore: store})
+		assert.NoError(err)
+
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		started, err := wrapper.StartSession(req, "video", "movie.mp4", "video/mp4")
+		assert.NoError(err)
+
+		content := make([]byte, 6<<20)
+		_, _ = rand.Read(content)
+
+		// parts are appended by other requests, in any order
+		session, err := wrapper.Session(ctx, started.ID)
+		assert.NoError(err)
+		assert.NoError(session.AppendPart(req, 2, bytes.NewReader(content[5<<20:])))
+		session, err = wrapper.Session(ctx, started.ID)
+		assert.NoError(err)
+		assert.NoError(session.AppendPart(req, 1, bytes.NewReader(content[:5<<20])))
+		assert.Equal(int64(len(content)), session.Size)
+
+		f, err := session.Complete(req)
+		assert.NoError(err)
+		assert.Equal("video", f.Field)
+		assert.Equal("movie.mp4", f.Name)
+		assert.Equal(int64(len(content)), f.Size)
+		obj, ok := backend.Object(bucket, f.Key)
+		assert.True(ok)
+		assert.Equal(content, obj.Body)
+		assert.Equal("video/mp4", aws.ToString(obj.Input.ContentType))
+
+		_, err = wrapper.Session(ctx, started.ID)
+		assert.ErrorIs(err, ErrSessionNotFound)
+		assert.Equal(http.StatusNotFound, StatusCode(err))
+
+		// aborted sessions remove their parts
+		session, err = wrapper.StartSession(req, "video", "movie.mp4", "video/mp4")
+		assert.NoError(err)
+		assert.NoError(session.AppendPart(req, 1, bytes.NewReader(content)))
+		assert.NoError(session.Abort(ctx))
+		assert.Equal(0, backend.Uploads())
+		assert.Len(backend.Objects(), 1)
+	}
+}
+
+func TestSessionLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, MaxFileSize: 10, AllowedTypes: []string{"text/*"}})
+	assert.NoError(err)
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+
+	_, err = wrapper.StartSession(req, "file", "movie.mp4", "")
+	assert.ErrorIs(err, ErrUnsupportedType)
+
+	session, err := wrapper.StartSession(req, "file", "notes.txt", "")
+	assert.NoError(err)
+	assert.NoError(session.AppendPart(req, 1, bytes.NewReader([]byte("hello"))))
+	assert.ErrorIs(session.AppendPart(req, 2, bytes.NewReader([]byte("world!"))), ErrTooLarge)
+	assert.ErrorIs(session.AppendPart(req, 0, bytes.NewReader([]byte("x"))), ErrMalformedMultipart)
+}
+
+func TestSessionOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	for _, cfg := range []Config{
+		{CustomerKeyFunc: func(*http.Request) ([]byte, error) { return make([]byte, 32), nil }},
+		{StagingPrefix: "staging/"},
+		{ContentAddressable: true},
+	} {
+		cfg.Bucket, cfg.Backend = bucket, mps3test.NewBackend()
+		wrapper, err := New(cfg)
+		assert.NoError(err)
+		_, err = wrapper.StartSession(req, "file", "notes.txt", "")
+		assert.ErrorContains(err, "sessions can't be used")
+	}
+}
@@ -0,0 +1,47 @@
+package mps3
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"slices"
+)
+
+// bucketFor returns the bucket where the file with the given key is stored.
+func (wr Wrapper) bucketFor(req *http.Request, key string) (string, error) {
+	if wr.bucketFunc != nil {
+		bucket, err := wr.bucketFunc(req)
+		if err != nil {
+			return "", fmt.Errorf("failed to select bucket: %w", err)
+		}
+		if bucket == "" {
+			return "", fmt.Errorf("failed to select bucket: empty bucket name")
+		}
+		return bucket, nil
+	}
+	if len(wr.buckets) == 0 {
+		return wr.bucket, nil
+	}
+	return wr.shardFunc(key, wr.buckets), nil
+}
+
+// hashShard is the default ShardFunc.
+func hashShard(key string, buckets []string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return buckets[h.Sum32()%uint32(len(buckets))]
+}
+
+// bucketNames returns all the buckets where files can be stored, except the ones of BucketFunc.
+func bucketNames(cfg Config) []string {
+	names := cfg.Buckets
+	if len(names) == 0 && cfg.Bucket != "" {
+		names = []string{cfg.Bucket}
+	}
+	for _, fc := range cfg.FieldConfigs {
+		if fc.Bucket != "" && !slices.Contains(names, fc.Bucket) {
+			names = append(slices.Clip(names), fc.Bucket)
+		}
+	}
+	return names
+}
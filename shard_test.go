@@ -0,0 +1,85 @@
+package mps3
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadToShardedBuckets(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{
+		Buckets: []string{"a", "b", "c"},
+		Backend: backend,
+	})
+	assert.NoError(err)
+
+	req, err := newRequest(nil, "test_file1.png", "test_file2.txt")
+	assert.NoError(err)
+	res := httptest.NewRecorder()
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for i, key := range req.Form["file"] {
+			b := req.Form["file_bucket"][i]
+			assert.Equal(hashShard(key, []string{"a", "b", "c"}), b)
+			_, ok := backend.Object(b, key)
+			assert.True(ok)
+		}
+	})).ServeHTTP(res, req)
+
+	assert.Equal(200, res.Result().StatusCode)
+}
+
+func TestHashShardIsStable(t *testing.T) {
+	buckets := []string{"a", "b", "c", "d"}
+	seen := map[string]bool{}
+	for _, key := range []string{"k1", "k2", "k3", "k4", "k5", "k6", "k7", "k8"} {
+		b := hashShard(key, buckets)
+		assert.Equal(t, b, hashShard(key, buckets))
+		seen[b] = true
+	}
+	assert.Greater(t, len(seen), 1)
+}
+
+func TestBucketFunc(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := Config{
+		BucketFunc: func(req *http.Request) (string, error) {
+			return "tenant-" + req.Header.Get("X-Tenant"), nil
+		},
+	}
+	backend := mps3test.NewBackend()
+	cfg.Backend = backend
+	wrapper, err := New(cfg)
+	assert.NoError(err)
+
+	req, err := newRequest(nil, "test_file2.txt")
+	assert.NoError(err)
+	req.Header.Set("X-Tenant", "a")
+	res := httptest.NewRecorder()
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal("tenant-a", req.Form.Get("file_bucket"))
+		_, ok := backend.Object("tenant-a", req.Form.Get("file"))
+		assert.True(ok)
+	})).ServeHTTP(res, req)
+	assert.Equal(200, res.Code)
+}
+
+func TestBucketFuncError(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := Config{
+		BucketFunc: func(*http.Request) (string, error) {
+			return "", errors.New("unknown tenant")
+		},
+	}
+	backend, _, res := uploadToMemory(t, cfg, nil, "test_file2.txt")
+	assert.Equal(500, res.Code)
+	assert.Empty(backend.Objects())
+}
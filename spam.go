@@ -0,0 +1,31 @@
+package mps3
+
+import (
+	"fmt"
+	"slices"
+)
+
+// readHoneypot rejects the request if a honeypot field has a value, returning false if the field
+// isn't a honeypot. Honeypot fields aren't reported in the form values.
+func (wr Wrapper) readHoneypot(field, value string) (bool, error) {
+	if !slices.Contains(wr.honeypots, field) {
+		return false, nil
+	}
+	if value != "" {
+		return true, fmt.Errorf("%w: honeypot field %q has a value", ErrSpam, field)
+	}
+	return true, nil
+}
+
+// checkSpam calls Config.SpamCheck with the fields read so far, once per request: before its
+// first file or at the end of the form if it has none.
+func (wr Wrapper) checkSpam(res *result) error {
+	if wr.spamCheck == nil || res.spamChecked {
+		return nil
+	}
+	res.spamChecked = true
+	if err := wr.spamCheck(res.form); err != nil {
+		return fmt.Errorf("%w: %w", ErrSpam, err)
+	}
+	return nil
+}
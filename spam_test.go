@@ -0,0 +1,57 @@
+package mps3
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHoneypotFields(t *testing.T) {
+	assert := assert.New(t)
+	cfg := Config{HoneypotFields: []string{"website"}}
+
+	backend, form, res := uploadToMemory(t, cfg, map[string]string{"website": "", "name": "Alice"}, "test_file1.png")
+	assert.Equal(200, res.Code)
+	assert.Len(backend.Objects(), 1)
+	assert.Equal("Alice", form.Get("name"))
+	_, ok := form["website"]
+	assert.False(ok)
+
+	backend, _, res = uploadToMemory(t, cfg, map[string]string{"website": "http://spam.example"}, "test_file1.png")
+	assert.Equal(400, res.Code)
+	assert.Empty(backend.Objects())
+}
+
+func TestSpamCheck(t *testing.T) {
+	assert := assert.New(t)
+	var checked []url.Values
+	cfg := Config{SpamCheck: func(fields url.Values) error {
+		checked = append(checked, fields)
+		if strings.Contains(fields.Get("comment"), "casino") {
+			return errors.New("spam words")
+		}
+		return nil
+	}}
+
+	backend, _, res := uploadToMemory(t, cfg, map[string]string{"comment": "free casino bonus"}, "test_file1.png", "test_file2.txt")
+	assert.Equal(400, res.Code)
+	assert.Empty(backend.Objects())
+	assert.Len(checked, 1)
+
+	// checked once per request, with the fields sent before the first file
+	checked = nil
+	backend, _, res = uploadToMemory(t, cfg, map[string]string{"comment": "my holiday photos"}, "test_file1.png", "test_file2.txt")
+	assert.Equal(200, res.Code)
+	assert.Len(backend.Objects(), 2)
+	assert.Len(checked, 1)
+	assert.Equal("my holiday photos", checked[0].Get("comment"))
+
+	// and at the end of forms without files
+	checked = nil
+	_, _, res = uploadToMemory(t, cfg, map[string]string{"comment": "casino"})
+	assert.Equal(400, res.Code)
+	assert.Len(checked, 1)
+}
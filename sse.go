@@ -0,0 +1,34 @@
+package mps3
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// customerKey is the SSE-C key of a file, needed to read it.
+type customerKey struct {
+	algorithm, key, md5 *string
+}
+
+// setCustomerKey sets the SSE-C parameters of the upload with the key returned by CustomerKeyFunc.
+func (wr Wrapper) setCustomerKey(req *http.Request, in *s3.PutObjectInput, f *file) error {
+	key, err := wr.sseKeyFunc(req)
+	if err != nil {
+		return fmt.Errorf("failed to get customer encryption key: %w", err)
+	}
+	if len(key) != 32 {
+		return fmt.Errorf("customer encryption key must be 32 bytes long, got %d", len(key))
+	}
+
+	sum := md5.Sum(key)
+	in.SSECustomerAlgorithm = aws.String("AES256")
+	in.SSECustomerKey = aws.String(base64.StdEncoding.EncodeToString(key))
+	in.SSECustomerKeyMD5 = aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+	f.sse = customerKey{algorithm: in.SSECustomerAlgorithm, key: in.SSECustomerKey, md5: in.SSECustomerKeyMD5}
+	return nil
+}
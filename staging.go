@@ -0,0 +1,104 @@
+package mps3
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// staged is the location of a file uploaded under Config.StagingPrefix.
+type staged struct {
+	key string
+	// in is the upload of the file, used to keep its settings when it's committed
+	in *s3.PutObjectInput
+}
+
+// stage changes the upload to be stored under the staging prefix.
+func (wr Wrapper) stage(in *s3.PutObjectInput) *staged {
+	key := prefixKey(wr.staging, aws.ToString(in.Key))
+	in.Key = aws.String(key)
+	cp := *in
+	cp.Body = nil
+	return &staged{key: key, in: &cp}
+}
+
+// prefixKey returns the key under the prefix.
+func prefixKey(prefix, key string) string {
+	return strings.TrimSuffix(prefix, "/") + "/" + strings.TrimPrefix(key, "/")
+}
+
+// commit moves the staged files of a request that succeeded to their keys.
+func (wr Wrapper) commit(req *http.Request, files []UploadedFile) {
+	// the files must be moved even if the client went away after the response
+	ctx := context.WithoutCancel(req.Context())
+	for _, f := range files {
+		if err := f.commit(ctx); err != nil {
+			wr.log(req).ErrorContext(ctx, "failed to commit upload", "key", f.Key, "error", err)
+		}
+	}
+}
+
+func (uf UploadedFile) commit(ctx context.Context) error {
+	if uf.staged == nil {
+		return nil
+	}
+	backend, bucket, src, err := uf.location()
+	if err != nil {
+		return err
+	}
+
+	in := *uf.staged.in
+	in.Bucket = aws.String(bucket)
+	in.Key = aws.String(src)
+	if _, err := backend.Copy(ctx, copyInput(&in, bucket, uf.Key)); err != nil {
+		return fmt.Errorf("failed to copy %q to %q: %w", src, uf.Key, err)
+	}
+	din := &s3.DeleteObjectInput{Bucket: in.Bucket, Key: in.Key, RequestPayer: in.RequestPayer}
+	if _, err := backend.Delete(ctx, din); err != nil {
+		return fmt.Errorf("copied %q to %q but failed to delete it: %w", src, uf.Key, err)
+	}
+	return nil
+}
+
+// statusWriter records the status code of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	// informational responses are followed by the final one
+	if sw.status == 0 && code >= 200 {
+		sw.status = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *statusWriter) Flush() {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	_ = http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// success returns true if the handler responded with a 2xx status, a handler that doesn't
+// write anything responds with 200.
+func (sw *statusWriter) success() bool {
+	return sw.status == 0 || (sw.status >= 200 && sw.status < 300)
+}
@@ -0,0 +1,55 @@
+package mps3
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStagingPrefix(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, status := range []int{http.StatusOK, http.StatusCreated, http.StatusBadRequest} {
+		backend := mps3test.NewBackend()
+		wrapper, err := New(Config{Bucket: bucket, Backend: backend, StagingPrefix: "staging/", Logger: log.New(io.Discard, "", 0)})
+		assert.NoError(err)
+
+		req, err := newRequest(nil, "test_file1.png", "test_file2.txt")
+		assert.NoError(err)
+		var keys []string
+		wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			keys = req.Form["file"]
+			// the files are staged until the handler responds
+			for _, o := range backend.Objects() {
+				assert.True(strings.HasPrefix(o.Key, "staging/"), o.Key)
+			}
+			assert.Len(backend.Objects(), 2)
+
+			r, err := FilesFromContext(req.Context())[0].Open(req.Context())
+			assert.NoError(err)
+			r.Close()
+
+			w.WriteHeader(status)
+		})).ServeHTTP(httptest.NewRecorder(), req)
+
+		if status >= 300 {
+			assert.Empty(backend.Objects())
+			continue
+		}
+		assert.Len(backend.Objects(), 2)
+		for _, key := range keys {
+			obj, ok := backend.Object(bucket, key)
+			assert.True(ok, key)
+			assert.NotEmpty(obj.Body)
+		}
+	}
+
+	_, err := New(Config{Bucket: bucket, StagingPrefix: "staging/", ContentAddressable: true})
+	assert.Error(err)
+}
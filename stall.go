@@ -0,0 +1,116 @@
+package mps3
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// stallReader records when the Read in progress started, so the time waiting for the client is
+// measured, not the time the uploader doesn't read (e.g. while all its parts are being sent).
+type stallReader struct {
+	ctx  context.Context
+	r    io.Reader
+	read atomic.Int64
+	// waiting is when the Read in progress started in Unix nanoseconds, zero if there's none
+	waiting atomic.Int64
+	eof     atomic.Bool
+}
+
+func (sr *stallReader) Read(p []byte) (int, error) {
+	sr.waiting.Store(time.Now().UnixNano())
+	n, err := sr.r.Read(p)
+	sr.waiting.Store(0)
+	sr.read.Add(int64(n))
+	if err == io.EOF {
+		sr.eof.Store(true)
+	}
+	if err != nil && err != io.EOF && sr.ctx.Err() != nil {
+		err = context.Cause(sr.ctx)
+	}
+	return n, err
+}
+
+// idle returns how long the Read in progress is waiting for data, zero if the body isn't being
+// read.
+func (sr *stallReader) idle() time.Duration {
+	started := sr.waiting.Load()
+	if started == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, started))
+}
+
+// watchFile returns the request and body used to upload a file, which log a warning when the
+// upload takes longer than Config.SlowUpload or a read of the body waits for data from the client
+// for Config.StallTimeout, aborting the upload if Config.AbortStalled. The returned function stops
+// watching.
+func (wr Wrapper) watchFile(req *http.Request, d *deadlines, field, name string, body io.Reader) (*http.Request, io.Reader, func()) {
+	if wr.slowAfter <= 0 && wr.stallAfter <= 0 {
+		return req, body, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(req.Context())
+	sr := &stallReader{ctx: ctx, r: body}
+	logger := wr.log(req).With("field", field, "filename", name)
+	done, stopped := make(chan struct{}), make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		var slow, stall <-chan time.Time
+		if wr.slowAfter > 0 {
+			t := time.NewTimer(wr.slowAfter)
+			defer t.Stop()
+			slow = t.C
+		}
+		if wr.stallAfter > 0 {
+			t := time.NewTicker(max(wr.stallAfter/4, time.Millisecond))
+			defer t.Stop()
+			stall = t.C
+		}
+		stalled := false
+		for {
+			select {
+			case <-done:
+				return
+			case <-slow:
+				logger.WarnContext(ctx, "slow upload", "duration", wr.slowAfter, "read", sr.read.Load())
+				wr.incr("mps3.files.slow")
+			case <-stall:
+				// only the time waiting for the client counts, the uploader may stop reading
+				// while it sends the parts, or after the body was received
+				idle := sr.idle()
+				if idle < wr.stallAfter || sr.eof.Load() {
+					stalled = false
+					continue
+				}
+				if stalled {
+					continue
+				}
+				stalled = true
+				logger.WarnContext(ctx, "stalled upload", "idle", idle, "read", sr.read.Load(), "abort", wr.abortStall)
+				wr.incr("mps3.files.stalled")
+				if wr.abortStall {
+					cancel(fmt.Errorf("%w: no data received for %s", ErrTimeout, idle.Round(time.Millisecond)))
+					d.abort()
+					return
+				}
+			}
+		}
+	}()
+
+	return req.WithContext(ctx), sr, func() {
+		close(done)
+		<-stopped
+		cancel(nil)
+	}
+}
+
+// incr increments a counter of Config.Metrics, if set.
+func (wr Wrapper) incr(name string) {
+	if wr.metrics != nil {
+		wr.metrics.Incr(name, nil)
+	}
+}
@@ -0,0 +1,134 @@
+package mps3
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+// sleepyBackend waits before uploading.
+type sleepyBackend struct {
+	*mps3test.Backend
+	sleep time.Duration
+}
+
+func (b sleepyBackend) Upload(ctx context.Context, in *s3.PutObjectInput) (*manager.UploadOutput, error) {
+	time.Sleep(b.sleep)
+	return b.Backend.Upload(ctx, in)
+}
+
+// pausingBackend stops reading the body for a while after its first byte.
+type pausingBackend struct {
+	*mps3test.Backend
+	pause time.Duration
+}
+
+func (b pausingBackend) Upload(ctx context.Context, in *s3.PutObjectInput) (*manager.UploadOutput, error) {
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(in.Body, first); err != nil {
+		return nil, err
+	}
+	time.Sleep(b.pause)
+	in.Body = io.MultiReader(bytes.NewReader(first), in.Body)
+	return b.Backend.Upload(ctx, in)
+}
+
+func TestSlowUpload(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	metrics := &recordedMetrics{}
+	cfg := Config{
+		Backend:    sleepyBackend{mps3test.NewBackend(), 100 * time.Millisecond},
+		Slog:       slog.New(slog.NewTextHandler(&buf, nil)),
+		Metrics:    metrics,
+		SlowUpload: 20 * time.Millisecond,
+	}
+	_, _, res := uploadToMemory(t, cfg, nil, "test_file2.txt")
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Contains(buf.String(), `level=WARN msg="slow upload" method=POST path=/ field=file filename=test_file2.txt`)
+	assert.Contains(metrics.lines, "incr mps3.files.slow ")
+
+	buf.Reset()
+	cfg.Backend, cfg.SlowUpload = mps3test.NewBackend(), time.Minute
+	_, _, res = uploadToMemory(t, cfg, nil, "test_file2.txt")
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Empty(buf.String())
+}
+
+func TestStallTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	metrics := &recordedMetrics{}
+	stalled := func(abort bool) (*http.Response, time.Duration, error) {
+		wrapper, err := New(Config{
+			Bucket:       bucket,
+			Backend:      mps3test.NewBackend(),
+			Slog:         slog.New(slog.NewTextHandler(&buf, nil)),
+			Metrics:      metrics,
+			StallTimeout: 50 * time.Millisecond,
+			AbortStalled: abort,
+		})
+		assert.NoError(err)
+		server := httptest.NewServer(wrapper.Wrap(http.NotFoundHandler()))
+		defer server.Close()
+
+		// the client sends part of the body and stops
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		assert.NoError(err)
+		defer conn.Close()
+		_, err = fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: test\r\nContent-Type: multipart/form-data; boundary=b\r\n"+
+			"Content-Length: 1000\r\n\r\n--b\r\nContent-Disposition: form-data; name=\"file\"; filename=\"a.txt\"\r\n\r\nhello")
+		assert.NoError(err)
+		start := time.Now()
+		assert.NoError(conn.SetReadDeadline(start.Add(500 * time.Millisecond)))
+		res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		return res, time.Since(start), err
+	}
+
+	res, d, err := stalled(true)
+	assert.NoError(err)
+	assert.Equal(http.StatusRequestTimeout, res.StatusCode)
+	assert.Less(d, 400*time.Millisecond)
+	assert.Contains(buf.String(), `level=WARN msg="stalled upload" method=POST path=/ field=file filename=a.txt`)
+	assert.Contains(buf.String(), "abort=true")
+	assert.Contains(metrics.lines, "incr mps3.files.stalled ")
+
+	// without AbortStalled the request keeps waiting
+	buf.Reset()
+	_, _, err = stalled(false)
+	assert.ErrorIs(err, os.ErrDeadlineExceeded)
+	assert.Contains(buf.String(), "abort=false")
+}
+
+func TestStallTimeoutBackendPause(t *testing.T) {
+	assert := assert.New(t)
+
+	// a backend that stops reading isn't a stalled client
+	var buf bytes.Buffer
+	cfg := Config{
+		Backend:      pausingBackend{mps3test.NewBackend(), 200 * time.Millisecond},
+		Slog:         slog.New(slog.NewTextHandler(&buf, nil)),
+		StallTimeout: 20 * time.Millisecond,
+		AbortStalled: true,
+	}
+	_, form, res := uploadToMemory(t, cfg, nil, "test_file1.png")
+	assert.Equal(http.StatusOK, res.Code)
+	assert.NotEmpty(form.Get("file"))
+	assert.NotContains(buf.String(), "stalled upload")
+}
@@ -0,0 +1,72 @@
+package mps3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// errNoState is the error of loadState when the state object doesn't exist.
+var errNoState = errors.New("state not found")
+
+// loadState decodes the JSON state object of uploads sent in several requests, like the ones of
+// TusHandler and ChunkedUploads.
+func (wr Wrapper) loadState(ctx context.Context, bucket, key string, v any) error {
+	getter, ok := wr.backend.(Getter)
+	if !ok {
+		return fmt.Errorf("backend doesn't support reading files")
+	}
+	out, err := getter.Get(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return errNoState
+		}
+		return fmt.Errorf("failed to read state %q: %w", key, err)
+	}
+	defer out.Body.Close()
+	if err := json.NewDecoder(out.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode state %q: %w", key, err)
+	}
+	return nil
+}
+
+// saveState stores v as a JSON state object, if exclusive it fails with ErrKeyExists if the
+// object already exists.
+func (wr Wrapper) saveState(ctx context.Context, bucket, key string, v any, exclusive bool) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode state %q: %w", key, err)
+	}
+	in := &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	}
+	if exclusive {
+		in.IfNoneMatch = aws.String("*")
+	}
+	if _, err := wr.backend.Upload(ctx, in); err != nil {
+		if isPreconditionFailed(err) {
+			return fmt.Errorf("%w: %q", ErrKeyExists, key)
+		}
+		return fmt.Errorf("failed to store state %q: %w", key, err)
+	}
+	return nil
+}
+
+// deleteState removes a state object, it's not an error if it doesn't exist.
+func (wr Wrapper) deleteState(ctx context.Context, bucket, key string) error {
+	_, err := wr.backend.Delete(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return fmt.Errorf("failed to delete state %q: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package mps3
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// stripMetadata returns the content of the file without its EXIF, XMP, IPTC and text metadata,
+// files other than JPEG, PNG and WebP images are returned as is. The metadata is removed while
+// the file is read, without buffering it.
+func stripMetadata(ftype string, r io.Reader) io.Reader {
+	s := &stripReader{r: bufio.NewReader(r)}
+	switch ftype {
+	case "image/jpeg":
+		s.next = (*stripReader).jpegSegment
+	case "image/png":
+		s.next = (*stripReader).pngChunk
+	case "image/webp":
+		s.next = (*stripReader).webpChunk
+	default:
+		return r
+	}
+	return s
+}
+
+// stripReader returns the segments of an image that are kept, parsing them with next.
+type stripReader struct {
+	r *bufio.Reader
+	// next parses the next segment, setting what is returned before parsing again
+	next  func(*stripReader) error
+	err   error
+	start bool
+	// out is returned, followed by copy bytes of r (all the rest if negative) and zeros zero bytes
+	out   []byte
+	copy  int64
+	zeros int64
+}
+
+func (s *stripReader) Read(p []byte) (int, error) {
+	for {
+		switch {
+		case len(p) == 0:
+			return 0, nil
+		case len(s.out) > 0:
+			n := copy(p, s.out)
+			s.out = s.out[n:]
+			return n, nil
+		case s.copy < 0:
+			return s.r.Read(p)
+		case s.copy > 0:
+			if int64(len(p)) > s.copy {
+				p = p[:s.copy]
+			}
+			n, err := s.r.Read(p)
+			s.copy -= int64(n)
+			return n, unexpected(err)
+		case s.zeros > 0:
+			n := int(min(int64(len(p)), s.zeros))
+			clear(p[:n])
+			s.zeros -= int64(n)
+			return n, nil
+		case s.err != nil:
+			return 0, s.err
+		default:
+			s.err = s.next(s)
+		}
+	}
+}
+
+// read reads exactly n bytes, a clean end of the file is io.EOF.
+func (s *stripReader) read(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(s.r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// discard skips n bytes.
+func (s *stripReader) discard(n int64) error {
+	_, err := io.CopyN(io.Discard, s.r, n)
+	return unexpected(err)
+}
+
+// unexpected returns io.ErrUnexpectedEOF if the file ended in the middle of a segment.
+func unexpected(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// signature reads the signature at the start of the file and checks it with valid.
+func (s *stripReader) signature(n int, format string, valid func([]byte) bool) error {
+	s.start = true
+	sig, err := s.read(n)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	if err != nil || !valid(sig) {
+		return fmt.Errorf("%w: invalid %s", ErrUnsupportedType, format)
+	}
+	s.out = sig
+	return nil
+}
+
+// jpegSegment parses a JPEG marker segment, the APP1 (EXIF and XMP), APP3 to APP13 (e.g. IPTC),
+// APP15 and comment segments are removed. APP0 (JFIF), APP2 (ICC profiles) and APP14 (Adobe) are
+// kept since they're needed to display the image. The image data after the first SOS segment is
+// returned as is.
+func (s *stripReader) jpegSegment() error {
+	if !s.start {
+		return s.signature(2, "JPEG", func(b []byte) bool { return b[0] == 0xff && b[1] == 0xd8 })
+	}
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if b != 0xff {
+		return fmt.Errorf("%w: invalid JPEG marker", ErrUnsupportedType)
+	}
+	marker := byte(0xff)
+	// markers can be preceded by fill bytes
+	for marker == 0xff {
+		if marker, err = s.r.ReadByte(); err != nil {
+			return unexpected(err)
+		}
+	}
+	if marker == 0xd8 || marker == 0xd9 || marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+		// markers without a length
+		s.out = []byte{0xff, marker}
+		return nil
+	}
+	size, err := s.read(2)
+	if err != nil {
+		return unexpected(err)
+	}
+	length := int64(binary.BigEndian.Uint16(size)) - 2
+	if length < 0 {
+		return fmt.Errorf("%w: invalid JPEG segment length", ErrUnsupportedType)
+	}
+	if marker == 0xe1 || (marker >= 0xe3 && marker <= 0xed) || marker == 0xef || marker == 0xfe {
+		return s.discard(length)
+	}
+	s.out = []byte{0xff, marker, size[0], size[1]}
+	s.copy = length
+	if marker == 0xda {
+		// the image data follows the start of scan header
+		s.next = (*stripReader).rest
+	}
+	return nil
+}
+
+// pngStripped are the PNG chunks that are removed: EXIF, text and modification time.
+var pngStripped = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+// pngChunk parses a PNG chunk, chunks after IEND are returned as is.
+func (s *stripReader) pngChunk() error {
+	if !s.start {
+		return s.signature(8, "PNG", func(b []byte) bool { return string(b) == "\x89PNG\r\n\x1a\n" })
+	}
+	h, err := s.read(8)
+	if err != nil {
+		return err
+	}
+	// the data is followed by the CRC
+	length := int64(binary.BigEndian.Uint32(h)) + 4
+	typ := string(h[4:])
+	if pngStripped[typ] {
+		return s.discard(length)
+	}
+	s.out = h
+	s.copy = length
+	if typ == "IEND" {
+		s.next = (*stripReader).rest
+	}
+	return nil
+}
+
+// webpChunk parses a chunk of a WebP file. Since the size of the file is in its header, the EXIF
+// and XMP chunks are replaced by unknown chunks of zeros instead of being removed, and their flags
+// are cleared in the VP8X chunk.
+func (s *stripReader) webpChunk() error {
+	if !s.start {
+		return s.signature(12, "WebP", func(b []byte) bool { return string(b[:4]) == "RIFF" && string(b[8:]) == "WEBP" })
+	}
+	h, err := s.read(8)
+	if err != nil {
+		return err
+	}
+	size := int64(binary.LittleEndian.Uint32(h[4:]))
+	// chunks are padded to an even size
+	size += size & 1
+	switch string(h[:4]) {
+	case "EXIF", "XMP ":
+		if err := s.discard(size); err != nil {
+			return err
+		}
+		copy(h, "JUNK")
+		s.out = h
+		s.zeros = size
+	case "VP8X":
+		if size > 64 {
+			return fmt.Errorf("%w: invalid WebP VP8X chunk", ErrUnsupportedType)
+		}
+		data, err := s.read(int(size))
+		if err != nil {
+			return unexpected(err)
+		}
+		if len(data) > 0 {
+			// the EXIF and XMP flags
+			data[0] &^= 0x08 | 0x04
+		}
+		s.out = append(h, data...)
+	default:
+		s.out = h
+		s.copy = size
+	}
+	return nil
+}
+
+// rest returns the rest of the file as is.
+func (s *stripReader) rest() error {
+	s.copy = -1
+	return nil
+}
@@ -0,0 +1,68 @@
+package mps3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/iotest"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStripMetadata(t *testing.T) {
+	assert := assert.New(t)
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	strip := func(ftype string, content []byte) []byte {
+		// read one byte at a time to check the segments can be split anywhere
+		out, err := io.ReadAll(iotest.OneByteReader(stripMetadata(ftype, bytes.NewReader(content))))
+		assert.NoError(err)
+		return out
+	}
+
+	var jpg bytes.Buffer
+	assert.NoError(jpeg.Encode(&jpg, img, nil))
+	exif := append([]byte{0xff, 0xe1, 0x00, 0x0e}, "Exif\x00\x00GPS!!!"...)
+	comment := append([]byte{0xff, 0xfe, 0x00, 0x07}, "hello"...)
+	withExif := append(append(append([]byte{0xff, 0xd8}, exif...), comment...), jpg.Bytes()[2:]...)
+	assert.Equal(jpg.Bytes(), strip("image/jpeg", withExif))
+
+	var pngImg bytes.Buffer
+	assert.NoError(png.Encode(&pngImg, img))
+	// a tEXt chunk after IHDR, which is 25 bytes long after the signature
+	text := []byte("\x00\x00\x00\x05tEXthello\x00\x00\x00\x00")
+	withText := append(append(append([]byte{}, pngImg.Bytes()[:33]...), text...), pngImg.Bytes()[33:]...)
+	assert.Equal(pngImg.Bytes(), strip("image/png", withText))
+
+	webp := []byte("RIFF\x00\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00\x0c\x00\x00\x00\x03\x00\x00\x03\x00\x00" +
+		"VP8L\x05\x00\x00\x00\x2f\x03\xc0\x00\x00\x00" + "EXIF\x03\x00\x00\x00GPS\x00")
+	binary.LittleEndian.PutUint32(webp[4:], uint32(len(webp)-8))
+	stripped := strip("image/webp", webp)
+	assert.Len(stripped, len(webp))
+	assert.Equal(byte(0), stripped[20])
+	assert.Equal("JUNK\x03\x00\x00\x00\x00\x00\x00\x00", string(stripped[len(webp)-12:]))
+	assert.NotContains(string(stripped), "GPS")
+
+	// other files aren't changed
+	assert.Equal("hello", string(strip("text/plain", []byte("hello"))))
+	_, err := io.ReadAll(stripMetadata("image/png", bytes.NewReader([]byte("hello"))))
+	assert.ErrorIs(err, ErrUnsupportedType)
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, StripMetadata: true})
+	assert.NoError(err)
+	res := httptest.NewRecorder()
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		obj, ok := backend.Object(bucket, req.FormValue("file"))
+		assert.True(ok)
+		assert.Equal(jpg.Bytes(), obj.Body)
+		assert.Equal(jpg.Len(), int(FilesFromContext(req.Context())[0].Size))
+	})).ServeHTTP(res, newFileRequest(t, "photo.jpg", string(withExif)))
+	assert.Equal(http.StatusOK, res.Code)
+}
@@ -0,0 +1,156 @@
+package mps3
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// svgDropped are the SVG elements removed with their content, they run scripts or embed
+// documents that can.
+var svgDropped = map[string]bool{
+	"script": true, "foreignobject": true, "iframe": true, "embed": true, "object": true,
+	"handler": true, "listener": true,
+}
+
+// svgURL matches the url() references of attributes and styles, that must be local ("#id").
+var svgURL = regexp.MustCompile(`(?i)url\s*\(\s*['"]?\s*([^'")\s]*)`)
+
+// svgSafeHref matches the links that can be kept: local references and embedded raster images.
+var svgSafeHref = regexp.MustCompile(`(?i)^\s*(#|data:image/(png|jpeg|gif|webp);)`)
+
+// svgSanitizer returns an SVG file without scripts, event handlers and external references,
+// see sanitizeSVG.
+type svgSanitizer struct {
+	dec *xml.Decoder
+	enc *xml.Encoder
+	buf bytes.Buffer
+	// skip is the depth inside a removed element
+	skip  int
+	style bool
+	err   error
+}
+
+// sanitizeSVG returns the content of the SVG file without the elements that run scripts or embed
+// documents, event handler attributes, links other than local references and embedded images,
+// external url() references, comments, processing instructions and the DOCTYPE (so entities
+// can't be declared). The file is sanitized while it's read, files that aren't well formed XML
+// fail with ErrUnsupportedType.
+func sanitizeSVG(r io.Reader) io.Reader {
+	s := &svgSanitizer{dec: xml.NewDecoder(r)}
+	s.enc = xml.NewEncoder(&s.buf)
+	return s
+}
+
+func (s *svgSanitizer) Read(p []byte) (int, error) {
+	for s.buf.Len() == 0 && s.err == nil {
+		s.err = s.next()
+	}
+	if s.buf.Len() > 0 {
+		return s.buf.Read(p)
+	}
+	return 0, s.err
+}
+
+// next sanitizes the next token.
+func (s *svgSanitizer) next() error {
+	tok, err := s.dec.RawToken()
+	if errors.Is(err, io.EOF) {
+		if s.skip > 0 {
+			return fmt.Errorf("%w: invalid SVG: unexpected EOF", ErrUnsupportedType)
+		}
+		return io.EOF
+	}
+	if err != nil {
+		return fmt.Errorf("%w: invalid SVG: %w", ErrUnsupportedType, err)
+	}
+
+	switch t := tok.(type) {
+	case xml.StartElement:
+		if s.skip > 0 || svgDropped[strings.ToLower(t.Name.Local)] || dangerousAnimation(t) {
+			s.skip++
+			return nil
+		}
+		s.style = strings.EqualFold(t.Name.Local, "style")
+		start := xml.StartElement{Name: rawName(t.Name)}
+		for _, a := range t.Attr {
+			if safeSVGAttr(a) {
+				start.Attr = append(start.Attr, xml.Attr{Name: rawName(a.Name), Value: a.Value})
+			}
+		}
+		tok = start
+	case xml.EndElement:
+		if s.skip > 0 {
+			s.skip--
+			return nil
+		}
+		s.style = false
+		tok = xml.EndElement{Name: rawName(t.Name)}
+	case xml.CharData:
+		// styles can import external stylesheets or reference external resources
+		if s.skip > 0 || (s.style && (bytes.Contains(bytes.ToLower(t), []byte("@import")) || externalURL(string(t)))) {
+			return nil
+		}
+	default:
+		// comments, processing instructions and directives
+		return nil
+	}
+	if err := s.enc.EncodeToken(tok); err != nil {
+		return fmt.Errorf("%w: invalid SVG: %v", ErrUnsupportedType, err)
+	}
+	return s.enc.Flush()
+}
+
+// rawName returns the name with its namespace prefix, which is written as is.
+func rawName(n xml.Name) xml.Name {
+	if n.Space == "" {
+		return n
+	}
+	return xml.Name{Local: n.Space + ":" + n.Local}
+}
+
+// safeSVGAttr returns false for event handlers, links other than local references and embedded
+// images, base URLs and external url() references.
+func safeSVGAttr(a xml.Attr) bool {
+	name := strings.ToLower(a.Name.Local)
+	switch {
+	case strings.HasPrefix(name, "on"), strings.EqualFold(a.Name.Space, "xml") && name == "base":
+		return false
+	case name == "href" || name == "src":
+		return svgSafeHref.MatchString(a.Value)
+	}
+	return !externalURL(a.Value)
+}
+
+// dangerousAnimation returns true for animations that change links or event handlers, e.g. to
+// set a javascript: URL.
+func dangerousAnimation(t xml.StartElement) bool {
+	switch strings.ToLower(t.Name.Local) {
+	case "set", "animate":
+	default:
+		return false
+	}
+	for _, a := range t.Attr {
+		if strings.ToLower(a.Name.Local) != "attributename" {
+			continue
+		}
+		v := strings.ToLower(a.Value)
+		v = v[strings.LastIndex(v, ":")+1:]
+		return v == "href" || strings.HasPrefix(v, "on")
+	}
+	return false
+}
+
+// externalURL returns true if the value has url() references that aren't local.
+func externalURL(v string) bool {
+	for _, m := range svgURL.FindAllStringSubmatch(v, -1) {
+		if !strings.HasPrefix(m[1], "#") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package mps3
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeSVG(t *testing.T) {
+	assert := assert.New(t)
+	sanitize := func(svg string) (string, error) {
+		out, err := io.ReadAll(sanitizeSVG(strings.NewReader(svg)))
+		return string(out), err
+	}
+
+	out, err := sanitize(`<?xml version="1.0"?>
+<!DOCTYPE svg [<!ENTITY x "y">]>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" onload="alert(1)">
+<!-- comment --><script>alert(1)</script>
+<style>@import url(https://evil.test/a.css);</style>
+<defs><linearGradient id="g"/></defs>
+<rect fill="url(#g)" width="10" height="10" onclick="alert(1)"/>
+<circle fill="url(https://evil.test/track)" r="1"/>
+<a xlink:href="javascript:alert(1)"><text>link &amp; text</text></a>
+<use href="#g"/><image href="https://evil.test/pixel.png"/>
+<foreignObject><div xmlns="http://www.w3.org/1999/xhtml"><script>alert(1)</script></div></foreignObject>
+<set attributeName="xlink:href" to="javascript:alert(1)"/>
+</svg>`)
+	assert.NoError(err)
+	for _, s := range []string{"alert", "evil", "DOCTYPE", "comment", "script", "foreignObject", "set"} {
+		assert.NotContains(out, s)
+	}
+	for _, s := range []string{`xmlns:xlink="http://www.w3.org/1999/xlink"`, `fill="url(#g)"`, `<use href="#g"></use>`, `<text>link &amp; text</text>`} {
+		assert.Contains(out, s)
+	}
+
+	_, err = sanitize(`<svg><rect></svg>`)
+	assert.ErrorIs(err, ErrUnsupportedType)
+	_, err = sanitize(`<svg>&xxe;</svg>`)
+	assert.ErrorIs(err, ErrUnsupportedType)
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, SanitizeSVG: true})
+	assert.NoError(err)
+	res := httptest.NewRecorder()
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		obj, ok := backend.Object(bucket, req.FormValue("file"))
+		assert.True(ok)
+		assert.Equal(`<svg><rect></rect></svg>`, string(obj.Body))
+		assert.Equal("image/svg+xml", req.FormValue("file_type"))
+	})).ServeHTTP(res, newFileRequest(t, "image.svg", `<svg onload="alert(1)"><rect/></svg>`))
+	assert.Equal(http.StatusOK, res.Code)
+}
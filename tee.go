@@ -0,0 +1,127 @@
+package mps3
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+)
+
+// defaultTeeMemory is the default Config.TeeMemoryLimit.
+const defaultTeeMemory = 10 << 20
+
+// tee copies the content of a file while it's uploaded, to make it available to the handler
+// through req.FormFile.
+type tee struct {
+	field string
+	pw    *io.PipeWriter
+	mw    *multipart.Writer
+	done  chan teeResult
+}
+
+type teeResult struct {
+	form *multipart.Form
+	err  error
+}
+
+// newTee returns a tee of the file of the part, reading body through the returned reader copies
+// the content. The copy is parsed as a form while it's written, so files larger than limit are
+// written only once to a temporary file.
+func newTee(part *multipart.Part, body io.Reader, limit int64) (*tee, io.Reader, error) {
+	pr, pw := io.Pipe()
+	t := &tee{field: part.FormName(), pw: pw, mw: multipart.NewWriter(pw), done: make(chan teeResult, 1)}
+	go func() {
+		form, err := multipart.NewReader(pr, t.mw.Boundary()).ReadForm(limit)
+		// unblock the writer if the form couldn't be read
+		pr.CloseWithError(fmt.Errorf("failed to copy file: %w", err))
+		t.done <- teeResult{form: form, err: err}
+	}()
+
+	w, err := t.mw.CreatePart(part.Header)
+	if err != nil {
+		pw.CloseWithError(err)
+		<-t.done
+		return nil, nil, fmt.Errorf("failed to copy file: %w", err)
+	}
+	return t, io.TeeReader(body, w), nil
+}
+
+// finish returns the copy of the file, or nothing if the upload failed.
+func (t *tee) finish(failed bool) (*multipart.FileHeader, error) {
+	if failed {
+		// the partial copy is removed by ReadForm
+		t.pw.CloseWithError(io.ErrUnexpectedEOF)
+		<-t.done
+		return nil, nil
+	}
+	if err := t.mw.Close(); err != nil {
+		t.pw.CloseWithError(err)
+	} else {
+		t.pw.Close()
+	}
+
+	res := <-t.done
+	if res.err != nil {
+		return nil, fmt.Errorf("failed to copy file: %w", res.err)
+	}
+	if files := res.form.File[t.field]; len(files) > 0 {
+		return files[0], nil
+	}
+	return nil, fmt.Errorf("failed to copy file %q", t.field)
+}
+
+// removeCopy removes the copy of a file that wasn't stored, if any.
+func (wr Wrapper) removeCopy(fh *multipart.FileHeader) {
+	if fh != nil {
+		wr.removeCopies(map[string][]*multipart.FileHeader{"": {fh}})
+	}
+}
+
+// removeCopies removes the temporary files of the copies of files, if any.
+func (wr Wrapper) removeCopies(files map[string][]*multipart.FileHeader) {
+	form := multipart.Form{File: files}
+	if err := form.RemoveAll(); err != nil {
+		wr.logger.Error("failed to remove copies of files", "error", err)
+	}
+}
+
+// tap passes the content of a file to a function while it's uploaded, e.g. to scan it.
+type tap struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+// newTap calls fn with the content of the file read from body through the returned reader. If fn
+// fails the upload fails too, if it returns early the rest of the content is discarded.
+func newTap(body io.Reader, fn func(r io.Reader) error) (*tap, io.Reader) {
+	pr, pw := io.Pipe()
+	t := &tap{pw: pw, done: make(chan error, 1)}
+	go func() {
+		if err := fn(pr); err != nil {
+			// the error is sent before the upload fails, so that finish returns it
+			t.done <- err
+			pr.CloseWithError(err)
+			return
+		}
+		_, _ = io.Copy(io.Discard, pr)
+		t.done <- nil
+	}()
+	return t, io.TeeReader(body, pw)
+}
+
+// finish waits for the function to return and returns its error. If the upload failed the
+// function is stopped, its error is only returned if it caused the upload to fail.
+func (t *tap) finish(failed bool) error {
+	if failed {
+		select {
+		case err := <-t.done:
+			return err
+		default:
+		}
+		t.pw.CloseWithError(errors.New("upload failed"))
+		<-t.done
+		return nil
+	}
+	t.pw.Close()
+	return <-t.done
+}
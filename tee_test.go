@@ -0,0 +1,70 @@
+package mps3
+
+import (
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTeeFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := mps3test.NewBackend()
+	// the png is larger than the limit, so it's copied to a temporary file
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, TeeFiles: true, TeeMemoryLimit: 100})
+	assert.NoError(err)
+
+	req, err := newRequest(nil, "test_file1.png", "test_file2.txt")
+	assert.NoError(err)
+	res := httptest.NewRecorder()
+	var copies []*multipart.FileHeader
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		copies = req.MultipartForm.File["file"]
+		assert.Len(copies, 2)
+		for i, name := range []string{"test_file1.png", "test_file2.txt"} {
+			expected, err := os.ReadFile(name)
+			assert.NoError(err)
+
+			f, err := copies[i].Open()
+			assert.NoError(err)
+			content, _ := io.ReadAll(f)
+			f.Close()
+			assert.Equal(expected, content)
+
+			obj, ok := backend.Object(bucket, copies[i].Header.Get(HeaderKey))
+			assert.True(ok)
+			assert.Equal(expected, obj.Body)
+		}
+	})).ServeHTTP(res, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	// the temporary file is removed after the handler returns
+	_, err = copies[0].Open()
+	assert.Error(err)
+}
+
+func TestTeeFilesFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	// the copy is larger than the limit and the temporary file can't be created
+	t.Setenv("TMPDIR", filepath.Join(t.TempDir(), "missing"))
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, TeeFiles: true, TeeMemoryLimit: 100})
+	assert.NoError(err)
+
+	res := httptest.NewRecorder()
+	wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t.Error("handler called")
+	})).ServeHTTP(res, newFileRequest(t, "a.txt", strings.Repeat("a", 200)))
+	assert.Equal(http.StatusInternalServerError, res.Code)
+	// the file uploaded before the copy failed is removed
+	assert.Empty(backend.Objects())
+}
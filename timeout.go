@@ -0,0 +1,111 @@
+package mps3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// deadlines enforces Config.RequestTimeout and Config.UploadTimeout. The context of the request
+// is canceled when they expire and reading the body fails, so clients that stop sending it don't
+// hold the connection.
+type deadlines struct {
+	rc      *http.ResponseController
+	request time.Time
+	cancel  context.CancelFunc
+	aborted atomic.Bool
+
+	// uploads counts the files that set the read deadline, so a file uploaded concurrently (see
+	// Config.ConcurrentUploads) doesn't restore it while the next one is read
+	mu      sync.Mutex
+	uploads int
+}
+
+// withTimeout returns the request with the context used while the files are uploaded, the
+// deadlines must be stopped before the handler is called. Requests that fail keep the read
+// deadline, so the server doesn't wait for the rest of the body of a stalled client.
+func (wr Wrapper) withTimeout(w http.ResponseWriter, req *http.Request) (*http.Request, *deadlines) {
+	d := &deadlines{rc: http.NewResponseController(w), cancel: func() {}}
+	if wr.reqTimeout <= 0 {
+		return req, d
+	}
+	d.request = time.Now().Add(wr.reqTimeout)
+	ctx, cancel := context.WithDeadline(req.Context(), d.request)
+	d.cancel = cancel
+	d.setRead(d.request)
+	return req.WithContext(ctx), d
+}
+
+// upload returns the request with the context used to upload a file and a function that restores
+// the deadlines of the request.
+func (d *deadlines) upload(req *http.Request, timeout time.Duration) (*http.Request, func()) {
+	if d == nil || timeout <= 0 {
+		return req, func() {}
+	}
+	deadline := time.Now().Add(timeout)
+	if !d.request.IsZero() && d.request.Before(deadline) {
+		deadline = d.request
+	}
+	ctx, cancel := context.WithDeadline(req.Context(), deadline)
+	d.mu.Lock()
+	d.uploads++
+	n := d.uploads
+	d.setRead(deadline)
+	d.mu.Unlock()
+	return req.WithContext(ctx), func() {
+		cancel()
+		d.mu.Lock()
+		if d.uploads == n {
+			d.setRead(d.request)
+		}
+		d.mu.Unlock()
+	}
+}
+
+// stop cancels the context and removes the read deadline.
+func (d *deadlines) stop() {
+	d.cancel()
+	if !d.request.IsZero() {
+		d.setRead(time.Time{})
+	}
+}
+
+// abort makes reading the body fail right away, e.g. when a client stalled, and keeps it failing
+// since the request can't succeed anymore.
+func (d *deadlines) abort() {
+	if d == nil {
+		return
+	}
+	_ = d.rc.SetReadDeadline(time.Now())
+	d.aborted.Store(true)
+}
+
+func (d *deadlines) setRead(t time.Time) {
+	if d.aborted.Load() {
+		return
+	}
+	// not every ResponseWriter supports deadlines, e.g. httptest.ResponseRecorder
+	_ = d.rc.SetReadDeadline(t)
+}
+
+// isTimeout returns true if the error was caused by an expired deadline.
+func isTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// timeoutError returns err with ErrTimeout if it was caused by an expired deadline.
+func timeoutError(err error) error {
+	if err == nil || errors.Is(err, ErrTimeout) || !isTimeout(err) {
+		return err
+	}
+	var ferr *FileError
+	if errors.As(err, &ferr) {
+		return &FileError{Field: ferr.Field, Name: ferr.Name, Err: fmt.Errorf("%w: %w", ErrTimeout, ferr.Err)}
+	}
+	return fmt.Errorf("%w: %w", ErrTimeout, err)
+}
@@ -0,0 +1,81 @@
+package mps3
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowBackend waits until the context is done before uploading.
+type slowBackend struct {
+	*mps3test.Backend
+}
+
+func (b slowBackend) Upload(ctx context.Context, in *s3.PutObjectInput) (*manager.UploadOutput, error) {
+	<-ctx.Done()
+	return nil, fmt.Errorf("upload canceled: %w", ctx.Err())
+}
+
+func TestUploadTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	var handled error
+	cfg := Config{
+		Bucket:        bucket,
+		Backend:       slowBackend{mps3test.NewBackend()},
+		UploadTimeout: 50 * time.Millisecond,
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			handled = err
+			w.WriteHeader(StatusCode(err))
+		},
+	}
+	_, _, res := uploadToMemory(t, cfg, nil, "test_file2.txt")
+	assert.Equal(http.StatusRequestTimeout, res.Code)
+	assert.ErrorIs(handled, ErrTimeout)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	wrapper, err := New(Config{Bucket: bucket, Backend: mps3test.NewBackend(), RequestTimeout: 100 * time.Millisecond})
+	assert.NoError(err)
+	handled := make(chan context.Context, 1)
+	server := httptest.NewServer(wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		handled <- req.Context()
+	})))
+	defer server.Close()
+
+	// the client sends part of the body and stops
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	assert.NoError(err)
+	defer conn.Close()
+	_, err = fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: test\r\nContent-Type: multipart/form-data; boundary=b\r\n"+
+		"Content-Length: 1000\r\n\r\n--b\r\nContent-Disposition: form-data; name=\"file\"; filename=\"a.txt\"\r\n\r\nhello")
+	assert.NoError(err)
+
+	start := time.Now()
+	assert.NoError(conn.SetReadDeadline(time.Now().Add(5 * time.Second)))
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	assert.NoError(err)
+	assert.Equal(http.StatusRequestTimeout, res.StatusCode)
+	assert.Less(time.Since(start), 2*time.Second)
+
+	// requests that finish in time get a context without the deadline
+	req, err := newRequest(nil, "test_file2.txt")
+	assert.NoError(err)
+	res, err = http.Post(server.URL, req.Header.Get("Content-Type"), req.Body)
+	assert.NoError(err)
+	assert.Equal(http.StatusOK, res.StatusCode)
+	_, ok := (<-handled).Deadline()
+	assert.False(ok)
+}
@@ -0,0 +1,191 @@
+package mps3
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// UploadToken is what a signed upload token allows, see TokenConfig. Empty values mean no
+// restriction.
+type UploadToken struct {
+	// Fields are the form fields files can be uploaded in
+	Fields []string
+	// MaxSize is the maximum size of each file in bytes
+	MaxSize int64
+	// AllowedTypes are the content types of the files, wildcards like "image/*" match all the subtypes
+	AllowedTypes []string
+	// ExpiresAt is when the token expires, it's required
+	ExpiresAt time.Time
+}
+
+// tokenClaims are the JWT claims of an UploadToken.
+type tokenClaims struct {
+	Fields  []string `json:"fields,omitempty"`
+	MaxSize int64    `json:"max_size,omitempty"`
+	Types   []string `json:"types,omitempty"`
+	Expires int64    `json:"exp"`
+}
+
+// tokenHeader is the JWT header of the upload tokens, only HMAC SHA-256 signatures are accepted.
+const tokenHeader = `{"alg":"HS256","typ":"JWT"}`
+
+// TokenConfig requires the requests to have a signed upload token, e.g. for public upload
+// endpoints: the application signs a token with SignUploadToken when it renders the upload form
+// and the middleware verifies it before reading any file. Tokens are JWTs signed with HMAC
+// SHA-256 (HS256), so they can also be created by JWT libraries with the claims "exp", "fields",
+// "max_size" and "types".
+type TokenConfig struct {
+	// Secret is the key the tokens are signed with
+	Secret []byte
+
+	// Header is the request header with the token, "X-Upload-Token" if not set. "Authorization"
+	// headers can have the "Bearer" scheme.
+	Header string
+
+	// Field is the form field with the token, "upload_token" if not set. It must be sent before the
+	// files and isn't reported in the form values.
+	Field string
+}
+
+// SignUploadToken returns an upload token allowing what t allows, signed with secret.
+func SignUploadToken(secret []byte, t UploadToken) (string, error) {
+	if t.ExpiresAt.IsZero() {
+		return "", errors.New("upload token expiration is required")
+	}
+	claims, err := json.Marshal(tokenClaims{Fields: t.Fields, MaxSize: t.MaxSize, Types: t.AllowedTypes, Expires: t.ExpiresAt.Unix()})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode upload token: %w", err)
+	}
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString([]byte(tokenHeader)) + "." + enc.EncodeToString(claims)
+	return signed + "." + enc.EncodeToString(tokenSignature(secret, signed)), nil
+}
+
+// verifyToken returns what the token allows if it's signed with secret and isn't expired.
+func verifyToken(secret []byte, token string) (*UploadToken, error) {
+	enc := base64.RawURLEncoding
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+	sig, err := enc.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, tokenSignature(secret, parts[0]+"."+parts[1])) {
+		return nil, fmt.Errorf("%w: invalid signature", ErrInvalidToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if h, err := enc.DecodeString(parts[0]); err != nil || json.Unmarshal(h, &header) != nil || header.Alg != "HS256" {
+		return nil, fmt.Errorf("%w: unsupported algorithm", ErrInvalidToken)
+	}
+	var claims tokenClaims
+	if c, err := enc.DecodeString(parts[1]); err != nil || json.Unmarshal(c, &claims) != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+	expires := time.Unix(claims.Expires, 0)
+	if claims.Expires == 0 || time.Now().After(expires) {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	return &UploadToken{Fields: claims.Fields, MaxSize: claims.MaxSize, AllowedTypes: claims.Types, ExpiresAt: expires}, nil
+}
+
+func tokenSignature(secret []byte, signed string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+type tokenKey struct{}
+
+// tokenState is the upload token of a request, which can be sent in a form field after the
+// request is read.
+type tokenState struct {
+	token *UploadToken
+}
+
+// withToken verifies the upload token of the request header, if any, and returns the request that
+// keeps the verified token.
+func (wr Wrapper) withToken(req *http.Request) (*http.Request, error) {
+	if wr.token == nil {
+		return req, nil
+	}
+	state := &tokenState{}
+	if v := req.Header.Get(wr.token.Header); v != "" {
+		if strings.EqualFold(wr.token.Header, "Authorization") {
+			v = strings.TrimSpace(strings.TrimPrefix(v, "Bearer "))
+		}
+		var err error
+		if state.token, err = verifyToken(wr.token.Secret, v); err != nil {
+			return req, err
+		}
+	}
+	return req.WithContext(context.WithValue(req.Context(), tokenKey{}, state)), nil
+}
+
+// readToken verifies the upload token sent in the form field, returning false if the field isn't
+// the token field.
+func (wr Wrapper) readToken(req *http.Request, field, value string) (bool, error) {
+	state, ok := req.Context().Value(tokenKey{}).(*tokenState)
+	if !ok || field != wr.token.Field {
+		return false, nil
+	}
+	token, err := verifyToken(wr.token.Secret, value)
+	if err != nil {
+		return true, err
+	}
+	state.token = token
+	return true, nil
+}
+
+// uploadToken returns the verified upload token of the request, nil if it isn't required.
+func uploadToken(req *http.Request) *UploadToken {
+	if state, ok := req.Context().Value(tokenKey{}).(*tokenState); ok {
+		return state.token
+	}
+	return nil
+}
+
+// checkToken returns an error if the upload token of the request is required and missing, or
+// doesn't allow files in the field.
+func (wr Wrapper) checkToken(req *http.Request, field string) error {
+	if wr.token == nil {
+		return nil
+	}
+	token := uploadToken(req)
+	switch {
+	case token == nil:
+		return fmt.Errorf("%w: missing upload token", ErrInvalidToken)
+	case len(token.Fields) > 0 && !slices.Contains(token.Fields, field):
+		return fmt.Errorf("%w: the upload token doesn't allow files in %q", ErrUnexpectedField, field)
+	}
+	return nil
+}
+
+// checkTokenType returns ErrUnsupportedType if the upload token of the request doesn't allow
+// files of the type.
+func checkTokenType(req *http.Request, ftype string) error {
+	token := uploadToken(req)
+	if token == nil || len(token.AllowedTypes) == 0 || typeAllowed(token.AllowedTypes, ftype) {
+		return nil
+	}
+	return fmt.Errorf("%w: the upload token doesn't allow %q", ErrUnsupportedType, ftype)
+}
+
+// tokenSizeLimit returns the maximum size of the files of the request, the smaller of limit and
+// the one of the upload token.
+func tokenSizeLimit(req *http.Request, limit int64) int64 {
+	token := uploadToken(req)
+	if token == nil || token.MaxSize <= 0 || (limit > 0 && limit < token.MaxSize) {
+		return limit
+	}
+	return token.MaxSize
+}
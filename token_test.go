@@ -0,0 +1,139 @@
+package mps3
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadToken(t *testing.T) {
+	assert := assert.New(t)
+	secret := []byte("secret")
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, RequireUploadToken: &TokenConfig{Secret: secret}})
+	assert.NoError(err)
+	sign := func(tok UploadToken) string {
+		if tok.ExpiresAt.IsZero() {
+			tok.ExpiresAt = time.Now().Add(time.Minute)
+		}
+		s, err := SignUploadToken(secret, tok)
+		assert.NoError(err)
+		return s
+	}
+	var form map[string][]string
+	upload := func(req *http.Request) int {
+		form = nil
+		res := httptest.NewRecorder()
+		wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			form = req.Form
+		})).ServeHTTP(res, req)
+		return res.Code
+	}
+	withHeader := func(token, name, content string) *http.Request {
+		req := newFileRequest(t, name, content)
+		req.Header.Set("X-Upload-Token", token)
+		return req
+	}
+
+	assert.Equal(http.StatusUnauthorized, upload(newFileRequest(t, "a.txt", "hello")))
+	assert.Equal(http.StatusUnauthorized, upload(withHeader("invalid", "a.txt", "hello")))
+	assert.Equal(http.StatusUnauthorized, upload(withHeader(sign(UploadToken{ExpiresAt: time.Now().Add(-time.Second)}), "a.txt", "hello")))
+	other, err := SignUploadToken([]byte("other"), UploadToken{ExpiresAt: time.Now().Add(time.Minute)})
+	assert.NoError(err)
+	assert.Equal(http.StatusUnauthorized, upload(withHeader(other, "a.txt", "hello")))
+	assert.Empty(backend.Objects())
+
+	assert.Equal(http.StatusOK, upload(withHeader(sign(UploadToken{}), "a.txt", "hello")))
+	assert.Equal(http.StatusBadRequest, upload(withHeader(sign(UploadToken{Fields: []string{"avatar"}}), "a.txt", "hello")))
+	assert.Equal(http.StatusRequestEntityTooLarge, upload(withHeader(sign(UploadToken{MaxSize: 3}), "a.txt", "hello")))
+	assert.Equal(http.StatusUnsupportedMediaType, upload(withHeader(sign(UploadToken{AllowedTypes: []string{"image/*"}}), "a.txt", "hello")))
+
+	// the token can be sent in a field before the files
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+	assert.NoError(mw.WriteField("upload_token", sign(UploadToken{Fields: []string{"file"}})))
+	fw, err := mw.CreateFormFile("file", "a.txt")
+	assert.NoError(err)
+	_, _ = fw.Write([]byte("hello"))
+	assert.NoError(mw.Close())
+	req := httptest.NewRequest(http.MethodPost, "/", buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	assert.Equal(http.StatusOK, upload(req))
+	assert.NotContains(form, "upload_token")
+	assert.NotEmpty(form["file"])
+
+	// tokens are JWTs
+	token := sign(UploadToken{})
+	assert.True(strings.HasPrefix(token, "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9."))
+}
+
+func TestUploadTokenChunksAndTus(t *testing.T) {
+	assert := assert.New(t)
+	secret := []byte("secret")
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, ChunkedUploads: true, RequireUploadToken: &TokenConfig{Secret: secret}})
+	assert.NoError(err)
+	sign := func(tok UploadToken) string {
+		tok.ExpiresAt = time.Now().Add(time.Minute)
+		s, err := SignUploadToken(secret, tok)
+		assert.NoError(err)
+		return s
+	}
+
+	// the chunks of video.mp4
+	chunk := func(token string) int {
+		req := newChunkRequest(t, []byte("hello"), "uploadId", "a", "chunkIndex", "0", "totalChunks", "1")
+		req.Header.Set("X-Upload-Token", token)
+		res := httptest.NewRecorder()
+		wrapper.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})).ServeHTTP(res, req)
+		return res.Code
+	}
+	assert.Equal(http.StatusRequestEntityTooLarge, chunk(sign(UploadToken{MaxSize: 3})))
+	assert.Equal(http.StatusUnsupportedMediaType, chunk(sign(UploadToken{AllowedTypes: []string{"image/png"}})))
+	assert.Empty(backend.Objects())
+	assert.Equal(0, backend.Uploads())
+	assert.Equal(http.StatusOK, chunk(sign(UploadToken{AllowedTypes: []string{"video/*"}})))
+	assert.Len(backend.Objects(), 1)
+
+	handler, err := wrapper.TusHandler(TusOptions{})
+	assert.NoError(err)
+	serve := func(req *http.Request, token string) *httptest.ResponseRecorder {
+		if token != "" {
+			req.Header.Set("X-Upload-Token", token)
+		}
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res
+	}
+	create := func(token string) *httptest.ResponseRecorder {
+		req := newTusRequest(http.MethodPost, "/files", nil)
+		req.Header.Set("Upload-Length", "5")
+		req.Header.Set("Upload-Metadata", "filename dmlkZW8ubXA0")
+		return serve(req, token)
+	}
+	patch := func(location, token string) int {
+		req := newTusRequest(http.MethodPatch, location, []byte("hello"))
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+		req.Header.Set("Upload-Offset", "0")
+		return serve(req, token).Code
+	}
+	assert.Equal(http.StatusUnauthorized, create("").Code)
+	assert.Equal(http.StatusRequestEntityTooLarge, create(sign(UploadToken{MaxSize: 3})).Code)
+	assert.Equal(http.StatusUnsupportedMediaType, create(sign(UploadToken{AllowedTypes: []string{"image/png"}})).Code)
+
+	res := create(sign(UploadToken{}))
+	assert.Equal(http.StatusCreated, res.Code)
+	location := res.Header().Get("Location")
+	assert.Equal(http.StatusUnauthorized, patch(location, ""))
+	assert.Equal(http.StatusRequestEntityTooLarge, patch(location, sign(UploadToken{MaxSize: 3})))
+	assert.Equal(http.StatusUnsupportedMediaType, patch(location, sign(UploadToken{AllowedTypes: []string{"image/png"}})))
+	assert.Equal(http.StatusNoContent, patch(location, sign(UploadToken{AllowedTypes: []string{"video/*"}})))
+	assert.Equal(0, backend.Uploads())
+}
@@ -0,0 +1,169 @@
+package mps3
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/smithy-go/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the spans of the middleware.
+const tracerName = "github.com/gabrielhora/mps3"
+
+// tracerProvider returns Config.TracerProvider, or the global one if it's not set.
+func tracerProvider(cfg Config) trace.TracerProvider {
+	if cfg.TracerProvider != nil {
+		return cfg.TracerProvider
+	}
+	return otel.GetTracerProvider()
+}
+
+// startRequest starts the span of a request, a child of the span of its context or, if there is
+// none, of the trace context sent by the client (with the global propagator).
+func (wr Wrapper) startRequest(req *http.Request) (*http.Request, trace.Span) {
+	ctx := req.Context()
+	kind := trace.SpanKindInternal
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(req.Header))
+		kind = trace.SpanKindServer
+	}
+	ctx, span := wr.tracer.Start(ctx, "mps3.request", trace.WithSpanKind(kind), trace.WithAttributes(
+		attribute.String("http.request.method", req.Method),
+		attribute.String("url.path", req.URL.Path),
+	))
+	if id := wr.requestID(req); id != "" {
+		span.SetAttributes(attribute.String("mps3.request_id", id))
+	}
+	return req.WithContext(ctx), span
+}
+
+// startFile starts the span of the upload of a file, the S3 requests of the upload are its
+// children.
+func (wr Wrapper) startFile(req *http.Request, field, filename string) (*http.Request, trace.Span) {
+	ctx, span := wr.tracer.Start(req.Context(), "mps3.file", trace.WithAttributes(
+		attribute.String("mps3.field", field),
+		attribute.String("mps3.filename", filename),
+	))
+	return req.WithContext(ctx), span
+}
+
+// endFile sets the location, size and type of the uploaded file to its span, or the error.
+func endFile(span trace.Span, uf UploadedFile, err error) {
+	if err != nil {
+		failSpan(span, err)
+		return
+	}
+	span.SetAttributes(
+		attribute.String("aws.s3.bucket", uf.Bucket),
+		attribute.String("aws.s3.key", uf.Key),
+		attribute.Int64("mps3.size", uf.Size),
+		attribute.String("mps3.content_type", uf.ContentType),
+	)
+}
+
+// failSpan records the error in the span.
+func failSpan(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// smithyTracerProvider adapts a TracerProvider to the tracing of the AWS SDK, so its requests are
+// children of the spans of the context.
+type smithyTracerProvider struct {
+	tp trace.TracerProvider
+}
+
+func (p smithyTracerProvider) Tracer(scope string, _ ...tracing.TracerOption) tracing.Tracer {
+	return smithyTracer{p.tp.Tracer(scope)}
+}
+
+type smithyTracer struct {
+	tracer trace.Tracer
+}
+
+func (t smithyTracer) StartSpan(ctx context.Context, name string, opts ...tracing.SpanOption) (context.Context, tracing.Span) {
+	var o tracing.SpanOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(smithyKinds[o.Kind]),
+		trace.WithAttributes(smithyAttributes(o.Properties.Values())...))
+	return ctx, smithySpan{name: name, span: span}
+}
+
+// smithyKinds are the span kinds of the SDK ones.
+var smithyKinds = map[tracing.SpanKind]trace.SpanKind{
+	tracing.SpanKindInternal: trace.SpanKindInternal,
+	tracing.SpanKindClient:   trace.SpanKindClient,
+	tracing.SpanKindServer:   trace.SpanKindServer,
+	tracing.SpanKindProducer: trace.SpanKindProducer,
+	tracing.SpanKindConsumer: trace.SpanKindConsumer,
+}
+
+type smithySpan struct {
+	name string
+	span trace.Span
+}
+
+func (s smithySpan) Name() string {
+	return s.name
+}
+
+func (s smithySpan) Context() tracing.SpanContext {
+	sc := s.span.SpanContext()
+	return tracing.SpanContext{TraceID: sc.TraceID().String(), SpanID: sc.SpanID().String(), IsRemote: sc.IsRemote()}
+}
+
+func (s smithySpan) AddEvent(name string, opts ...tracing.EventOption) {
+	var o tracing.EventOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	s.span.AddEvent(name, trace.WithAttributes(smithyAttributes(o.Properties.Values())...))
+}
+
+func (s smithySpan) SetStatus(status tracing.SpanStatus) {
+	switch status {
+	case tracing.SpanStatusOK:
+		s.span.SetStatus(codes.Ok, "")
+	case tracing.SpanStatusError:
+		s.span.SetStatus(codes.Error, "")
+	}
+}
+
+func (s smithySpan) SetProperty(k, v any) {
+	s.span.SetAttributes(smithyAttributes(map[any]any{k: v})...)
+}
+
+func (s smithySpan) End() {
+	s.span.End()
+}
+
+// smithyAttributes returns the properties of the SDK spans as attributes.
+func smithyAttributes(props map[any]any) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(props))
+	for k, v := range props {
+		key := attribute.Key(fmt.Sprint(k))
+		switch v := v.(type) {
+		case string:
+			attrs = append(attrs, key.String(v))
+		case bool:
+			attrs = append(attrs, key.Bool(v))
+		case int:
+			attrs = append(attrs, key.Int(v))
+		case int64:
+			attrs = append(attrs, key.Int64(v))
+		case float64:
+			attrs = append(attrs, key.Float64(v))
+		default:
+			attrs = append(attrs, key.String(fmt.Sprint(v)))
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,95 @@
+package mps3
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/smithy-go/tracing"
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracing(t *testing.T) {
+	assert := assert.New(t)
+
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	upload := func(cfg Config, content string) []sdktrace.ReadOnlySpan {
+		cfg.Bucket, cfg.Backend, cfg.TracerProvider = bucket, mps3test.NewBackend(), tp
+		wrapper, err := New(cfg)
+		assert.NoError(err)
+		req := newFileRequest(t, "a.txt", content)
+		req.Header.Set("Traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+		wrapper.Wrap(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), req)
+		return recorder.Ended()
+	}
+
+	spans := upload(Config{}, "hello")
+	assert.Len(spans, 2)
+	file, request := spans[0], spans[1]
+	assert.Equal("mps3.request", request.Name())
+	assert.Equal(trace.SpanKindServer, request.SpanKind())
+	assert.Equal("0af7651916cd43dd8448eb211c80319c", request.SpanContext().TraceID().String())
+	assert.Equal("b7ad6b7169203331", request.Parent().SpanID().String())
+
+	assert.Equal("mps3.file", file.Name())
+	assert.Equal(request.SpanContext().SpanID(), file.Parent().SpanID())
+	attrs := attribute.NewSet(file.Attributes()...)
+	for key, want := range map[attribute.Key]attribute.Value{
+		"mps3.field":        attribute.StringValue("file"),
+		"mps3.filename":     attribute.StringValue("a.txt"),
+		"aws.s3.bucket":     attribute.StringValue(bucket),
+		"mps3.size":         attribute.Int64Value(5),
+		"mps3.content_type": attribute.StringValue("text/plain; charset=utf-8"),
+	} {
+		got, _ := attrs.Value(key)
+		assert.Equal(want, got, key)
+	}
+	assert.True(attrs.HasValue("aws.s3.key"))
+
+	spans = upload(Config{MaxFileSize: 2}, "hello")
+	assert.Len(spans, 4)
+	assert.Equal(codes.Error, spans[2].Status().Code)
+	assert.Equal(codes.Error, spans[3].Status().Code)
+}
+
+func TestSmithyTracerProvider(t *testing.T) {
+	assert := assert.New(t)
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+
+	tracer := smithyTracerProvider{tp}.Tracer("s3")
+	_, span := tracer.StartSpan(ctx, "S3.PutObject", func(o *tracing.SpanOptions) {
+		o.Kind = tracing.SpanKindClient
+		o.Properties.Set("rpc.method", "PutObject")
+	})
+	span.SetProperty("http.response.status_code", 200)
+	span.SetStatus(tracing.SpanStatusOK)
+	assert.Equal(parent.SpanContext().TraceID().String(), span.Context().TraceID)
+	span.End()
+
+	spans := recorder.Ended()
+	assert.Len(spans, 1)
+	assert.Equal("S3.PutObject", spans[0].Name())
+	assert.Equal(trace.SpanKindClient, spans[0].SpanKind())
+	assert.Equal(parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.ElementsMatch([]attribute.KeyValue{
+		attribute.String("rpc.method", "PutObject"),
+		attribute.Int("http.response.status_code", 200),
+	}, spans[0].Attributes())
+	assert.Equal(codes.Ok, spans[0].Status().Code)
+}
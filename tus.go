@@ -0,0 +1,659 @@
+package mps3
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// tusVersion is the version of the tus protocol implemented by TusHandler.
+const tusVersion = "1.0.0"
+
+// TusOptions configures Wrapper.TusHandler.
+type TusOptions struct {
+	// Path is the URL path the handler is served at, uploads are created with POST requests to it
+	// and written at `<Path>/<id>` (default: "/files")
+	Path string
+
+	// Field is the form field reported for the files, its FieldConfigs apply (default: "file")
+	Field string
+
+	// StateBucket and StatePrefix are where the state of the uploads is stored (default:
+	// Config.Bucket and ".tus/"). It's not removed when uploads finish so clients can still check
+	// them, a lifecycle rule should expire it.
+	StateBucket string
+	StatePrefix string
+}
+
+// tusUpload is the state of an upload, stored as JSON in `<StatePrefix><id>.info`.
+type tusUpload struct {
+	UploadID string    `json:"upload_id"`
+	Bucket   string    `json:"bucket"`
+	Key      string    `json:"key"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Length   int64     `json:"length"`
+	Metadata string    `json:"metadata,omitempty"`
+	Created  time.Time `json:"created"`
+	Done     bool      `json:"done"`
+}
+
+type tusHandler struct {
+	wr     Wrapper
+	opts   TusOptions
+	parts  MultipartUploader
+	getter Getter
+}
+
+// TusHandler returns a handler of the tus resumable upload protocol 1.0 (https://tus.io) with the
+// creation and termination extensions, so clients can resume large uploads after connection
+// drops. Files are written with multipart uploads, the backend must implement MultipartUploader
+// and Getter.
+//
+// The files get the keys, buckets, settings and hooks of the other uploads, the name and content
+// type are the "filename" and "filetype" values of the Upload-Metadata header. Since the content
+// isn't known when an upload is created, it's validated with them, and the first bytes are checked
+// before the first part is stored (executable content, the type detected from them and
+// RejectTypeMismatch): an upload that fails is aborted. When an upload finishes, the key and
+// bucket of the file are sent in the X-Mps3-Key and X-Mps3-Bucket headers of the response.
+// With Config.RequireUploadToken, the requests creating and appending to uploads must send the
+// token in its header.
+//
+// The rest of the content isn't inspected, so it can't be used with ArchiveLimits, ImageLimits,
+// StripMetadata, SanitizeSVG or PII.FileTypes, nor with ContentAddressable, StagingPrefix,
+// CustomerKeyFunc or Encryption.
+//
+//	tus, err := wrapper.TusHandler(mps3.TusOptions{Path: "/files"})
+//	mux.Handle("/files/", tus)
+func (wr Wrapper) TusHandler(opts TusOptions) (http.Handler, error) {
+	parts, ok := wr.backend.(MultipartUploader)
+	if !ok {
+		return nil, fmt.Errorf("TusHandler requires a backend that implements MultipartUploader")
+	}
+	getter, ok := wr.backend.(Getter)
+	if !ok {
+		return nil, fmt.Errorf("TusHandler requires a backend that implements Getter")
+	}
+	if wr.contentKeys || wr.staging != "" || wr.sseKeyFunc != nil || wr.encryption != nil {
+		return nil, fmt.Errorf("TusHandler can't be used with ContentAddressable, StagingPrefix, CustomerKeyFunc or Encryption")
+	}
+	if wr.archLimits != nil || wr.imgLimits != nil || wr.stripMeta || wr.cleanSVG || (wr.pii != nil && len(wr.pii.FileTypes) > 0) {
+		// the parts are stored as they're received, the whole content isn't inspected
+		return nil, fmt.Errorf("TusHandler can't be used with ArchiveLimits, ImageLimits, StripMetadata, SanitizeSVG or PII.FileTypes")
+	}
+	if opts.StateBucket == "" {
+		opts.StateBucket = wr.bucket
+	}
+	if opts.StateBucket == "" {
+		return nil, fmt.Errorf("TusHandler requires a StateBucket when Config.Bucket is not set")
+	}
+	if opts.StatePrefix == "" {
+		opts.StatePrefix = ".tus/"
+	}
+	if opts.Field == "" {
+		opts.Field = "file"
+	}
+	opts.Path = "/" + strings.Trim(opts.Path, "/")
+	if opts.Path == "/" {
+		opts.Path = "/files"
+	}
+	return &tusHandler{wr: wr, opts: opts, parts: parts, getter: getter}, nil
+}
+
+func (h *tusHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	method := req.Method
+	if m := req.Header.Get("X-HTTP-Method-Override"); m != "" {
+		method = m
+	}
+
+	if method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", "creation,termination")
+		if h.wr.maxSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.wr.maxSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if req.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	if err := h.wr.checkAuthorized(req); err != nil {
+		h.wr.handleError(w, req, err)
+		return
+	}
+	req, err := h.wr.withToken(req)
+	if err != nil {
+		h.wr.handleError(w, req, err)
+		return
+	}
+
+	id, ok := strings.CutPrefix(req.URL.Path, h.opts.Path)
+	if !ok || (id != "" && id[0] != '/') {
+		http.NotFound(w, req)
+		return
+	}
+	id = strings.Trim(id, "/")
+
+	switch {
+	case id == "" && method == http.MethodPost:
+		h.create(w, req)
+	case id == "":
+		w.Header().Set("Allow", "OPTIONS, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	case !validULID(id):
+		http.NotFound(w, req)
+	case method == http.MethodHead:
+		h.head(w, req, id)
+	case method == http.MethodPatch:
+		h.patch(w, req, id)
+	case method == http.MethodDelete:
+		h.terminate(w, req, id)
+	default:
+		w.Header().Set("Allow", "OPTIONS, HEAD, PATCH, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// create starts an upload, the file is validated with what is known before its content is sent.
+func (h *tusHandler) create(w http.ResponseWriter, req *http.Request) {
+	wr := h.wr
+	length, err := strconv.ParseInt(req.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	meta, err := parseTusMetadata(req.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "invalid Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+
+	field := h.opts.Field
+	f := file{name: wr.filename(filePart(field, meta["filename"], ""))}
+	if err := wr.checkToken(req, field); err != nil {
+		wr.handleError(w, req, &FileError{Field: field, Name: f.name, Err: err})
+		return
+	}
+	if limit := tokenSizeLimit(req, wr.sizeLimit(field)); limit > 0 && length > limit {
+		wr.handleError(w, req, &FileError{Field: field, Name: f.name, Err: fmt.Errorf("%w: larger than %d bytes", ErrTooLarge, limit)})
+		return
+	}
+	if length < wr.minSize {
+		wr.handleError(w, req, &FileError{Field: field, Name: f.name, Err: fmt.Errorf("%w: smaller than %d bytes", ErrTooSmall, wr.minSize)})
+		return
+	}
+	if err := wr.checkQuota(req, length); err != nil {
+		wr.handleError(w, req, &FileError{Field: field, Name: f.name, Err: err})
+		return
+	}
+	if f.ftype = mediaType(meta["filetype"]); f.ftype == "" {
+		f.ftype = h.wr.detectType(nil, f.name)
+	}
+	err = wr.checkBlocked(f.name, nil)
+	if err == nil {
+		err = wr.checkType(field, f.ftype)
+	}
+	if err == nil {
+		err = checkTokenType(req, f.ftype)
+	}
+	if err == nil {
+		err = wr.locate(req, field, &f)
+	}
+	if err != nil {
+		wr.handleError(w, req, &FileError{Field: field, Name: f.name, Err: err})
+		return
+	}
+
+	in := wr.objectInput(req, f, wr.fieldCfgs[field])
+	u := tusUpload{
+		Bucket:   f.bucket,
+		Key:      f.key,
+		Name:     f.name,
+		Type:     f.ftype,
+		Length:   length,
+		Metadata: req.Header.Get("Upload-Metadata"),
+		Created:  time.Now().UTC(),
+	}
+	id := NewULID()
+	if length == 0 {
+		// S3 can't complete multipart uploads without parts
+		in.Body = http.NoBody
+		if wr.exclusive {
+			in.IfNoneMatch = aws.String("*")
+		}
+		out, err := wr.backend.Upload(req.Context(), in)
+		if err != nil {
+			wr.handleError(w, req, h.uploadError(f.name, in.Key, err))
+			return
+		}
+		u.Done = true
+		if err := h.save(req.Context(), id, u); err != nil {
+			wr.handleError(w, req, err)
+			return
+		}
+		if err := h.finished(w, req, u, aws.ToString(out.ETag), aws.ToString(out.VersionID)); err != nil {
+			wr.handleError(w, req, err)
+			return
+		}
+	} else {
+		out, err := h.parts.CreateMultipartUpload(req.Context(), createMultipartInput(in))
+		if err != nil {
+			wr.handleError(w, req, &FileError{Field: field, Name: f.name, Err: fmt.Errorf("%w: failed to create multipart upload: %w", ErrS3Upload, err)})
+			return
+		}
+		u.UploadID = aws.ToString(out.UploadId)
+		if err := h.save(req.Context(), id, u); err != nil {
+			wr.handleError(w, req, err)
+			return
+		}
+	}
+
+	w.Header().Set("Location", h.opts.Path+"/"+id)
+	w.Header().Set("Upload-Offset", "0")
+	w.WriteHeader(http.StatusCreated)
+}
+
+// head responds with the offset of the upload, so the client knows where to resume it.
+func (h *tusHandler) head(w http.ResponseWriter, req *http.Request, id string) {
+	u, err := h.load(req.Context(), id)
+	if err != nil {
+		h.fail(w, req, err)
+		return
+	}
+	offset := u.Length
+	if !u.Done {
+		if _, _, offset, err = h.progress(req.Context(), id, u); err != nil {
+			h.fail(w, req, err)
+			return
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	if u.Metadata != "" {
+		w.Header().Set("Upload-Metadata", u.Metadata)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// patch appends the body to the upload. The content is uploaded in parts of Config.PartSize, what
+// doesn't fill a part is kept in `<StatePrefix><id>.part` until the next request.
+func (h *tusHandler) patch(w http.ResponseWriter, req *http.Request, id string) {
+	wr := h.wr
+	if req.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	reqOffset, err := strconv.ParseInt(req.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || reqOffset < 0 {
+		http.Error(w, "invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+	ctx := req.Context()
+	u, err := h.load(ctx, id)
+	if err != nil {
+		h.fail(w, req, err)
+		return
+	}
+	if u.Done {
+		if reqOffset != u.Length {
+			http.Error(w, "Upload-Offset doesn't match the upload", http.StatusConflict)
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.Length, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	// the token of each request must allow the upload, not only the one that created it
+	err = wr.checkToken(req, h.opts.Field)
+	if limit := tokenSizeLimit(req, 0); err == nil && limit > 0 && u.Length > limit {
+		err = fmt.Errorf("%w: larger than %d bytes", ErrTooLarge, limit)
+	}
+	if err == nil {
+		err = checkTokenType(req, u.Type)
+	}
+	if err != nil {
+		wr.handleError(w, req, &FileError{Field: h.opts.Field, Name: u.Name, Err: err})
+		return
+	}
+	parts, pending, offset, err := h.progress(ctx, id, u)
+	if err != nil {
+		h.fail(w, req, err)
+		return
+	}
+	if reqOffset != offset {
+		http.Error(w, "Upload-Offset doesn't match the upload", http.StatusConflict)
+		return
+	}
+	remaining := u.Length - offset
+	if req.ContentLength > remaining {
+		http.Error(w, "the body is larger than the rest of the upload", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	completed := make([]types.CompletedPart, 0, len(parts)+1)
+	for _, p := range parts {
+		completed = append(completed, completedPart(p))
+	}
+	uploaded := offset - int64(len(pending))
+	body := io.MultiReader(bytes.NewReader(pending), io.LimitReader(req.Body, remaining))
+	release, err := wr.memory.reserve(ctx, wr.partSize)
+	if err != nil {
+		wr.handleError(w, req, fmt.Errorf("failed to wait for memory: %w", err))
+		return
+	}
+	defer release()
+	bufp := wr.buffers.get()
+	defer wr.buffers.put(bufp)
+	buf := *bufp
+	var rerr error
+	for rerr == nil {
+		var n int
+		n, rerr = io.ReadFull(body, buf)
+		if n == 0 {
+			break
+		}
+		if n < len(buf) && uploaded+int64(n) < u.Length {
+			// keep what doesn't fill a part, also if the client disconnected, so it can resume from it
+			if err := h.savePending(ctx, id, buf[:n]); err != nil {
+				wr.handleError(w, req, err)
+				return
+			}
+			offset = uploaded + int64(n)
+			break
+		}
+		if len(completed) == 0 {
+			if err := h.checkContent(req, u, buf[:n]); err != nil {
+				if rerr := h.remove(context.WithoutCancel(ctx), id, u); rerr != nil {
+					wr.log(req).ErrorContext(ctx, "failed to remove rejected upload", "id", id, "error", rerr)
+				}
+				wr.handleError(w, req, &FileError{Field: h.opts.Field, Name: u.Name, Err: err})
+				return
+			}
+		}
+		out, err := h.parts.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:            aws.String(u.Bucket),
+			Key:               aws.String(u.Key),
+			UploadId:          aws.String(u.UploadID),
+			PartNumber:        aws.Int32(int32(len(completed) + 1)),
+			Body:              bytes.NewReader(buf[:n]),
+			ContentLength:     aws.Int64(int64(n)),
+			ChecksumAlgorithm: types.ChecksumAlgorithm(wr.checksumAlgo),
+			RequestPayer:      wr.payer(),
+		})
+		if err != nil {
+			wr.handleError(w, req, h.uploadError(u.Name, &u.Key, err))
+			return
+		}
+		completed = append(completed, types.CompletedPart{
+			PartNumber:        aws.Int32(int32(len(completed) + 1)),
+			ETag:              out.ETag,
+			ChecksumCRC32:     out.ChecksumCRC32,
+			ChecksumCRC32C:    out.ChecksumCRC32C,
+			ChecksumCRC64NVME: out.ChecksumCRC64NVME,
+			ChecksumSHA1:      out.ChecksumSHA1,
+			ChecksumSHA256:    out.ChecksumSHA256,
+		})
+		uploaded += int64(n)
+		offset = uploaded
+		if len(pending) > 0 {
+			// the pending content is now part of an uploaded part
+			if err := h.deleteState(ctx, id, ".part"); err != nil {
+				wr.handleError(w, req, err)
+				return
+			}
+			pending = nil
+		}
+	}
+	if rerr != nil && !errors.Is(rerr, io.EOF) && !errors.Is(rerr, io.ErrUnexpectedEOF) {
+		wr.handleError(w, req, fmt.Errorf("%w: %w", ErrClientDisconnected, rerr))
+		return
+	}
+	if offset == u.Length {
+		in := &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(u.Bucket),
+			Key:             aws.String(u.Key),
+			UploadId:        aws.String(u.UploadID),
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+			RequestPayer:    wr.payer(),
+		}
+		if wr.exclusive {
+			in.IfNoneMatch = aws.String("*")
+		}
+		out, err := h.parts.CompleteMultipartUpload(ctx, in)
+		if err != nil {
+			wr.handleError(w, req, h.uploadError(u.Name, in.Key, err))
+			return
+		}
+		u.Done = true
+		if err := h.save(ctx, id, u); err != nil {
+			wr.handleError(w, req, err)
+			return
+		}
+		if err := h.finished(w, req, u, aws.ToString(out.ETag), aws.ToString(out.VersionId)); err != nil {
+			wr.handleError(w, req, err)
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// terminate removes the upload, the file of a finished upload is kept.
+func (h *tusHandler) terminate(w http.ResponseWriter, req *http.Request, id string) {
+	ctx := req.Context()
+	u, err := h.load(ctx, id)
+	if err != nil {
+		h.fail(w, req, err)
+		return
+	}
+	if err := h.remove(ctx, id, u); err != nil {
+		h.wr.handleError(w, req, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// remove aborts the multipart upload, if it's not finished, and deletes the state of the upload.
+func (h *tusHandler) remove(ctx context.Context, id string, u tusUpload) error {
+	if !u.Done {
+		_, err := h.parts.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:       aws.String(u.Bucket),
+			Key:          aws.String(u.Key),
+			UploadId:     aws.String(u.UploadID),
+			RequestPayer: h.wr.payer(),
+		})
+		var nsu *types.NoSuchUpload
+		if err != nil && !errors.As(err, &nsu) {
+			return fmt.Errorf("failed to abort multipart upload %q: %w", u.UploadID, err)
+		}
+	}
+	for _, suffix := range []string{".part", ".info"} {
+		if err := h.deleteState(ctx, id, suffix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkContent validates the first bytes of the upload like the middleware does with the files,
+// since only the declared name and type were known when the upload was created.
+func (h *tusHandler) checkContent(req *http.Request, u tusUpload, head []byte) error {
+	wr := h.wr
+	head = head[:min(len(head), wr.sniffSize)]
+	if err := wr.checkBlocked(u.Name, head); err != nil {
+		return err
+	}
+	if wr.mismatch {
+		if err := checkMismatch(u.Name, u.Type, head); err != nil {
+			return err
+		}
+	}
+	ftype := wr.detectType(head, u.Name)
+	if err := wr.checkType(h.opts.Field, ftype); err != nil {
+		return err
+	}
+	return checkTokenType(req, ftype)
+}
+
+// finished calls the OnUploadComplete hook and sets the location of the file in the response.
+func (h *tusHandler) finished(w http.ResponseWriter, req *http.Request, u tusUpload, etag, version string) error {
+	uf := h.wr.uploadedFile(h.opts.Field, file{
+		name:    u.Name,
+		ftype:   u.Type,
+		key:     u.Key,
+		bucket:  u.Bucket,
+		size:    u.Length,
+		etag:    etag,
+		version: version,
+	})
+	uf, err := h.wr.scanStored(req, uf)
+	if err != nil {
+		return &FileError{Field: uf.Field, Name: uf.Name, Err: err}
+	}
+	if h.wr.onComplete != nil {
+		if err := h.wr.onComplete(req, uf, time.Since(u.Created), nil); err != nil {
+			return &FileError{Field: uf.Field, Name: uf.Name, Err: fmt.Errorf("file rejected by OnUploadComplete: %w", err)}
+		}
+	}
+	w.Header().Set(HeaderKey, uf.Key)
+	w.Header().Set(HeaderBucket, uf.Bucket)
+	return nil
+}
+
+// progress returns the uploaded parts, the content kept until it fills a part and the offset of
+// the upload.
+func (h *tusHandler) progress(ctx context.Context, id string, u tusUpload) ([]types.Part, []byte, int64, error) {
+	parts, err := listParts(ctx, h.parts, u.Bucket, u.Key, u.UploadID, h.wr.payer())
+	if err != nil {
+		var nsu *types.NoSuchUpload
+		if errors.As(err, &nsu) {
+			return nil, nil, 0, errTusNotFound
+		}
+		return nil, nil, 0, err
+	}
+	var offset int64
+	for _, p := range parts {
+		offset += aws.ToInt64(p.Size)
+	}
+
+	out, err := h.getter.Get(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(h.opts.StateBucket),
+		Key:    aws.String(h.opts.StatePrefix + id + ".part"),
+	})
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return parts, nil, offset, nil
+		}
+		return nil, nil, 0, fmt.Errorf("failed to read pending content of upload %q: %w", id, err)
+	}
+	defer out.Body.Close()
+	pending, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to read pending content of upload %q: %w", id, err)
+	}
+	return parts, pending, offset + int64(len(pending)), nil
+}
+
+// errTusNotFound is the error of requests to uploads that don't exist.
+var errTusNotFound = errors.New("upload not found")
+
+// fail responds with 404 Not Found to requests of uploads that don't exist.
+func (h *tusHandler) fail(w http.ResponseWriter, req *http.Request, err error) {
+	if errors.Is(err, errTusNotFound) {
+		http.NotFound(w, req)
+		return
+	}
+	h.wr.handleError(w, req, err)
+}
+
+// uploadError returns the error of a failed upload of the file.
+func (h *tusHandler) uploadError(name string, key *string, err error) error {
+	if isPreconditionFailed(err) {
+		err = fmt.Errorf("%w: %q", ErrKeyExists, aws.ToString(key))
+	} else {
+		err = fmt.Errorf("%w: %w", ErrS3Upload, err)
+	}
+	return &FileError{Field: h.opts.Field, Name: name, Err: err}
+}
+
+func (h *tusHandler) load(ctx context.Context, id string) (tusUpload, error) {
+	var u tusUpload
+	err := h.wr.loadState(ctx, h.opts.StateBucket, h.opts.StatePrefix+id+".info", &u)
+	if errors.Is(err, errNoState) {
+		return u, errTusNotFound
+	}
+	return u, err
+}
+
+func (h *tusHandler) save(ctx context.Context, id string, u tusUpload) error {
+	return h.wr.saveState(ctx, h.opts.StateBucket, h.opts.StatePrefix+id+".info", u, false)
+}
+
+func (h *tusHandler) savePending(ctx context.Context, id string, content []byte) error {
+	_, err := h.wr.backend.Upload(context.WithoutCancel(ctx), &s3.PutObjectInput{
+		Bucket:      aws.String(h.opts.StateBucket),
+		Key:         aws.String(h.opts.StatePrefix + id + ".part"),
+		Body:        bytes.NewReader(content),
+		ContentType: aws.String("application/octet-stream"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store pending content of upload %q: %w", id, err)
+	}
+	return nil
+}
+
+func (h *tusHandler) deleteState(ctx context.Context, id, suffix string) error {
+	return h.wr.deleteState(ctx, h.opts.StateBucket, h.opts.StatePrefix+id+suffix)
+}
+
+// parseTusMetadata parses the Upload-Metadata header, comma separated pairs of a key and a base64
+// encoded value, the value is optional.
+func parseTusMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for pair := range strings.SplitSeq(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, " ")
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %q: %w", key, err)
+		}
+		meta[key] = string(decoded)
+	}
+	return meta, nil
+}
+
+// validULID returns true if id is a ULID, the IDs of the uploads.
+func validULID(id string) bool {
+	if len(id) != 26 {
+		return false
+	}
+	for _, c := range id {
+		if !strings.ContainsRune(crockford, c) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,230 @@
+package mps3
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTusRequest(method, target string, body []byte) *http.Request {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	return req
+}
+
+func TestTusHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, MaxFileSize: 10 << 20})
+	assert.NoError(err)
+	handler, err := wrapper.TusHandler(TusOptions{Path: "/files/"})
+	assert.NoError(err)
+
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodOptions, "/files", nil))
+	assert.Equal(http.StatusNoContent, res.Code)
+	assert.Equal(tusVersion, res.Header().Get("Tus-Version"))
+	assert.Equal("10485760", res.Header().Get("Tus-Max-Size"))
+
+	// the file is sent in three requests, the first doesn't fill a part
+	content := make([]byte, 7<<20)
+	_, _ = rand.Read(content)
+	req := newTusRequest(http.MethodPost, "/files", nil)
+	req.Header.Set("Upload-Length", strconv.Itoa(len(content)))
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("video.mp4"))+",filetype "+base64.StdEncoding.EncodeToString([]byte("video/mp4")))
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	assert.Equal(http.StatusCreated, res.Code)
+	location := res.Header().Get("Location")
+	assert.Regexp(`^/files/[0-9A-Z]{26}$`, location)
+
+	offset, key := 0, ""
+	for _, size := range []int{3 << 20, 3 << 20, 1 << 20} {
+		req := newTusRequest(http.MethodPatch, location, content[offset:offset+size])
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+		req.Header.Set("Upload-Offset", strconv.Itoa(offset))
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		assert.Equal(http.StatusNoContent, res.Code)
+		offset += size
+		assert.Equal(strconv.Itoa(offset), res.Header().Get("Upload-Offset"))
+		key = res.Header().Get(HeaderKey)
+
+		res = httptest.NewRecorder()
+		handler.ServeHTTP(res, newTusRequest(http.MethodHead, location, nil))
+		assert.Equal(http.StatusOK, res.Code)
+		assert.Equal(strconv.Itoa(offset), res.Header().Get("Upload-Offset"))
+		assert.Equal(strconv.Itoa(len(content)), res.Header().Get("Upload-Length"))
+	}
+
+	// the key is sent when the upload finishes
+	obj, ok := backend.Object(bucket, key)
+	assert.True(ok)
+	assert.Equal(content, obj.Body)
+	assert.Equal("video/mp4", aws.ToString(obj.Input.ContentType))
+	assert.Equal(0, backend.Uploads())
+}
+
+func TestTusHandlerErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, MaxFileSize: 100})
+	assert.NoError(err)
+	handler, err := wrapper.TusHandler(TusOptions{})
+	assert.NoError(err)
+
+	create := func(length string) *httptest.ResponseRecorder {
+		req := newTusRequest(http.MethodPost, "/files", nil)
+		req.Header.Set("Upload-Length", length)
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res
+	}
+	assert.Equal(http.StatusRequestEntityTooLarge, create("101").Code)
+	assert.Equal(http.StatusBadRequest, create("").Code)
+
+	req := httptest.NewRequest(http.MethodPost, "/files", nil)
+	req.Header.Set("Upload-Length", "10")
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	assert.Equal(http.StatusPreconditionFailed, res.Code)
+
+	res = create("10")
+	assert.Equal(http.StatusCreated, res.Code)
+	location := res.Header().Get("Location")
+
+	patch := func(offset string, body []byte) *httptest.ResponseRecorder {
+		req := newTusRequest(http.MethodPatch, location, body)
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+		req.Header.Set("Upload-Offset", offset)
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res
+	}
+	assert.Equal(http.StatusConflict, patch("5", []byte("12345")).Code)
+	assert.Equal(http.StatusRequestEntityTooLarge, patch("0", []byte("12345678901")).Code)
+	assert.Equal(http.StatusNoContent, patch("0", []byte("12345")).Code)
+
+	// termination removes the upload and its state
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, newTusRequest(http.MethodDelete, location, nil))
+	assert.Equal(http.StatusNoContent, res.Code)
+	assert.Equal(0, backend.Uploads())
+	assert.Empty(backend.Objects())
+
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, newTusRequest(http.MethodHead, location, nil))
+	assert.Equal(http.StatusNotFound, res.Code)
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, newTusRequest(http.MethodHead, "/files/../secret", nil))
+	assert.Equal(http.StatusNotFound, res.Code)
+}
+
+func TestTusHandlerContent(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, AllowedTypes: []string{"image/png"}, BlockExecutables: true})
+	assert.NoError(err)
+	handler, err := wrapper.TusHandler(TusOptions{})
+	assert.NoError(err)
+
+	// the uploads are declared as PNG images, the content is checked before it's stored
+	upload := func(content []byte) (*httptest.ResponseRecorder, string) {
+		req := newTusRequest(http.MethodPost, "/files", nil)
+		req.Header.Set("Upload-Length", strconv.Itoa(len(content)))
+		req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("photo.png"))+",filetype "+base64.StdEncoding.EncodeToString([]byte("image/png")))
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		assert.Equal(http.StatusCreated, res.Code)
+		location := res.Header().Get("Location")
+
+		req = newTusRequest(http.MethodPatch, location, content)
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+		req.Header.Set("Upload-Offset", "0")
+		res = httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res, location
+	}
+
+	png, err := os.ReadFile("test_file1.png")
+	assert.NoError(err)
+	res, _ := upload(png)
+	assert.Equal(http.StatusNoContent, res.Code)
+	assert.NotEmpty(res.Header().Get(HeaderKey))
+
+	for _, content := range [][]byte{append([]byte("MZ"), make([]byte, 100)...), append([]byte("GIF89a"), make([]byte, 100)...)} {
+		res, location := upload(content)
+		assert.Equal(http.StatusUnsupportedMediaType, res.Code)
+
+		// the upload was aborted
+		res = httptest.NewRecorder()
+		handler.ServeHTTP(res, newTusRequest(http.MethodHead, location, nil))
+		assert.Equal(http.StatusNotFound, res.Code)
+	}
+	assert.Equal(0, backend.Uploads())
+
+	// options that inspect the whole content can't be used
+	wrapper, err = New(Config{Bucket: bucket, Backend: backend, StripMetadata: true})
+	assert.NoError(err)
+	_, err = wrapper.TusHandler(TusOptions{})
+	assert.ErrorContains(err, "StripMetadata")
+}
+
+func TestTusHandlerAuthorize(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := mps3test.NewBackend()
+	wrapper, err := New(Config{Bucket: bucket, Backend: backend, Authorize: func(req *http.Request) error {
+		if req.Header.Get("Authorization") == "" {
+			return errors.New("missing credentials")
+		}
+		return nil
+	}})
+	assert.NoError(err)
+	handler, err := wrapper.TusHandler(TusOptions{})
+	assert.NoError(err)
+	serve := func(req *http.Request, auth string) *httptest.ResponseRecorder {
+		req.Header.Set("Authorization", auth)
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res
+	}
+	create := func(auth string) *httptest.ResponseRecorder {
+		req := newTusRequest(http.MethodPost, "/files", nil)
+		req.Header.Set("Upload-Length", "5")
+		return serve(req, auth)
+	}
+	patch := func(location, auth string) *httptest.ResponseRecorder {
+		req := newTusRequest(http.MethodPatch, location, []byte("hello"))
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+		req.Header.Set("Upload-Offset", "0")
+		return serve(req, auth)
+	}
+
+	assert.Equal(http.StatusUnauthorized, create("").Code)
+	assert.Empty(backend.Objects())
+
+	res := create("Bearer token")
+	assert.Equal(http.StatusCreated, res.Code)
+	location := res.Header().Get("Location")
+	assert.Equal(http.StatusUnauthorized, serve(newTusRequest(http.MethodHead, location, nil), "").Code)
+	assert.Equal(http.StatusUnauthorized, patch(location, "").Code)
+	assert.Equal(http.StatusUnauthorized, serve(newTusRequest(http.MethodDelete, location, nil), "").Code)
+	assert.Equal(1, backend.Uploads())
+
+	assert.Equal(http.StatusNoContent, patch(location, "Bearer token").Code)
+	assert.Equal(0, backend.Uploads())
+}
@@ -0,0 +1,129 @@
+package mps3
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebhookSignatureHeader is the header with the signature of the webhook requests, see
+// VerifyWebhook.
+const WebhookSignatureHeader = "X-Mps3-Signature"
+
+// WebhookConfig configures NewWebhookNotifier.
+type WebhookConfig struct {
+	// URL the events are POSTed to as JSON
+	URL string
+
+	// Secret signs the requests, with HMAC-SHA256 (see VerifyWebhook)
+	Secret []byte
+
+	// Client sends the requests (default: an http.Client with a timeout of 10s)
+	Client *http.Client
+}
+
+// NewWebhookNotifier returns a Notifier that POSTs the events to a URL. The requests have the
+// WebhookSignatureHeader header "t=<unix time>,v1=<signature>", where the signature is the hex
+// HMAC-SHA256 of "<unix time>.<body>" with the secret. Notify sends a single request, the requests
+// that fail with a network error or a 429 or 5xx status are retried by Config.NotifyRetries.
+func NewWebhookNotifier(cfg WebhookConfig) (Notifier, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("webhook URL is required")
+	}
+	if len(cfg.Secret) == 0 {
+		return nil, fmt.Errorf("webhook secret is required")
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return NotifierFunc(func(ctx context.Context, e UploadEvent) error {
+		body, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := cfg.post(ctx, body); err != nil {
+			return fmt.Errorf("failed to send webhook: %w", err)
+		}
+		return nil
+	}), nil
+}
+
+// post sends the event, the errors that can't be retried are a permanentError.
+func (cfg WebhookConfig) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookSignatureHeader, signWebhook(cfg.Secret, time.Now(), body))
+	res, err := cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	_ = res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		err := fmt.Errorf("status %d", res.StatusCode)
+		if res.StatusCode != http.StatusTooManyRequests && res.StatusCode < 500 {
+			return permanentError{err}
+		}
+		return err
+	}
+	return nil
+}
+
+// signWebhook returns the signature header of a webhook request sent at t.
+func signWebhook(secret []byte, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + webhookMAC(secret, ts, body)
+}
+
+// webhookMAC returns the hex HMAC-SHA256 of the timestamp and body.
+func webhookMAC(secret []byte, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the WebhookSignatureHeader of a request of NewWebhookNotifier with its
+// body, failing with ErrInvalidSignature if it's invalid or older than maxAge (if greater than
+// zero), which prevents replays.
+//
+//	body, _ := io.ReadAll(req.Body)
+//	if err := mps3.VerifyWebhook(secret, req.Header.Get(mps3.WebhookSignatureHeader), body, 5*time.Minute); err != nil {
+//		http.Error(w, err.Error(), http.StatusUnauthorized)
+//		return
+//	}
+func VerifyWebhook(secret []byte, header string, body []byte, maxAge time.Duration) error {
+	var ts, sig string
+	for _, field := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(field, "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return fmt.Errorf("%w: malformed header", ErrInvalidSignature)
+	}
+	if !hmac.Equal([]byte(sig), []byte(webhookMAC(secret, ts, body))) {
+		return ErrInvalidSignature
+	}
+	if maxAge > 0 && time.Since(time.Unix(unix, 0)) > maxAge {
+		return fmt.Errorf("%w: expired", ErrInvalidSignature)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package mps3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gabrielhora/mps3/mps3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	assert := assert.New(t)
+
+	secret := []byte("secret")
+	var attempts int
+	var status []int
+	var received UploadEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		assert.NoError(VerifyWebhook(secret, req.Header.Get(WebhookSignatureHeader), body, time.Minute))
+		assert.NoError(json.Unmarshal(body, &received))
+		w.WriteHeader(status[attempts])
+		attempts++
+	}))
+	defer server.Close()
+
+	notifier, err := NewWebhookNotifier(WebhookConfig{URL: server.URL, Secret: secret})
+	assert.NoError(err)
+	event := UploadEvent{Event: "mps3.upload", Bucket: bucket, Key: "a.txt", Size: 5}
+
+	status = []int{http.StatusNoContent}
+	assert.NoError(notifier.Notify(context.Background(), event))
+	assert.Equal(1, attempts)
+	assert.Equal(event, received)
+
+	// server errors can be retried, client errors can't
+	attempts = 0
+	status = []int{http.StatusServiceUnavailable}
+	err = notifier.Notify(context.Background(), event)
+	assert.ErrorContains(err, "status 503")
+	assert.False(errors.As(err, new(permanentError)))
+	attempts = 0
+	status = []int{http.StatusBadRequest}
+	assert.ErrorAs(notifier.Notify(context.Background(), event), new(permanentError))
+
+	// the events are retried in the background
+	publish := func(codes ...int) int {
+		attempts, status = 0, codes
+		wrapper, err := New(Config{Bucket: bucket, Backend: mps3test.NewBackend(), Notifier: notifier, NotifyBackoff: time.Millisecond})
+		assert.NoError(err)
+		wrapper.Wrap(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), newFileRequest(t, "a.txt", "hello"))
+		assert.NoError(wrapper.Close(context.Background()))
+		return attempts
+	}
+	assert.Equal(3, publish(http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusNoContent))
+	assert.Equal(4, publish(500, 500, 500, 500))
+	assert.Equal(1, publish(http.StatusBadRequest))
+
+	_, err = NewWebhookNotifier(WebhookConfig{URL: server.URL})
+	assert.Error(err)
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	assert := assert.New(t)
+
+	secret, body := []byte("secret"), []byte(`{"key":"a.txt"}`)
+	header := signWebhook(secret, time.Now(), body)
+	assert.NoError(VerifyWebhook(secret, header, body, time.Minute))
+	assert.ErrorIs(VerifyWebhook([]byte("other"), header, body, time.Minute), ErrInvalidSignature)
+	assert.ErrorIs(VerifyWebhook(secret, header, []byte(`{"key":"b.txt"}`), time.Minute), ErrInvalidSignature)
+	assert.ErrorIs(VerifyWebhook(secret, "", body, time.Minute), ErrInvalidSignature)
+
+	old := signWebhook(secret, time.Now().Add(-time.Hour), body)
+	assert.ErrorIs(VerifyWebhook(secret, old, body, time.Minute), ErrInvalidSignature)
+	assert.NoError(VerifyWebhook(secret, old, body, 0))
+}